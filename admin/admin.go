@@ -1,7 +1,12 @@
 package admin
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
 	"sort"
 	"strconv"
 	"sync"
@@ -10,6 +15,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
@@ -34,26 +40,131 @@ func (e ErrQuery) Cause() error {
 	return e.err
 }
 
+var (
+	// ErrTopicExists is the cause of an `ErrQuery` returned on an attempt to
+	// create a topic that already exists.
+	ErrTopicExists = errors.New("topic already exists")
+	// ErrInvalidTopicParams is the cause of an `ErrQuery` returned if the
+	// parameters of a topic creation or a partition expansion are invalid.
+	ErrInvalidTopicParams = errors.New("invalid topic parameters")
+	// ErrUnknownGroup is the cause of an `ErrQuery` returned if a consumer
+	// group is neither registered in ZooKeeper nor known to Kafka.
+	ErrUnknownGroup = errors.New("unknown group")
+	// ErrNoZooKeeper is the cause of an `ErrQuery` returned by operations
+	// that require ZooKeeper if no ZooKeeper peers are configured.
+	ErrNoZooKeeper = errors.New("ZooKeeper is not configured")
+	// ErrInvalidOffset is the cause of an `ErrQuery` returned if an offset
+	// to be imported is negative.
+	ErrInvalidOffset = errors.New("invalid offset")
+)
+
 const (
 	ProtocolVer1 = 1 // Supported by Kafka v0.8.2 and later
+
+	// Kafka does not accept topic names longer than that.
+	maxTopicNameLen = 249
+
+	// ZooKeeper sessions of the admin are kept alive for that long while
+	// ZooKeeper is unreachable.
+	zookeeperSessionTimeout = 15 * time.Second
+
+	// Members of Kafka coordinated groups that use this protocol type
+	// subscribe to topics and get partitions assigned in the standard format.
+	consumerProtocolType = "consumer"
+	// Kafka-Pixy members of Kafka coordinated groups join with this protocol,
+	// and resolve partition assignments locally rather than getting them from
+	// the group leader.
+	kafkaPixyProtocol = "kafka-pixy"
+	// The state of a Kafka coordinated group that does not exist.
+	deadGroupState = "Dead"
 )
 
+var topicNameRE = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
 // T provides methods to perform administrative operations on a Kafka cluster.
+//
+// It maintains a Kafka client and a ZooKeeper session shared by all queries,
+// so that frequent queries, e.g. by monitoring dashboards, do not make
+// connections to brokers and ZooKeeper every time. The ZooKeeper session is
+// established when it is needed for the first time, and if ZooKeeper is not
+// configured, then only queries that require it fail. Cluster metadata cached by
+// the Kafka client is refreshed when it gets older than
+// `Config.Admin.MetadataTTL`, or when a query fails, for failures are often
+// caused by stale metadata, e.g. after a partition leader has moved.
 type T struct {
-	cfg *config.T
+	cfg      *config.T
+	kafkaClt sarama.Client
+
+	zookeeperClt  *zk.Conn
+	zookeeperLock sync.Mutex
+
+	metadataLock        sync.Mutex
+	metadataRefreshedAt time.Time
+
+	// Connections to brokers used to make requests that are not tied to a
+	// particular partition or group, keyed by broker ID.
+	brokers     map[int32]*sarama.Broker
+	brokersLock sync.Mutex
 }
 
 // Spawn creates an admin instance with the specified configuration and starts
 // internal goroutines to support its operation.
 func Spawn(config *config.T) (*T, error) {
 	a := T{
-		cfg: config,
+		cfg:     config,
+		brokers: make(map[int32]*sarama.Broker),
+	}
+	kafkaClt, err := sarama.NewClient(a.cfg.Kafka.SeedPeers, a.saramaConfig())
+	if err != nil {
+		return nil, ErrSetup(fmt.Errorf("failed to create sarama.Client: err=(%v)", err))
 	}
+	// The client has just fetched metadata of the entire cluster.
+	a.kafkaClt = kafkaClt
+	a.metadataRefreshedAt = time.Now()
 	return &a, nil
 }
 
 // Stop gracefully terminates internal goroutines.
 func (a *T) Stop() {
+	a.brokersLock.Lock()
+	for brokerID, broker := range a.brokers {
+		broker.Close()
+		delete(a.brokers, brokerID)
+	}
+	a.brokersLock.Unlock()
+	a.zookeeperLock.Lock()
+	if a.zookeeperClt != nil {
+		a.zookeeperClt.Close()
+	}
+	a.zookeeperLock.Unlock()
+	a.kafkaClt.Close()
+}
+
+// zookeeper returns the ZooKeeper session of the admin. The session is only
+// established on first use, so that deployments that do not use ZooKeeper,
+// e.g. with Kafka coordinated group membership, do not have to configure it.
+// If no ZooKeeper peers are configured, then an `ErrQuery` caused by
+// `ErrNoZooKeeper` is returned.
+func (a *T) zookeeper() (*zk.Conn, error) {
+	a.zookeeperLock.Lock()
+	defer a.zookeeperLock.Unlock()
+	if a.zookeeperClt != nil {
+		return a.zookeeperClt, nil
+	}
+	if !a.hasZooKeeper() {
+		return nil, NewErrQuery(ErrNoZooKeeper, "operation requires ZooKeeper")
+	}
+	zookeeperClt, _, err := zk.Connect(a.cfg.ZooKeeper.SeedPeers, zookeeperSessionTimeout)
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to create zk.Conn")
+	}
+	a.zookeeperClt = zookeeperClt
+	return zookeeperClt, nil
+}
+
+// hasZooKeeper tells whether ZooKeeper peers are configured.
+func (a *T) hasZooKeeper() bool {
+	return len(a.cfg.ZooKeeper.SeedPeers) > 0
 }
 
 type PartitionOffset struct {
@@ -64,6 +175,55 @@ type PartitionOffset struct {
 	Metadata  string
 }
 
+// PartitionMetadata describes replicas of a topic partition. Replicas are
+// given by broker IDs.
+type PartitionMetadata struct {
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	ISR       []int32
+}
+
+// BrokerMetadata describes a broker of a Kafka cluster.
+type BrokerMetadata struct {
+	ID   int32
+	Addr string
+}
+
+// GroupSummary describes a consumer group known to the cluster.
+type GroupSummary struct {
+	Group string
+	// Either `config.GroupMembershipZooKeeper` or `config.GroupMembershipKafka`.
+	Membership string
+}
+
+// GroupDescription describes a consumer group, its members and offsets it
+// has committed.
+type GroupDescription struct {
+	Group string
+	// Either `config.GroupMembershipZooKeeper` or `config.GroupMembershipKafka`.
+	Membership string
+	// The state reported by the group coordinator, e.g. `Stable`. It is empty
+	// for groups registered in ZooKeeper.
+	State   string
+	Members []GroupMember
+	// Offsets committed by the group for every topic that its members are
+	// either subscribed to or assigned partitions of.
+	Offsets map[string][]PartitionOffset
+}
+
+// GroupMember describes a member of a consumer group.
+type GroupMember struct {
+	ID         string
+	ClientID   string
+	ClientHost string
+	Topics     []string
+	// Topic partitions assigned to the member. It is nil for Kafka-Pixy
+	// members of Kafka coordinated groups, for they resolve assignments
+	// locally.
+	Assignment map[string][]int32
+}
+
 type indexedPartition struct {
 	index     int
 	partition int32
@@ -73,13 +233,16 @@ type indexedPartition struct {
 // current offset range along with the latest offset and metadata committed by
 // the specified consumer group.
 func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
-	kafkaClt, err := sarama.NewClient(a.cfg.Kafka.SeedPeers, a.saramaConfig())
-	if err != nil {
-		return nil, ErrSetup(fmt.Errorf("failed to create sarama.Client: err=(%v)", err))
-	}
-	defer kafkaClt.Close()
+	var offsets []PartitionOffset
+	err := a.withMetadata(func() (err error) {
+		offsets, err = a.getGroupOffsets(group, topic)
+		return err
+	})
+	return offsets, err
+}
 
-	partitions, err := kafkaClt.Partitions(topic)
+func (a *T) getGroupOffsets(group, topic string) ([]PartitionOffset, error) {
+	partitions, err := a.kafkaClt.Partitions(topic)
 	if err != nil {
 		return nil, NewErrQuery(err, "failed to get topic partitions")
 	}
@@ -87,7 +250,7 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 	// Figure out distribution of partitions among brokers.
 	brokerToPartitions := make(map[*sarama.Broker][]indexedPartition)
 	for i, p := range partitions {
-		broker, err := kafkaClt.Leader(topic, p)
+		broker, err := a.kafkaClt.Leader(topic, p)
 		if err != nil {
 			return nil, NewErrQuery(err, "failed to get partition leader: partition=%d", p)
 		}
@@ -145,7 +308,7 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 	}
 
 	// Fetch the last committed offsets for all partitions of the group/topic.
-	coordinator, err := kafkaClt.Coordinator(group)
+	coordinator, err := a.kafkaClt.Coordinator(group)
 	if err != nil {
 		return nil, NewErrQuery(err, "failed to get coordinator")
 	}
@@ -155,6 +318,7 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 	}
 	res, err := coordinator.FetchOffset(&req)
 	if err != nil {
+		a.resetCoordinator(group)
 		return nil, NewErrQuery(err, "failed to fetch offsets")
 	}
 	for i, p := range partitions {
@@ -172,46 +336,173 @@ func (a *T) GetGroupOffsets(group, topic string) ([]PartitionOffset, error) {
 // SetGroupOffsets commits specific offset values along with metadata for a list
 // of partitions of a particular topic on behalf of the specified group.
 func (a *T) SetGroupOffsets(group, topic string, offsets []PartitionOffset) error {
-	kafkaClt, err := sarama.NewClient(a.cfg.Kafka.SeedPeers, a.saramaConfig())
+	return a.withMetadata(func() error {
+		_, err := a.commitOffsets(group, map[string][]PartitionOffset{topic: offsets})
+		return err
+	})
+}
+
+// ExportGroupOffsets returns offsets along with metadata committed by the
+// specified group for all partitions of all topics in the cluster. Partitions
+// that the group has not committed offsets for are omitted. Offsets of all
+// partitions are fetched with a single request to the group coordinator.
+func (a *T) ExportGroupOffsets(group string) (map[string][]PartitionOffset, error) {
+	var offsets map[string][]PartitionOffset
+	err := a.withMetadata(func() (err error) {
+		offsets, err = a.exportGroupOffsets(group)
+		return err
+	})
+	return offsets, err
+}
+
+func (a *T) exportGroupOffsets(group string) (map[string][]PartitionOffset, error) {
+	topics, err := a.kafkaClt.Topics()
 	if err != nil {
-		return ErrSetup(fmt.Errorf("failed to create sarama.Client: err=(%v)", err))
+		return nil, NewErrQuery(err, "failed to get topics")
+	}
+	req := sarama.OffsetFetchRequest{ConsumerGroup: group, Version: ProtocolVer1}
+	topicPartitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		partitions, err := a.kafkaClt.Partitions(topic)
+		if err != nil {
+			return nil, NewErrQuery(err, "failed to get topic partitions: topic=%s", topic)
+		}
+		for _, p := range partitions {
+			req.AddPartition(topic, p)
+		}
+		topicPartitions[topic] = partitions
 	}
-	defer kafkaClt.Close()
 
-	coordinator, err := kafkaClt.Coordinator(group)
+	coordinator, err := a.kafkaClt.Coordinator(group)
 	if err != nil {
-		return NewErrQuery(err, "failed to get coordinator")
+		return nil, NewErrQuery(err, "failed to get coordinator")
+	}
+	res, err := coordinator.FetchOffset(&req)
+	if err != nil {
+		a.resetCoordinator(group)
+		return nil, NewErrQuery(err, "failed to fetch offsets")
 	}
+	offsets := make(map[string][]PartitionOffset)
+	for topic, partitions := range topicPartitions {
+		for _, p := range partitions {
+			block := res.GetBlock(topic, p)
+			if block == nil {
+				return nil, NewErrQuery(nil, "offset block is missing: topic=%s, partition=%d", topic, p)
+			}
+			if block.Err != sarama.ErrNoError {
+				return nil, NewErrQuery(block.Err, "failed to fetch offset: topic=%s, partition=%d", topic, p)
+			}
+			// Kafka reports -1 for partitions with no offset committed.
+			if block.Offset < 0 {
+				continue
+			}
+			offsets[topic] = append(offsets[topic], PartitionOffset{
+				Partition: p,
+				Offset:    block.Offset,
+				Metadata:  block.Metadata,
+			})
+		}
+	}
+	for _, topicOffsets := range offsets {
+		sort.Sort(partitionOffsetSlice(topicOffsets))
+	}
+	return offsets, nil
+}
 
-	req := sarama.OffsetCommitRequest{
-		Version:                 ProtocolVer1,
-		ConsumerGroup:           group,
-		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+// ImportGroupOffsets commits offsets along with metadata for partitions of
+// any number of topics on behalf of the specified group. All offsets are
+// validated against the cluster metadata first, and nothing is committed if
+// any of them is invalid. Then all offsets are committed with a single
+// request to the group coordinator, but the coordinator accepts or rejects
+// them partition by partition, e.g. if metadata of some of them is too
+// large. So in case of a commit failure some offsets may still have been
+// committed. Offsets that have been committed are returned, along with an
+// error if not all of them have been.
+func (a *T) ImportGroupOffsets(group string, offsets map[string][]PartitionOffset) (map[string][]PartitionOffset, error) {
+	if len(offsets) == 0 {
+		return offsets, nil
 	}
-	for _, po := range offsets {
-		req.AddBlock(topic, po.Partition, po.Offset, sarama.ReceiveTime, po.Metadata)
+	var committed map[string][]PartitionOffset
+	err := a.withMetadata(func() (err error) {
+		for topic, topicOffsets := range offsets {
+			partitions, err := a.kafkaClt.Partitions(topic)
+			if err != nil {
+				return NewErrQuery(err, "failed to get topic partitions: topic=%s", topic)
+			}
+			for _, po := range topicOffsets {
+				if !int32Slice(partitions).contains(po.Partition) {
+					return NewErrQuery(sarama.ErrUnknownTopicOrPartition, "invalid partition: topic=%s, partition=%d",
+						topic, po.Partition)
+				}
+				if po.Offset < 0 {
+					return NewErrQuery(ErrInvalidOffset, "invalid offset: topic=%s, partition=%d, offset=%d",
+						topic, po.Partition, po.Offset)
+				}
+			}
+		}
+		committed, err = a.commitOffsets(group, offsets)
+		return err
+	})
+	return committed, err
+}
+
+// CopyGroupOffsets commits offsets committed by the source group for all
+// partitions of all topics on behalf of the destination group. Offsets that
+// have been copied are returned, along with an error if not all of them
+// have been, see `ImportGroupOffsets`.
+func (a *T) CopyGroupOffsets(srcGroup, dstGroup string) (map[string][]PartitionOffset, error) {
+	offsets, err := a.ExportGroupOffsets(srcGroup)
+	if err != nil {
+		return nil, err
 	}
-	res, err := coordinator.CommitOffset(&req)
+	return a.ImportGroupOffsets(dstGroup, offsets)
+}
+
+// GetTimeOffsets for every partition of the specified topic resolves the
+// offset that corresponds to the specified time given in milliseconds since
+// epoch. The time can also be either `sarama.OffsetOldest` or
+// `sarama.OffsetNewest`. Note that Kafka resolves time with log segment
+// granularity, that is the returned offset is the first offset of the newest
+// segment created before the specified time, or the oldest offset if there
+// is no such segment.
+func (a *T) GetTimeOffsets(topic string, time int64) ([]PartitionOffset, error) {
+	var offsets []PartitionOffset
+	err := a.withMetadata(func() (err error) {
+		offsets, err = a.getTimeOffsets(topic, time)
+		return err
+	})
+	return offsets, err
+}
+
+func (a *T) getTimeOffsets(topic string, time int64) ([]PartitionOffset, error) {
+	partitions, err := a.kafkaClt.Partitions(topic)
 	if err != nil {
-		return NewErrQuery(err, "failed to commit offsets")
+		return nil, NewErrQuery(err, "failed to get topic partitions")
 	}
-	for p, err := range res.Errors[topic] {
-		if err != sarama.ErrNoError {
-			return NewErrQuery(err, "failed to commit offset: partition=%d", p)
+	offsets := make([]PartitionOffset, len(partitions))
+	for i, p := range partitions {
+		offset, err := a.kafkaClt.GetOffset(topic, p, time)
+		// If there are no segments created before the time, then Kafka
+		// returns no offsets, and that is reported by sarama as out of range.
+		if err == sarama.ErrOffsetOutOfRange {
+			offset, err = a.kafkaClt.GetOffset(topic, p, sarama.OffsetOldest)
+		}
+		if err != nil {
+			return nil, NewErrQuery(err, "failed to get offset: partition=%d", p)
 		}
+		offsets[i].Partition = p
+		offsets[i].Offset = offset
 	}
-	return nil
+	return offsets, nil
 }
 
 // GetTopicConsumers returns client-id -> consumed-partitions-list mapping
 // for a clients from a particular consumer group and a particular topic.
 func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
-	zookeeperClt, _, err := zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second)
+	zookeeperClt, err := a.zookeeper()
 	if err != nil {
-		return nil, ErrSetup(fmt.Errorf("failed to create zk.Conn: err=(%v)", err))
+		return nil, err
 	}
-	defer zookeeperClt.Close()
-
 	consumedPartitionsPath := fmt.Sprintf("%s/consumers/%s/owners/%s",
 		a.cfg.ZooKeeper.Chroot, group, topic)
 	partitionNodes, _, err := zookeeperClt.Children(consumedPartitionsPath)
@@ -248,12 +539,10 @@ func (a *T) GetTopicConsumers(group, topic string) (map[string][]int32, error) {
 // mapping for a particular topic. Warning, the function performs scan of all
 // consumer groups registered in ZooKeeper and therefore can take a lot of time.
 func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, error) {
-	zookeeperClt, _, err := zk.Connect(a.cfg.ZooKeeper.SeedPeers, 1*time.Second)
+	zookeeperClt, err := a.zookeeper()
 	if err != nil {
-		return nil, ErrSetup(fmt.Errorf("failed to create zk.Conn: err=(%v)", err))
+		return nil, err
 	}
-	defer zookeeperClt.Close()
-
 	groupsPath := fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot)
 	groups, _, err := zookeeperClt.Children(groupsPath)
 	if err != nil {
@@ -276,6 +565,735 @@ func (a *T) GetAllTopicConsumers(topic string) (map[string]map[string][]int32, e
 	return consumers, nil
 }
 
+// GetTopics returns a sorted list of all topics in the cluster.
+func (a *T) GetTopics() ([]string, error) {
+	var topics []string
+	err := a.withMetadata(func() (err error) {
+		if topics, err = a.kafkaClt.Topics(); err != nil {
+			return NewErrQuery(err, "failed to get topics")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// GetTopicMetadata returns the leader, the replicas and the in-sync replicas
+// of every partition of the specified topic, sorted by partition.
+func (a *T) GetTopicMetadata(topic string) ([]PartitionMetadata, error) {
+	var partitionsMetadata []PartitionMetadata
+	err := a.withMetadata(func() (err error) {
+		partitionsMetadata, err = a.getTopicMetadata(topic)
+		return err
+	})
+	return partitionsMetadata, err
+}
+
+func (a *T) getTopicMetadata(topic string) ([]PartitionMetadata, error) {
+	partitions, err := a.kafkaClt.Partitions(topic)
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to get topic partitions")
+	}
+	if len(partitions) == 0 {
+		return nil, NewErrQuery(sarama.ErrUnknownTopicOrPartition, "topic has no partitions")
+	}
+	// The client does not keep in-sync replicas, so metadata is requested
+	// from a broker directly.
+	broker, err := a.kafkaClt.Leader(topic, partitions[0])
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to get partition leader: partition=%d", partitions[0])
+	}
+	res, err := broker.GetMetadata(&sarama.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to fetch metadata: broker=%d", broker.ID())
+	}
+	for _, topicMetadata := range res.Topics {
+		if topicMetadata.Name != topic {
+			continue
+		}
+		if topicMetadata.Err != sarama.ErrNoError {
+			return nil, NewErrQuery(topicMetadata.Err, "failed to fetch metadata: broker=%d", broker.ID())
+		}
+		partitionsMetadata := make([]PartitionMetadata, len(topicMetadata.Partitions))
+		for i, pm := range topicMetadata.Partitions {
+			partitionsMetadata[i] = PartitionMetadata{
+				Partition: pm.ID,
+				Leader:    pm.Leader,
+				Replicas:  pm.Replicas,
+				ISR:       pm.Isr,
+			}
+		}
+		sort.Sort(partitionMetadataSlice(partitionsMetadata))
+		return partitionsMetadata, nil
+	}
+	return nil, NewErrQuery(sarama.ErrUnknownTopicOrPartition, "topic metadata is missing: broker=%d", broker.ID())
+}
+
+// GetBrokers returns a list of brokers registered in the cluster sorted by
+// ID, along with the ID of the controller broker, or -1 if no broker is
+// elected as the controller at the moment. The information is read from
+// ZooKeeper, for the metadata API version that we use does not report the
+// controller.
+func (a *T) GetBrokers() ([]BrokerMetadata, int32, error) {
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return nil, 0, err
+	}
+	brokersPath := fmt.Sprintf("%s/brokers/ids", a.cfg.ZooKeeper.Chroot)
+	brokerNodes, _, err := zookeeperClt.Children(brokersPath)
+	if err != nil {
+		return nil, 0, NewErrQuery(err, "failed to fetch brokers")
+	}
+	brokers := make([]BrokerMetadata, 0, len(brokerNodes))
+	for _, brokerNode := range brokerNodes {
+		brokerID, err := strconv.Atoi(brokerNode)
+		if err != nil {
+			return nil, 0, NewErrQuery(err, "invalid broker id: %s", brokerNode)
+		}
+		brokerNodeData, _, err := zookeeperClt.Get(fmt.Sprintf("%s/%s", brokersPath, brokerNode))
+		if err != nil {
+			if err == zk.ErrNoNode {
+				// The broker has just gone.
+				continue
+			}
+			return nil, 0, NewErrQuery(err, "failed to fetch broker: id=%d", brokerID)
+		}
+		var registration struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		}
+		if err := json.Unmarshal(brokerNodeData, &registration); err != nil {
+			return nil, 0, NewErrQuery(err, "invalid broker registration: id=%d", brokerID)
+		}
+		brokers = append(brokers, BrokerMetadata{
+			ID:   int32(brokerID),
+			Addr: net.JoinHostPort(registration.Host, strconv.Itoa(registration.Port)),
+		})
+	}
+	sort.Sort(brokerMetadataSlice(brokers))
+
+	controllerID := int32(-1)
+	controllerNodeData, _, err := zookeeperClt.Get(fmt.Sprintf("%s/controller", a.cfg.ZooKeeper.Chroot))
+	if err != nil && err != zk.ErrNoNode {
+		return nil, 0, NewErrQuery(err, "failed to fetch controller")
+	}
+	if err == nil {
+		var controller struct {
+			BrokerID int32 `json:"brokerid"`
+		}
+		if err := json.Unmarshal(controllerNodeData, &controller); err != nil {
+			return nil, 0, NewErrQuery(err, "invalid controller registration")
+		}
+		controllerID = controller.BrokerID
+	}
+	return brokers, controllerID, nil
+}
+
+// CreateTopic creates a topic with the specified number of partitions,
+// replication factor and topic level configuration overrides. Partition
+// replicas are spread across the registered brokers the same way Kafka does
+// it. Like Kafka admin tools, it writes the topic assignment directly to
+// ZooKeeper, and the controller broker creates the partitions shortly after.
+func (a *T) CreateTopic(topic string, partitions, replicationFactor int, configs map[string]string) error {
+	if !isValidTopicName(topic) {
+		return NewErrQuery(ErrInvalidTopicParams, "invalid topic name: %s", topic)
+	}
+	if partitions <= 0 {
+		return NewErrQuery(ErrInvalidTopicParams, "partition count must be positive: %d", partitions)
+	}
+	if replicationFactor <= 0 {
+		return NewErrQuery(ErrInvalidTopicParams, "replication factor must be positive: %d", replicationFactor)
+	}
+
+	brokerIDs, err := a.fetchBrokerIDs()
+	if err != nil {
+		return err
+	}
+	if replicationFactor > len(brokerIDs) {
+		return NewErrQuery(ErrInvalidTopicParams, "replication factor %d is larger than the number of brokers %d",
+			replicationFactor, len(brokerIDs))
+	}
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return err
+	}
+	topicPath := a.topicPath(topic)
+	exists, _, err := zookeeperClt.Exists(topicPath)
+	if err != nil {
+		return NewErrQuery(err, "failed to check topic")
+	}
+	if exists {
+		return NewErrQuery(ErrTopicExists, "failed to create topic: %s", topic)
+	}
+
+	// Configuration overrides must be in place before the topic is created,
+	// for brokers read them when they create partition logs.
+	if configs == nil {
+		configs = make(map[string]string)
+	}
+	configData, err := json.Marshal(topicConfigNode{Version: 1, Config: configs})
+	if err != nil {
+		return NewErrQuery(err, "failed to encode topic config")
+	}
+	configPath := fmt.Sprintf("%s/config/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+	if _, err := zookeeperClt.Create(configPath, configData, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		if err != zk.ErrNodeExists {
+			return NewErrQuery(err, "failed to write topic config")
+		}
+		// Left behind by a deleted topic with the same name.
+		if _, err := zookeeperClt.Set(configPath, configData, -1); err != nil {
+			return NewErrQuery(err, "failed to write topic config")
+		}
+	}
+
+	assignment := assignReplicas(brokerIDs, 0, partitions, replicationFactor,
+		rand.Intn(len(brokerIDs)), rand.Intn(len(brokerIDs)))
+	if _, err := zookeeperClt.Create(topicPath, encodeAssignment(assignment), 0, zk.WorldACL(zk.PermAll)); err != nil {
+		if err == zk.ErrNodeExists {
+			return NewErrQuery(ErrTopicExists, "failed to create topic: %s", topic)
+		}
+		return NewErrQuery(err, "failed to write topic assignment")
+	}
+	a.invalidateMetadata()
+	return nil
+}
+
+// DeleteTopic marks the specified topic for deletion. Deletion is performed
+// by the controller broker asynchronously, and only if the cluster is
+// configured with `delete.topic.enable=true`, otherwise the topic stays
+// marked for deletion forever.
+func (a *T) DeleteTopic(topic string) error {
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return err
+	}
+	exists, _, err := zookeeperClt.Exists(a.topicPath(topic))
+	if err != nil {
+		return NewErrQuery(err, "failed to check topic")
+	}
+	if !exists {
+		return NewErrQuery(sarama.ErrUnknownTopicOrPartition, "failed to delete topic: %s", topic)
+	}
+	deletePath := fmt.Sprintf("%s/admin/delete_topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+	if _, err := zookeeperClt.Create(deletePath, nil, 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+		return NewErrQuery(err, "failed to mark topic for deletion")
+	}
+	a.invalidateMetadata()
+	return nil
+}
+
+// AddPartitions increases the number of partitions of the specified topic to
+// the specified count. Replicas of the new partitions are assigned the same
+// way Kafka does it, and with the replication factor of the existing ones.
+// Note that Kafka does not support reducing the number of partitions.
+func (a *T) AddPartitions(topic string, partitions int) error {
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return err
+	}
+	topicPath := a.topicPath(topic)
+	topicNodeData, topicNodeStat, err := zookeeperClt.Get(topicPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return NewErrQuery(sarama.ErrUnknownTopicOrPartition, "failed to add partitions: %s", topic)
+		}
+		return NewErrQuery(err, "failed to fetch topic assignment")
+	}
+	assignment, err := decodeAssignment(topicNodeData)
+	if err != nil {
+		return NewErrQuery(err, "invalid topic assignment")
+	}
+	if partitions <= len(assignment) {
+		return NewErrQuery(ErrInvalidTopicParams, "partition count can only be increased: current=%d, requested=%d",
+			len(assignment), partitions)
+	}
+	partition0Replicas := assignment[0]
+	if len(partition0Replicas) == 0 {
+		return NewErrQuery(nil, "partition 0 has no replicas")
+	}
+
+	brokerIDs, err := a.fetchBrokerIDs()
+	if err != nil {
+		return err
+	}
+	if len(partition0Replicas) > len(brokerIDs) {
+		return NewErrQuery(ErrInvalidTopicParams, "replication factor %d is larger than the number of brokers %d",
+			len(partition0Replicas), len(brokerIDs))
+	}
+	// New partitions continue the assignment started by the existing ones.
+	startIndex := 0
+	for i, brokerID := range brokerIDs {
+		if brokerID == partition0Replicas[0] {
+			startIndex = i
+			break
+		}
+	}
+	added := assignReplicas(brokerIDs, int32(len(assignment)), partitions-len(assignment),
+		len(partition0Replicas), startIndex, startIndex)
+	for partition, replicas := range added {
+		assignment[partition] = replicas
+	}
+	// The version check makes sure that no one has changed the assignment
+	// since it was read.
+	if _, err := zookeeperClt.Set(topicPath, encodeAssignment(assignment), topicNodeStat.Version); err != nil {
+		return NewErrQuery(err, "failed to write topic assignment")
+	}
+	a.invalidateMetadata()
+	return nil
+}
+
+// fetchBrokerIDs returns a sorted list of IDs of all brokers registered in
+// the cluster.
+func (a *T) fetchBrokerIDs() ([]int32, error) {
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return nil, err
+	}
+	brokerNodes, _, err := zookeeperClt.Children(fmt.Sprintf("%s/brokers/ids", a.cfg.ZooKeeper.Chroot))
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to fetch brokers")
+	}
+	if len(brokerNodes) == 0 {
+		return nil, NewErrQuery(nil, "no brokers available")
+	}
+	brokerIDs := make([]int32, len(brokerNodes))
+	for i, brokerNode := range brokerNodes {
+		brokerID, err := strconv.Atoi(brokerNode)
+		if err != nil {
+			return nil, NewErrQuery(err, "invalid broker id: %s", brokerNode)
+		}
+		brokerIDs[i] = int32(brokerID)
+	}
+	sort.Sort(int32Slice(brokerIDs))
+	return brokerIDs, nil
+}
+
+func (a *T) topicPath(topic string) string {
+	return fmt.Sprintf("%s/brokers/topics/%s", a.cfg.ZooKeeper.Chroot, topic)
+}
+
+// GetGroups returns a list of consumer groups sorted by name. It includes
+// groups registered in ZooKeeper along with groups known to Kafka group
+// coordinators, that is groups coordinated by Kafka and groups that have
+// committed offsets to Kafka. If a group is both registered in ZooKeeper and
+// known to Kafka, then it is reported as a ZooKeeper group.
+func (a *T) GetGroups() ([]GroupSummary, error) {
+	if !a.hasZooKeeper() {
+		brokers, err := a.kafkaBrokers()
+		if err != nil {
+			return nil, err
+		}
+		kafkaGroups, err := a.listKafkaGroups(brokers)
+		if err != nil {
+			return nil, err
+		}
+		return groupSummaries(kafkaGroups, nil), nil
+	}
+	brokers, _, err := a.GetBrokers()
+	if err != nil {
+		return nil, err
+	}
+	kafkaGroups, err := a.listKafkaGroups(brokers)
+	if err != nil {
+		return nil, err
+	}
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return nil, err
+	}
+	zookeeperGroups, _, err := zookeeperClt.Children(fmt.Sprintf("%s/consumers", a.cfg.ZooKeeper.Chroot))
+	if err != nil && err != zk.ErrNoNode {
+		return nil, NewErrQuery(err, "failed to fetch consumer groups")
+	}
+	return groupSummaries(kafkaGroups, zookeeperGroups), nil
+}
+
+// groupSummaries merges lists of groups known to Kafka and registered in
+// ZooKeeper into a sorted list of group summaries.
+func groupSummaries(kafkaGroups, zookeeperGroups []string) []GroupSummary {
+
+	membership := make(map[string]string, len(kafkaGroups)+len(zookeeperGroups))
+	for _, group := range kafkaGroups {
+		membership[group] = config.GroupMembershipKafka
+	}
+	for _, group := range zookeeperGroups {
+		membership[group] = config.GroupMembershipZooKeeper
+	}
+	groups := make([]GroupSummary, 0, len(membership))
+	for group, groupMembership := range membership {
+		groups = append(groups, GroupSummary{Group: group, Membership: groupMembership})
+	}
+	sort.Sort(groupSummarySlice(groups))
+	return groups
+}
+
+// kafkaBrokers returns brokers of the cluster as reported in metadata by
+// any of the seed brokers. It is used in place of `GetBrokers` when ZooKeeper
+// is not configured.
+func (a *T) kafkaBrokers() ([]BrokerMetadata, error) {
+	var lastErr error
+	for _, addr := range a.cfg.Kafka.SeedPeers {
+		seedBroker := sarama.NewBroker(addr)
+		if err := seedBroker.Open(a.saramaConfig()); err != nil {
+			lastErr = err
+			continue
+		}
+		res, err := seedBroker.GetMetadata(&sarama.MetadataRequest{})
+		seedBroker.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		brokers := make([]BrokerMetadata, len(res.Brokers))
+		for i, broker := range res.Brokers {
+			brokers[i] = BrokerMetadata{ID: broker.ID(), Addr: broker.Addr()}
+		}
+		sort.Sort(brokerMetadataSlice(brokers))
+		return brokers, nil
+	}
+	return nil, NewErrQuery(lastErr, "failed to fetch brokers from seed peers")
+}
+
+// GetGroup describes the specified consumer group. Members of a group
+// registered in ZooKeeper are read from ZooKeeper, otherwise they are
+// requested from the group coordinator. If the group is not known either way,
+// then an `ErrQuery` caused by `ErrUnknownGroup` is returned.
+func (a *T) GetGroup(group string) (*GroupDescription, error) {
+	desc := GroupDescription{Group: group}
+	var registered bool
+	if a.hasZooKeeper() {
+		var err error
+		if registered, err = a.describeZooKeeperGroup(&desc); err != nil {
+			return nil, err
+		}
+	}
+	if !registered {
+		err := a.withMetadata(func() error {
+			return a.describeKafkaGroup(&desc)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(groupMemberSlice(desc.Members))
+
+	// Collect topics that the group may have committed offsets for.
+	topics := make(map[string]bool)
+	for _, member := range desc.Members {
+		for _, topic := range member.Topics {
+			if !consumer.IsTopicPattern(topic) {
+				topics[topic] = true
+			}
+		}
+		for topic := range member.Assignment {
+			topics[topic] = true
+		}
+	}
+	desc.Offsets = make(map[string][]PartitionOffset, len(topics))
+	for topic := range topics {
+		offsets, err := a.GetGroupOffsets(group, topic)
+		if err != nil {
+			// Members may be subscribed to topics that do not exist.
+			if err, ok := err.(ErrQuery); ok && err.Cause() == sarama.ErrUnknownTopicOrPartition {
+				continue
+			}
+			return nil, err
+		}
+		desc.Offsets[topic] = offsets
+	}
+	return &desc, nil
+}
+
+// listKafkaGroups returns a list of groups known to Kafka group coordinators.
+// Every broker is a coordinator for some groups, so all of them are asked.
+func (a *T) listKafkaGroups(brokers []BrokerMetadata) ([]string, error) {
+	var wg sync.WaitGroup
+	groupsCh := make(chan []string, len(brokers))
+	errorsCh := make(chan ErrQuery, len(brokers))
+	for _, bm := range brokers {
+		bm := bm
+		actorID := actor.RootID.NewChild("adminGroupLister")
+		actor.Spawn(actorID, &wg, func() {
+			broker, err := a.broker(bm)
+			if err != nil {
+				errorsCh <- NewErrQuery(err, "failed to connect: broker=%d", bm.ID)
+				return
+			}
+			res, err := broker.ListGroups(&sarama.ListGroupsRequest{})
+			if err != nil {
+				a.closeBroker(bm.ID, broker)
+				errorsCh <- NewErrQuery(err, "failed to list groups: broker=%d", bm.ID)
+				return
+			}
+			if res.Err != sarama.ErrNoError {
+				errorsCh <- NewErrQuery(res.Err, "failed to list groups: broker=%d", bm.ID)
+				return
+			}
+			groups := make([]string, 0, len(res.Groups))
+			for group := range res.Groups {
+				groups = append(groups, group)
+			}
+			groupsCh <- groups
+		})
+	}
+	wg.Wait()
+	close(errorsCh)
+	if err, ok := <-errorsCh; ok {
+		return nil, err
+	}
+	close(groupsCh)
+	var groups []string
+	for brokerGroups := range groupsCh {
+		groups = append(groups, brokerGroups...)
+	}
+	return groups, nil
+}
+
+// describeZooKeeperGroup populates the group description with members
+// registered in ZooKeeper and partitions they own. False is returned if the
+// group is not registered in ZooKeeper.
+func (a *T) describeZooKeeperGroup(desc *GroupDescription) (bool, error) {
+	zookeeperClt, err := a.zookeeper()
+	if err != nil {
+		return false, err
+	}
+	groupPath := fmt.Sprintf("%s/consumers/%s", a.cfg.ZooKeeper.Chroot, desc.Group)
+	registered, _, err := zookeeperClt.Exists(groupPath)
+	if err != nil {
+		return false, NewErrQuery(err, "failed to check group")
+	}
+	if !registered {
+		return false, nil
+	}
+	desc.Membership = config.GroupMembershipZooKeeper
+
+	members := make(map[string]*GroupMember)
+	memberNodes, _, err := zookeeperClt.Children(groupPath + "/ids")
+	if err != nil && err != zk.ErrNoNode {
+		return false, NewErrQuery(err, "failed to fetch group members")
+	}
+	for _, memberNode := range memberNodes {
+		memberNodeData, _, err := zookeeperClt.Get(fmt.Sprintf("%s/ids/%s", groupPath, memberNode))
+		if err != nil {
+			if err == zk.ErrNoNode {
+				// The member has just left.
+				continue
+			}
+			return false, NewErrQuery(err, "failed to fetch group member: id=%s", memberNode)
+		}
+		var registration struct {
+			Subscription map[string]int `json:"subscription"`
+		}
+		if err := json.Unmarshal(memberNodeData, &registration); err != nil {
+			return false, NewErrQuery(err, "invalid group member registration: id=%s", memberNode)
+		}
+		member := &GroupMember{ID: memberNode, ClientID: memberNode}
+		for topic := range registration.Subscription {
+			member.Topics = append(member.Topics, topic)
+		}
+		sort.Strings(member.Topics)
+		members[memberNode] = member
+	}
+
+	ownersPath := groupPath + "/owners"
+	topics, _, err := zookeeperClt.Children(ownersPath)
+	if err != nil && err != zk.ErrNoNode {
+		return false, NewErrQuery(err, "failed to fetch partition owners")
+	}
+	for _, topic := range topics {
+		partitionNodes, _, err := zookeeperClt.Children(fmt.Sprintf("%s/%s", ownersPath, topic))
+		if err != nil && err != zk.ErrNoNode {
+			return false, NewErrQuery(err, "failed to fetch partition owners: topic=%s", topic)
+		}
+		for _, partitionNode := range partitionNodes {
+			partition, err := strconv.Atoi(partitionNode)
+			if err != nil {
+				return false, NewErrQuery(err, "invalid partition id: %s", partitionNode)
+			}
+			ownerNodeData, _, err := zookeeperClt.Get(fmt.Sprintf("%s/%s/%s", ownersPath, topic, partitionNode))
+			if err != nil {
+				if err == zk.ErrNoNode {
+					// The partition has just been released.
+					continue
+				}
+				return false, NewErrQuery(err, "failed to fetch partition owner")
+			}
+			// A partition may be owned by a member that has just gone.
+			owner := string(ownerNodeData)
+			member := members[owner]
+			if member == nil {
+				member = &GroupMember{ID: owner, ClientID: owner}
+				members[owner] = member
+			}
+			if member.Assignment == nil {
+				member.Assignment = make(map[string][]int32)
+			}
+			member.Assignment[topic] = append(member.Assignment[topic], int32(partition))
+		}
+	}
+
+	for _, member := range members {
+		for _, partitions := range member.Assignment {
+			sort.Sort(int32Slice(partitions))
+		}
+		desc.Members = append(desc.Members, *member)
+	}
+	return true, nil
+}
+
+// describeKafkaGroup populates the group description with members reported
+// by the group coordinator.
+func (a *T) describeKafkaGroup(desc *GroupDescription) error {
+	coordinator, err := a.kafkaClt.Coordinator(desc.Group)
+	if err != nil {
+		return NewErrQuery(err, "failed to get coordinator")
+	}
+	res, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{desc.Group}})
+	if err != nil {
+		a.resetCoordinator(desc.Group)
+		return NewErrQuery(err, "failed to describe group")
+	}
+	if len(res.Groups) != 1 {
+		return NewErrQuery(nil, "group description is missing")
+	}
+	groupDesc := res.Groups[0]
+	if groupDesc.Err != sarama.ErrNoError {
+		a.resetCoordinator(desc.Group)
+		return NewErrQuery(groupDesc.Err, "failed to describe group")
+	}
+	if groupDesc.State == deadGroupState {
+		return NewErrQuery(ErrUnknownGroup, "failed to describe group: %s", desc.Group)
+	}
+	desc.Membership = config.GroupMembershipKafka
+	desc.State = groupDesc.State
+
+	for memberID, memberDesc := range groupDesc.Members {
+		member := GroupMember{
+			ID:         memberID,
+			ClientID:   memberDesc.ClientId,
+			ClientHost: memberDesc.ClientHost,
+		}
+		// Metadata and assignments of other protocol types, e.g. that of
+		// Kafka Connect, have a different format.
+		if groupDesc.ProtocolType != consumerProtocolType {
+			desc.Members = append(desc.Members, member)
+			continue
+		}
+		if len(memberDesc.MemberMetadata) > 0 {
+			joinRes := sarama.JoinGroupResponse{Members: map[string][]byte{memberID: memberDesc.MemberMetadata}}
+			metadata, err := joinRes.GetMembers()
+			if err != nil {
+				return NewErrQuery(err, "invalid group member metadata: id=%s", memberID)
+			}
+			member.Topics = metadata[memberID].Topics
+			sort.Strings(member.Topics)
+		}
+		// Assignments are empty while the group is rebalancing.
+		if groupDesc.Protocol != kafkaPixyProtocol && len(memberDesc.MemberAssignment) > 0 {
+			syncRes := sarama.SyncGroupResponse{MemberAssignment: memberDesc.MemberAssignment}
+			assignment, err := syncRes.GetMemberAssignment()
+			if err != nil {
+				return NewErrQuery(err, "invalid group member assignment: id=%s", memberID)
+			}
+			member.Assignment = assignment.Topics
+			for _, partitions := range member.Assignment {
+				sort.Sort(int32Slice(partitions))
+			}
+		}
+		desc.Members = append(desc.Members, member)
+	}
+	return nil
+}
+
+// withMetadata calls the function after refreshing cluster metadata cached by
+// the Kafka client, if it is older than `Config.Admin.MetadataTTL`. If the
+// function fails, then metadata is refreshed regardless of its age, and the
+// function is called once again, unless the error is certainly not caused by
+// stale metadata.
+func (a *T) withMetadata(fn func() error) error {
+	if err := a.refreshMetadata(false); err != nil {
+		return err
+	}
+	err := fn()
+	if err == nil || !isMetadataRelated(err) {
+		return err
+	}
+	if err := a.refreshMetadata(true); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// refreshMetadata refreshes metadata of all topics if forced, or if metadata
+// is older than `Config.Admin.MetadataTTL`. Concurrent callers wait for the
+// same refresh rather than make their own.
+func (a *T) refreshMetadata(force bool) error {
+	requestedAt := time.Now()
+	a.metadataLock.Lock()
+	defer a.metadataLock.Unlock()
+	// Metadata might have been refreshed while we were waiting for the lock.
+	if a.metadataRefreshedAt.After(requestedAt) ||
+		(!force && time.Since(a.metadataRefreshedAt) < a.cfg.Admin.MetadataTTL) {
+		return nil
+	}
+	if err := a.kafkaClt.RefreshMetadata(); err != nil {
+		return NewErrQuery(err, "failed to refresh metadata")
+	}
+	a.metadataRefreshedAt = time.Now()
+	return nil
+}
+
+// invalidateMetadata makes the next query refresh metadata regardless of its
+// age. It is called after changes to topics made via ZooKeeper.
+func (a *T) invalidateMetadata() {
+	a.metadataLock.Lock()
+	a.metadataRefreshedAt = time.Time{}
+	a.metadataLock.Unlock()
+}
+
+// resetCoordinator makes the Kafka client look up the coordinator of the
+// group anew, for a request to the group coordinator may have failed because
+// the coordinator has moved. An error is ignored, for the original error is
+// reported to the caller anyway.
+func (a *T) resetCoordinator(group string) {
+	a.kafkaClt.RefreshCoordinator(group)
+}
+
+// broker returns a connection to the specified broker, establishing one if
+// there is none yet.
+func (a *T) broker(bm BrokerMetadata) (*sarama.Broker, error) {
+	a.brokersLock.Lock()
+	defer a.brokersLock.Unlock()
+	if broker := a.brokers[bm.ID]; broker != nil {
+		if broker.Addr() == bm.Addr {
+			return broker, nil
+		}
+		// The broker has been restarted with another address.
+		broker.Close()
+		delete(a.brokers, bm.ID)
+	}
+	broker := sarama.NewBroker(bm.Addr)
+	if err := broker.Open(a.saramaConfig()); err != nil {
+		return nil, err
+	}
+	a.brokers[bm.ID] = broker
+	return broker, nil
+}
+
+// closeBroker closes a connection to a broker that a request has failed on,
+// so that the next request establishes a new one.
+func (a *T) closeBroker(brokerID int32, broker *sarama.Broker) {
+	a.brokersLock.Lock()
+	defer a.brokersLock.Unlock()
+	if a.brokers[brokerID] == broker {
+		delete(a.brokers, brokerID)
+	}
+	broker.Close()
+}
+
 // saramaConfig generates a `Shopify/sarama` library config.
 func (a *T) saramaConfig() *sarama.Config {
 	saramaConfig := sarama.NewConfig()
@@ -283,6 +1301,53 @@ func (a *T) saramaConfig() *sarama.Config {
 	return saramaConfig
 }
 
+// commitOffsets commits offsets of partitions of any number of topics with a
+// single request to the group coordinator.
+// commitOffsets commits offsets with a single request to the group
+// coordinator, and returns the offsets that have been committed. If the
+// coordinator rejects some of them, then the error of the first rejected
+// partition is returned along with the offsets committed nonetheless.
+func (a *T) commitOffsets(group string, offsets map[string][]PartitionOffset) (map[string][]PartitionOffset, error) {
+	coordinator, err := a.kafkaClt.Coordinator(group)
+	if err != nil {
+		return nil, NewErrQuery(err, "failed to get coordinator")
+	}
+
+	req := sarama.OffsetCommitRequest{
+		Version:                 ProtocolVer1,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+	}
+	for topic, topicOffsets := range offsets {
+		for _, po := range topicOffsets {
+			req.AddBlock(topic, po.Partition, po.Offset, sarama.ReceiveTime, po.Metadata)
+		}
+	}
+	res, err := coordinator.CommitOffset(&req)
+	if err != nil {
+		a.resetCoordinator(group)
+		return nil, NewErrQuery(err, "failed to commit offsets")
+	}
+	committed := make(map[string][]PartitionOffset, len(offsets))
+	var firstErr error
+	for topic, topicOffsets := range offsets {
+		for _, po := range topicOffsets {
+			kerr := res.Errors[topic][po.Partition]
+			if kerr == sarama.ErrNoError {
+				committed[topic] = append(committed[topic], po)
+				continue
+			}
+			if kerr == sarama.ErrNotCoordinatorForConsumer {
+				a.resetCoordinator(group)
+			}
+			if firstErr == nil {
+				firstErr = NewErrQuery(kerr, "failed to commit offset: topic=%s, partition=%d", topic, po.Partition)
+			}
+		}
+	}
+	return committed, firstErr
+}
+
 func getOffsetResult(res *sarama.OffsetResponse, topic string, partition int32) (int64, error) {
 	block := res.GetBlock(topic, partition)
 	if block == nil {
@@ -297,8 +1362,130 @@ func getOffsetResult(res *sarama.OffsetResponse, topic string, partition int32)
 	return block.Offsets[0], nil
 }
 
+// topicAssignmentNode is the structure of a `/brokers/topics/<topic>` node.
+type topicAssignmentNode struct {
+	Version    int                `json:"version"`
+	Partitions map[string][]int32 `json:"partitions"`
+}
+
+// topicConfigNode is the structure of a `/config/topics/<topic>` node.
+type topicConfigNode struct {
+	Version int               `json:"version"`
+	Config  map[string]string `json:"config"`
+}
+
+// assignReplicas assigns replicas of the specified number of partitions,
+// starting with `startPartition`, to brokers. It is a port of the rack unaware
+// algorithm of Kafka `AdminUtils.assignReplicasToBrokers`: first replicas
+// are assigned to brokers in the round-robin fashion starting with the
+// broker at `startIndex`, and the other replicas of a partition follow its
+// first replica with a shift that increases every time all brokers have got
+// a first replica. That spreads both leaders and followers evenly.
+func assignReplicas(brokerIDs []int32, startPartition int32, partitions, replicationFactor, startIndex, replicaShift int) map[int32][]int32 {
+	brokerCount := len(brokerIDs)
+	assignment := make(map[int32][]int32, partitions)
+	for i := 0; i < partitions; i++ {
+		partition := startPartition + int32(i)
+		if partition > 0 && int(partition)%brokerCount == 0 {
+			replicaShift++
+		}
+		firstReplicaIndex := (int(partition) + startIndex) % brokerCount
+		replicas := []int32{brokerIDs[firstReplicaIndex]}
+		for j := 0; j < replicationFactor-1; j++ {
+			shift := 1 + (replicaShift+j)%(brokerCount-1)
+			replicas = append(replicas, brokerIDs[(firstReplicaIndex+shift)%brokerCount])
+		}
+		assignment[partition] = replicas
+	}
+	return assignment
+}
+
+func encodeAssignment(assignment map[int32][]int32) []byte {
+	node := topicAssignmentNode{Version: 1, Partitions: make(map[string][]int32, len(assignment))}
+	for partition, replicas := range assignment {
+		node.Partitions[strconv.Itoa(int(partition))] = replicas
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		// Must never happen.
+		panic(err)
+	}
+	return data
+}
+
+func decodeAssignment(data []byte) (map[int32][]int32, error) {
+	var node topicAssignmentNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	assignment := make(map[int32][]int32, len(node.Partitions))
+	for partitionStr, replicas := range node.Partitions {
+		partition, err := strconv.Atoi(partitionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition: %s", partitionStr)
+		}
+		assignment[int32(partition)] = replicas
+	}
+	return assignment, nil
+}
+
+// isMetadataRelated tells whether an error returned by a query might be
+// caused by stale metadata.
+func isMetadataRelated(err error) bool {
+	if err, ok := err.(ErrQuery); ok {
+		switch err.Cause() {
+		case ErrUnknownGroup, ErrInvalidTopicParams, ErrTopicExists:
+			return false
+		}
+	}
+	return true
+}
+
+func isValidTopicName(topic string) bool {
+	return len(topic) <= maxTopicNameLen && topic != "." && topic != ".." && topicNameRE.MatchString(topic)
+}
+
 type int32Slice []int32
 
 func (p int32Slice) Len() int           { return len(p) }
 func (p int32Slice) Less(i, j int) bool { return p[i] < p[j] }
 func (p int32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (p int32Slice) contains(v int32) bool {
+	for _, x := range p {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+type partitionOffsetSlice []PartitionOffset
+
+func (p partitionOffsetSlice) Len() int           { return len(p) }
+func (p partitionOffsetSlice) Less(i, j int) bool { return p[i].Partition < p[j].Partition }
+func (p partitionOffsetSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type partitionMetadataSlice []PartitionMetadata
+
+func (p partitionMetadataSlice) Len() int           { return len(p) }
+func (p partitionMetadataSlice) Less(i, j int) bool { return p[i].Partition < p[j].Partition }
+func (p partitionMetadataSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type brokerMetadataSlice []BrokerMetadata
+
+func (p brokerMetadataSlice) Len() int           { return len(p) }
+func (p brokerMetadataSlice) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p brokerMetadataSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type groupSummarySlice []GroupSummary
+
+func (p groupSummarySlice) Len() int           { return len(p) }
+func (p groupSummarySlice) Less(i, j int) bool { return p[i].Group < p[j].Group }
+func (p groupSummarySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type groupMemberSlice []GroupMember
+
+func (p groupMemberSlice) Len() int           { return len(p) }
+func (p groupMemberSlice) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p groupMemberSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,225 @@
+package admin
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+)
+
+const (
+	benchGroup      = "g1"
+	benchTopic      = "test.4"
+	benchPartitions = 4
+)
+
+// The benchmarks below compare the admin sharing Kafka and ZooKeeper clients
+// between calls against an admin spawned for every call, which is what every
+// call used to cost. All connections go through counting proxies, so the
+// number of connections established per operation is logged along with the
+// usual metrics, run with `-v` to see it.
+
+func BenchmarkGetGroupOffsetsShared(b *testing.B) {
+	kafkaProxy, zookeeperProxy, cleanup := setUpBenchCluster(b)
+	defer cleanup()
+	a, err := Spawn(benchConfig(kafkaProxy, zookeeperProxy))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer a.Stop()
+
+	kafkaProxy.reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := a.GetGroupOffsets(benchGroup, benchTopic); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.Logf("kafka-conns/op: %.2f", kafkaProxy.perOp(b.N))
+}
+
+func BenchmarkGetGroupOffsetsPerCall(b *testing.B) {
+	kafkaProxy, zookeeperProxy, cleanup := setUpBenchCluster(b)
+	defer cleanup()
+	cfg := benchConfig(kafkaProxy, zookeeperProxy)
+
+	kafkaProxy.reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a, err := Spawn(cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := a.GetGroupOffsets(benchGroup, benchTopic); err != nil {
+			b.Fatal(err)
+		}
+		a.Stop()
+	}
+	b.StopTimer()
+	b.Logf("kafka-conns/op: %.2f", kafkaProxy.perOp(b.N))
+}
+
+func BenchmarkGetTopicConsumersShared(b *testing.B) {
+	kafkaProxy, zookeeperProxy, cleanup := setUpBenchCluster(b)
+	defer cleanup()
+	a, err := Spawn(benchConfig(kafkaProxy, zookeeperProxy))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer a.Stop()
+
+	zookeeperProxy.reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := a.GetTopicConsumers(benchGroup, benchTopic); err != nil {
+			if _, ok := err.(ErrInvalidParam); !ok {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.Logf("zk-conns/op: %.2f", zookeeperProxy.perOp(b.N))
+}
+
+func BenchmarkGetTopicConsumersPerCall(b *testing.B) {
+	kafkaProxy, zookeeperProxy, cleanup := setUpBenchCluster(b)
+	defer cleanup()
+	cfg := benchConfig(kafkaProxy, zookeeperProxy)
+
+	zookeeperProxy.reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a, err := Spawn(cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := a.GetTopicConsumers(benchGroup, benchTopic); err != nil {
+			if _, ok := err.(ErrInvalidParam); !ok {
+				b.Fatal(err)
+			}
+		}
+		a.Stop()
+	}
+	b.StopTimer()
+	b.Logf("zk-conns/op: %.2f", zookeeperProxy.perOp(b.N))
+}
+
+// setUpBenchCluster starts a mock Kafka broker that serves offsets of
+// `benchTopic` and puts counting proxies in front of it and of the first
+// ZooKeeper peer of the test cluster. The mock broker advertises the proxy
+// address, so that all Kafka connections are counted.
+func setUpBenchCluster(b *testing.B) (*countingProxy, *countingProxy, func()) {
+	broker := sarama.NewMockBroker(b, 1)
+	kafkaProxy := newCountingProxy(b, broker.Addr())
+	zookeeperProxy := newCountingProxy(b, testhelpers.ZookeeperPeers[0])
+
+	host, portStr, err := net.SplitHostPort(kafkaProxy.addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	metadataRes := sarama.NewMockMetadataResponse(b).SetBroker(kafkaProxy.addr(), broker.BrokerID())
+	offsetRes := sarama.NewMockOffsetResponse(b)
+	offsetFetchRes := sarama.NewMockOffsetFetchResponse(b)
+	for p := int32(0); p < benchPartitions; p++ {
+		metadataRes.SetLeader(benchTopic, p, broker.BrokerID())
+		offsetRes.SetOffset(benchTopic, p, sarama.OffsetOldest, 0)
+		offsetRes.SetOffset(benchTopic, p, sarama.OffsetNewest, 100)
+		offsetFetchRes.SetOffset(benchGroup, benchTopic, p, 50, "", sarama.ErrNoError)
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadataRes,
+		"OffsetRequest":   offsetRes,
+		"ConsumerMetadataRequest": sarama.NewMockWrapper(&sarama.ConsumerMetadataResponse{
+			CoordinatorID:   broker.BrokerID(),
+			CoordinatorHost: host,
+			CoordinatorPort: int32(port),
+		}),
+		"OffsetFetchRequest": offsetFetchRes,
+	})
+	return kafkaProxy, zookeeperProxy, func() {
+		zookeeperProxy.close()
+		kafkaProxy.close()
+		broker.Close()
+	}
+}
+
+func benchConfig(kafkaProxy, zookeeperProxy *countingProxy) *config.T {
+	cfg := config.Default()
+	cfg.Kafka.SeedPeers = []string{kafkaProxy.addr()}
+	cfg.ZooKeeper.SeedPeers = []string{zookeeperProxy.addr()}
+	return cfg
+}
+
+// countingProxy forwards TCP connections to a target address and counts
+// connections accepted since the last reset.
+type countingProxy struct {
+	listener net.Listener
+	target   string
+	accepted int64
+	wg       sync.WaitGroup
+}
+
+func newCountingProxy(b *testing.B, target string) *countingProxy {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cp := &countingProxy{listener: listener, target: target}
+	cp.wg.Add(1)
+	go cp.serve()
+	return cp
+}
+
+func (cp *countingProxy) addr() string {
+	return cp.listener.Addr().String()
+}
+
+func (cp *countingProxy) reset() {
+	atomic.StoreInt64(&cp.accepted, 0)
+}
+
+func (cp *countingProxy) perOp(n int) float64 {
+	return float64(atomic.LoadInt64(&cp.accepted)) / float64(n)
+}
+
+func (cp *countingProxy) close() {
+	cp.listener.Close()
+	cp.wg.Wait()
+}
+
+func (cp *countingProxy) serve() {
+	defer cp.wg.Done()
+	for {
+		downstream, err := cp.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&cp.accepted, 1)
+		upstream, err := net.Dial("tcp", cp.target)
+		if err != nil {
+			downstream.Close()
+			continue
+		}
+		go pipe(upstream, downstream)
+		go pipe(downstream, upstream)
+	}
+}
+
+// pipe copies data from src to dst and closes both connections once either
+// side is done.
+func pipe(dst, src net.Conn) {
+	io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
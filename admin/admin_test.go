@@ -1,9 +1,14 @@
 package admin
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/testhelpers"
@@ -110,3 +115,444 @@ func (s *AdminSuite) TestSetOffsetsPartialUpdate(c *C) {
 
 	a.Stop()
 }
+
+// Time offsets for the oldest and newest special times are the beginning and
+// the end of partition ranges respectively.
+func (s *AdminSuite) TestGetTimeOffsets(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	s.kh.PutMessages("time_offsets", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+	offsets, err := a.GetGroupOffsets("foo", "test.4")
+	c.Assert(err, IsNil)
+
+	// When
+	oldest, err := a.GetTimeOffsets("test.4", sarama.OffsetOldest)
+	c.Assert(err, IsNil)
+	newest, err := a.GetTimeOffsets("test.4", sarama.OffsetNewest)
+	c.Assert(err, IsNil)
+
+	// Then
+	for i := 0; i < 4; i++ {
+		c.Assert(oldest[i].Partition, Equals, int32(i))
+		c.Assert(oldest[i].Offset, Equals, offsets[i].Begin)
+		c.Assert(newest[i].Partition, Equals, int32(i))
+		c.Assert(newest[i].Offset, Equals, offsets[i].End)
+	}
+}
+
+// An attempt to get time offsets of a topic that does not exist fails.
+func (s *AdminSuite) TestGetTimeOffsetsNoSuchTopic(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	offsets, err := a.GetTimeOffsets("no_such_topic", sarama.OffsetNewest)
+
+	// Then
+	c.Assert(offsets, IsNil)
+	c.Assert(err.(ErrQuery).Cause(), Equals, sarama.ErrUnknownTopicOrPartition)
+}
+
+// All topics of the cluster are listed in sorted order.
+func (s *AdminSuite) TestGetTopics(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	topics, err := a.GetTopics()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(sort.StringsAreSorted(topics), Equals, true)
+	for _, topic := range []string{"test.1", "test.4", "test.64"} {
+		i := sort.SearchStrings(topics, topic)
+		c.Assert(i < len(topics) && topics[i] == topic, Equals, true, Commentf("topic %s", topic))
+	}
+}
+
+// Every partition of a topic is reported with its leader and replicas.
+func (s *AdminSuite) TestGetTopicMetadata(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	partitions, err := a.GetTopicMetadata("test.4")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(len(partitions), Equals, 4)
+	for i, pm := range partitions {
+		c.Assert(pm.Partition, Equals, int32(i))
+		c.Assert(pm.Replicas, Not(HasLen), 0)
+		c.Assert(pm.ISR, Not(HasLen), 0)
+		c.Assert(int32Slice(pm.Replicas).contains(pm.Leader), Equals, true)
+	}
+}
+
+// An attempt to get metadata of a topic that does not exist fails.
+func (s *AdminSuite) TestGetTopicMetadataNoSuchTopic(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	partitions, err := a.GetTopicMetadata("no_such_topic")
+
+	// Then
+	c.Assert(partitions, IsNil)
+	c.Assert(err.(ErrQuery).Cause(), Equals, sarama.ErrUnknownTopicOrPartition)
+}
+
+// Brokers are listed along with the controller that is one of them.
+func (s *AdminSuite) TestGetBrokers(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	brokers, controllerID, err := a.GetBrokers()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(brokers, Not(HasLen), 0)
+	var brokerIDs int32Slice
+	for _, broker := range brokers {
+		c.Assert(broker.Addr, Not(Equals), "")
+		brokerIDs = append(brokerIDs, broker.ID)
+	}
+	c.Assert(brokerIDs.contains(controllerID), Equals, true)
+}
+
+// A topic can be created, expanded and deleted.
+func (s *AdminSuite) TestCreateAddPartitionsDelete(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	topic := fmt.Sprintf("admin.created.%d", time.Now().UnixNano())
+
+	// When
+	err = a.CreateTopic(topic, 3, 1, map[string]string{"retention.ms": "3600000"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.waitPartitions(a, topic, 3), Equals, true)
+
+	// When
+	err = a.AddPartitions(topic, 5)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(s.waitPartitions(a, topic, 5), Equals, true)
+
+	// When
+	err = a.DeleteTopic(topic)
+
+	// Then
+	c.Assert(err, IsNil)
+}
+
+// An attempt to create a topic that already exists fails.
+func (s *AdminSuite) TestCreateTopicExists(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	err = a.CreateTopic("test.4", 4, 1, nil)
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrTopicExists)
+}
+
+// Topics with invalid parameters are not created.
+func (s *AdminSuite) TestCreateTopicInvalid(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	for i, tc := range []struct {
+		topic             string
+		partitions        int
+		replicationFactor int
+	}{
+		{topic: "bad/name", partitions: 1, replicationFactor: 1},
+		{topic: "..", partitions: 1, replicationFactor: 1},
+		{topic: "admin.invalid", partitions: 0, replicationFactor: 1},
+		{topic: "admin.invalid", partitions: 1, replicationFactor: 0},
+		{topic: "admin.invalid", partitions: 1, replicationFactor: 1000},
+	} {
+		// When
+		err = a.CreateTopic(tc.topic, tc.partitions, tc.replicationFactor, nil)
+
+		// Then
+		c.Assert(err.(ErrQuery).Cause(), Equals, ErrInvalidTopicParams, Commentf("case #%d", i))
+	}
+}
+
+// The number of partitions cannot be decreased.
+func (s *AdminSuite) TestAddPartitionsDecrease(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	err = a.AddPartitions("test.4", 2)
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrInvalidTopicParams)
+}
+
+// An attempt to delete a topic that does not exist fails.
+func (s *AdminSuite) TestDeleteTopicNoSuchTopic(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	err = a.DeleteTopic("no_such_topic")
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, sarama.ErrUnknownTopicOrPartition)
+}
+
+// Groups are listed in sorted order.
+func (s *AdminSuite) TestGetGroups(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	groups, err := a.GetGroups()
+
+	// Then
+	c.Assert(err, IsNil)
+	for i := 1; i < len(groups); i++ {
+		c.Assert(groups[i-1].Group < groups[i].Group, Equals, true)
+	}
+}
+
+// An attempt to describe a group that does not exist fails.
+func (s *AdminSuite) TestGetGroupNoSuchGroup(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+
+	// When
+	desc, err := a.GetGroup("no_such_group")
+
+	// Then
+	c.Assert(desc, IsNil)
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrUnknownGroup)
+}
+
+// Offsets exported from one group and imported to another one are committed
+// for the other group, and so are copied offsets.
+func (s *AdminSuite) TestExportImportCopyOffsets(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	suffix := time.Now().UnixNano()
+	srcGroup := fmt.Sprintf("export.src.%d", suffix)
+	dstGroup := fmt.Sprintf("export.dst.%d", suffix)
+	copyGroup := fmt.Sprintf("export.copy.%d", suffix)
+	c.Assert(a.SetGroupOffsets(srcGroup, "test.4", []PartitionOffset{
+		{Partition: 0, Offset: 1001, Metadata: "A1"},
+		{Partition: 2, Offset: 1003, Metadata: "A3"},
+	}), IsNil)
+
+	// When
+	exported, err := a.ExportGroupOffsets(srcGroup)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(exported, DeepEquals, map[string][]PartitionOffset{
+		"test.4": {
+			{Partition: 0, Offset: 1001, Metadata: "A1"},
+			{Partition: 2, Offset: 1003, Metadata: "A3"},
+		},
+	})
+
+	// When
+	committed, err := a.ImportGroupOffsets(dstGroup, exported)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(committed, DeepEquals, exported)
+	imported, err := a.ExportGroupOffsets(dstGroup)
+	c.Assert(err, IsNil)
+	c.Assert(imported, DeepEquals, exported)
+
+	// When
+	copied, err := a.CopyGroupOffsets(srcGroup, copyGroup)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(copied, DeepEquals, exported)
+	offsets, err := a.GetGroupOffsets(copyGroup, "test.4")
+	c.Assert(err, IsNil)
+	c.Assert(offsets[0].Offset, Equals, int64(1001))
+	c.Assert(offsets[2].Offset, Equals, int64(1003))
+	c.Assert(offsets[2].Metadata, Equals, "A3")
+}
+
+// If any of imported partitions does not exist, then no offsets are
+// committed at all.
+func (s *AdminSuite) TestImportOffsetsInvalidPartition(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	group := fmt.Sprintf("import.invalid.%d", time.Now().UnixNano())
+
+	// When
+	committed, err := a.ImportGroupOffsets(group, map[string][]PartitionOffset{
+		"test.1": {{Partition: 0, Offset: 1001}},
+		"test.4": {{Partition: 4, Offset: 1005}},
+	})
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, sarama.ErrUnknownTopicOrPartition)
+	c.Assert(committed, IsNil)
+	exported, err := a.ExportGroupOffsets(group)
+	c.Assert(err, IsNil)
+	c.Assert(exported, HasLen, 0)
+}
+
+// If the coordinator rejects some of the imported offsets, then the others
+// are committed nonetheless and returned along with the error.
+func (s *AdminSuite) TestImportOffsetsPartialFailure(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	group := fmt.Sprintf("import.partial.%d", time.Now().UnixNano())
+	// Kafka rejects offset metadata larger than `offset.metadata.max.bytes`,
+	// that is 4096 by default.
+	tooLarge := strings.Repeat("x", 5000)
+
+	// When
+	committed, err := a.ImportGroupOffsets(group, map[string][]PartitionOffset{
+		"test.4": {{Partition: 0, Offset: 1001}, {Partition: 1, Offset: 1002, Metadata: tooLarge}},
+	})
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, sarama.ErrOffsetMetadataTooLarge)
+	c.Assert(committed, DeepEquals, map[string][]PartitionOffset{
+		"test.4": {{Partition: 0, Offset: 1001}},
+	})
+	exported, err := a.ExportGroupOffsets(group)
+	c.Assert(err, IsNil)
+	c.Assert(exported, DeepEquals, committed)
+}
+
+// Offsets are validated before anything is committed.
+func (s *AdminSuite) TestImportOffsetsInvalidOffset(c *C) {
+	// Given
+	a, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	group := fmt.Sprintf("import.negative.%d", time.Now().UnixNano())
+
+	// When
+	committed, err := a.ImportGroupOffsets(group, map[string][]PartitionOffset{
+		"test.1": {{Partition: 0, Offset: 1001}},
+		"test.4": {{Partition: 1, Offset: -1}},
+	})
+
+	// Then
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrInvalidOffset)
+	c.Assert(committed, IsNil)
+	exported, err := a.ExportGroupOffsets(group)
+	c.Assert(err, IsNil)
+	c.Assert(exported, HasLen, 0)
+}
+
+// If ZooKeeper is not configured, then the admin does not connect to it, and
+// only operations that require ZooKeeper fail.
+func (s *AdminSuite) TestNoZooKeeper(c *C) {
+	// Given
+	cfg := *s.cfg
+	cfg.ZooKeeper.SeedPeers = nil
+
+	// When
+	a, err := Spawn(&cfg)
+
+	// Then
+	c.Assert(err, IsNil)
+	defer a.Stop()
+	topics, err := a.GetTopics()
+	c.Assert(err, IsNil)
+	c.Assert(len(topics) > 0, Equals, true)
+	_, err = a.GetGroups()
+	c.Assert(err, IsNil)
+	_, err = a.GetTopicConsumers("g1", "test.4")
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrNoZooKeeper)
+	_, _, err = a.GetBrokers()
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrNoZooKeeper)
+	err = a.CreateTopic("admin.no.zookeeper", 1, 1, nil)
+	c.Assert(err.(ErrQuery).Cause(), Equals, ErrNoZooKeeper)
+}
+
+// Replicas are assigned the same way Kafka does it.
+func (s *AdminSuite) TestAssignReplicas(c *C) {
+	// When
+	assignment := assignReplicas([]int32{0, 1, 2, 3, 4}, 0, 10, 3, 0, 0)
+
+	// Then
+	c.Assert(assignment, DeepEquals, map[int32][]int32{
+		0: {0, 1, 2},
+		1: {1, 2, 3},
+		2: {2, 3, 4},
+		3: {3, 4, 0},
+		4: {4, 0, 1},
+		5: {0, 2, 3},
+		6: {1, 3, 4},
+		7: {2, 4, 0},
+		8: {3, 0, 1},
+		9: {4, 1, 2},
+	})
+}
+
+// Replicas of added partitions continue the existing assignment.
+func (s *AdminSuite) TestAssignReplicasAdded(c *C) {
+	// When
+	assignment := assignReplicas([]int32{10, 20, 30}, 2, 3, 2, 1, 1)
+
+	// Then
+	c.Assert(assignment, DeepEquals, map[int32][]int32{
+		2: {10, 30},
+		3: {20, 30},
+		4: {30, 10},
+	})
+}
+
+// waitPartitions waits for the topic to have the specified number of
+// partitions, for the controller creates them asynchronously.
+func (s *AdminSuite) waitPartitions(a *T, topic string, count int) bool {
+	for i := 0; i < 50; i++ {
+		partitions, err := a.GetTopicMetadata(topic)
+		if err == nil && len(partitions) == count {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
@@ -1,23 +1,33 @@
 package apiserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/prettyfmt"
 	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/kafka-pixy/producer/deadletter"
+	"github.com/mailgun/kafka-pixy/pusher"
 	"github.com/mailgun/log"
 	"github.com/mailgun/manners"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
@@ -29,10 +39,29 @@ const (
 	headerContentType   = "Content-Type"
 
 	// HTTP request parameters.
-	paramTopic = "topic"
-	paramKey   = "key"
-	paramSync  = "sync"
-	paramGroup = "group"
+	paramTopic           = "topic"
+	paramPattern         = "pattern"
+	paramKey             = "key"
+	paramSync            = "sync"
+	paramGroup           = "group"
+	paramExplicitAck     = "explicitAck"
+	paramPartition       = "partition"
+	paramOffset          = "offset"
+	paramMax             = "max"
+	paramMaxWait         = "maxWait"
+	paramTo              = "to"
+	paramLimit           = "limit"
+	paramFormat          = "format"
+	paramAutoOffsetReset = "autoOffsetReset"
+	paramVerbose         = "verbose"
+
+	// Formats of messages pushed by the consume stream.
+	streamFormatNDJSON = "ndjson"
+	streamFormatSSE    = "sse"
+
+	// Values of the `to` parameter of an offset reset request.
+	resetToOldest = "oldest"
+	resetToNewest = "newest"
 )
 
 var (
@@ -47,13 +76,15 @@ type T struct {
 	prod       *producer.T
 	cons       consumer.T
 	admin      *admin.T
+	pusher     *pusher.T
+	stopCh     chan none.T
 	errorCh    chan error
 }
 
 // New creates an HTTP server instance that will accept API requests at the
 // specified `network`/`address` and execute them with the specified `producer`,
-// `consumer`, or `admin`, depending on the request type.
-func New(network, addr string, prod *producer.T, cons consumer.T, admin *admin.T) (*T, error) {
+// `consumer`, `admin`, or `pusher`, depending on the request type.
+func New(network, addr string, prod *producer.T, cons consumer.T, admin *admin.T, pusher *pusher.T) (*T, error) {
 	// Start listening on the specified network/address.
 	listener, err := net.Listen(network, addr)
 	if err != nil {
@@ -62,6 +93,7 @@ func New(network, addr string, prod *producer.T, cons consumer.T, admin *admin.T
 	// If the address is Unix Domain Socket then make it accessible for everyone.
 	if network == NetworkUnix {
 		if err := os.Chmod(addr, 0777); err != nil {
+			listener.Close()
 			return nil, fmt.Errorf("failed to change socket permissions, err=(%s)", err)
 		}
 	}
@@ -76,20 +108,64 @@ func New(network, addr string, prod *producer.T, cons consumer.T, admin *admin.T
 		prod:       prod,
 		cons:       cons,
 		admin:      admin,
+		pusher:     pusher,
+		stopCh:     make(chan none.T),
 		errorCh:    make(chan error, 1),
 	}
 	// Configure the API request handlers.
+	router.HandleFunc("/topics", as.handleGetTopics).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}", paramTopic),
+		as.handleGetTopicMetadata).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}", paramTopic),
+		as.handleCreateTopic).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}", paramTopic),
+		as.handleDeleteTopic).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions", paramTopic),
+		as.handleAddPartitions).Methods("POST")
+	router.HandleFunc("/brokers", as.handleGetBrokers).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", paramTopic),
 		as.handleProduce).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages/batch", paramTopic),
+		as.handleProduceBatch).Methods("POST")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages", paramTopic),
 		as.handleConsume).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/patterns/{%s}/messages", paramPattern),
+		as.handleConsumePattern).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/messages/stream", paramTopic),
+		as.handleConsumeStream).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/partitions/{%s}/messages", paramTopic, paramPartition),
+		as.handleRead).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/acks", paramTopic),
+		as.handleAck).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/nacks", paramTopic),
+		as.handleNack).Methods("POST")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", paramTopic),
 		as.handleGetOffsets).Methods("GET")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets", paramTopic),
 		as.handleSetOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/topics/{%s}/offsets/reset", paramTopic),
+		as.handleResetOffsets).Methods("POST")
 	router.HandleFunc(fmt.Sprintf("/topics/{%s}/consumers", paramTopic),
 		as.handleGetTopicConsumers).Methods("GET")
+	router.HandleFunc("/groups", as.handleGetGroups).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}", paramGroup),
+		as.handleGetGroup).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/offsets/export", paramGroup),
+		as.handleExportOffsets).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/offsets/import", paramGroup),
+		as.handleImportOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/offsets/copy", paramGroup),
+		as.handleCopyOffsets).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/topics/{%s}/pause", paramGroup, paramTopic),
+		as.handlePause).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/groups/{%s}/topics/{%s}/resume", paramGroup, paramTopic),
+		as.handleResume).Methods("POST")
+	router.HandleFunc("/ws", as.handleWebSocket).Methods("GET")
+	router.HandleFunc("/deadletters", as.handleListDeadLetters).Methods("GET")
+	router.HandleFunc("/deadletters/replay", as.handleReplayDeadLetters).Methods("POST")
+	router.HandleFunc("/push/subscriptions", as.handleListPushSubscriptions).Methods("GET")
 	router.HandleFunc("/_ping", as.handlePing).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	return as, nil
 }
 
@@ -111,10 +187,19 @@ func (as *T) ErrorCh() <-chan error {
 	return as.errorCh
 }
 
-// AsyncStop triggers HTTP API listener stop. If a caller wants to know when
-// the server terminates it should read from the `Error()` channel that will be
-// closed upon server termination.
+// Close releases the listener of a server that has not been started. It is
+// used to clean up if the service fails to spawn after the server was created.
+func (as *T) Close() {
+	as.listener.Close()
+}
+
+// AsyncStop triggers HTTP API listener stop. Consume streams in progress are
+// terminated, and the server waits for all other pending requests to
+// complete. If a caller wants to know when the server terminates it should
+// read from the `Error()` channel that will be closed upon server
+// termination.
 func (as *T) AsyncStop() {
+	close(as.stopCh)
 	as.httpServer.Close()
 }
 
@@ -154,7 +239,10 @@ func (as *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 
 	// Asynchronously submit the message to the Kafka cluster.
 	if !isSync {
-		as.prod.AsyncProduce(topic, toEncoderPreservingNil(key), sarama.StringEncoder(message))
+		if err := as.prod.AsyncProduce(topic, toEncoderPreservingNil(key), sarama.StringEncoder(message)); err != nil {
+			respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+			return
+		}
 		respondWithJSON(w, http.StatusOK, EmptyResponse)
 		return
 	}
@@ -178,8 +266,178 @@ func (as *T) handleProduce(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleProduceBatch is an HTTP request handler for
+// `POST /topic/{topic}/messages/batch`
+func (as *T) handleProduceBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	// Parameters are taken from the URL only, for `ParseForm` would consume
+	// the body if it is sent as `application/x-www-form-urlencoded`.
+	_, isSync := r.URL.Query()[paramSync]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to read the request: err=(%s)", err)
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		return
+	}
+	records, err := parseProduceBatch(body)
+	if err != nil {
+		errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		return
+	}
+	// Asynchronously submit the messages to the Kafka cluster.
+	if !isSync {
+		for i, record := range records {
+			if err := as.prod.AsyncProduce(topic, toEncoderPreservingNil(record.Key), sarama.ByteEncoder(record.Value)); err != nil {
+				errorText := fmt.Sprintf("Record #%d: %s", i, err)
+				respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{errorText})
+				return
+			}
+		}
+		respondWithJSON(w, http.StatusOK, EmptyResponse)
+		return
+	}
+
+	prodMsgs := make([]*sarama.ProducerMessage, len(records))
+	for i, record := range records {
+		prodMsgs[i] = &sarama.ProducerMessage{
+			Topic: topic,
+			Key:   toEncoderPreservingNil(record.Key),
+			Value: sarama.ByteEncoder(record.Value),
+		}
+	}
+	errs := as.prod.ProduceBatch(prodMsgs)
+	res := make([]interface{}, len(prodMsgs))
+	for i, prodMsg := range prodMsgs {
+		if errs[i] != nil {
+			res[i] = errorHTTPResponse{errs[i].Error()}
+			continue
+		}
+		res[i] = produceHTTPResponse{
+			Partition: prodMsg.Partition,
+			Offset:    prodMsg.Offset,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
 // handleConsume is an HTTP request handler for `GET /topic/{topic}/messages`
 func (as *T) handleConsume(w http.ResponseWriter, r *http.Request) {
+	as.serveConsume(w, r, mux.Vars(r)[paramTopic])
+}
+
+// handleConsumePattern is an HTTP request handler for
+// `GET /patterns/{pattern}/messages`. It consumes messages from all topics
+// that entirely match the pattern regular expression.
+func (as *T) handleConsumePattern(w http.ResponseWriter, r *http.Request) {
+	pattern := consumer.TopicPattern(mux.Vars(r)[paramPattern])
+	if _, err := consumer.CompileTopicPattern(pattern); err != nil {
+		errorText := fmt.Sprintf("Invalid %s: %s", paramPattern, err)
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		return
+	}
+	as.serveConsume(w, r, pattern)
+}
+
+// serveConsume serves a consume request for either a topic or a topic
+// pattern.
+func (as *T) serveConsume(w http.ResponseWriter, r *http.Request, topic string) {
+	defer r.Body.Close()
+
+	group, err := getGroupParam(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	if err := as.applyAutoOffsetResetParam(r, group); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	isExplicitAck, err := getBoolParam(r, paramExplicitAck)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	if _, isBatch := r.Form[paramMax]; isBatch {
+		as.handleConsumeBatch(w, r, group, topic, isExplicitAck)
+		return
+	}
+
+	var consMsg *consumer.Message
+	if isExplicitAck {
+		consMsg, err = as.cons.ConsumeExplicitAck(group, topic)
+	} else {
+		consMsg, err = as.cons.Consume(group, topic)
+	}
+	if err != nil {
+		status := consumeErrorStatus(err)
+		countConsumeRequest(group, topic, status)
+		respondWithJSON(w, status, errorHTTPResponse{err.Error()})
+		return
+	}
+	countConsumeRequest(group, topic, http.StatusOK)
+
+	respondWithJSON(w, http.StatusOK, toConsumeHTTPResponse(topic, consMsg))
+}
+
+// handleConsumeBatch handles `GET /topic/{topic}/messages` requests that have
+// the `max` parameter specified.
+func (as *T) handleConsumeBatch(w http.ResponseWriter, r *http.Request, group, topic string, isExplicitAck bool) {
+	max, err := getIntParam(r, paramMax, 32)
+	if err == nil && max < 1 {
+		err = fmt.Errorf("Invalid %s: %d", paramMax, max)
+	}
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	var maxWait time.Duration
+	if values := r.Form[paramMaxWait]; len(values) > 0 {
+		if maxWait, err = time.ParseDuration(values[0]); err != nil || maxWait < 0 {
+			errorText := fmt.Sprintf("Invalid %s: %s", paramMaxWait, values[0])
+			respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+			return
+		}
+	}
+
+	var consMsgs []*consumer.Message
+	if isExplicitAck {
+		consMsgs, err = as.cons.ConsumeBatchExplicitAck(group, topic, int(max), maxWait)
+	} else {
+		consMsgs, err = as.cons.ConsumeBatch(group, topic, int(max), maxWait)
+	}
+	if err != nil {
+		status := consumeErrorStatus(err)
+		countConsumeRequest(group, topic, status)
+		respondWithJSON(w, status, errorHTTPResponse{err.Error()})
+		return
+	}
+	countConsumeRequest(group, topic, http.StatusOK)
+
+	res := make([]consumeHTTPResponse, len(consMsgs))
+	for i, consMsg := range consMsgs {
+		res[i] = toConsumeHTTPResponse(topic, consMsg)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleConsumeStream is an HTTP request handler for
+// `GET /topic/{topic}/messages/stream`. It keeps the connection open and
+// pushes messages to the client as they are consumed, either as newline
+// delimited JSON documents or as Server-Sent Events. The next message is not
+// consumed until the previous one is written to the connection, so a client
+// that does not keep up with the stream slows it down via TCP backpressure.
+//
+// Messages are always consumed in the explicit acknowledgement mode. Unless
+// the client asked for it, a message is acknowledged by the handler as soon
+// as it is written to the connection, so a message that could not be written
+// is consumed again when its acknowledgement timeout expires.
+func (as *T) handleConsumeStream(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	topic := mux.Vars(r)[paramTopic]
@@ -188,28 +446,189 @@ func (as *T) handleConsume(w http.ResponseWriter, r *http.Request) {
 		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
 		return
 	}
+	if err := as.applyAutoOffsetResetParam(r, group); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	isExplicitAck, err := getBoolParam(r, paramExplicitAck)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	format, err := getStreamFormatParam(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorText := "Streaming is not supported by the connection"
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{errorText})
+		return
+	}
+	var closedCh <-chan bool
+	if closeNotifier, ok := w.(http.CloseNotifier); ok {
+		closedCh = closeNotifier.CloseNotify()
+	}
+
+	if format == streamFormatSSE {
+		w.Header().Add(headerContentType, "text/event-stream")
+		w.Header().Add("Cache-Control", "no-cache")
+	} else {
+		w.Header().Add(headerContentType, "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-closedCh:
+			return
+		case <-as.stopCh:
+			return
+		default:
+		}
+		// Every consume request resets the registration timeout of the
+		// consumer group and topic, so they stay registered for as long as
+		// the stream is attached.
+		consMsg, err := as.cons.ConsumeExplicitAck(group, topic)
+		if err != nil {
+			delay, ok := consumer.RepeatAfter(err)
+			if !ok {
+				countConsumeRequest(group, topic, consumeErrorStatus(err))
+				writeStreamEvent(w, format, "error", errorHTTPResponse{err.Error()})
+				return
+			}
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-closedCh:
+					return
+				case <-as.stopCh:
+					return
+				}
+				continue
+			}
+			// Keep the connection alive through proxies while waiting.
+			if format == streamFormatSSE {
+				if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+			continue
+		}
+		countConsumeRequest(group, topic, http.StatusOK)
+
+		if err := writeStreamEvent(w, format, "message", toConsumeHTTPResponse(topic, consMsg)); err != nil {
+			log.Infof("<%s> stream closed with a message not sent: topic=%s, partition=%d, offset=%d, err=(%s)",
+				as.actorID, consMsg.Topic, consMsg.Partition, consMsg.Offset, err)
+			return
+		}
+		flusher.Flush()
+		if !isExplicitAck {
+			if err := as.cons.Ack(group, consMsg.Topic, consMsg.Partition, consMsg.Offset); err != nil {
+				log.Infof("<%s> stream ack failed: topic=%s, partition=%d, offset=%d, err=(%s)",
+					as.actorID, consMsg.Topic, consMsg.Partition, consMsg.Offset, err)
+			}
+		}
+	}
+}
+
+// handleRead is an HTTP request handler for
+// `GET /topic/{topic}/partitions/{partition}/messages`
+func (as *T) handleRead(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	partitionStr := mux.Vars(r)[paramPartition]
+	partition, err := strconv.ParseInt(partitionStr, 10, 32)
+	if err != nil {
+		errorText := fmt.Sprintf("Invalid %s: %s", paramPartition, partitionStr)
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		return
+	}
+	offset, err := getIntParam(r, paramOffset, 64)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	limit := int64(1)
+	if _, ok := r.Form[paramLimit]; ok {
+		limit, err = getIntParam(r, paramLimit, 32)
+		if err == nil && limit < 1 {
+			err = fmt.Errorf("Invalid %s: %d", paramLimit, limit)
+		}
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+			return
+		}
+	}
 
-	consMsg, err := as.cons.Consume(group, topic)
+	consMsgs, highWaterMark, err := as.cons.Read(topic, int32(partition), offset, int(limit))
 	if err != nil {
 		var status int
-		switch err.(type) {
-		case consumer.ErrRequestTimeout:
-			status = http.StatusRequestTimeout
-		case consumer.ErrBufferOverflow:
-			status = 429 // StatusTooManyRequests
+		switch err {
+		case sarama.ErrUnknownTopicOrPartition:
+			status = http.StatusNotFound
 		default:
-			status = http.StatusInternalServerError
+			status = consumeErrorStatus(err)
 		}
 		respondWithJSON(w, status, errorHTTPResponse{err.Error()})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, consumeHTTPResponse{
-		Key:       consMsg.Key,
-		Value:     consMsg.Value,
-		Partition: consMsg.Partition,
-		Offset:    consMsg.Offset,
-	})
+	res := readHTTPResponse{
+		HighWaterMark: highWaterMark,
+		Messages:      make([]consumeHTTPResponse, len(consMsgs)),
+	}
+	for i, consMsg := range consMsgs {
+		res.Messages[i] = consumeHTTPResponse{
+			Key:       consMsg.Key,
+			Value:     consMsg.Value,
+			Partition: consMsg.Partition,
+			Offset:    consMsg.Offset,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleAck is an HTTP request handler for `POST /topic/{topic}/acks`
+func (as *T) handleAck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	group, partition, offsets, err := getAckParams(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	if err := as.cons.Ack(group, topic, partition, offsets...); err != nil {
+		respondWithJSON(w, ackErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleNack is an HTTP request handler for `POST /topic/{topic}/nacks`
+func (as *T) handleNack(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	group, partition, offsets, err := getAckParams(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	if err := as.cons.Nack(group, topic, partition, offsets...); err != nil {
+		respondWithJSON(w, ackErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
 // handleGetOffsets is an HTTP request handler for `GET /topic/{topic}/offsets`
@@ -235,19 +654,7 @@ func (as *T) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
 
 	partitionOffsetView := make([]partitionOffsetView, len(partitionOffsets))
 	for i, po := range partitionOffsets {
-		partitionOffsetView[i].Partition = po.Partition
-		partitionOffsetView[i].Begin = po.Begin
-		partitionOffsetView[i].End = po.End
-		partitionOffsetView[i].Count = po.End - po.Begin
-		partitionOffsetView[i].Offset = po.Offset
-		if po.Offset == sarama.OffsetNewest {
-			partitionOffsetView[i].Lag = 0
-		} else if po.Offset == sarama.OffsetOldest {
-			partitionOffsetView[i].Lag = po.End - po.Begin
-		} else {
-			partitionOffsetView[i].Lag = po.End - po.Offset
-		}
-		partitionOffsetView[i].Metadata = po.Metadata
+		partitionOffsetView[i] = toPartitionOffsetView(po)
 	}
 	respondWithJSON(w, http.StatusOK, partitionOffsetView)
 }
@@ -297,6 +704,64 @@ func (as *T) handleSetOffsets(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
+// handleResetOffsets is an HTTP request handler for
+// `POST /topic/{topic}/offsets/reset`
+func (as *T) handleResetOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	group, err := getGroupParam(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	resetTime, err := getResetTimeParam(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	partitionOffsets, err := as.admin.GetTimeOffsets(topic, resetTime)
+	if err != nil {
+		if err, ok := err.(admin.ErrQuery); ok && err.Cause() == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{"Unknown topic"})
+			return
+		}
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	// Partitions consumed by this instance are repositioned by their partition
+	// consumers, that also commit the new offsets. Offsets of the rest are
+	// committed directly.
+	var notConsumed []admin.PartitionOffset
+	for i, po := range partitionOffsets {
+		offset, err := as.cons.Seek(group, topic, po.Partition, po.Offset)
+		if err != nil {
+			if _, ok := err.(consumer.ErrNotConsumed); ok {
+				notConsumed = append(notConsumed, po)
+				continue
+			}
+			respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+			return
+		}
+		partitionOffsets[i].Offset = offset
+	}
+	if len(notConsumed) > 0 {
+		if err := as.admin.SetGroupOffsets(group, topic, notConsumed); err != nil {
+			respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+			return
+		}
+	}
+
+	res := make([]resetOffsetView, len(partitionOffsets))
+	for i, po := range partitionOffsets {
+		res[i].Partition = po.Partition
+		res[i].Offset = po.Offset
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
 // handleGetTopicConsumers is an HTTP request handler for `GET /topic/{topic}/consumers`
 func (as *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
@@ -339,7 +804,21 @@ func (as *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	encodedRes, err := json.MarshalIndent(consumers, "", "  ")
+	// In the verbose mode every group also reports whether its consumption of
+	// the topic is paused in this instance.
+	var res interface{} = consumers
+	if _, isVerbose := r.Form[paramVerbose]; isVerbose {
+		groupViews := make(map[string]groupConsumersView, len(consumers))
+		for group, members := range consumers {
+			groupViews[group] = groupConsumersView{
+				Paused:  as.cons.IsPaused(group, topic),
+				Members: members,
+			}
+		}
+		res = groupViews
+	}
+
+	encodedRes, err := json.MarshalIndent(res, "", "  ")
 	if err != nil {
 		log.Errorf("Failed to send HTTP response: status=%d, body=%v, reason=%v", http.StatusOK, encodedRes, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -354,40 +833,468 @@ func (as *T) handleGetTopicConsumers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (as *T) handlePing(w http.ResponseWriter, r *http.Request) {
+// handlePause is an HTTP request handler for
+// `POST /groups/{group}/topics/{topic}/pause`
+func (as *T) handlePause(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("pong"))
-}
 
-type produceHTTPResponse struct {
-	Partition int32 `json:"partition"`
-	Offset    int64 `json:"offset"`
+	vars := mux.Vars(r)
+	as.cons.Pause(vars[paramGroup], vars[paramTopic])
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
-type consumeHTTPResponse struct {
-	Key       []byte `json:"key"`
-	Value     []byte `json:"value"`
-	Partition int32  `json:"partition"`
-	Offset    int64  `json:"offset"`
-}
+// handleResume is an HTTP request handler for
+// `POST /groups/{group}/topics/{topic}/resume`
+func (as *T) handleResume(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-type partitionOffsetView struct {
-	Partition int32  `json:"partition"`
-	Begin     int64  `json:"begin"`
-	End       int64  `json:"end"`
-	Count     int64  `json:"count"`
-	Offset    int64  `json:"offset"`
-	Lag       int64  `json:"lag"`
-	Metadata  string `json:"metadata,omitempty"`
+	vars := mux.Vars(r)
+	as.cons.Resume(vars[paramGroup], vars[paramTopic])
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
 }
 
-type errorHTTPResponse struct {
-	Error string `json:"error"`
-}
+// handleListDeadLetters is an HTTP request handler for `GET /deadletters`
+func (as *T) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-// getParamBytes returns the request parameter as a slice of bytes. It works
-// pretty much the same way as `http.FormValue`, except it distinguishes empty
+	records, err := as.prod.ListDeadLetters()
+	if err != nil {
+		respondWithJSON(w, deadLettersErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, records)
+}
+
+// handleReplayDeadLetters is an HTTP request handler for
+// `POST /deadletters/replay`
+func (as *T) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	replayed, failed, err := as.prod.ReplayDeadLetters()
+	if err != nil {
+		respondWithJSON(w, deadLettersErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, replayHTTPResponse{Replayed: replayed, Failed: failed})
+}
+
+// handleListPushSubscriptions is an HTTP request handler for
+// `GET /push/subscriptions`
+func (as *T) handleListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	respondWithJSON(w, http.StatusOK, as.pusher.Status())
+}
+
+// handleGetTopics is an HTTP request handler for `GET /topics`
+func (as *T) handleGetTopics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topics, err := as.admin.GetTopics()
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+	if topics == nil {
+		topics = []string{}
+	}
+	respondWithJSON(w, http.StatusOK, topics)
+}
+
+// handleGetTopicMetadata is an HTTP request handler for `GET /topics/{topic}`
+func (as *T) handleGetTopicMetadata(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	partitionsMetadata, err := as.admin.GetTopicMetadata(topic)
+	if err != nil {
+		if err, ok := err.(admin.ErrQuery); ok && err.Cause() == sarama.ErrUnknownTopicOrPartition {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{"Unknown topic"})
+			return
+		}
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	res := topicMetadataView{
+		Topic:      topic,
+		Partitions: make([]partitionMetadataView, len(partitionsMetadata)),
+	}
+	for i, pm := range partitionsMetadata {
+		res.Partitions[i].Partition = pm.Partition
+		res.Partitions[i].Leader = pm.Leader
+		res.Partitions[i].Replicas = pm.Replicas
+		res.Partitions[i].ISR = pm.ISR
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleCreateTopic is an HTTP request handler for `POST /topics/{topic}`
+func (as *T) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	var req createTopicRequest
+	if err := readJSONRequest(r, &req); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	err := as.admin.CreateTopic(topic, req.Partitions, req.ReplicationFactor, req.Configs)
+	if err != nil {
+		respondWithJSON(w, topicAdminErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleDeleteTopic is an HTTP request handler for `DELETE /topics/{topic}`
+func (as *T) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	if err := as.admin.DeleteTopic(topic); err != nil {
+		respondWithJSON(w, topicAdminErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleAddPartitions is an HTTP request handler for
+// `POST /topics/{topic}/partitions`
+func (as *T) handleAddPartitions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	topic := mux.Vars(r)[paramTopic]
+	var req addPartitionsRequest
+	if err := readJSONRequest(r, &req); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	if err := as.admin.AddPartitions(topic, req.Partitions); err != nil {
+		respondWithJSON(w, topicAdminErrorStatus(err), errorHTTPResponse{err.Error()})
+		return
+	}
+	// Let local consumer groups pick up the new partitions without waiting
+	// for the next topic scan. The Kafka controller has not applied the
+	// change yet, so they keep checking until the partitions show up.
+	as.cons.RefreshPartitions()
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleGetGroups is an HTTP request handler for `GET /groups`
+func (as *T) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	groups, err := as.admin.GetGroups()
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	res := make([]groupSummaryView, len(groups))
+	for i, group := range groups {
+		res[i].Group = group.Group
+		res[i].Membership = group.Membership
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleGetGroup is an HTTP request handler for `GET /groups/{group}`
+func (as *T) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	group := mux.Vars(r)[paramGroup]
+	desc, err := as.admin.GetGroup(group)
+	if err != nil {
+		if err, ok := err.(admin.ErrQuery); ok && err.Cause() == admin.ErrUnknownGroup {
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{"Unknown group"})
+			return
+		}
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	res := groupView{
+		Group:      desc.Group,
+		Membership: desc.Membership,
+		State:      desc.State,
+		Members:    make([]groupMemberView, len(desc.Members)),
+		Offsets:    make(map[string][]partitionOffsetView, len(desc.Offsets)),
+	}
+	for i, member := range desc.Members {
+		res.Members[i].ID = member.ID
+		res.Members[i].ClientID = member.ClientID
+		res.Members[i].ClientHost = member.ClientHost
+		res.Members[i].Topics = member.Topics
+		res.Members[i].Assignment = member.Assignment
+	}
+	for topic, partitionOffsets := range desc.Offsets {
+		views := make([]partitionOffsetView, len(partitionOffsets))
+		for i, po := range partitionOffsets {
+			views[i] = toPartitionOffsetView(po)
+			res.Lag += views[i].Lag
+		}
+		res.Offsets[topic] = views
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handleExportOffsets is an HTTP request handler for
+// `GET /groups/{group}/offsets/export`
+func (as *T) handleExportOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	group := mux.Vars(r)[paramGroup]
+	offsets, err := as.admin.ExportGroupOffsets(group)
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, toGroupOffsetsView(offsets))
+}
+
+// handleImportOffsets is an HTTP request handler for
+// `POST /groups/{group}/offsets/import`
+func (as *T) handleImportOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	group := mux.Vars(r)[paramGroup]
+	var req map[string][]committedOffsetView
+	if err := readJSONRequest(r, &req); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+		return
+	}
+	offsets := make(map[string][]admin.PartitionOffset, len(req))
+	for topic, views := range req {
+		topicOffsets := make([]admin.PartitionOffset, len(views))
+		for i, view := range views {
+			topicOffsets[i].Partition = view.Partition
+			topicOffsets[i].Offset = view.Offset
+			topicOffsets[i].Metadata = view.Metadata
+		}
+		offsets[topic] = topicOffsets
+	}
+
+	committed, err := as.admin.ImportGroupOffsets(group, offsets)
+	if err != nil {
+		respondWithOffsetsImportError(w, committed, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, EmptyResponse)
+}
+
+// handleCopyOffsets is an HTTP request handler for
+// `POST /groups/{group}/offsets/copy`
+func (as *T) handleCopyOffsets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	srcGroup := mux.Vars(r)[paramGroup]
+	r.ParseForm()
+	dstGroups := r.Form[paramTo]
+	if len(dstGroups) != 1 {
+		errorText := fmt.Sprintf("One %s is expected, but %d provided", paramTo, len(dstGroups))
+		respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{errorText})
+		return
+	}
+
+	offsets, err := as.admin.CopyGroupOffsets(srcGroup, dstGroups[0])
+	if err != nil {
+		respondWithOffsetsImportError(w, offsets, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, toGroupOffsetsView(offsets))
+}
+
+// respondWithOffsetsImportError responds to an offsets import or copy request
+// that failed. If some of the offsets have been committed nonetheless, then
+// they are listed in the response along with the error.
+func respondWithOffsetsImportError(w http.ResponseWriter, committed map[string][]admin.PartitionOffset, err error) {
+	if err, ok := err.(admin.ErrQuery); ok {
+		switch err.Cause() {
+		case admin.ErrInvalidOffset:
+			respondWithJSON(w, http.StatusBadRequest, errorHTTPResponse{err.Error()})
+			return
+		case sarama.ErrUnknownTopicOrPartition:
+			respondWithJSON(w, http.StatusNotFound, errorHTTPResponse{err.Error()})
+			return
+		}
+	}
+	if len(committed) == 0 {
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusInternalServerError, partialImportView{
+		Error:     err.Error(),
+		Committed: toGroupOffsetsView(committed),
+	})
+}
+
+// handleGetBrokers is an HTTP request handler for `GET /brokers`
+func (as *T) handleGetBrokers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	brokers, controllerID, err := as.admin.GetBrokers()
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, errorHTTPResponse{err.Error()})
+		return
+	}
+
+	res := brokersView{
+		Controller: controllerID,
+		Brokers:    make([]brokerView, len(brokers)),
+	}
+	for i, broker := range brokers {
+		res.Brokers[i].ID = broker.ID
+		res.Brokers[i].Addr = broker.Addr
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (as *T) handlePing(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("pong"))
+}
+
+type produceHTTPResponse struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+type produceBatchRecord struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// UnmarshalJSON decodes a batch produce record rejecting fields other than
+// `key` and `value`. Kafka message headers in particular are not supported by
+// the Kafka protocol version in use, and should not be silently dropped.
+func (pbr *produceBatchRecord) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name := range fields {
+		if !strings.EqualFold(name, "key") && !strings.EqualFold(name, "value") {
+			return fmt.Errorf("unsupported record field: %s", name)
+		}
+	}
+	type plainRecord produceBatchRecord
+	return json.Unmarshal(data, (*plainRecord)(pbr))
+}
+
+type consumeHTTPResponse struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Topic     string `json:"topic,omitempty"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Retries   int    `json:"retries,omitempty"`
+}
+
+type readHTTPResponse struct {
+	HighWaterMark int64                 `json:"highWaterMark"`
+	Messages      []consumeHTTPResponse `json:"messages"`
+}
+
+type partitionOffsetView struct {
+	Partition int32  `json:"partition"`
+	Begin     int64  `json:"begin"`
+	End       int64  `json:"end"`
+	Count     int64  `json:"count"`
+	Offset    int64  `json:"offset"`
+	Lag       int64  `json:"lag"`
+	Metadata  string `json:"metadata,omitempty"`
+}
+
+type resetOffsetView struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+type topicMetadataView struct {
+	Topic      string                  `json:"topic"`
+	Partitions []partitionMetadataView `json:"partitions"`
+}
+
+type partitionMetadataView struct {
+	Partition int32   `json:"partition"`
+	Leader    int32   `json:"leader"`
+	Replicas  []int32 `json:"replicas"`
+	ISR       []int32 `json:"isr"`
+}
+
+type createTopicRequest struct {
+	Partitions        int               `json:"partitions"`
+	ReplicationFactor int               `json:"replicationFactor"`
+	Configs           map[string]string `json:"configs"`
+}
+
+type addPartitionsRequest struct {
+	Partitions int `json:"partitions"`
+}
+
+type groupSummaryView struct {
+	Group      string `json:"group"`
+	Membership string `json:"membership"`
+}
+
+type groupView struct {
+	Group      string                           `json:"group"`
+	Membership string                           `json:"membership"`
+	State      string                           `json:"state,omitempty"`
+	Members    []groupMemberView                `json:"members"`
+	Offsets    map[string][]partitionOffsetView `json:"offsets"`
+	Lag        int64                            `json:"lag"`
+}
+
+type groupMemberView struct {
+	ID         string             `json:"id"`
+	ClientID   string             `json:"clientId"`
+	ClientHost string             `json:"clientHost,omitempty"`
+	Topics     []string           `json:"topics"`
+	Assignment map[string][]int32 `json:"assignment,omitempty"`
+}
+
+type committedOffsetView struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata,omitempty"`
+}
+
+type brokersView struct {
+	Controller int32        `json:"controller"`
+	Brokers    []brokerView `json:"brokers"`
+}
+
+type brokerView struct {
+	ID   int32  `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type groupConsumersView struct {
+	Paused  bool               `json:"paused"`
+	Members map[string][]int32 `json:"members"`
+}
+
+type replayHTTPResponse struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+}
+
+type errorHTTPResponse struct {
+	Error string `json:"error"`
+}
+
+type partialImportView struct {
+	Error     string                           `json:"error"`
+	Committed map[string][]committedOffsetView `json:"committed"`
+}
+
+// getParamBytes returns the request parameter as a slice of bytes. It works
+// pretty much the same way as `http.FormValue`, except it distinguishes empty
 // value (`[]byte{}`) from missing one (`nil`).
 func getParamBytes(r *http.Request, name string) []byte {
 	r.ParseForm() // Ignore errors, the go library does the same in FormValue.
@@ -424,6 +1331,308 @@ func getGroupParam(r *http.Request) (string, error) {
 	return groups[0], nil
 }
 
+// applyAutoOffsetResetParam overrides the auto offset reset policy of the
+// group if the request specifies one.
+func (as *T) applyAutoOffsetResetParam(r *http.Request, group string) error {
+	values := r.Form[paramAutoOffsetReset]
+	if len(values) == 0 {
+		return nil
+	}
+	if err := config.ValidateAutoOffsetReset(paramAutoOffsetReset, values[0]); err != nil {
+		return fmt.Errorf("Invalid %s: %s", paramAutoOffsetReset, values[0])
+	}
+	as.cons.SetAutoOffsetReset(group, values[0])
+	return nil
+}
+
+// parseProduceBatch parses a batch produce request body that is either a JSON
+// array of records, or a sequence of JSON records delimited by new lines.
+func parseProduceBatch(body []byte) ([]produceBatchRecord, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var records []produceBatchRecord
+		if err := json.Unmarshal(body, &records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	var records []produceBatchRecord
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var record produceBatchRecord
+		if err := decoder.Decode(&record); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// getAckParams returns the group, the partition, and the offsets specified
+// in an acknowledgement request. Several offsets can be acknowledged at once,
+// e.g. those of a batch.
+func getAckParams(r *http.Request) (string, int32, []int64, error) {
+	group, err := getGroupParam(r)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	partition, err := getIntParam(r, paramPartition, 32)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	offsetStrs := r.Form[paramOffset]
+	if len(offsetStrs) == 0 {
+		return "", 0, nil, fmt.Errorf("One %s is expected, but 0 provided", paramOffset)
+	}
+	offsets := make([]int64, len(offsetStrs))
+	for i, offsetStr := range offsetStrs {
+		if offsets[i], err = strconv.ParseInt(offsetStr, 10, 64); err != nil {
+			return "", 0, nil, fmt.Errorf("Invalid %s: %s", paramOffset, offsetStr)
+		}
+	}
+	return group, int32(partition), offsets, nil
+}
+
+// ackErrorStatus returns an HTTP status code for an error returned by either
+// `consumer.T.Ack` or `consumer.T.Nack`.
+func ackErrorStatus(err error) int {
+	switch err.(type) {
+	case consumer.ErrInvalidAck:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// consumeErrorStatus returns an HTTP status code that corresponds to a
+// consume error.
+func consumeErrorStatus(err error) int {
+	switch err.(type) {
+	case consumer.ErrPaused:
+		return http.StatusConflict
+	case consumer.ErrNoValidOffset:
+		return http.StatusConflict
+	case consumer.ErrRequestTimeout:
+		return http.StatusRequestTimeout
+	case consumer.ErrBufferOverflow:
+		return 429 // StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// toConsumeHTTPResponse converts a message consumed from the topic to a
+// consume response. Messages consumed via a topic pattern or from a retry
+// topic report the topic they were consumed from, for it is needed to
+// acknowledge them.
+func toConsumeHTTPResponse(topic string, consMsg *consumer.Message) consumeHTTPResponse {
+	res := consumeHTTPResponse{
+		Key:       consMsg.Key,
+		Value:     consMsg.Value,
+		Partition: consMsg.Partition,
+		Offset:    consMsg.Offset,
+		Retries:   consMsg.Retries,
+	}
+	if consMsg.Topic != topic {
+		res.Topic = consMsg.Topic
+	}
+	return res
+}
+
+// deadLettersErrorStatus returns an HTTP status code that corresponds to a
+// dead letter spool error.
+func deadLettersErrorStatus(err error) int {
+	if err == deadletter.ErrNotSpooled {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// toPartitionOffsetView converts partition offsets returned by
+// `admin.T.GetGroupOffsets` to a view, calculating the number of messages
+// available in the partition and the lag of the group.
+func toPartitionOffsetView(po admin.PartitionOffset) partitionOffsetView {
+	view := partitionOffsetView{
+		Partition: po.Partition,
+		Begin:     po.Begin,
+		End:       po.End,
+		Count:     po.End - po.Begin,
+		Offset:    po.Offset,
+		Metadata:  po.Metadata,
+	}
+	if po.Offset == sarama.OffsetNewest {
+		view.Lag = 0
+	} else if po.Offset == sarama.OffsetOldest {
+		view.Lag = po.End - po.Begin
+	} else {
+		view.Lag = po.End - po.Offset
+	}
+	return view
+}
+
+// toGroupOffsetsView converts offsets committed by a group to a view that
+// maps topics to lists of partition offsets.
+func toGroupOffsetsView(offsets map[string][]admin.PartitionOffset) map[string][]committedOffsetView {
+	view := make(map[string][]committedOffsetView, len(offsets))
+	for topic, topicOffsets := range offsets {
+		views := make([]committedOffsetView, len(topicOffsets))
+		for i, po := range topicOffsets {
+			views[i].Partition = po.Partition
+			views[i].Offset = po.Offset
+			views[i].Metadata = po.Metadata
+		}
+		view[topic] = views
+	}
+	return view
+}
+
+// topicAdminErrorStatus returns an HTTP status code for an error returned by
+// a topic administration method of `admin.T`.
+func topicAdminErrorStatus(err error) int {
+	if err, ok := err.(admin.ErrQuery); ok {
+		switch err.Cause() {
+		case admin.ErrInvalidTopicParams:
+			return http.StatusBadRequest
+		case admin.ErrTopicExists:
+			return http.StatusConflict
+		case sarama.ErrUnknownTopicOrPartition:
+			return http.StatusNotFound
+		case admin.ErrNoZooKeeper:
+			return http.StatusNotImplemented
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// readJSONRequest parses the JSON body of the request into the given value.
+func readJSONRequest(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read the request: err=(%s)", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Failed to parse the request: err=(%s)", err)
+	}
+	return nil
+}
+
+// countConsumeRequest updates consume request metrics with an outcome that
+// corresponds to the HTTP status code the request is replied with.
+func countConsumeRequest(group, topic string, status int) {
+	var outcome string
+	switch status {
+	case http.StatusOK:
+		outcome = metrics.ConsumeSuccess
+	case http.StatusRequestTimeout:
+		outcome = metrics.ConsumeTimeout
+	case 429: // StatusTooManyRequests
+		outcome = metrics.ConsumeOverflow
+	default:
+		outcome = metrics.ConsumeError
+	}
+	metrics.ConsumeRequests.WithLabelValues(group, topic, outcome).Inc()
+}
+
+// getIntParam returns the value of a mandatory integer request parameter. The
+// value must fit into `bitSize` bits.
+func getIntParam(r *http.Request, name string, bitSize int) (int64, error) {
+	r.ParseForm()
+	values := r.Form[name]
+	if len(values) != 1 {
+		return 0, fmt.Errorf("One %s is expected, but %d provided", name, len(values))
+	}
+	value, err := strconv.ParseInt(values[0], 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %s", name, values[0])
+	}
+	return value, nil
+}
+
+// getBoolParam returns the value of an optional boolean request parameter.
+// A parameter specified without a value, e.g. `?explicitAck`, is true, and a
+// missing one is false.
+func getBoolParam(r *http.Request, name string) (bool, error) {
+	r.ParseForm()
+	values, ok := r.Form[name]
+	if !ok {
+		return false, nil
+	}
+	if len(values) != 1 {
+		return false, fmt.Errorf("One %s is expected, but %d provided", name, len(values))
+	}
+	if values[0] == "" {
+		return true, nil
+	}
+	value, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s: %s", name, values[0])
+	}
+	return value, nil
+}
+
+// getResetTimeParam returns the time to reset offsets to, given by the
+// mandatory `to` request parameter, in milliseconds since epoch. The value is
+// either `oldest`, `newest`, a time in RFC3339 format, or a number of
+// milliseconds since epoch. `oldest` and `newest` are returned as
+// `sarama.OffsetOldest` and `sarama.OffsetNewest` respectively.
+func getResetTimeParam(r *http.Request) (int64, error) {
+	r.ParseForm()
+	values := r.Form[paramTo]
+	if len(values) != 1 {
+		return 0, fmt.Errorf("One %s is expected, but %d provided", paramTo, len(values))
+	}
+	switch values[0] {
+	case resetToOldest:
+		return sarama.OffsetOldest, nil
+	case resetToNewest:
+		return sarama.OffsetNewest, nil
+	}
+	if t, err := time.Parse(time.RFC3339, values[0]); err == nil {
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	if millis, err := strconv.ParseInt(values[0], 10, 64); err == nil && millis >= 0 {
+		return millis, nil
+	}
+	return 0, fmt.Errorf("Invalid %s: %s", paramTo, values[0])
+}
+
+// getStreamFormatParam returns the format of a consume stream given by the
+// optional `format` request parameter. If the parameter is not specified,
+// then Server-Sent Events are used if the client accepts `text/event-stream`,
+// and newline delimited JSON otherwise.
+func getStreamFormatParam(r *http.Request) (string, error) {
+	r.ParseForm()
+	values := r.Form[paramFormat]
+	if len(values) == 0 {
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			return streamFormatSSE, nil
+		}
+		return streamFormatNDJSON, nil
+	}
+	if len(values) != 1 || (values[0] != streamFormatNDJSON && values[0] != streamFormatSSE) {
+		return "", fmt.Errorf("Invalid %s: %s", paramFormat, strings.Join(values, ","))
+	}
+	return values[0], nil
+}
+
+// writeStreamEvent writes `body` marshaled to JSON to a consume stream in the
+// specified format. In the Server-Sent Events format, `event` is used as the
+// event type, in the newline delimited JSON format it is ignored.
+func writeStreamEvent(w io.Writer, format, event string, body interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if format == streamFormatSSE {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", encoded)
+	return err
+}
+
 // toEncoderPreservingNil converts a slice of bytes to `sarama.Encoder` but
 // returns `nil` if the passed slice is `nil`.
 func toEncoderPreservingNil(b []byte) sarama.Encoder {
@@ -0,0 +1,426 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gorilla/websocket"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+const (
+	// Operations that can be requested over a WebSocket connection.
+	wsOpSubscribe   = "subscribe"
+	wsOpUnsubscribe = "unsubscribe"
+	wsOpAck         = "ack"
+	wsOpNack        = "nack"
+	wsOpProduce     = "produce"
+
+	// Types of events sent over a WebSocket connection.
+	wsEventMessage = "message"
+	wsEventReply   = "reply"
+	wsEventError   = "error"
+)
+
+var (
+	// The default origin check of the upgrader rejects connections made by
+	// browser pages of other origins, but accepts connections of clients that
+	// do not send the `Origin` header, which is what non-browser clients do.
+	wsUpgrader  = websocket.Upgrader{}
+	wsConnCount int64
+)
+
+// wsRequest is a request sent by a client over a WebSocket connection. Fields
+// that are relevant depend on the requested operation.
+type wsRequest struct {
+	ID              string  `json:"id"`
+	Op              string  `json:"op"`
+	Group           string  `json:"group"`
+	Topic           string  `json:"topic"`
+	ExplicitAck     bool    `json:"explicitAck"`
+	AutoOffsetReset string  `json:"autoOffsetReset"`
+	Partition       int32   `json:"partition"`
+	Offsets         []int64 `json:"offsets"`
+	Key             []byte  `json:"key"`
+	Value           []byte  `json:"value"`
+	Sync            bool    `json:"sync"`
+}
+
+// wsMessageEvent is sent to a client for every message consumed on behalf of
+// one of its subscriptions. The topic is the one the message was consumed
+// from, that may be a retry topic of the subscription topic.
+type wsMessageEvent struct {
+	Type      string `json:"type"`
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Retries   int    `json:"retries,omitempty"`
+}
+
+// wsWrittenEvent wraps an event whose sender needs to know when it has been
+// written to the connection. The writer closes `writtenCh` when the event is
+// written, and leaves it open if the write fails.
+type wsWrittenEvent struct {
+	event     interface{}
+	writtenCh chan none.T
+}
+
+// wsReplyEvent is sent to a client when a request succeeds. The partition and
+// offset are only reported for synchronous produce requests.
+type wsReplyEvent struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Partition *int32 `json:"partition,omitempty"`
+	Offset    *int64 `json:"offset,omitempty"`
+}
+
+// wsErrorEvent is sent to a client when a request fails, or a subscription is
+// terminated due to an error.
+type wsErrorEvent struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Group string `json:"group,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Error string `json:"error"`
+}
+
+// wsConn serves requests received over a WebSocket connection. It consumes
+// messages on behalf of the group/topic subscriptions made by the client and
+// sends them to the client. All events are written to the connection by a
+// dedicated writer goroutine, so a client that does not keep up with the
+// messages slows down consumption via TCP backpressure.
+type wsConn struct {
+	actorID       *actor.ID
+	as            *T
+	conn          *websocket.Conn
+	eventsCh      chan interface{}
+	closingCh     chan none.T
+	writerDoneCh  chan none.T
+	subscriptions map[groupTopic]chan none.T
+	subLock       sync.Mutex
+	wg            sync.WaitGroup
+}
+
+type groupTopic struct {
+	group string
+	topic string
+}
+
+// handleWebSocket is an HTTP request handler for `GET /ws`
+func (as *T) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// The upgrader replies with an error itself if the upgrade fails.
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	wc := &wsConn{
+		actorID:       as.actorID.NewChild("ws", atomic.AddInt64(&wsConnCount, 1)),
+		as:            as,
+		conn:          conn,
+		eventsCh:      make(chan interface{}),
+		closingCh:     make(chan none.T),
+		writerDoneCh:  make(chan none.T),
+		subscriptions: make(map[groupTopic]chan none.T),
+	}
+	wc.run()
+}
+
+func (wc *wsConn) run() {
+	var writerWG sync.WaitGroup
+	actor.Spawn(wc.actorID.NewChild("writer"), &writerWG, wc.runWriter)
+	for {
+		_, data, err := wc.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var rq wsRequest
+		if err := json.Unmarshal(data, &rq); err != nil {
+			errorText := fmt.Sprintf("Failed to parse the request: err=(%s)", err)
+			wc.send(wsErrorEvent{Type: wsEventError, Error: errorText}, nil)
+			continue
+		}
+		wc.handleRequest(rq)
+	}
+	close(wc.closingCh)
+	wc.subLock.Lock()
+	for gt, stopCh := range wc.subscriptions {
+		close(stopCh)
+		delete(wc.subscriptions, gt)
+	}
+	wc.subLock.Unlock()
+	wc.wg.Wait()
+	writerWG.Wait()
+}
+
+// runWriter writes events to the connection. The connection is closed when
+// either the client disconnects, a write fails, or the server is stopped.
+func (wc *wsConn) runWriter() {
+	defer close(wc.writerDoneCh)
+	defer wc.conn.Close()
+	for {
+		select {
+		case event := <-wc.eventsCh:
+			writtenEvent, isWrittenEvent := event.(wsWrittenEvent)
+			if isWrittenEvent {
+				event = writtenEvent.event
+			}
+			if err := wc.conn.WriteJSON(event); err != nil {
+				log.Infof("<%s> write failed: err=(%s)", wc.actorID, err)
+				return
+			}
+			if isWrittenEvent {
+				close(writtenEvent.writtenCh)
+			}
+		case <-wc.as.stopCh:
+			return
+		case <-wc.closingCh:
+			return
+		}
+	}
+}
+
+// send passes an event to the writer goroutine. It returns false if the
+// connection is being closed, or `stopCh` is closed before the event is
+// accepted by the writer.
+func (wc *wsConn) send(event interface{}, stopCh <-chan none.T) bool {
+	select {
+	case wc.eventsCh <- event:
+		return true
+	case <-wc.writerDoneCh:
+		return false
+	case <-wc.closingCh:
+		return false
+	case <-stopCh:
+		return false
+	}
+}
+
+// sendAndWait passes an event to the writer goroutine and waits for it to be
+// written to the connection. It returns false if the event has not been
+// written for the same reasons as `send`, or because the write failed.
+func (wc *wsConn) sendAndWait(event interface{}, stopCh <-chan none.T) bool {
+	writtenCh := make(chan none.T)
+	if !wc.send(wsWrittenEvent{event, writtenCh}, stopCh) {
+		return false
+	}
+	select {
+	case <-writtenCh:
+		return true
+	case <-wc.writerDoneCh:
+		// The writer may have closed `writtenCh` right before it quit.
+		select {
+		case <-writtenCh:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (wc *wsConn) handleRequest(rq wsRequest) {
+	var err error
+	switch rq.Op {
+	case wsOpSubscribe:
+		err = wc.subscribe(rq)
+	case wsOpUnsubscribe:
+		err = wc.unsubscribe(rq)
+	case wsOpAck:
+		err = wc.ack(rq)
+	case wsOpNack:
+		err = wc.nack(rq)
+	case wsOpProduce:
+		if rq.Sync {
+			// Synchronous produce may take a while, so it is executed
+			// concurrently to keep serving other requests.
+			actor.Spawn(wc.actorID.NewChild("produce"), &wc.wg, func() {
+				wc.produceSync(rq)
+			})
+			return
+		}
+		err = wc.produceAsync(rq)
+	default:
+		err = fmt.Errorf("Invalid op: %s", rq.Op)
+	}
+	if err != nil {
+		wc.send(wsErrorEvent{Type: wsEventError, ID: rq.ID, Error: err.Error()}, nil)
+		return
+	}
+	wc.send(wsReplyEvent{Type: wsEventReply, ID: rq.ID}, nil)
+}
+
+func (wc *wsConn) subscribe(rq wsRequest) error {
+	if err := validateGroupTopic(rq); err != nil {
+		return err
+	}
+	if rq.AutoOffsetReset != "" {
+		if err := config.ValidateAutoOffsetReset(paramAutoOffsetReset, rq.AutoOffsetReset); err != nil {
+			return fmt.Errorf("Invalid %s: %s", paramAutoOffsetReset, rq.AutoOffsetReset)
+		}
+	}
+	gt := groupTopic{rq.Group, rq.Topic}
+	wc.subLock.Lock()
+	defer wc.subLock.Unlock()
+	if _, ok := wc.subscriptions[gt]; ok {
+		return fmt.Errorf("Already subscribed: group=%s, topic=%s", rq.Group, rq.Topic)
+	}
+	if rq.AutoOffsetReset != "" {
+		wc.as.cons.SetAutoOffsetReset(rq.Group, rq.AutoOffsetReset)
+	}
+	stopCh := make(chan none.T)
+	wc.subscriptions[gt] = stopCh
+	actor.Spawn(wc.actorID.NewChild("sub", rq.Group, rq.Topic), &wc.wg, func() {
+		wc.runSubscription(gt, rq.ExplicitAck, stopCh)
+	})
+	return nil
+}
+
+func (wc *wsConn) unsubscribe(rq wsRequest) error {
+	if err := validateGroupTopic(rq); err != nil {
+		return err
+	}
+	gt := groupTopic{rq.Group, rq.Topic}
+	wc.subLock.Lock()
+	defer wc.subLock.Unlock()
+	stopCh, ok := wc.subscriptions[gt]
+	if !ok {
+		return fmt.Errorf("Not subscribed: group=%s, topic=%s", rq.Group, rq.Topic)
+	}
+	close(stopCh)
+	delete(wc.subscriptions, gt)
+	return nil
+}
+
+func (wc *wsConn) ack(rq wsRequest) error {
+	if err := validateGroupTopic(rq); err != nil {
+		return err
+	}
+	if len(rq.Offsets) == 0 {
+		return fmt.Errorf("One offset is expected, but 0 provided")
+	}
+	return wc.as.cons.Ack(rq.Group, rq.Topic, rq.Partition, rq.Offsets...)
+}
+
+func (wc *wsConn) nack(rq wsRequest) error {
+	if err := validateGroupTopic(rq); err != nil {
+		return err
+	}
+	if len(rq.Offsets) == 0 {
+		return fmt.Errorf("One offset is expected, but 0 provided")
+	}
+	return wc.as.cons.Nack(rq.Group, rq.Topic, rq.Partition, rq.Offsets...)
+}
+
+func (wc *wsConn) produceAsync(rq wsRequest) error {
+	if rq.Topic == "" {
+		return fmt.Errorf("Topic is not specified")
+	}
+	return wc.as.prod.AsyncProduce(rq.Topic, toEncoderPreservingNil(rq.Key), sarama.ByteEncoder(rq.Value))
+}
+
+func (wc *wsConn) produceSync(rq wsRequest) {
+	if rq.Topic == "" {
+		wc.send(wsErrorEvent{Type: wsEventError, ID: rq.ID, Error: "Topic is not specified"}, nil)
+		return
+	}
+	prodMsg, err := wc.as.prod.Produce(rq.Topic, toEncoderPreservingNil(rq.Key), sarama.ByteEncoder(rq.Value))
+	if err != nil {
+		wc.send(wsErrorEvent{Type: wsEventError, ID: rq.ID, Error: err.Error()}, nil)
+		return
+	}
+	wc.send(wsReplyEvent{
+		Type:      wsEventReply,
+		ID:        rq.ID,
+		Partition: &prodMsg.Partition,
+		Offset:    &prodMsg.Offset,
+	}, nil)
+}
+
+// runSubscription keeps consuming messages from the group/topic and sending
+// them to the client until the subscription is stopped. Consume requests are
+// made one at a time, so the next message is not consumed until the previous
+// one is accepted by the writer goroutine.
+func (wc *wsConn) runSubscription(gt groupTopic, isExplicitAck bool, stopCh chan none.T) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		// Messages are always consumed in the explicit acknowledgement mode,
+		// and acknowledged after they are written to the connection unless
+		// the client subscribed with `explicitAck`, so that a message that
+		// could not be written is consumed again once the ack timeout expires.
+		consMsg, err := wc.as.cons.ConsumeExplicitAck(gt.group, gt.topic)
+		if err != nil {
+			if delay, ok := consumer.RepeatAfter(err); ok {
+				if delay > 0 {
+					select {
+					case <-time.After(delay):
+					case <-stopCh:
+						return
+					}
+				}
+				continue
+			}
+			countConsumeRequest(gt.group, gt.topic, consumeErrorStatus(err))
+			wc.subLock.Lock()
+			if wc.subscriptions[gt] == stopCh {
+				delete(wc.subscriptions, gt)
+			}
+			wc.subLock.Unlock()
+			wc.send(wsErrorEvent{Type: wsEventError, Group: gt.group, Topic: gt.topic, Error: err.Error()}, stopCh)
+			return
+		}
+		countConsumeRequest(gt.group, gt.topic, http.StatusOK)
+
+		event := wsMessageEvent{
+			Type:      wsEventMessage,
+			Group:     gt.group,
+			Topic:     consMsg.Topic,
+			Key:       consMsg.Key,
+			Value:     consMsg.Value,
+			Partition: consMsg.Partition,
+			Offset:    consMsg.Offset,
+			Retries:   consMsg.Retries,
+		}
+		if isExplicitAck {
+			if !wc.send(event, stopCh) {
+				log.Infof("<%s> subscription stopped with a message not sent: topic=%s, partition=%d, offset=%d",
+					wc.actorID, consMsg.Topic, consMsg.Partition, consMsg.Offset)
+				return
+			}
+			continue
+		}
+		if !wc.sendAndWait(event, stopCh) {
+			log.Infof("<%s> subscription stopped with a message not sent: topic=%s, partition=%d, offset=%d",
+				wc.actorID, consMsg.Topic, consMsg.Partition, consMsg.Offset)
+			return
+		}
+		if err := wc.as.cons.Ack(gt.group, consMsg.Topic, consMsg.Partition, consMsg.Offset); err != nil {
+			log.Infof("<%s> subscription ack failed: topic=%s, partition=%d, offset=%d, err=(%s)",
+				wc.actorID, consMsg.Topic, consMsg.Partition, consMsg.Offset, err)
+		}
+	}
+}
+
+func validateGroupTopic(rq wsRequest) error {
+	if rq.Group == "" {
+		return fmt.Errorf("Group is not specified")
+	}
+	if rq.Topic == "" {
+		return fmt.Errorf("Topic is not specified")
+	}
+	return nil
+}
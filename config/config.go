@@ -4,69 +4,249 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"gopkg.in/yaml.v2"
 )
 
+const (
+	CompressionNone   = "none"
+	CompressionGZIP   = "gzip"
+	CompressionSnappy = "snappy"
+
+	DeadLetterNone  = "none"
+	DeadLetterSpool = "spool"
+	DeadLetterTopic = "topic"
+
+	GroupMembershipZooKeeper = "zookeeper"
+	GroupMembershipKafka     = "kafka"
+
+	AssignmentRange      = "range"
+	AssignmentRoundRobin = "roundrobin"
+	AssignmentSticky     = "sticky"
+
+	AutoOffsetResetEarliest = "earliest"
+	AutoOffsetResetLatest   = "latest"
+	AutoOffsetResetFail     = "fail"
+)
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	CompressionNone:   sarama.CompressionNone,
+	CompressionGZIP:   sarama.CompressionGZIP,
+	CompressionSnappy: sarama.CompressionSnappy,
+}
+
 type T struct {
 	// A unix domain socket address that the service should listen at.
-	UnixAddr string
+	UnixAddr string `yaml:"unix_addr"`
 	// A TCP address that the service should listen at.
-	TCPAddr string
+	TCPAddr string `yaml:"tcp_addr"`
+	// An address that the gRPC API should listen at. It is either a TCP
+	// address "<host>:<port>" or a unix domain socket address prefixed with
+	// "unix:". If empty, then the gRPC API is not served.
+	GRPCAddr string `yaml:"grpc_addr"`
 	// A unique id that identifies this particular Kafka-Pixy instance in both
 	// Kafka and ZooKeeper.
-	ClientID string
+	ClientID string `yaml:"client_id"`
+	// A file that keeps the client ID across restarts. If the file exists,
+	// then the client ID is read from it overriding `ClientID`, otherwise
+	// `ClientID` is written to it. See `LoadClientIDFile`.
+	ClientIDFile string `yaml:"client_id_file"`
 
 	Kafka struct {
 		// A list of seed Kafka peers in the form "<host>:<port>" that the
 		// service will try to connect to to resolve the cluster topology.
-		SeedPeers []string
-	}
+		SeedPeers []string `yaml:"seed_peers"`
+	} `yaml:"kafka"`
 	ZooKeeper struct {
 		// A list of seed ZooKeeper peers in the form "<host>:<port>" that the
 		// service will try to connect to to resolve the cluster topology.
-		SeedPeers []string
+		SeedPeers []string `yaml:"seed_peers"`
 		// The root directory where Kafka keeps all its znodes.
-		Chroot string
-	}
+		Chroot string `yaml:"chroot"`
+	} `yaml:"zoo_keeper"`
 	Producer struct {
 		// Size of all buffered channels created by the producer components.
-		ChannelBufferSize int
+		ChannelBufferSize int `yaml:"channel_buffer_size"`
 		// The period of time that a proxy should allow to `sarama.Producer` to
 		// submit buffered messages to Kafka. It should be large enough to avoid
 		// event loss when shutdown is performed during Kafka leader election.
-		ShutdownTimeout time.Duration
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// The compression codec to use for produced messages. It can be one
+		// of "none", "gzip", or "snappy".
+		Compression string `yaml:"compression"`
+		// The best-effort frequency of flushes to Kafka.
+		FlushFrequency time.Duration `yaml:"flush_frequency"`
+		// The best-effort number of bytes needed to trigger a flush.
+		FlushBytes int `yaml:"flush_bytes"`
+		// The total number of times to retry sending a message.
+		RetryMax int `yaml:"retry_max"`
+		// How long to wait for the cluster to settle between retries.
+		RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+		// Dead letter handling of messages that could not be delivered.
+		DeadLetter struct {
+			// What to do with asynchronously produced messages that could
+			// not be delivered to Kafka. It can be one of "none" (messages
+			// are only logged), "spool" (messages are appended to a local
+			// spool file), or "topic" (messages are produced to a dedicated
+			// Kafka topic).
+			Mode string `yaml:"mode"`
+			// Path to the spool file used in the "spool" mode.
+			SpoolFile string `yaml:"spool_file"`
+			// Name of the topic to produce dead letters to in the "topic"
+			// mode.
+			Topic string `yaml:"topic"`
+		} `yaml:"dead_letter"`
+
+		// Write-ahead log that asynchronously produced messages are
+		// persisted to before they are submitted to Kafka.
+		WAL struct {
+			// Directory to keep write-ahead log segment files in. If empty,
+			// then the write-ahead log is disabled.
+			Dir string `yaml:"dir"`
+			// A new segment file is started when the current one grows
+			// larger than this number of bytes.
+			SegmentSize int `yaml:"segment_size"`
+			// If true, then every message is flushed to disk with fsync
+			// before an async produce request is replied. Otherwise
+			// messages survive a process crash but may be lost if the host
+			// crashes.
+			Fsync bool `yaml:"fsync"`
+		} `yaml:"wal"`
 		// DeadMessageCh is a channel to dump undelivered messages into. It is
 		// used in testing only.
-		DeadMessageCh chan<- *sarama.ProducerMessage
-	}
+		DeadMessageCh chan<- *sarama.ProducerMessage `yaml:"-"`
+	} `yaml:"producer"`
 	Consumer struct {
 		// Size of all buffered channels created by the consumer components.
-		ChannelBufferSize int
+		ChannelBufferSize int `yaml:"channel_buffer_size"`
 		// A consume request will wait at most this long until a message from
 		// the specified group/topic becomes available. This timeout is
 		// necessary to account for consumer rebalancing that happens whenever
 		// a new consumer joins a group or subscribes to a topic.
-		LongPollingTimeout time.Duration
+		LongPollingTimeout time.Duration `yaml:"long_polling_timeout"`
 		// The period of time that a proxy should keep registration with a
 		// consumer group or subscription for a topic in the absence of requests
 		// to the aforementioned consumer group or topic.
-		RegistrationTimeout time.Duration
+		RegistrationTimeout time.Duration `yaml:"registration_timeout"`
 		// If a request to a KafkaBroker fails for any reason then the proxy
 		// should wait this long before retrying.
-		BackOffTimeout time.Duration
+		BackOffTimeout time.Duration `yaml:"back_off_timeout"`
 		// A consumer should wait this long after it gets notification that a
 		// consumer joined/left its consumer group before it should rebalance.
-		RebalanceDelay time.Duration
+		RebalanceDelay time.Duration `yaml:"rebalance_delay"`
+		// How long consumer group members keep partitions of a member that
+		// left the group unassigned, waiting for it to come back. If an
+		// instance restarted with the same `ClientID` rejoins within this
+		// period, then it gets its partitions back and the group is not
+		// rebalanced at all. If zero, then partitions are reassigned right
+		// away. Used with the zookeeper group membership only.
+		MemberGracePeriod time.Duration `yaml:"member_grace_period"`
+		// How frequently to fetch the list of topics from Kafka to find
+		// topics that match topic patterns consumer groups subscribed to.
+		TopicScanInterval time.Duration `yaml:"topic_scan_interval"`
 		// How frequently to commit updated offsets. Defaults to 0.5s.
-		OffsetsCommitInterval time.Duration
+		OffsetsCommitInterval time.Duration `yaml:"offsets_commit_interval"`
+		// If a message consumed in the explicit acknowledgement mode has not
+		// been acknowledged for this long, then it is offered for consumption
+		// again.
+		AckTimeout time.Duration `yaml:"ack_timeout"`
+		// The maximum number of consumed but not yet acknowledged messages per
+		// partition. When the limit is reached, a partition consumer stops
+		// offering new messages until some of the pending ones are either
+		// acknowledged or redelivered.
+		MaxPendingMessages int `yaml:"max_pending_messages"`
+		// How consumer group members find each other and agree on partition
+		// assignments: either by registering in ZooKeeper, or by using the
+		// group coordinator API of Kafka (JoinGroup, SyncGroup, etc.). All
+		// members of a group must use the same one.
+		GroupMembership string `yaml:"group_membership"`
+		// If the Kafka group coordinator does not receive a heartbeat from a
+		// group member for this long, then the member is removed from the
+		// group. Used with the kafka group membership only.
+		SessionTimeout time.Duration `yaml:"session_timeout"`
+		// How frequently group members send heartbeats to the Kafka group
+		// coordinator. It must be lower than `SessionTimeout`. Used with the
+		// kafka group membership only.
+		HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+		// A strategy used to divide topic partitions among consumer group
+		// members: range, roundrobin, or sticky. All members of a group must
+		// use the same one.
+		AssignmentStrategy string `yaml:"assignment_strategy"`
+		// Assignment strategies for particular consumer groups that override
+		// `AssignmentStrategy`.
+		GroupAssignmentStrategies map[string]string `yaml:"group_assignment_strategies"`
+		// Where to start consuming a partition from if a consumer group has
+		// no offset committed for it yet, or if the offset to be fetched is
+		// out of the range available in Kafka: earliest, latest, or fail. In
+		// the latter case consumption of the partition stops until its offset
+		// is reset, and consume requests return an error.
+		AutoOffsetReset string `yaml:"auto_offset_reset"`
+		// Auto offset reset policies for particular consumer groups that
+		// override `AutoOffsetReset`.
+		GroupAutoOffsetResets map[string]string `yaml:"group_auto_offset_resets"`
+
+		// Negatively acknowledged messages are republished to retry topics
+		// and consumed again once their retry delay has elapsed.
+		Retry struct {
+			// Delays of consecutive retries in ascending order. A message
+			// negatively acknowledged for the n-th time is republished to
+			// the `<group>.<topic>.retry.<n-th delay>` topic, and a message
+			// that has run out of retries to the dead letter topic. If
+			// empty, then negatively acknowledged messages go to the dead
+			// letter topic right away.
+			Delays []time.Duration `yaml:"delays"`
+			// A suffix appended to `<group>.<topic>` to get the name of the
+			// dead letter topic of the topic for the group.
+			DeadLetterSuffix string `yaml:"dead_letter_suffix"`
+		} `yaml:"retry"`
 		// If enabled, any errors that occurred while consuming are returned on
 		// the Errors channel (default disabled).
-		ReturnErrors bool
-	}
+		ReturnErrors bool `yaml:"return_errors"`
+	} `yaml:"consumer"`
+	Admin struct {
+		// Cluster metadata used by administrative queries is refreshed if it
+		// is older than this, or if a query fails.
+		MetadataTTL time.Duration `yaml:"metadata_ttl"`
+	} `yaml:"admin"`
+	// Subscriptions that make Kafka-Pixy consume messages on behalf of
+	// consumer groups and POST them to webhooks.
+	PushSubscriptions []PushSubscription `yaml:"push_subscriptions"`
+}
+
+// PushSubscription defines a consumer group/topic whose messages are POSTed
+// to a webhook. Fields that are not specified take the default values given
+// in the comments.
+type PushSubscription struct {
+	Group string `yaml:"group"`
+	Topic string `yaml:"topic"`
+	// The URL of the webhook to POST messages to.
+	URL string `yaml:"url"`
+	// The maximum number of messages being delivered concurrently. Defaults
+	// to 1, that preserves the order of messages within a partition as long
+	// as deliveries do not fail for longer than `Consumer.AckTimeout`. A
+	// message whose retries are postponed until it is consumed again is
+	// delivered after the messages that follow it.
+	Concurrency int `yaml:"concurrency"`
+	// How long to wait for the webhook to respond. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+	// How long to wait before retrying a failed delivery. The delay doubles
+	// with every failure up to `RetryBackoffMax`. Defaults to 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// The maximum delay between retries. Defaults to 1m.
+	RetryBackoffMax time.Duration `yaml:"retry_backoff_max"`
+	// If this many retries of a message fail, then the message is negatively
+	// acknowledged, see `Consumer.Retry`. If zero, then delivery is retried
+	// until it succeeds. Retries that would not complete within
+	// `Consumer.AckTimeout` are postponed until the message is consumed again.
+	MaxRetries int `yaml:"max_retries"`
 }
 
 func Default() *T {
@@ -75,18 +255,267 @@ func Default() *T {
 
 	config.Producer.ChannelBufferSize = 4096
 	config.Producer.ShutdownTimeout = 30 * time.Second
+	config.Producer.Compression = CompressionSnappy
+	config.Producer.FlushFrequency = 500 * time.Millisecond
+	config.Producer.FlushBytes = 1024 * 1024
+	config.Producer.RetryMax = 6
+	config.Producer.RetryBackoff = 10 * time.Second
+	config.Producer.DeadLetter.Mode = DeadLetterNone
+	config.Producer.WAL.SegmentSize = 64 * 1024 * 1024
 
 	config.Consumer.ChannelBufferSize = 64
 	config.Consumer.LongPollingTimeout = 3 * time.Second
 	config.Consumer.RegistrationTimeout = 20 * time.Second
 	config.Consumer.BackOffTimeout = 500 * time.Millisecond
 	config.Consumer.RebalanceDelay = 250 * time.Millisecond
+	config.Consumer.TopicScanInterval = 10 * time.Second
 	config.Consumer.OffsetsCommitInterval = 500 * time.Millisecond
+	config.Consumer.AckTimeout = 5 * time.Minute
+	config.Consumer.MaxPendingMessages = 300
+	config.Consumer.GroupMembership = GroupMembershipZooKeeper
+	config.Consumer.SessionTimeout = 15 * time.Second
+	config.Consumer.HeartbeatInterval = 3 * time.Second
+	config.Consumer.AssignmentStrategy = AssignmentRange
+	config.Consumer.AutoOffsetReset = AutoOffsetResetLatest
+	config.Consumer.Retry.DeadLetterSuffix = ".dlq"
 	config.Consumer.ReturnErrors = false
 
+	config.Admin.MetadataTTL = 30 * time.Second
+
 	return config
 }
 
+// LoadYAMLFile reads a YAML configuration file and overrides the config
+// fields with values found in the file. Fields that are not mentioned in the
+// file are left intact.
+func (c *T) LoadYAMLFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file, err=(%s)", err)
+	}
+	if err := c.LoadYAML(data); err != nil {
+		return fmt.Errorf("failed to parse config file %s, err=(%s)", filename, err)
+	}
+	return nil
+}
+
+// LoadYAML overrides the config fields with values specified in a YAML
+// document. Unknown fields are reported as errors.
+func (c *T) LoadYAML(data []byte) error {
+	return yaml.UnmarshalStrict(data, c)
+}
+
+// Validate checks that all config fields have sensible values, and returns
+// an error describing the first problem found.
+func (c *T) Validate() error {
+	if c.TCPAddr == "" {
+		return errors.New("tcp_addr must be specified")
+	}
+	if c.ClientID == "" {
+		return errors.New("client_id must be specified")
+	}
+	if len(c.Kafka.SeedPeers) == 0 {
+		return errors.New("kafka.seed_peers must not be empty")
+	}
+	switch c.Consumer.GroupMembership {
+	case GroupMembershipZooKeeper:
+		if len(c.ZooKeeper.SeedPeers) == 0 {
+			return errors.New("zoo_keeper.seed_peers must not be empty")
+		}
+	case GroupMembershipKafka:
+	default:
+		return fmt.Errorf("consumer.group_membership must be one of %s, %s, got %q",
+			GroupMembershipZooKeeper, GroupMembershipKafka, c.Consumer.GroupMembership)
+	}
+	if err := validateAssignmentStrategy("consumer.assignment_strategy", c.Consumer.AssignmentStrategy); err != nil {
+		return err
+	}
+	for group, strategy := range c.Consumer.GroupAssignmentStrategies {
+		name := fmt.Sprintf("consumer.group_assignment_strategies.%s", group)
+		if err := validateAssignmentStrategy(name, strategy); err != nil {
+			return err
+		}
+	}
+	if err := ValidateAutoOffsetReset("consumer.auto_offset_reset", c.Consumer.AutoOffsetReset); err != nil {
+		return err
+	}
+	for group, policy := range c.Consumer.GroupAutoOffsetResets {
+		name := fmt.Sprintf("consumer.group_auto_offset_resets.%s", group)
+		if err := ValidateAutoOffsetReset(name, policy); err != nil {
+			return err
+		}
+	}
+	for i, delay := range c.Consumer.Retry.Delays {
+		if delay <= 0 || (i > 0 && delay <= c.Consumer.Retry.Delays[i-1]) {
+			return errors.New("consumer.retry.delays must be positive and ascending")
+		}
+	}
+	if c.Consumer.Retry.DeadLetterSuffix == "" {
+		return errors.New("consumer.retry.dead_letter_suffix must be specified")
+	}
+	if err := c.validatePushSubscriptions(); err != nil {
+		return err
+	}
+	if _, ok := compressionCodecs[c.Producer.Compression]; !ok {
+		return fmt.Errorf("producer.compression must be one of %s, %s, %s, got %q",
+			CompressionNone, CompressionGZIP, CompressionSnappy, c.Producer.Compression)
+	}
+	switch c.Producer.DeadLetter.Mode {
+	case DeadLetterNone:
+	case DeadLetterSpool:
+		if c.Producer.DeadLetter.SpoolFile == "" {
+			return errors.New("producer.dead_letter.spool_file must be specified in the spool mode")
+		}
+	case DeadLetterTopic:
+		if c.Producer.DeadLetter.Topic == "" {
+			return errors.New("producer.dead_letter.topic must be specified in the topic mode")
+		}
+	default:
+		return fmt.Errorf("producer.dead_letter.mode must be one of %s, %s, %s, got %q",
+			DeadLetterNone, DeadLetterSpool, DeadLetterTopic, c.Producer.DeadLetter.Mode)
+	}
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"producer.channel_buffer_size", int64(c.Producer.ChannelBufferSize)},
+		{"producer.shutdown_timeout", int64(c.Producer.ShutdownTimeout)},
+		{"producer.flush_frequency", int64(c.Producer.FlushFrequency)},
+		{"producer.flush_bytes", int64(c.Producer.FlushBytes)},
+		{"producer.retry_backoff", int64(c.Producer.RetryBackoff)},
+		{"producer.wal.segment_size", int64(c.Producer.WAL.SegmentSize)},
+		{"consumer.channel_buffer_size", int64(c.Consumer.ChannelBufferSize)},
+		{"consumer.long_polling_timeout", int64(c.Consumer.LongPollingTimeout)},
+		{"consumer.registration_timeout", int64(c.Consumer.RegistrationTimeout)},
+		{"consumer.back_off_timeout", int64(c.Consumer.BackOffTimeout)},
+		{"consumer.rebalance_delay", int64(c.Consumer.RebalanceDelay)},
+		{"consumer.topic_scan_interval", int64(c.Consumer.TopicScanInterval)},
+		{"consumer.offsets_commit_interval", int64(c.Consumer.OffsetsCommitInterval)},
+		{"consumer.ack_timeout", int64(c.Consumer.AckTimeout)},
+		{"consumer.max_pending_messages", int64(c.Consumer.MaxPendingMessages)},
+		{"consumer.session_timeout", int64(c.Consumer.SessionTimeout)},
+		{"consumer.heartbeat_interval", int64(c.Consumer.HeartbeatInterval)},
+		{"admin.metadata_ttl", int64(c.Admin.MetadataTTL)},
+	} {
+		if field.value <= 0 {
+			return fmt.Errorf("%s must be positive", field.name)
+		}
+	}
+	if c.Consumer.MemberGracePeriod < 0 {
+		return errors.New("consumer.member_grace_period must not be negative")
+	}
+	if c.Producer.RetryMax < 0 {
+		return errors.New("producer.retry_max must not be negative")
+	}
+	if c.Consumer.HeartbeatInterval >= c.Consumer.SessionTimeout {
+		return errors.New("consumer.heartbeat_interval must be lower than consumer.session_timeout")
+	}
+	return nil
+}
+
+func (c *T) validatePushSubscriptions() error {
+	seen := make(map[string]bool, len(c.PushSubscriptions))
+	for i, sub := range c.PushSubscriptions {
+		name := fmt.Sprintf("push_subscriptions[%d]", i)
+		if sub.Group == "" || sub.Topic == "" {
+			return fmt.Errorf("%s must specify group and topic", name)
+		}
+		if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.url must be an absolute http(s) URL, got %q", name, sub.URL)
+		}
+		if sub.Concurrency < 0 || sub.Timeout < 0 || sub.RetryBackoff < 0 || sub.RetryBackoffMax < 0 || sub.MaxRetries < 0 {
+			return fmt.Errorf("%s parameters must not be negative", name)
+		}
+		groupTopic := sub.Group + "/" + sub.Topic
+		if seen[groupTopic] {
+			return fmt.Errorf("%s duplicates group=%s, topic=%s", name, sub.Group, sub.Topic)
+		}
+		seen[groupTopic] = true
+	}
+	return nil
+}
+
+// LoadClientIDFile makes the client ID stable across restarts, if
+// `ClientIDFile` is specified. If the file exists, then `ClientID` is read
+// from it. Otherwise the file is created and `ClientID` is written to it, so
+// the ID generated on the first start is used from then on.
+func (c *T) LoadClientIDFile() error {
+	if c.ClientIDFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.ClientIDFile)
+	if err == nil {
+		clientID := strings.TrimSpace(string(data))
+		if clientID == "" {
+			return fmt.Errorf("client id file %s is empty", c.ClientIDFile)
+		}
+		c.ClientID = clientID
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read client id file, err=(%s)", err)
+	}
+	if err := ioutil.WriteFile(c.ClientIDFile, []byte(c.ClientID+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write client id file, err=(%s)", err)
+	}
+	return nil
+}
+
+// GroupAssignmentStrategy returns the partition assignment strategy to be
+// used by the specified consumer group.
+func (c *T) GroupAssignmentStrategy(group string) string {
+	if strategy, ok := c.Consumer.GroupAssignmentStrategies[group]; ok {
+		return strategy
+	}
+	return c.Consumer.AssignmentStrategy
+}
+
+// GroupAutoOffsetReset returns the auto offset reset policy to be used by the
+// specified consumer group.
+func (c *T) GroupAutoOffsetReset(group string) string {
+	if policy, ok := c.Consumer.GroupAutoOffsetResets[group]; ok {
+		return policy
+	}
+	return c.Consumer.AutoOffsetReset
+}
+
+// SaramaProducerCfg returns a `sarama.Config` instance to be used by the
+// producer, initialized from the respective config fields.
+func (c *T) SaramaProducerCfg() *sarama.Config {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.ChannelBufferSize = c.Producer.ChannelBufferSize
+	saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Producer.Return.Errors = true
+	saramaCfg.Producer.Compression = compressionCodecs[c.Producer.Compression]
+	saramaCfg.Producer.Retry.Backoff = c.Producer.RetryBackoff
+	saramaCfg.Producer.Retry.Max = c.Producer.RetryMax
+	saramaCfg.Producer.Flush.Frequency = c.Producer.FlushFrequency
+	saramaCfg.Producer.Flush.Bytes = c.Producer.FlushBytes
+	return saramaCfg
+}
+
+func validateAssignmentStrategy(name, strategy string) error {
+	switch strategy {
+	case AssignmentRange, AssignmentRoundRobin, AssignmentSticky:
+		return nil
+	}
+	return fmt.Errorf("%s must be one of %s, %s, %s, got %q",
+		name, AssignmentRange, AssignmentRoundRobin, AssignmentSticky, strategy)
+}
+
+// ValidateAutoOffsetReset returns an error if the policy is not one of the
+// supported auto offset reset policies. The name is used to refer to the
+// policy in the error message.
+func ValidateAutoOffsetReset(name, policy string) error {
+	switch policy {
+	case AutoOffsetResetEarliest, AutoOffsetResetLatest, AutoOffsetResetFail:
+		return nil
+	}
+	return fmt.Errorf("%s must be one of %s, %s, %s, got %q",
+		name, AutoOffsetResetEarliest, AutoOffsetResetLatest, AutoOffsetResetFail, policy)
+}
+
 // newClientID creates a unique id that identifies this particular Kafka-Pixy
 // in both Kafka and ZooKeeper.
 func newClientID() string {
@@ -1,9 +1,19 @@
 package config
 
 import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
 	. "gopkg.in/check.v1"
 )
 
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
 type ConfigSuite struct{}
 
 var _ = Suite(&ConfigSuite{})
@@ -14,3 +24,241 @@ func (s *ConfigSuite) TestGetIP(c *C) {
 	c.Assert(ip.String(), Matches, "\\d+.\\d+.\\d+.\\d+")
 	c.Assert(ip.String(), Not(Equals), "127.0.0.1")
 }
+
+// Values specified in a YAML document override the respective fields, and
+// the rest of the fields are left intact.
+func (s *ConfigSuite) TestLoadYAML(c *C) {
+	cfg := Default()
+	cfg.Kafka.SeedPeers = []string{"localhost:9092"}
+
+	// When
+	err := cfg.LoadYAML([]byte(`
+tcp_addr: 0.0.0.0:19093
+kafka:
+  seed_peers:
+    - kafka1:9092
+    - kafka2:9092
+producer:
+  compression: gzip
+  flush_frequency: 100ms
+consumer:
+  long_polling_timeout: 5s
+  max_pending_messages: 1000
+`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(cfg.TCPAddr, Equals, "0.0.0.0:19093")
+	c.Assert(cfg.Kafka.SeedPeers, DeepEquals, []string{"kafka1:9092", "kafka2:9092"})
+	c.Assert(cfg.Producer.Compression, Equals, CompressionGZIP)
+	c.Assert(cfg.Producer.FlushFrequency, Equals, 100*time.Millisecond)
+	c.Assert(cfg.Producer.ShutdownTimeout, Equals, Default().Producer.ShutdownTimeout)
+	c.Assert(cfg.Consumer.LongPollingTimeout, Equals, 5*time.Second)
+	c.Assert(cfg.Consumer.MaxPendingMessages, Equals, 1000)
+	c.Assert(cfg.Consumer.AckTimeout, Equals, Default().Consumer.AckTimeout)
+}
+
+// The sample config file shipped with Kafka-Pixy specifies the default values.
+func (s *ConfigSuite) TestLoadYAMLFileDefault(c *C) {
+	cfg := Default()
+
+	// When
+	err := cfg.LoadYAMLFile("../default.yaml")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Validate(), IsNil)
+	expected := validConfig()
+	expected.ClientID = cfg.ClientID
+	c.Assert(cfg, DeepEquals, expected)
+}
+
+func (s *ConfigSuite) TestLoadYAMLUnknownField(c *C) {
+	cfg := Default()
+
+	// When
+	err := cfg.LoadYAML([]byte("consumer:\n  long_pooling_timeout: 5s\n"))
+
+	// Then
+	c.Assert(err, ErrorMatches, "(?s).*field long_pooling_timeout not found.*")
+}
+
+func (s *ConfigSuite) TestLoadYAMLInvalidDuration(c *C) {
+	cfg := Default()
+
+	// When
+	err := cfg.LoadYAML([]byte("consumer:\n  ack_timeout: 5 minutes\n"))
+
+	// Then
+	c.Assert(err, NotNil)
+}
+
+func (s *ConfigSuite) TestValidate(c *C) {
+	for i, tc := range []struct {
+		yaml  string
+		error string
+	}{{
+		yaml:  "tcp_addr: ''",
+		error: "tcp_addr must be specified",
+	}, {
+		yaml:  "kafka: {seed_peers: []}",
+		error: "kafka.seed_peers must not be empty",
+	}, {
+		yaml:  "zoo_keeper: {seed_peers: []}",
+		error: "zoo_keeper.seed_peers must not be empty",
+	}, {
+		yaml:  "producer: {compression: lz4}",
+		error: `producer.compression must be one of none, gzip, snappy, got "lz4"`,
+	}, {
+		yaml:  "producer: {flush_frequency: 0s}",
+		error: "producer.flush_frequency must be positive",
+	}, {
+		yaml:  "producer: {retry_max: -1}",
+		error: "producer.retry_max must not be negative",
+	}, {
+		yaml:  "producer: {dead_letter: {mode: file}}",
+		error: `producer.dead_letter.mode must be one of none, spool, topic, got "file"`,
+	}, {
+		yaml:  "producer: {dead_letter: {mode: spool}}",
+		error: "producer.dead_letter.spool_file must be specified in the spool mode",
+	}, {
+		yaml:  "producer: {dead_letter: {mode: topic}}",
+		error: "producer.dead_letter.topic must be specified in the topic mode",
+	}, {
+		yaml:  "consumer: {long_polling_timeout: -1s}",
+		error: "consumer.long_polling_timeout must be positive",
+	}, {
+		yaml:  "consumer: {max_pending_messages: 0}",
+		error: "consumer.max_pending_messages must be positive",
+	}, {
+		yaml:  "consumer: {group_membership: etcd}",
+		error: `consumer.group_membership must be one of zookeeper, kafka, got "etcd"`,
+	}, {
+		yaml:  "consumer: {member_grace_period: -1s}",
+		error: "consumer.member_grace_period must not be negative",
+	}, {
+		yaml:  "consumer: {heartbeat_interval: 15s}",
+		error: "consumer.heartbeat_interval must be lower than consumer.session_timeout",
+	}, {
+		yaml:  "admin: {metadata_ttl: 0s}",
+		error: "admin.metadata_ttl must be positive",
+	}, {
+		yaml:  "{zoo_keeper: {seed_peers: []}, consumer: {group_membership: kafka}}",
+		error: "",
+	}, {
+		yaml:  "consumer: {assignment_strategy: fair}",
+		error: `consumer.assignment_strategy must be one of range, roundrobin, sticky, got "fair"`,
+	}, {
+		yaml:  "consumer: {group_assignment_strategies: {foo: sticky, bar: fair}}",
+		error: `consumer.group_assignment_strategies.bar must be one of range, roundrobin, sticky, got "fair"`,
+	}, {
+		yaml:  "consumer: {auto_offset_reset: smallest}",
+		error: `consumer.auto_offset_reset must be one of earliest, latest, fail, got "smallest"`,
+	}, {
+		yaml:  "consumer: {group_auto_offset_resets: {foo: earliest, bar: none}}",
+		error: `consumer.group_auto_offset_resets.bar must be one of earliest, latest, fail, got "none"`,
+	}, {
+		yaml: "consumer: {retry: {delays: [1m, 10m]}}",
+	}, {
+		yaml:  "consumer: {retry: {delays: [10m, 1m]}}",
+		error: "consumer.retry.delays must be positive and ascending",
+	}, {
+		yaml:  "consumer: {retry: {delays: [0s]}}",
+		error: "consumer.retry.delays must be positive and ascending",
+	}, {
+		yaml:  `consumer: {retry: {dead_letter_suffix: ""}}`,
+		error: "consumer.retry.dead_letter_suffix must be specified",
+	}, {
+		yaml: "push_subscriptions: [{group: foo, topic: bar, url: 'http://localhost:8080/bar', concurrency: 4}]",
+	}, {
+		yaml:  "push_subscriptions: [{group: foo, url: 'http://localhost:8080/bar'}]",
+		error: `push_subscriptions\[0\] must specify group and topic`,
+	}, {
+		yaml:  "push_subscriptions: [{group: foo, topic: bar, url: localhost/bar}]",
+		error: `push_subscriptions\[0\].url must be an absolute http\(s\) URL, got "localhost/bar"`,
+	}, {
+		yaml:  "push_subscriptions: [{group: foo, topic: bar, url: 'http://a/b', max_retries: -1}]",
+		error: `push_subscriptions\[0\] parameters must not be negative`,
+	}, {
+		yaml:  "push_subscriptions: [{group: foo, topic: bar, url: 'http://a/b'}, {group: foo, topic: bar, url: 'http://a/c'}]",
+		error: `push_subscriptions\[1\] duplicates group=foo, topic=bar`,
+	}} {
+		cfg := validConfig()
+		c.Assert(cfg.LoadYAML([]byte(tc.yaml)), IsNil, Commentf("case #%d", i))
+
+		// When
+		err := cfg.Validate()
+
+		// Then
+		if tc.error == "" {
+			c.Assert(err, IsNil, Commentf("case #%d", i))
+			continue
+		}
+		c.Assert(err, ErrorMatches, tc.error, Commentf("case #%d", i))
+	}
+	c.Assert(validConfig().Validate(), IsNil)
+}
+
+func (s *ConfigSuite) TestGroupAssignmentStrategy(c *C) {
+	cfg := validConfig()
+	c.Assert(cfg.LoadYAML([]byte("consumer: {assignment_strategy: roundrobin, group_assignment_strategies: {foo: sticky}}")), IsNil)
+
+	// When/Then
+	c.Assert(cfg.GroupAssignmentStrategy("foo"), Equals, AssignmentSticky)
+	c.Assert(cfg.GroupAssignmentStrategy("bar"), Equals, AssignmentRoundRobin)
+}
+
+func (s *ConfigSuite) TestGroupAutoOffsetReset(c *C) {
+	cfg := validConfig()
+	c.Assert(cfg.LoadYAML([]byte("consumer: {auto_offset_reset: fail, group_auto_offset_resets: {foo: earliest}}")), IsNil)
+
+	// When/Then
+	c.Assert(cfg.GroupAutoOffsetReset("foo"), Equals, AutoOffsetResetEarliest)
+	c.Assert(cfg.GroupAutoOffsetReset("bar"), Equals, AutoOffsetResetFail)
+}
+
+// The client ID is written to the client ID file on the first start, and is
+// read from it on subsequent starts.
+func (s *ConfigSuite) TestLoadClientIDFile(c *C) {
+	clientIDFile := filepath.Join(c.MkDir(), "client_id")
+	cfg := validConfig()
+	cfg.ClientID = "pixy_first"
+	cfg.ClientIDFile = clientIDFile
+	c.Assert(cfg.LoadClientIDFile(), IsNil)
+
+	// When
+	cfg = validConfig()
+	cfg.ClientID = "pixy_second"
+	cfg.ClientIDFile = clientIDFile
+	err := cfg.LoadClientIDFile()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(cfg.ClientID, Equals, "pixy_first")
+	data, err := ioutil.ReadFile(clientIDFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "pixy_first\n")
+}
+
+func (s *ConfigSuite) TestSaramaProducerCfg(c *C) {
+	cfg := validConfig()
+	cfg.Producer.Compression = CompressionNone
+	cfg.Producer.RetryMax = 3
+
+	// When
+	saramaCfg := cfg.SaramaProducerCfg()
+
+	// Then
+	c.Assert(saramaCfg.Producer.Compression, Equals, sarama.CompressionNone)
+	c.Assert(saramaCfg.Producer.Retry.Max, Equals, 3)
+	c.Assert(saramaCfg.Producer.Flush.Frequency, Equals, cfg.Producer.FlushFrequency)
+	c.Assert(saramaCfg.Validate(), IsNil)
+}
+
+func validConfig() *T {
+	cfg := Default()
+	cfg.TCPAddr = "0.0.0.0:19092"
+	cfg.Kafka.SeedPeers = []string{"localhost:9092"}
+	cfg.ZooKeeper.SeedPeers = []string{"localhost:2181"}
+	return cfg
+}
@@ -1,5 +1,15 @@
 package consumer
 
+import (
+	"fmt"
+	"time"
+)
+
+// How long a client that keeps consuming messages in a loop should wait
+// before the next consume request, if the previous one was rejected due to
+// buffer overflow.
+const bufferOverflowBackOffTimeout = 500 * time.Millisecond
+
 type T interface {
 	// Consume consumes a message from the specified topic on behalf of the
 	// specified consumer group. If there are no more new messages in the topic
@@ -12,8 +22,109 @@ type T interface {
 	// `ErrBufferOverflow` or `ErrRequestTimeout` even when there are messages
 	// available for consumption. In that case the user should back off a bit
 	// and then repeat the request.
+	//
+	// A topic pattern created with `TopicPattern` can be passed in place of
+	// a topic name to consume messages from all topics that match it. If the
+	// pattern is not a valid regular expression, then an error is returned
+	// right away.
 	Consume(group, topic string) (*Message, error)
 
+	// ConsumeExplicitAck works pretty much the same way as `Consume`, except
+	// that the returned message is not considered to be consumed until it is
+	// acknowledged with `Ack`. If a message is not acknowledged within
+	// `Config.Consumer.AckTimeout`, then it is offered for consumption again.
+	ConsumeExplicitAck(group, topic string) (*Message, error)
+
+	// ConsumeBatch consumes up to `max` messages from the specified topic on
+	// behalf of the specified consumer group. It waits for the first message
+	// the same way as `Consume` does, and once it is available, keeps
+	// collecting messages for at most `maxWait`. If `maxWait` is zero, then
+	// only messages that are available right away are added to the batch.
+	// All messages in the batch are acknowledged at once.
+	ConsumeBatch(group, topic string, max int, maxWait time.Duration) ([]*Message, error)
+
+	// ConsumeBatchExplicitAck is a batch counterpart of `ConsumeExplicitAck`.
+	// Every message in the returned batch has to be acknowledged with `Ack`.
+	ConsumeBatchExplicitAck(group, topic string, max int, maxWait time.Duration) ([]*Message, error)
+
+	// Ack acknowledges messages of a topic partition consumed with either
+	// `ConsumeExplicitAck` or `ConsumeBatchExplicitAck`. All the specified
+	// offsets are applied to the partition at once. The committed offset of a
+	// partition never moves past the oldest message that has not been
+	// acknowledged yet. If the partition is not consumed by this instance at
+	// the moment, or any of the offsets is not pending acknowledgement, then
+	// `ErrInvalidAck` is returned and none of the offsets is applied.
+	Ack(group, topic string, partition int32, offsets ...int64) error
+
+	// Nack negatively acknowledges messages of a topic partition consumed
+	// with either `ConsumeExplicitAck` or `ConsumeBatchExplicitAck`. Every
+	// message is republished to the retry topic of its next retry, see
+	// `Config.Consumer.Retry`, or to the dead letter topic if it has run out
+	// of retries, and then acknowledged. Retry and dead letter topics are
+	// specific to the group, see `RetryTopic`. Messages of retry topics are
+	// consumed by the group along with messages of the topic they were
+	// derived from, but not before their retry delay elapses. If a message is not pending
+	// acknowledgement in this instance, then `ErrInvalidAck` is returned.
+	Nack(group, topic string, partition int32, offsets ...int64) error
+
+	// Seek makes the partition consumer of the group/topic/partition running
+	// in this instance drop all pending messages and continue consumption
+	// from the specified offset, which is submitted for commit. The offset is
+	// adjusted to the available offset range, and the adjusted value is
+	// returned. If the partition is not consumed by this instance at the
+	// moment, then `ErrNotConsumed` is returned.
+	Seek(group, topic string, partition int32, offset int64) (int64, error)
+
+	// SetAutoOffsetReset overrides the auto offset reset policy of the
+	// specified consumer group in this instance. It defines where partition
+	// consumers start if the group has no offset committed for a partition
+	// yet, or continue if the offset to fetch is out of the range available
+	// in Kafka. If the policy is `config.AutoOffsetResetFail`, then
+	// consumption of such a partition stops until its offset is reset with
+	// `Seek`, and consume requests that time out return `ErrNoValidOffset`.
+	SetAutoOffsetReset(group, policy string)
+
+	// Pause stops consumption of the topic by the consumer group in this
+	// instance. The group stays registered and keeps its partitions claimed,
+	// but no messages are fetched from Kafka, and consume requests return
+	// `ErrPaused` when `Config.Consumer.LongPollingTimeout` elapses. Messages
+	// that are pending acknowledgement can still be acknowledged. A topic
+	// pattern can be paused the same way as a topic, pausing a topic also
+	// pauses its retry topics.
+	Pause(group, topic string)
+
+	// Resume resumes consumption of the topic by the consumer group in this
+	// instance that was stopped with `Pause`.
+	Resume(group, topic string)
+
+	// IsPaused tells whether consumption of the topic by the consumer group
+	// is paused in this instance, either directly or by pausing a topic
+	// pattern that matches the topic.
+	IsPaused(group, topic string) bool
+
+	// RefreshPartitions makes all consumer groups running in this instance
+	// check partition counts of the topics they consume, and rebalance if
+	// any of them has changed. Partition counts are checked every
+	// `Config.Consumer.TopicScanInterval` anyway, this is to be called when
+	// partitions are known to have been added, to pick them up sooner. Since
+	// it takes a while for added partitions to show up in the cluster
+	// metadata, the check is repeated every `Config.Consumer.BackOffTimeout`
+	// until a change is detected or `Config.Consumer.TopicScanInterval`
+	// elapses.
+	RefreshPartitions()
+
+	// Read reads up to `limit` messages from the specified topic partition
+	// starting from the specified offset, bypassing consumer groups: no group
+	// is joined and no offsets are committed. The offset is adjusted to the
+	// available offset range. Reading stops as soon as either `limit`
+	// messages are read, or the high water mark that the partition had when
+	// the request was made is reached, so the call never waits for new
+	// messages to be produced. The messages are returned along with that
+	// high water mark. If no messages can be read within
+	// `Config.Consumer.LongPollingTimeout`, then `ErrRequestTimeout` is
+	// returned.
+	Read(topic string, partition int32, offset int64, limit int) ([]*Message, int64, error)
+
 	// Stop sends a shutdown signal to all internal goroutines and blocks until
 	// they are stopped. It is guaranteed that all last consumed offsets of all
 	// consumer groups/topics are committed to Kafka before Consumer stops.
@@ -27,10 +138,94 @@ type Message struct {
 	Partition     int32
 	Offset        int64
 	HighWaterMark int64
+	// The number of times the message has been negatively acknowledged. It
+	// is only non zero for messages consumed from retry topics.
+	Retries int
 }
 
-type (
-	ErrSetup          error
-	ErrBufferOverflow error
-	ErrRequestTimeout error
-)
+type ErrSetup error
+
+// The errors below are concrete types rather than aliases of `error` like
+// `ErrSetup`, so that they can be told apart with a type switch.
+
+// ErrNoValidOffset is returned by consume requests that time out while
+// consumption of a partition of the group/topic is stopped, because the group
+// has no valid offset for it and the auto offset reset policy is
+// `config.AutoOffsetResetFail`.
+type ErrNoValidOffset struct {
+	Err error
+}
+
+func (e ErrNoValidOffset) Error() string {
+	return e.Err.Error()
+}
+
+// ErrNotConsumed is returned by `T.Seek` if the partition is not consumed by
+// this instance at the moment.
+type ErrNotConsumed struct {
+	Err error
+}
+
+func (e ErrNotConsumed) Error() string {
+	return e.Err.Error()
+}
+
+// ErrRequestTimeout is returned by consume requests if no message has become
+// available within `Config.Consumer.LongPollingTimeout`.
+type ErrRequestTimeout struct {
+	Err error
+}
+
+func (e ErrRequestTimeout) Error() string {
+	return e.Err.Error()
+}
+
+// ErrBufferOverflow is returned by consume requests if there are too many
+// requests for the group/topic pending in this instance.
+type ErrBufferOverflow struct {
+	Err error
+}
+
+func (e ErrBufferOverflow) Error() string {
+	return e.Err.Error()
+}
+
+// ErrInvalidAck is returned by `T.Ack` and `T.Nack` if an offset is not
+// pending acknowledgement in this instance.
+type ErrInvalidAck struct {
+	Err error
+}
+
+func (e ErrInvalidAck) Error() string {
+	return e.Err.Error()
+}
+
+// ErrPaused is returned by consume requests for a group/topic whose
+// consumption is paused with `T.Pause`.
+type ErrPaused struct {
+	Group string
+	Topic string
+}
+
+func (e ErrPaused) Error() string {
+	return fmt.Sprintf("consumption is paused: group=%s, topic=%s", e.Group, e.Topic)
+}
+
+// RepeatAfter tells whether a client that keeps consuming messages in a
+// loop, e.g. to serve a stream, should repeat a consume request that failed
+// with the specified error, and how long it should wait before that.
+// `ErrRequestTimeout` and `ErrPaused` only mean that there is no message at
+// the moment, and since they are returned when the long polling timeout
+// elapses, a request can be repeated right away. On `ErrBufferOverflow` a
+// client should back off for a while to let the pending requests be served.
+// Any other error is final.
+func RepeatAfter(err error) (time.Duration, bool) {
+	switch err.(type) {
+	case ErrRequestTimeout, ErrPaused:
+		return 0, true
+	case ErrBufferOverflow:
+		return bufferOverflowBackOffTimeout, true
+	default:
+		return 0, false
+	}
+}
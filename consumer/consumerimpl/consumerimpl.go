@@ -10,7 +10,11 @@ import (
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
 	"github.com/mailgun/kafka-pixy/consumer/groupcsm"
+	"github.com/mailgun/kafka-pixy/consumer/msgstream"
 	"github.com/mailgun/kafka-pixy/consumer/offsetmgr"
+	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/log"
 	"github.com/wvanbergen/kazoo-go"
 )
 
@@ -23,6 +27,8 @@ import (
 // `Config.Consumer.RegistrationTimeout` period of time, the consumer
 // unsubscribes from the topic, likewise if a consumer group has not seen any
 // requests for that period then the consumer deregisters from the group.
+// Negatively acknowledged messages are republished with the producer passed
+// to `Spawn`.
 //
 // implements `consumer.T`.
 // implements `dispatcher.Factory`.
@@ -34,11 +40,14 @@ type t struct {
 	clientForOffsetMgrs sarama.Client
 	kazooConn           *kazoo.Kazoo
 	offsetMgrFactory    offsetmgr.Factory
+	partitionCsmReg     *partitioncsm.Registry
+	partitionsNotifier  *groupcsm.Notifier
+	prod                *producer.T
 }
 
 // Spawn creates a consumer instance with the specified configuration and
-// starts all its goroutines.
-func Spawn(namespace *actor.ID, cfg *config.T) (*t, error) {
+// starts all its goroutines. If the producer is nil, then `Nack` fails.
+func Spawn(namespace *actor.ID, cfg *config.T, prod *producer.T) (*t, error) {
 	saramaCfg := sarama.NewConfig()
 	saramaCfg.ClientID = cfg.ClientID
 	saramaCfg.ChannelBufferSize = cfg.Consumer.ChannelBufferSize
@@ -57,17 +66,21 @@ func Spawn(namespace *actor.ID, cfg *config.T) (*t, error) {
 		return nil, consumer.ErrSetup(fmt.Errorf("failed to create Kafka client for offset managers: err=(%v)", err))
 	}
 
-	kazooCfg := kazoo.NewConfig()
-	kazooCfg.Chroot = cfg.ZooKeeper.Chroot
-	// ZooKeeper documentation says following about the session timeout: "The
-	// current (ZooKeeper) implementation requires that the timeout be a
-	// minimum of 2 times the tickTime (as set in the server configuration) and
-	// a maximum of 20 times the tickTime". The default tickTime is 2 seconds.
-	// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
-	kazooCfg.Timeout = 15 * time.Second
-	kazooConn, err := kazoo.NewKazoo(cfg.ZooKeeper.SeedPeers, kazooCfg)
-	if err != nil {
-		return nil, consumer.ErrSetup(fmt.Errorf("failed to create kazoo.Kazoo: err=(%v)", err))
+	// ZooKeeper is not needed if group membership is maintained by Kafka.
+	var kazooConn *kazoo.Kazoo
+	if cfg.Consumer.GroupMembership == config.GroupMembershipZooKeeper {
+		kazooCfg := kazoo.NewConfig()
+		kazooCfg.Chroot = cfg.ZooKeeper.Chroot
+		// ZooKeeper documentation says following about the session timeout: "The
+		// current (ZooKeeper) implementation requires that the timeout be a
+		// minimum of 2 times the tickTime (as set in the server configuration) and
+		// a maximum of 20 times the tickTime". The default tickTime is 2 seconds.
+		// See http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#ch_zkSessions
+		kazooCfg.Timeout = 15 * time.Second
+		kazooConn, err = kazoo.NewKazoo(cfg.ZooKeeper.SeedPeers, kazooCfg)
+		if err != nil {
+			return nil, consumer.ErrSetup(fmt.Errorf("failed to create kazoo.Kazoo: err=(%v)", err))
+		}
 	}
 
 	offsetMgrFactory := offsetmgr.SpawnFactory(namespace, cfg, clientForOffsetMgrs)
@@ -79,6 +92,9 @@ func Spawn(namespace *actor.ID, cfg *config.T) (*t, error) {
 		clientForOffsetMgrs: clientForOffsetMgrs,
 		offsetMgrFactory:    offsetMgrFactory,
 		kazooConn:           kazooConn,
+		partitionCsmReg:     partitioncsm.NewRegistry(),
+		partitionsNotifier:  groupcsm.NewNotifier(),
+		prod:                prod,
 	}
 	c.dispatcher = dispatcher.New(c.namespace, c, c.cfg)
 	c.dispatcher.Start()
@@ -87,17 +103,213 @@ func Spawn(namespace *actor.ID, cfg *config.T) (*t, error) {
 
 // implements `consumer.T`
 func (c *t) Consume(group, topic string) (*consumer.Message, error) {
+	msg, err := c.ConsumeExplicitAck(group, topic)
+	if err != nil {
+		return nil, err
+	}
+	// If the partition has been reassigned to another group member after the
+	// message was consumed, then it is going to be consumed for the second
+	// time, but there is nothing we can do about that here. The message is
+	// acknowledged to the topic it was consumed from, for the requested
+	// topic can be a topic pattern.
+	if err := c.Ack(group, msg.Topic, msg.Partition, msg.Offset); err != nil {
+		log.Infof("<%s> auto ack failed: err=(%s)", c.namespace, err)
+	}
+	return msg, nil
+}
+
+// implements `consumer.T`
+func (c *t) ConsumeExplicitAck(group, topic string) (*consumer.Message, error) {
+	if err := checkTopicPattern(topic); err != nil {
+		return nil, err
+	}
 	replyCh := make(chan dispatcher.Response, 1)
-	c.dispatcher.Requests() <- dispatcher.Request{time.Now().UTC(), group, topic, replyCh}
+	c.dispatcher.Requests() <- dispatcher.Request{
+		Timestamp:  time.Now().UTC(),
+		Group:      group,
+		Topic:      topic,
+		ResponseCh: replyCh,
+	}
 	result := <-replyCh
-	return result.Msg, result.Err
+	return result.Msg, c.surfaceNoOffsetError(group, topic, result.Err)
+}
+
+// implements `consumer.T`
+func (c *t) ConsumeBatch(group, topic string, max int, maxWait time.Duration) ([]*consumer.Message, error) {
+	msgs, err := c.ConsumeBatchExplicitAck(group, topic, max, maxWait)
+	if err != nil {
+		return nil, err
+	}
+	// Acknowledge all messages of a partition at once to make the committed
+	// offset jump over the entire batch. If the batch was consumed via a
+	// topic pattern, then it can contain messages of several topics.
+	partitionOffsets := make(map[topicPartition][]int64)
+	for _, msg := range msgs {
+		tp := topicPartition{msg.Topic, msg.Partition}
+		partitionOffsets[tp] = append(partitionOffsets[tp], msg.Offset)
+	}
+	for tp, offsets := range partitionOffsets {
+		if err := c.Ack(group, tp.topic, tp.partition, offsets...); err != nil {
+			log.Infof("<%s> auto ack failed: err=(%s)", c.namespace, err)
+		}
+	}
+	return msgs, nil
+}
+
+// implements `consumer.T`
+func (c *t) ConsumeBatchExplicitAck(group, topic string, max int, maxWait time.Duration) ([]*consumer.Message, error) {
+	if err := checkTopicPattern(topic); err != nil {
+		return nil, err
+	}
+	if max < 1 {
+		max = 1
+	}
+	replyCh := make(chan dispatcher.Response, 1)
+	c.dispatcher.Requests() <- dispatcher.Request{
+		Timestamp:   time.Now().UTC(),
+		Group:       group,
+		Topic:       topic,
+		ResponseCh:  replyCh,
+		MaxMessages: max,
+		MaxWait:     maxWait,
+	}
+	result := <-replyCh
+	return result.Msgs, c.surfaceNoOffsetError(group, topic, result.Err)
+}
+
+// implements `consumer.T`
+func (c *t) Ack(group, topic string, partition int32, offsets ...int64) error {
+	return c.partitionCsmReg.Ack(group, topic, partition, offsets...)
+}
+
+// implements `consumer.T`
+func (c *t) Nack(group, topic string, partition int32, offsets ...int64) error {
+	if c.prod == nil {
+		return fmt.Errorf("negative acknowledgements are not supported")
+	}
+	for _, offset := range offsets {
+		msg, err := c.partitionCsmReg.Pending(group, topic, partition, offset)
+		if err != nil {
+			return err
+		}
+		// Retries of a message are derived from the topic it was originally
+		// produced to, rather than from the retry topic it was consumed from.
+		originTopic := topic
+		if msg.Retries > 0 {
+			if retryTopicOrigin, ok := consumer.RetryTopicOrigin(group, topic); ok {
+				originTopic = retryTopicOrigin
+			}
+		}
+		env := consumer.RetryEnvelope{
+			Group:   group,
+			Topic:   originTopic,
+			Retries: msg.Retries + 1,
+			Value:   msg.Value,
+		}
+		delays := c.cfg.Consumer.Retry.Delays
+		retryTopic := consumer.DeadLetterTopic(group, originTopic, c.cfg.Consumer.Retry.DeadLetterSuffix)
+		if msg.Retries < len(delays) {
+			retryTopic = consumer.RetryTopic(group, originTopic, delays[msg.Retries])
+			env.DueTime = time.Now().UTC().Add(delays[msg.Retries])
+		}
+		var key sarama.Encoder
+		if msg.Key != nil {
+			key = sarama.ByteEncoder(msg.Key)
+		}
+		if _, err := c.prod.Produce(retryTopic, key, sarama.ByteEncoder(consumer.EncodeRetryEnvelope(env))); err != nil {
+			return fmt.Errorf("failed to republish message: topic=%s, err=(%s)", retryTopic, err)
+		}
+		if err := c.Ack(group, topic, partition, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// implements `consumer.T`
+func (c *t) Seek(group, topic string, partition int32, offset int64) (int64, error) {
+	return c.partitionCsmReg.Seek(group, topic, partition, offset)
+}
+
+// implements `consumer.T`
+func (c *t) SetAutoOffsetReset(group, policy string) {
+	c.partitionCsmReg.SetAutoOffsetReset(group, policy)
+}
+
+// implements `consumer.T`
+func (c *t) Pause(group, topic string) {
+	c.partitionCsmReg.Pause(group, topic)
+}
+
+// implements `consumer.T`
+func (c *t) Resume(group, topic string) {
+	c.partitionCsmReg.Resume(group, topic)
+}
+
+// implements `consumer.T`
+func (c *t) IsPaused(group, topic string) bool {
+	return c.partitionCsmReg.IsPaused(group, topic)
+}
+
+// implements `consumer.T`
+func (c *t) RefreshPartitions() {
+	c.partitionsNotifier.Notify()
+}
+
+// implements `consumer.T`
+func (c *t) Read(topic string, partition int32, offset int64, limit int) ([]*consumer.Message, int64, error) {
+	highWaterMark, err := c.clientForMsgStreams.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, 0, err
+	}
+	// A dedicated message stream factory is used, for a factory allows only
+	// one message stream per partition at a time, and reads should neither
+	// interfere with consumer groups nor with each other. The factory is not
+	// associated with any consumer group.
+	namespace := c.namespace.NewChild("read", topic, partition)
+	msgStreamFactory, err := msgstream.SpawnFactory(namespace, "", c.clientForMsgStreams)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer msgStreamFactory.Stop()
+	ms, concreteOffset, err := msgStreamFactory.SpawnMessageStream(namespace, topic, partition, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ms.Stop()
+
+	var msgs []*consumer.Message
+	if concreteOffset >= highWaterMark || limit < 1 {
+		return nil, highWaterMark, nil
+	}
+	timeoutCh := time.After(c.cfg.Consumer.LongPollingTimeout)
+	for len(msgs) < limit {
+		select {
+		case msg, ok := <-ms.Messages():
+			if !ok {
+				return msgs, highWaterMark, nil
+			}
+			msgs = append(msgs, msg)
+			if msg.Offset+1 >= highWaterMark {
+				return msgs, highWaterMark, nil
+			}
+		case <-timeoutCh:
+			if len(msgs) == 0 {
+				return nil, 0, consumer.ErrRequestTimeout{Err: fmt.Errorf("long polling timeout")}
+			}
+			return msgs, highWaterMark, nil
+		}
+	}
+	return msgs, highWaterMark, nil
 }
 
 // implements `consumer.T`
 func (c *t) Stop() {
 	c.dispatcher.Stop()
 	c.offsetMgrFactory.Stop()
-	c.kazooConn.Close()
+	if c.kazooConn != nil {
+		c.kazooConn.Close()
+	}
 	c.clientForOffsetMgrs.Close()
 	c.clientForMsgStreams.Close()
 }
@@ -109,7 +321,38 @@ func (c *t) KeyOf(req dispatcher.Request) string {
 
 // implements `dispatcher.Factory`.
 func (c *t) NewTier(key string) dispatcher.Tier {
-	return groupcsm.New(c.namespace, key, c.cfg, c.clientForMsgStreams, c.kazooConn, c.offsetMgrFactory)
+	return groupcsm.New(c.namespace, key, c.cfg, c.clientForMsgStreams, c.kazooConn, c.offsetMgrFactory, c.partitionCsmReg, c.partitionsNotifier)
+}
+
+// surfaceNoOffsetError replaces a timeout of a consume request with an error
+// of a group/topic partition consumer that stopped consumption due to the
+// `fail` auto offset reset policy, for otherwise clients would never learn
+// why there are no messages. Other errors are returned as is.
+func (c *t) surfaceNoOffsetError(group, topic string, err error) error {
+	if _, ok := err.(consumer.ErrRequestTimeout); !ok {
+		return err
+	}
+	if noOffsetErr := c.partitionCsmReg.NoOffsetError(group, topic); noOffsetErr != nil {
+		return noOffsetErr
+	}
+	return err
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// checkTopicPattern returns an error if the topic is a topic pattern with an
+// invalid regular expression.
+func checkTopicPattern(topic string) error {
+	if !consumer.IsTopicPattern(topic) {
+		return nil
+	}
+	if _, err := consumer.CompileTopicPattern(topic); err != nil {
+		return fmt.Errorf("invalid topic pattern: %s, err=(%s)", topic, err)
+	}
+	return nil
 }
 
 // String returns a string ID of this instance to be used in logs.
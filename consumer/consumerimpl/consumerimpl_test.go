@@ -59,7 +59,7 @@ func (s *ConsumerSuite) TestInitialOffsetTooLarge(c *C) {
 	om.SubmitOffset(newestOffsets[0]+100, "")
 	om.Stop()
 
-	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("g1"))
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("g1"), nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -67,7 +67,7 @@ func (s *ConsumerSuite) TestInitialOffsetTooLarge(c *C) {
 	_, err = sc.Consume("g1", "test.1")
 
 	// Then
-	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout(fmt.Errorf("")))
+	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout{})
 
 	produced := s.kh.PutMessages("offset-too-large", "test.1", map[string]int{"key": 1})
 	consumed := s.consume(c, sc, "g1", "test.1", 1)
@@ -82,7 +82,7 @@ func (s *ConsumerSuite) TestSinglePartitionTopic(c *C) {
 	s.kh.ResetOffsets("g1", "test.1")
 	produced := s.kh.PutMessages("single", "test.1", map[string]int{"": 3})
 
-	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -99,7 +99,7 @@ func (s *ConsumerSuite) TestSequentialConsume(c *C) {
 	produced := s.kh.PutMessages("sequencial", "test.1", map[string]int{"": 3})
 
 	cfg := testhelpers.NewTestConfig("consumer-1")
-	sc1, err := Spawn(s.ns, cfg)
+	sc1, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	log.Infof("*** GIVEN 1")
 	consumed := s.consume(c, sc1, "g1", "test.1", 2)
@@ -109,7 +109,7 @@ func (s *ConsumerSuite) TestSequentialConsume(c *C) {
 	// When: one consumer stopped and another one takes its place.
 	log.Infof("*** WHEN")
 	sc1.Stop()
-	sc2, err := Spawn(s.ns, cfg)
+	sc2, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc2.Stop()
 
@@ -127,7 +127,7 @@ func (s *ConsumerSuite) TestMultiplePartitions(c *C) {
 	s.kh.PutMessages("multiple.partitions", "test.4", map[string]int{"A": 100, "B": 100})
 
 	log.Infof("*** GIVEN 1")
-	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -155,7 +155,7 @@ func (s *ConsumerSuite) TestMultipleTopics(c *C) {
 	produced4 := s.kh.PutMessages("multiple.topics", "test.4", map[string]int{"B": 1, "C": 1})
 
 	log.Infof("*** GIVEN 1")
-	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -181,7 +181,7 @@ func (s *ConsumerSuite) TestMultipleGroups(c *C) {
 	s.kh.PutMessages("multi", "test.4", map[string]int{"A": 10, "B": 10, "C": 10})
 
 	log.Infof("*** GIVEN 1")
-	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -204,7 +204,7 @@ func (s *ConsumerSuite) TestTooFewPartitions(c *C) {
 	s.kh.ResetOffsets("g1", "test.1")
 	produced := s.kh.PutMessages("few", "test.1", map[string]int{"": 3})
 
-	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc1.Stop()
 	log.Infof("*** GIVEN 1")
@@ -214,7 +214,7 @@ func (s *ConsumerSuite) TestTooFewPartitions(c *C) {
 
 	// When:
 	log.Infof("*** WHEN")
-	sc2, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-2"))
+	sc2, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-2"), nil)
 	c.Assert(err, IsNil)
 	defer sc2.Stop()
 	_, err = sc2.Consume("g1", "test.1")
@@ -236,7 +236,7 @@ func (s *ConsumerSuite) TestRebalanceOnJoin(c *C) {
 	s.kh.ResetOffsets("g1", "test.4")
 	s.kh.PutMessages("join", "test.4", map[string]int{"A": 10, "B": 10})
 
-	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc1.Stop()
 
@@ -257,7 +257,7 @@ func (s *ConsumerSuite) TestRebalanceOnJoin(c *C) {
 
 	// When: another consumer joins the group rebalancing occurs.
 	log.Infof("*** WHEN")
-	sc2, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-2"))
+	sc2, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-2"), nil)
 	c.Assert(err, IsNil)
 	defer sc2.Stop()
 
@@ -287,7 +287,7 @@ func (s *ConsumerSuite) TestRebalanceOnLeave(c *C) {
 	var err error
 	consumers := make([]*t, 3)
 	for i := 0; i < 3; i++ {
-		consumers[i], err = Spawn(s.ns, testhelpers.NewTestConfig(fmt.Sprintf("consumer-%d", i)))
+		consumers[i], err = Spawn(s.ns, testhelpers.NewTestConfig(fmt.Sprintf("consumer-%d", i)), nil)
 		c.Assert(err, IsNil)
 	}
 	defer consumers[0].Stop()
@@ -361,13 +361,13 @@ func (s *ConsumerSuite) TestRebalanceOnTimeout(c *C) {
 	s.kh.ResetOffsets("g1", "test.4")
 	s.kh.PutMessages("timeout", "test.4", map[string]int{"A": 10, "B": 10})
 
-	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"))
+	sc1, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
 	c.Assert(err, IsNil)
 	defer sc1.Stop()
 
 	cfg2 := testhelpers.NewTestConfig("consumer-2")
 	cfg2.Consumer.RegistrationTimeout = 300 * time.Millisecond
-	sc2, err := Spawn(s.ns, cfg2)
+	sc2, err := Spawn(s.ns, cfg2, nil)
 	c.Assert(err, IsNil)
 	defer sc2.Stop()
 
@@ -423,7 +423,7 @@ func (s *ConsumerSuite) TestBufferOverflowError(c *C) {
 
 	cfg := testhelpers.NewTestConfig("consumer-1")
 	cfg.Consumer.ChannelBufferSize = 1
-	sc, err := Spawn(s.ns, cfg)
+	sc, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -468,7 +468,7 @@ func (s *ConsumerSuite) TestRequestDuringTimeout(c *C) {
 	cfg := testhelpers.NewTestConfig("consumer-1")
 	cfg.Consumer.RegistrationTimeout = 200 * time.Millisecond
 	cfg.Consumer.ChannelBufferSize = 1
-	sc, err := Spawn(s.ns, cfg)
+	sc, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -499,7 +499,7 @@ func (s *ConsumerSuite) TestInvalidTopic(c *C) {
 	// Given
 	cfg := testhelpers.NewTestConfig("consumer-1")
 	cfg.Consumer.LongPollingTimeout = 1 * time.Second
-	sc, err := Spawn(s.ns, cfg)
+	sc, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -519,7 +519,7 @@ func (s *ConsumerSuite) TestLotsOfPartitions(c *C) {
 	s.kh.ResetOffsets("g1", "test.64")
 
 	cfg := testhelpers.NewTestConfig("consumer-1")
-	sc, err := Spawn(s.ns, cfg)
+	sc, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 
@@ -550,7 +550,7 @@ func (s *ConsumerSuite) TestNewGroup(c *C) {
 
 	group := fmt.Sprintf("g%d", time.Now().Unix())
 	cfg := testhelpers.NewTestConfig(group)
-	sc, err := Spawn(s.ns, cfg)
+	sc, err := Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 
 	// The very first consumption of a group is terminated by timeout because
@@ -566,7 +566,7 @@ func (s *ConsumerSuite) TestNewGroup(c *C) {
 	// Then: message produced after that will be consumed by the new consumer
 	// instance from the same group.
 	produced := s.kh.PutMessages("rand", "test.1", map[string]int{"A2": 1})
-	sc, err = Spawn(s.ns, cfg)
+	sc, err = Spawn(s.ns, cfg, nil)
 	c.Assert(err, IsNil)
 	defer sc.Stop()
 	msg, err = sc.Consume(group, "test.1")
@@ -587,14 +587,14 @@ func (s *ConsumerSuite) TestTopicTimeout(c *C) {
 	cfg1 := testhelpers.NewTestConfig("c1")
 	cfg1.Consumer.LongPollingTimeout = 3000 * time.Millisecond
 	cfg1.Consumer.RegistrationTimeout = 10000 * time.Millisecond
-	cons1, err := Spawn(s.ns, cfg1)
+	cons1, err := Spawn(s.ns, cfg1, nil)
 	c.Assert(err, IsNil)
 	defer cons1.Stop()
 
 	cfg2 := testhelpers.NewTestConfig("c2")
 	cfg2.Consumer.LongPollingTimeout = 3000 * time.Millisecond
 	cfg2.Consumer.RegistrationTimeout = 10000 * time.Millisecond
-	cons2, err := Spawn(s.ns, cfg2)
+	cons2, err := Spawn(s.ns, cfg2, nil)
 	c.Assert(err, IsNil)
 	defer cons2.Stop()
 
@@ -608,7 +608,7 @@ func (s *ConsumerSuite) TestTopicTimeout(c *C) {
 	c.Assert(len(consumedTest4ByCons1["B"]), Equals, 1)
 	msg, err := cons2.Consume("g1", "test.1")
 	c.Assert(msg, IsNil)
-	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout(fmt.Errorf("")))
+	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout{})
 
 	delay := (5000 * time.Millisecond) - time.Now().Sub(start)
 	log.Infof("*** sleeping for %v", delay)
@@ -619,7 +619,7 @@ func (s *ConsumerSuite) TestTopicTimeout(c *C) {
 	c.Assert(len(consumedTest4ByCons1["B"]), Equals, 2)
 	msg, err = cons2.Consume("g1", "test.1")
 	c.Assert(msg, IsNil)
-	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout(fmt.Errorf("")))
+	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout{})
 
 	// When: wait for the cons1 subscription to test.1 topic to expire.
 	log.Infof("*** WHEN")
@@ -635,6 +635,328 @@ func (s *ConsumerSuite) TestTopicTimeout(c *C) {
 	c.Assert(len(consumedTest4ByCons1["B"]), Equals, 3)
 }
 
+// If a message consumed in the explicit acknowledgement mode is not
+// acknowledged within `Config.Consumer.AckTimeout`, then it is offered for
+// consumption again.
+func (s *ConsumerSuite) TestExplicitAckRedelivery(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("explicit-ack", "test.1", map[string]int{"A": 2})
+
+	cfg := testhelpers.NewTestConfig("consumer-1")
+	cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	sc, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	msg1, err := sc.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg1, produced["A"][0])
+	msg2, err := sc.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg2, produced["A"][1])
+
+	// When: only the second message is acknowledged.
+	c.Assert(sc.Ack("g1", "test.1", msg2.Partition, msg2.Offset), IsNil)
+	time.Sleep(600 * time.Millisecond)
+
+	// Then: the first message is consumed again.
+	msg, err := sc.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg, produced["A"][0])
+	c.Assert(sc.Ack("g1", "test.1", msg.Partition, msg.Offset), IsNil)
+	_, err = sc.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout{})
+}
+
+// The committed offset never moves past the oldest unacknowledged message,
+// so a new consumer instance starts consumption from that message.
+func (s *ConsumerSuite) TestExplicitAckNotCommitted(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("explicit-ack", "test.1", map[string]int{"A": 3})
+
+	cfg := testhelpers.NewTestConfig("consumer-1")
+	sc1, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	_, err = sc1.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	msg2, err := sc1.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	c.Assert(sc1.Ack("g1", "test.1", msg2.Partition, msg2.Offset), IsNil)
+
+	// When
+	sc1.Stop()
+	sc2, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	defer sc2.Stop()
+
+	// Then: the second message is consumed again even though it has been
+	// acknowledged, for it follows the unacknowledged one.
+	consumed := s.consume(c, sc2, "g1", "test.1", 3)
+	assertMsg(c, consumed["A"][0], produced["A"][0])
+	assertMsg(c, consumed["A"][1], produced["A"][1])
+	assertMsg(c, consumed["A"][2], produced["A"][2])
+}
+
+// Messages consumed via a topic pattern are acknowledged to the topics they
+// come from, so the committed offsets move past them.
+func (s *ConsumerSuite) TestConsumePatternCommitted(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("pattern-auto-ack", "test.1", map[string]int{"A": 3})
+	cfg := testhelpers.NewTestConfig("consumer-1")
+	sc1, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	pattern := consumer.TopicPattern(`test\.1`)
+
+	// When
+	msg1, err := sc1.Consume("g1", pattern)
+	c.Assert(err, IsNil)
+	msg2, err := sc1.Consume("g1", pattern)
+	c.Assert(err, IsNil)
+	sc1.Stop()
+
+	// Then
+	assertMsg(c, msg1, produced["A"][0])
+	assertMsg(c, msg2, produced["A"][1])
+	sc2, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	defer sc2.Stop()
+	msg, err := sc2.Consume("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg, produced["A"][2])
+}
+
+// Batches consumed via a topic pattern are acknowledged to the topics their
+// messages come from, so the committed offsets move past them.
+func (s *ConsumerSuite) TestConsumeBatchPatternCommitted(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("pattern-batch-auto-ack", "test.1", map[string]int{"A": 3})
+	cfg := testhelpers.NewTestConfig("consumer-1")
+	sc1, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+
+	// When
+	msgs, err := sc1.ConsumeBatch("g1", consumer.TopicPattern(`test\.1`), 2, 5*time.Second)
+	c.Assert(err, IsNil)
+	sc1.Stop()
+
+	// Then
+	c.Assert(len(msgs), Equals, 2)
+	assertMsg(c, msgs[0], produced["A"][0])
+	assertMsg(c, msgs[1], produced["A"][1])
+	sc2, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	defer sc2.Stop()
+	msg, err := sc2.Consume("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg, produced["A"][2])
+}
+
+// An acknowledgement for a partition that is not consumed by the instance is
+// rejected.
+func (s *ConsumerSuite) TestAckNotConsumed(c *C) {
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	err = sc.Ack("g1", "test.1", 0, 1000)
+
+	// Then
+	c.Assert(err, FitsTypeOf, consumer.ErrInvalidAck{})
+	c.Assert(err.Error(), Equals, "partition is not consumed by this instance: group=g1, topic=test.1, partition=0")
+}
+
+// Seek makes a running partition consumer drop pending messages and continue
+// consumption from the specified offset.
+func (s *ConsumerSuite) TestSeek(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("seek", "test.1", map[string]int{"A": 3})
+
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	s.consume(c, sc, "g1", "test.1", 2)
+	msg3, err := sc.ConsumeExplicitAck("g1", "test.1")
+	c.Assert(err, IsNil)
+	assertMsg(c, msg3, produced["A"][2])
+
+	// When
+	offset, err := sc.Seek("g1", "test.1", msg3.Partition, produced["A"][0].Offset)
+
+	// Then: the unacknowledged message is dropped, and all messages are
+	// consumed again.
+	c.Assert(err, IsNil)
+	c.Assert(offset, Equals, produced["A"][0].Offset)
+	consumed := s.consume(c, sc, "g1", "test.1", 3)
+	assertMsg(c, consumed["A"][0], produced["A"][0])
+	assertMsg(c, consumed["A"][1], produced["A"][1])
+	assertMsg(c, consumed["A"][2], produced["A"][2])
+}
+
+// A seek for a partition that is not consumed by the instance is rejected.
+func (s *ConsumerSuite) TestSeekNotConsumed(c *C) {
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	_, err = sc.Seek("g1", "test.1", 0, 1000)
+
+	// Then
+	c.Assert(err, FitsTypeOf, consumer.ErrNotConsumed{})
+	c.Assert(err.Error(), Equals, "partition is not consumed by this instance: group=g1, topic=test.1, partition=0")
+}
+
+// A read returns messages of a partition starting from the specified offset
+// and does not affect offsets committed by consumer groups.
+func (s *ConsumerSuite) TestRead(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("read", "test.1", map[string]int{"A": 3})
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	msgs, highWaterMark, err := sc.Read("test.1", 0, produced["A"][1].Offset, 10)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(highWaterMark, Equals, produced["A"][2].Offset+1)
+	c.Assert(len(msgs), Equals, 2)
+	assertMsg(c, msgs[0], produced["A"][1])
+	assertMsg(c, msgs[1], produced["A"][2])
+
+	// The group offset is not affected by the read.
+	consumed := s.consume(c, sc, "g1", "test.1", 3)
+	assertMsg(c, consumed["A"][0], produced["A"][0])
+}
+
+// A read returns no more then `limit` messages, and several reads of the same
+// partition can be made at the same time.
+func (s *ConsumerSuite) TestReadLimit(c *C) {
+	// Given
+	produced := s.kh.PutMessages("read-limit", "test.1", map[string]int{"A": 3})
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	var wg sync.WaitGroup
+	results := make([][]*consumer.Message, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, errs[i] = sc.Read("test.1", 0, produced["A"][0].Offset, 2)
+		}(i)
+	}
+	wg.Wait()
+
+	// Then
+	for i, msgs := range results {
+		c.Assert(errs[i], IsNil)
+		c.Assert(len(msgs), Equals, 2)
+		assertMsg(c, msgs[0], produced["A"][0])
+		assertMsg(c, msgs[1], produced["A"][1])
+	}
+}
+
+// A read from the high water mark returns no messages right away.
+func (s *ConsumerSuite) TestReadHighWaterMark(c *C) {
+	// Given
+	produced := s.kh.PutMessages("read-hwm", "test.1", map[string]int{"A": 1})
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	msgs, highWaterMark, err := sc.Read("test.1", 0, sarama.OffsetNewest, 10)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(msgs, IsNil)
+	c.Assert(highWaterMark, Equals, produced["A"][0].Offset+1)
+}
+
+// A batch consume returns up to the requested number of messages as soon as
+// the batch is full.
+func (s *ConsumerSuite) TestConsumeBatch(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("batch", "test.1", map[string]int{"A": 5})
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	msgs, err := sc.ConsumeBatch("g1", "test.1", 3, 5*time.Second)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(len(msgs), Equals, 3)
+	for i, msg := range msgs {
+		assertMsg(c, msg, produced["A"][i])
+	}
+	msgs, err = sc.ConsumeBatch("g1", "test.1", 3, 100*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(len(msgs), Equals, 2)
+	assertMsg(c, msgs[0], produced["A"][3])
+	assertMsg(c, msgs[1], produced["A"][4])
+}
+
+// If there are no messages available then a batch consume times out the same
+// way a single message consume does.
+func (s *ConsumerSuite) TestConsumeBatchTimeout(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	sc, err := Spawn(s.ns, testhelpers.NewTestConfig("consumer-1"), nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	// When
+	msgs, err := sc.ConsumeBatch("g1", "test.1", 10, 100*time.Millisecond)
+
+	// Then
+	c.Assert(err, FitsTypeOf, consumer.ErrRequestTimeout{})
+	c.Assert(msgs, IsNil)
+}
+
+// Messages of a batch consumed in explicit acknowledgement mode are
+// redelivered unless acknowledged.
+func (s *ConsumerSuite) TestConsumeBatchExplicitAck(c *C) {
+	// Given
+	s.kh.ResetOffsets("g1", "test.1")
+	produced := s.kh.PutMessages("batch-explicit-ack", "test.1", map[string]int{"A": 3})
+
+	cfg := testhelpers.NewTestConfig("consumer-1")
+	cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	sc, err := Spawn(s.ns, cfg, nil)
+	c.Assert(err, IsNil)
+	defer sc.Stop()
+
+	msgs, err := sc.ConsumeBatchExplicitAck("g1", "test.1", 3, 5*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(len(msgs), Equals, 3)
+
+	// When: all but the last message are acknowledged at once.
+	c.Assert(sc.Ack("g1", "test.1", msgs[0].Partition, msgs[0].Offset, msgs[1].Offset), IsNil)
+	time.Sleep(600 * time.Millisecond)
+
+	// Then
+	msgs, err = sc.ConsumeBatchExplicitAck("g1", "test.1", 3, 100*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(len(msgs), Equals, 1)
+	assertMsg(c, msgs[0], produced["A"][2])
+}
+
 func assertMsg(c *C, consMsg *consumer.Message, prodMsg *sarama.ProducerMessage) {
 	c.Assert(sarama.StringEncoder(consMsg.Value), Equals, prodMsg.Value)
 	c.Assert(consMsg.Offset, Equals, prodMsg.Offset)
@@ -33,11 +33,19 @@ type Request struct {
 	Group      string
 	Topic      string
 	ResponseCh chan<- Response
+	// The maximum number of messages to be returned in a batch. If it is
+	// zero, then exactly one message is requested.
+	MaxMessages int
+	// How long to wait for a batch to fill up once the first message is
+	// available. If it is zero, then only messages available right away are
+	// added to the batch.
+	MaxWait time.Duration
 }
 
 type Response struct {
-	Msg *consumer.Message
-	Err error
+	Msg  *consumer.Message
+	Msgs []*consumer.Message
+	Err  error
 }
 
 // Factory defines an interface to create Tiers.
@@ -123,7 +131,7 @@ func (d *T) run() {
 			select {
 			case dt.Requests() <- req:
 			default:
-				overflowErr := consumer.ErrBufferOverflow(fmt.Errorf("<%s> buffer overflow", dt))
+				overflowErr := consumer.ErrBufferOverflow{Err: fmt.Errorf("<%s> buffer overflow", dt)}
 				req.ResponseCh <- Response{Err: overflowErr}
 			}
 
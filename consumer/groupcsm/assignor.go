@@ -0,0 +1,200 @@
+package groupcsm
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"github.com/mailgun/kafka-pixy/config"
+)
+
+// Assignor divides partitions of a topic among consumer group members
+// subscribed to it. Every group member resolves partitions assigned to it on
+// its own, so an assignor must produce the same result on all members given
+// the same partitions and subscribers, regardless of their order.
+type Assignor interface {
+	// Assign returns a map of subscribers to partitions assigned to them.
+	// Subscribers that are not assigned any partitions are omitted. Nil is
+	// returned if there are either no partitions or no subscribers.
+	Assign(topic string, partitions []int32, subscribers []string) map[string][]int32
+}
+
+// newAssignor returns an assignor that implements the specified strategy.
+// The range assignor is returned for an unknown strategy, but that should
+// never happen for strategies are checked by `config.T.Validate`.
+func newAssignor(strategy string) Assignor {
+	switch strategy {
+	case config.AssignmentRoundRobin:
+		return roundRobinAssignor{}
+	case config.AssignmentSticky:
+		return stickyAssignor{}
+	default:
+		return rangeAssignor{}
+	}
+}
+
+// rangeAssignor gives every subscriber a contiguous range of partitions. The
+// algorithm used closely resembles the one implemented by the standard Java
+// High-Level consumer (see http://kafka.apache.org/documentation.html#distributionimpl
+// and scroll down to *Consumer registration algorithm*) except it does not
+// take in account how partitions are distributed among brokers.
+//
+// implements `Assignor`.
+type rangeAssignor struct{}
+
+func (rangeAssignor) Assign(topic string, partitions []int32, subscribers []string) map[string][]int32 {
+	partitionCount := len(partitions)
+	subscriberCount := len(subscribers)
+	if partitionCount == 0 || subscriberCount == 0 {
+		return nil
+	}
+	sort.Sort(Int32Slice(partitions))
+	sort.Sort(sort.StringSlice(subscribers))
+
+	subscribersToPartitions := make(map[string][]int32, subscriberCount)
+	partitionsPerSubscriber := partitionCount / subscriberCount
+	extra := partitionCount - subscriberCount*partitionsPerSubscriber
+
+	begin := 0
+	for _, groupMemberID := range subscribers {
+		end := begin + partitionsPerSubscriber
+		if extra != 0 {
+			end++
+			extra--
+		}
+		assigned := partitions[begin:end]
+		if len(assigned) > 0 {
+			subscribersToPartitions[groupMemberID] = partitions[begin:end]
+		}
+		begin = end
+	}
+	return subscribersToPartitions
+}
+
+// roundRobinAssignor deals partitions to subscribers one by one in order.
+//
+// implements `Assignor`.
+type roundRobinAssignor struct{}
+
+func (roundRobinAssignor) Assign(topic string, partitions []int32, subscribers []string) map[string][]int32 {
+	if len(partitions) == 0 || len(subscribers) == 0 {
+		return nil
+	}
+	sort.Sort(Int32Slice(partitions))
+	sort.Sort(sort.StringSlice(subscribers))
+
+	subscribersToPartitions := make(map[string][]int32, len(subscribers))
+	for i, partition := range partitions {
+		groupMemberID := subscribers[i%len(subscribers)]
+		subscribersToPartitions[groupMemberID] = append(subscribersToPartitions[groupMemberID], partition)
+	}
+	return subscribersToPartitions
+}
+
+// stickyAssignor balances partitions among subscribers so that only a small
+// share of them moves to another subscriber when subscribers join or leave.
+//
+// Group members do not share their current assignments with each other, so
+// the assignment has to be derived from the partitions and subscribers only.
+// It is done with rendezvous hashing with bounded load: every partition and
+// subscriber pair is given a pseudo random weight, and pairs are considered
+// in the order of descending weight. A partition goes to the subscriber of the
+// first pair considered, unless the subscriber already has its fair share of
+// partitions. Weights of pairs do not depend on other subscribers, so when a
+// subscriber joins the group it takes over mostly partitions that it has the
+// highest weight for, and most of the rest stay where they were. Likewise
+// when a subscriber leaves, mostly its own partitions are redistributed.
+//
+// implements `Assignor`.
+type stickyAssignor struct{}
+
+func (stickyAssignor) Assign(topic string, partitions []int32, subscribers []string) map[string][]int32 {
+	partitionCount := len(partitions)
+	subscriberCount := len(subscribers)
+	if partitionCount == 0 || subscriberCount == 0 {
+		return nil
+	}
+	pairs := make(weightedPairs, 0, partitionCount*subscriberCount)
+	for _, partition := range partitions {
+		for _, groupMemberID := range subscribers {
+			pairs = append(pairs, weightedPair{
+				partition:     partition,
+				groupMemberID: groupMemberID,
+				weight:        pairWeight(topic, partition, groupMemberID),
+			})
+		}
+	}
+	sort.Sort(pairs)
+
+	// Every subscriber gets either `partitionsPerSubscriber` partitions, or
+	// one more if it is among the first `extra` subscribers to reach that
+	// number.
+	partitionsPerSubscriber := partitionCount / subscriberCount
+	extra := partitionCount - subscriberCount*partitionsPerSubscriber
+
+	subscribersToPartitions := make(map[string][]int32, subscriberCount)
+	assigned := make(map[int32]bool, partitionCount)
+	for _, pair := range pairs {
+		if assigned[pair.partition] {
+			continue
+		}
+		count := len(subscribersToPartitions[pair.groupMemberID])
+		if count > partitionsPerSubscriber {
+			continue
+		}
+		if count == partitionsPerSubscriber {
+			if extra == 0 {
+				continue
+			}
+			extra--
+		}
+		assigned[pair.partition] = true
+		subscribersToPartitions[pair.groupMemberID] = append(subscribersToPartitions[pair.groupMemberID], pair.partition)
+	}
+	for _, assignedPartitions := range subscribersToPartitions {
+		sort.Sort(Int32Slice(assignedPartitions))
+	}
+	return subscribersToPartitions
+}
+
+// pairWeight returns a pseudo random weight of a partition and subscriber
+// pair, that is the same on all group members.
+func pairWeight(topic string, partition int32, groupMemberID string) uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(partition))
+	h.Write([]byte(topic))
+	h.Write(buf[:])
+	h.Write([]byte(groupMemberID))
+	// FNV values of similar inputs are close to each other, so they are
+	// mixed with the splitmix64 finalizer.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type weightedPair struct {
+	partition     int32
+	groupMemberID string
+	weight        uint64
+}
+
+// weightedPairs sorts pairs by weight in descending order. Ties are broken
+// by subscriber and partition to keep the order deterministic.
+type weightedPairs []weightedPair
+
+func (p weightedPairs) Len() int      { return len(p) }
+func (p weightedPairs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p weightedPairs) Less(i, j int) bool {
+	if p[i].weight != p[j].weight {
+		return p[i].weight > p[j].weight
+	}
+	if p[i].groupMemberID != p[j].groupMemberID {
+		return p[i].groupMemberID < p[j].groupMemberID
+	}
+	return p[i].partition < p[j].partition
+}
@@ -0,0 +1,306 @@
+package groupcsm
+
+import (
+	"fmt"
+
+	"github.com/mailgun/kafka-pixy/config"
+	. "gopkg.in/check.v1"
+)
+
+type AssignorSuite struct{}
+
+var _ = Suite(&AssignorSuite{})
+
+func (s *AssignorSuite) TestNewAssignor(c *C) {
+	c.Assert(newAssignor(config.AssignmentRange), Equals, rangeAssignor{})
+	c.Assert(newAssignor(config.AssignmentRoundRobin), Equals, roundRobinAssignor{})
+	c.Assert(newAssignor(config.AssignmentSticky), Equals, stickyAssignor{})
+}
+
+// All assignors return nil if there is nothing to assign or nobody to assign
+// it to.
+func (s *AssignorSuite) TestAssignEmpty(c *C) {
+	for _, assignor := range []Assignor{rangeAssignor{}, roundRobinAssignor{}, stickyAssignor{}} {
+		for i, tc := range []struct {
+			partitions  []int32
+			subscribers []string
+		}{
+			{nil, nil},
+			{nil, []string{}},
+			{nil, []string{"a"}},
+			{nil, []string{"a", "b"}},
+			{[]int32{}, nil},
+			{[]int32{}, []string{}},
+			{[]int32{}, []string{"a"}},
+			{[]int32{}, []string{"a", "b"}},
+			{[]int32{1}, nil},
+			{[]int32{1}, []string{}},
+		} {
+			c.Assert(assignor.Assign("t", tc.partitions, tc.subscribers), IsNil,
+				Commentf("%T case #%d", assignor, i))
+		}
+	}
+}
+
+func (s *AssignorSuite) TestRange(c *C) {
+	for i, tc := range []struct {
+		partitions  []int32
+		subscribers []string
+		assigned    map[string][]int32
+	}{{
+		partitions:  []int32{0},
+		subscribers: []string{"a"},
+		assigned:    map[string][]int32{"a": {0}},
+	}, {
+		partitions:  []int32{1, 2, 0},
+		subscribers: []string{"a"},
+		assigned:    map[string][]int32{"a": {0, 1, 2}},
+	}, {
+		partitions:  []int32{0},
+		subscribers: []string{"b", "a"},
+		assigned:    map[string][]int32{"a": {0}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2},
+		subscribers: []string{"b", "a"},
+		assigned:    map[string][]int32{"a": {0, 1}, "b": {2, 3}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 1}, "b": {2}, "c": {3}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2, 4},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 1}, "b": {2, 3}, "c": {4}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2, 5, 4},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 1}, "b": {2, 3}, "c": {4, 5}},
+	}, {
+		partitions:  []int32{6, 0, 3, 1, 2, 5, 4},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 1, 2}, "b": {3, 4}, "c": {5, 6}},
+	}, {
+		partitions:  []int32{6, 0, 3, 1, 2, 5, 4},
+		subscribers: []string{"d", "b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 1}, "b": {2, 3}, "c": {4, 5}, "d": {6}},
+	}} {
+		// When
+		assigned := rangeAssignor{}.Assign("t", tc.partitions, tc.subscribers)
+
+		// Then
+		c.Assert(assigned, DeepEquals, tc.assigned, Commentf("case #%d", i))
+	}
+}
+
+func (s *AssignorSuite) TestRoundRobin(c *C) {
+	for i, tc := range []struct {
+		partitions  []int32
+		subscribers []string
+		assigned    map[string][]int32
+	}{{
+		partitions:  []int32{0},
+		subscribers: []string{"a"},
+		assigned:    map[string][]int32{"a": {0}},
+	}, {
+		partitions:  []int32{1, 2, 0},
+		subscribers: []string{"a"},
+		assigned:    map[string][]int32{"a": {0, 1, 2}},
+	}, {
+		partitions:  []int32{0},
+		subscribers: []string{"b", "a"},
+		assigned:    map[string][]int32{"a": {0}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2},
+		subscribers: []string{"b", "a"},
+		assigned:    map[string][]int32{"a": {0, 2}, "b": {1, 3}},
+	}, {
+		partitions:  []int32{0, 3, 1, 2},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 3}, "b": {1}, "c": {2}},
+	}, {
+		partitions:  []int32{6, 0, 3, 1, 2, 5, 4},
+		subscribers: []string{"b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 3, 6}, "b": {1, 4}, "c": {2, 5}},
+	}, {
+		partitions:  []int32{6, 0, 3, 1, 2, 5, 4},
+		subscribers: []string{"d", "b", "c", "a"},
+		assigned:    map[string][]int32{"a": {0, 4}, "b": {1, 5}, "c": {2, 6}, "d": {3}},
+	}} {
+		// When
+		assigned := roundRobinAssignor{}.Assign("t", tc.partitions, tc.subscribers)
+
+		// Then
+		c.Assert(assigned, DeepEquals, tc.assigned, Commentf("case #%d", i))
+	}
+}
+
+// The sticky assignor assigns every partition exactly once, and every
+// subscriber gets a fair share of partitions. The result does not depend on
+// the order of partitions and subscribers.
+func (s *AssignorSuite) TestStickyBalanced(c *C) {
+	for i, tc := range []struct {
+		partitionCount  int
+		subscriberCount int
+	}{
+		{1, 1},
+		{1, 3},
+		{3, 2},
+		{7, 3},
+		{8, 4},
+		{10, 3},
+		{32, 5},
+		{64, 9},
+		{100, 7},
+	} {
+		partitions := makePartitions(tc.partitionCount)
+		subscribers := makeSubscribers(tc.subscriberCount)
+
+		// When
+		assigned := stickyAssignor{}.Assign("t", partitions, subscribers)
+
+		// Then
+		comment := Commentf("case #%d", i)
+		minCount := tc.partitionCount / tc.subscriberCount
+		maxCount := minCount
+		if tc.partitionCount%tc.subscriberCount != 0 {
+			maxCount++
+		}
+		if minCount == 0 {
+			c.Assert(len(assigned), Equals, tc.partitionCount, comment)
+		} else {
+			c.Assert(len(assigned), Equals, tc.subscriberCount, comment)
+		}
+		seen := make(map[int32]bool)
+		for _, assignedPartitions := range assigned {
+			c.Assert(len(assignedPartitions) >= minCount, Equals, true, comment)
+			c.Assert(len(assignedPartitions) <= maxCount, Equals, true, comment)
+			for _, partition := range assignedPartitions {
+				c.Assert(seen[partition], Equals, false, comment)
+				seen[partition] = true
+			}
+		}
+		c.Assert(len(seen), Equals, tc.partitionCount, comment)
+
+		reversedPartitions := make([]int32, len(partitions))
+		for j, partition := range partitions {
+			reversedPartitions[len(partitions)-1-j] = partition
+		}
+		reversedSubscribers := make([]string, len(subscribers))
+		for j, subscriber := range subscribers {
+			reversedSubscribers[len(subscribers)-1-j] = subscriber
+		}
+		c.Assert(stickyAssignor{}.Assign("t", reversedPartitions, reversedSubscribers),
+			DeepEquals, assigned, comment)
+	}
+}
+
+// When subscribers join or leave, the sticky assignor moves far fewer
+// partitions than the range assignor. For reference, the least possible
+// number of moved partitions is given in comments.
+func (s *AssignorSuite) TestStickyMovement(c *C) {
+	for i, tc := range []struct {
+		partitionCount int
+		before         []string
+		after          []string
+		maxMoved       int
+	}{{
+		// Least possible: 21
+		partitionCount: 64,
+		before:         makeSubscribers(2),
+		after:          makeSubscribers(3),
+		maxMoved:       24,
+	}, {
+		// Least possible: 7
+		partitionCount: 64,
+		before:         makeSubscribers(8),
+		after:          makeSubscribers(9),
+		maxMoved:       12,
+	}, {
+		// Least possible: 11
+		partitionCount: 100,
+		before:         makeSubscribers(8),
+		after:          makeSubscribers(9),
+		maxMoved:       16,
+	}, {
+		// Least possible: 7
+		partitionCount: 64,
+		before:         makeSubscribers(9),
+		after:          makeSubscribers(8),
+		maxMoved:       12,
+	}, {
+		// Least possible: 16
+		partitionCount: 32,
+		before:         makeSubscribers(2),
+		after:          makeSubscribers(2)[1:],
+		maxMoved:       16,
+	}} {
+		partitions := makePartitions(tc.partitionCount)
+		sticky := stickyAssignor{}
+		rng := rangeAssignor{}
+
+		// When
+		stickyMoved := countMoved(sticky.Assign("t", partitions, tc.before), sticky.Assign("t", partitions, tc.after))
+		rangeMoved := countMoved(rng.Assign("t", partitions, tc.before), rng.Assign("t", partitions, tc.after))
+
+		// Then
+		comment := Commentf("case #%d: sticky=%d, range=%d", i, stickyMoved, rangeMoved)
+		c.Assert(stickyMoved <= tc.maxMoved, Equals, true, comment)
+		c.Assert(stickyMoved <= rangeMoved, Equals, true, comment)
+	}
+}
+
+// Partitions of different topics are spread differently, so that the same
+// subscriber does not get all the extra partitions.
+func (s *AssignorSuite) TestStickyTopics(c *C) {
+	subscribers := makeSubscribers(3)
+	extraCount := make(map[string]int)
+
+	// When
+	for i := 0; i < 30; i++ {
+		assigned := stickyAssignor{}.Assign(fmt.Sprintf("t%d", i), makePartitions(4), subscribers)
+		for subscriber, partitions := range assigned {
+			if len(partitions) == 2 {
+				extraCount[subscriber]++
+			}
+		}
+	}
+
+	// Then
+	c.Assert(len(extraCount), Equals, 3)
+}
+
+func makePartitions(count int) []int32 {
+	partitions := make([]int32, count)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions
+}
+
+func makeSubscribers(count int) []string {
+	subscribers := make([]string, count)
+	for i := range subscribers {
+		subscribers[i] = fmt.Sprintf("pixy_%d", i)
+	}
+	return subscribers
+}
+
+// countMoved returns the number of partitions that are assigned to different
+// subscribers in the before and after assignments.
+func countMoved(before, after map[string][]int32) int {
+	owners := make(map[int32]string)
+	for subscriber, partitions := range before {
+		for _, partition := range partitions {
+			owners[partition] = subscriber
+		}
+	}
+	moved := 0
+	for subscriber, partitions := range after {
+		for _, partition := range partitions {
+			if owners[partition] != subscriber {
+				moved++
+			}
+		}
+	}
+	return moved
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/mailgun/kafka-pixy/consumer/offsetmgr"
 	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
 	"github.com/mailgun/kafka-pixy/consumer/topiccsm"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
 	"github.com/wvanbergen/kazoo-go"
@@ -28,43 +29,56 @@ import (
 // implements `dispatcher.Factory`.
 // implements `dispatcher.Tier`.
 type T struct {
-	supActorID         *actor.ID
-	mgrActorID         *actor.ID
-	cfg                *config.T
-	group              string
-	dispatcher         *dispatcher.T
-	saramaClient       sarama.Client
-	kazooConn          *kazoo.Kazoo
-	msgStreamFactory   msgstream.Factory
-	offsetMgrFactory   offsetmgr.Factory
-	groupMember        *groupmember.T
-	multiplexers       map[string]*multiplexer.T
-	topicCsmLifespanCh chan *topiccsm.T
-	stopCh             chan none.T
-	wg                 sync.WaitGroup
+	supActorID          *actor.ID
+	mgrActorID          *actor.ID
+	cfg                 *config.T
+	group               string
+	dispatcher          *dispatcher.T
+	saramaClient        sarama.Client
+	kazooConn           *kazoo.Kazoo
+	msgStreamFactory    msgstream.Factory
+	offsetMgrFactory    offsetmgr.Factory
+	partitionCsmReg     *partitioncsm.Registry
+	notifier            *Notifier
+	assignor            Assignor
+	groupMember         groupmember.T
+	multiplexers        map[string]*multiplexer.T
+	topicCsmLifespanCh  chan *topiccsm.T
+	partitionsChangedCh chan none.T
+	stopCh              chan none.T
+	wg                  sync.WaitGroup
 
 	// Exist just to be overridden in tests with mocks.
 	fetchTopicPartitionsFn func(topic string) ([]int32, error)
+	fetchTopicsFn          func() ([]string, error)
+	refreshMetadataFn      func(topics ...string) error
 }
 
 func New(namespace *actor.ID, group string, cfg *config.T, saramaClient sarama.Client,
-	kazooConn *kazoo.Kazoo, offsetMgrFactory offsetmgr.Factory,
+	kazooConn *kazoo.Kazoo, offsetMgrFactory offsetmgr.Factory, partitionCsmReg *partitioncsm.Registry,
+	notifier *Notifier,
 ) *T {
 	supervisorActorID := namespace.NewChild(fmt.Sprintf("G:%s", group))
 	gc := &T{
-		supActorID:         supervisorActorID,
-		mgrActorID:         supervisorActorID.NewChild("manager"),
-		cfg:                cfg,
-		group:              group,
-		saramaClient:       saramaClient,
-		kazooConn:          kazooConn,
-		offsetMgrFactory:   offsetMgrFactory,
-		multiplexers:       make(map[string]*multiplexer.T),
-		topicCsmLifespanCh: make(chan *topiccsm.T),
-		stopCh:             make(chan none.T),
+		supActorID:          supervisorActorID,
+		mgrActorID:          supervisorActorID.NewChild("manager"),
+		cfg:                 cfg,
+		group:               group,
+		saramaClient:        saramaClient,
+		kazooConn:           kazooConn,
+		offsetMgrFactory:    offsetMgrFactory,
+		partitionCsmReg:     partitionCsmReg,
+		notifier:            notifier,
+		assignor:            newAssignor(cfg.GroupAssignmentStrategy(group)),
+		multiplexers:        make(map[string]*multiplexer.T),
+		topicCsmLifespanCh:  make(chan *topiccsm.T),
+		partitionsChangedCh: make(chan none.T, 1),
+		stopCh:              make(chan none.T),
 
 		fetchTopicPartitionsFn: saramaClient.Partitions,
+		refreshMetadataFn:      saramaClient.RefreshMetadata,
 	}
+	gc.fetchTopicsFn = gc.fetchTopics
 	gc.dispatcher = dispatcher.New(gc.supActorID, gc, cfg)
 	return gc
 }
@@ -76,7 +90,7 @@ func (gc *T) KeyOf(req dispatcher.Request) string {
 
 // implements `dispatcher.Factory`.
 func (gc *T) NewTier(key string) dispatcher.Tier {
-	tc := topiccsm.New(gc.supActorID, gc.group, key, gc.cfg, gc.topicCsmLifespanCh)
+	tc := topiccsm.New(gc.supActorID, gc.group, key, gc.cfg, gc.partitionCsmReg, gc.topicCsmLifespanCh)
 	return tc
 }
 
@@ -95,12 +109,20 @@ func (gc *T) Start(stoppedCh chan<- dispatcher.Tier) {
 	actor.Spawn(gc.supActorID, &gc.wg, func() {
 		defer func() { stoppedCh <- gc }()
 		var err error
-		gc.msgStreamFactory, err = msgstream.SpawnFactory(gc.supActorID, gc.saramaClient)
+		gc.msgStreamFactory, err = msgstream.SpawnFactory(gc.supActorID, gc.group, gc.saramaClient)
 		if err != nil {
 			// Must never happen.
 			panic(consumer.ErrSetup(fmt.Errorf("failed to create sarama.Consumer: err=(%v)", err)))
 		}
-		gc.groupMember = groupmember.Spawn(gc.supActorID, gc.group, gc.cfg.ClientID, gc.cfg, gc.kazooConn)
+		if gc.cfg.Consumer.GroupMembership == config.GroupMembershipKafka {
+			gc.groupMember, err = groupmember.SpawnKafka(gc.supActorID, gc.group, gc.cfg.ClientID, gc.cfg, gc.offsetMgrFactory)
+			if err != nil {
+				// Must never happen.
+				panic(consumer.ErrSetup(fmt.Errorf("failed to create group member: err=(%v)", err)))
+			}
+		} else {
+			gc.groupMember = groupmember.Spawn(gc.supActorID, gc.group, gc.cfg.ClientID, gc.cfg, gc.kazooConn)
+		}
 		var manageWg sync.WaitGroup
 		actor.Spawn(gc.mgrActorID, &manageWg, gc.runManager)
 		gc.dispatcher.Start()
@@ -128,6 +150,7 @@ func (gc *T) runManager() {
 	var (
 		topicConsumers        = make(map[string]*topiccsm.T)
 		topics                []string
+		clusterTopics         []string
 		subscriptions         map[string][]string
 		ok                    = true
 		nilOrRetryCh          <-chan time.Time
@@ -135,9 +158,31 @@ func (gc *T) runManager() {
 		rebalancingRequired   = false
 		rebalancingInProgress = false
 		retryScheduled        = false
+		scanRequired          = false
+		scanInProgress        = false
+		topicsScanned         = false
 		stopped               = false
-		rebalanceResultCh     = make(chan error, 1)
+		rebalanceResultCh     = make(chan rebalanceResult, 1)
+		scanResultCh          = make(chan topicScanResult, 1)
+		// Partition counts of the topics consumed by this group member as of
+		// the last successful rebalancing.
+		partitionCounts          map[string]int
+		partitionCheckRequired   = false
+		partitionCheckInProgress = false
+		partitionCheckResultCh   = make(chan partitionCheckResult, 1)
+		// When partitions are known to have been added, partition counts
+		// are checked every `BackOffTimeout` until either a change is
+		// detected or `TopicScanInterval` elapses, for it takes the Kafka
+		// controller a while to apply the change.
+		partitionWatchUntil        time.Time
+		nilOrPartitionCheckRetryCh <-chan time.Time
 	)
+	scanTicker := time.NewTicker(gc.cfg.Consumer.TopicScanInterval)
+	defer scanTicker.Stop()
+	if gc.notifier != nil {
+		gc.notifier.subscribe(gc)
+		defer gc.notifier.unsubscribe(gc)
+	}
 	for {
 		select {
 		case tc := <-gc.topicCsmLifespanCh:
@@ -147,10 +192,22 @@ func (gc *T) runManager() {
 				delete(topicConsumers, tc.Topic())
 			} else {
 				topicConsumers[tc.Topic()] = tc
+				// Do not wait for the next scan to find topics matching a
+				// new topic pattern, or retry topics of a new topic.
+				if consumer.IsTopicPattern(tc.Topic()) || gc.hasRetries() {
+					scanRequired = true
+				}
 			}
-			topics = listTopics(topicConsumers)
+			prevTopics := topics
+			topics = gc.listTopics(topicConsumers, clusterTopics)
 			nilOrRegistryTopicsCh = gc.groupMember.Topics()
-			continue
+			// If the topics to subscribe to remain the same, then the group
+			// membership is not updated, but topics may have to be rewired
+			// to other topic consumers, e.g. when a topic matching a pattern
+			// is requested explicitly.
+			if subscriptions != nil && topicsEqual(prevTopics, topics) {
+				rebalancingRequired = true
+			}
 		case nilOrRegistryTopicsCh <- topics:
 			nilOrRegistryTopicsCh = nil
 			continue
@@ -164,15 +221,20 @@ func (gc *T) runManager() {
 				continue
 			}
 			rebalancingRequired = true
-		case err := <-rebalanceResultCh:
+			if !topicsScanned && hasTopicPatterns(subscriptions) {
+				scanRequired = true
+			}
+		case result := <-rebalanceResultCh:
 			rebalancingInProgress = false
-			if err != nil {
-				log.Errorf("<%s> rebalancing failed: err=(%s)", gc.mgrActorID, err)
+			if result.err != nil {
+				log.Errorf("<%s> rebalancing failed: err=(%s)", gc.mgrActorID, result.err)
 				if stopped {
 					goto done
 				}
 				nilOrRetryCh = time.After(gc.cfg.Consumer.BackOffTimeout)
 				retryScheduled = true
+			} else {
+				partitionCounts = result.partitionCounts
 			}
 			if stopped {
 				goto done
@@ -180,9 +242,83 @@ func (gc *T) runManager() {
 
 		case <-nilOrRetryCh:
 			retryScheduled = false
+
+		case <-scanTicker.C:
+			if hasTopicPatterns(subscriptions) || hasPatternConsumers(topicConsumers) ||
+				(gc.hasRetries() && len(topicConsumers) > 0) {
+				scanRequired = true
+			}
+			partitionCheckRequired = true
+
+		case <-gc.partitionsChangedCh:
+			partitionCheckRequired = true
+			partitionWatchUntil = time.Now().Add(gc.cfg.Consumer.TopicScanInterval)
+
+		case <-nilOrPartitionCheckRetryCh:
+			nilOrPartitionCheckRetryCh = nil
+			partitionCheckRequired = true
+
+		case result := <-partitionCheckResultCh:
+			partitionCheckInProgress = false
+			if result.err != nil {
+				log.Errorf("<%s> partition check failed: err=(%s)", gc.mgrActorID, result.err)
+			} else if result.changed {
+				log.Infof("<%s> partition count changed", gc.mgrActorID)
+				rebalancingRequired = true
+				partitionWatchUntil = time.Time{}
+				break
+			}
+			if !partitionCheckRequired && time.Now().Before(partitionWatchUntil) {
+				nilOrPartitionCheckRetryCh = time.After(gc.cfg.Consumer.BackOffTimeout)
+			}
+
+		case result := <-scanResultCh:
+			scanInProgress = false
+			if result.err != nil {
+				log.Errorf("<%s> topic scan failed: err=(%s)", gc.mgrActorID, result.err)
+				break
+			}
+			topicsScanned = true
+			if topicsEqual(result.topics, clusterTopics) {
+				break
+			}
+			clusterTopics = result.topics
+			prevTopics := topics
+			topics = gc.listTopics(topicConsumers, clusterTopics)
+			if !topicsEqual(prevTopics, topics) {
+				nilOrRegistryTopicsCh = gc.groupMember.Topics()
+			}
+			// Topics subscribed to via patterns by other members could have
+			// been created or deleted.
+			if hasTopicPatterns(subscriptions) {
+				rebalancingRequired = true
+			}
+		}
+
+		if scanRequired && !scanInProgress {
+			actor.Spawn(gc.mgrActorID.NewChild("scan"), nil, func() {
+				topics, err := gc.fetchTopicsFn()
+				scanResultCh <- topicScanResult{normalizeTopics(topics), err}
+			})
+			scanInProgress = true
+			scanRequired = false
+		}
+
+		if partitionCheckRequired && !partitionCheckInProgress && len(partitionCounts) > 0 {
+			partitionCounts := partitionCounts
+			actor.Spawn(gc.mgrActorID.NewChild("partitionCheck"), nil, func() {
+				changed, err := gc.checkPartitions(partitionCounts)
+				partitionCheckResultCh <- partitionCheckResult{changed, err}
+			})
+			partitionCheckInProgress = true
+			partitionCheckRequired = false
 		}
 
-		if rebalancingRequired && !rebalancingInProgress && !retryScheduled {
+		// Rebalancing is postponed until the topic list is fetched for the
+		// first time, for otherwise members subscribed to topic patterns
+		// would be considered subscribed to no topics at all.
+		awaitingScan := scanInProgress && !topicsScanned && hasTopicPatterns(subscriptions)
+		if rebalancingRequired && !rebalancingInProgress && !retryScheduled && !awaitingScan {
 			actorID := gc.mgrActorID.NewChild("rebalance")
 			// Copy topicConsumers to make sure `rebalance` doesn't see any
 			// changes we make while it is running.
@@ -191,8 +327,9 @@ func (gc *T) runManager() {
 				topicConsumersCopy[topic] = tc
 			}
 			subscriptions := subscriptions
+			clusterTopics := clusterTopics
 			actor.Spawn(actorID, nil, func() {
-				gc.runRebalancing(actorID, topicConsumersCopy, subscriptions, rebalanceResultCh)
+				gc.runRebalancing(actorID, topicConsumersCopy, subscriptions, clusterTopics, rebalanceResultCh)
 			})
 			rebalancingInProgress = true
 			rebalancingRequired = false
@@ -211,11 +348,13 @@ done:
 }
 
 func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topiccsm.T,
-	subscriptions map[string][]string, rebalanceResultCh chan<- error,
+	subscriptions map[string][]string, clusterTopics []string, rebalanceResultCh chan<- rebalanceResult,
 ) {
-	assignedPartitions, err := gc.resolvePartitions(subscriptions)
+	startedAt := time.Now()
+	metrics.Rebalances.WithLabelValues(gc.group).Inc()
+	assignedPartitions, partitionCounts, err := gc.resolvePartitions(subscriptions, clusterTopics)
 	if err != nil {
-		rebalanceResultCh <- err
+		rebalanceResultCh <- rebalanceResult{err: err}
 		return
 	}
 	log.Infof("<%s> assigned partitions: %v", actorID, assignedPartitions)
@@ -224,11 +363,11 @@ func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topicc
 	// and start consuming newly assigned partitions for topics that has been
 	// consumed already.
 	for topic, tcg := range gc.multiplexers {
-		gc.rewireMuxAsync(topic, &wg, tcg, topicConsumers[topic], assignedPartitions[topic])
+		gc.rewireMuxAsync(topic, &wg, tcg, topicConsumerOf(gc.group, topicConsumers, topic), assignedPartitions[topic])
 	}
 	// Start consuming partitions for topics that has not been consumed before.
 	for topic, assignedTopicPartitions := range assignedPartitions {
-		tc := topicConsumers[topic]
+		tc := topicConsumerOf(gc.group, topicConsumers, topic)
 		mux := gc.multiplexers[topic]
 		if tc == nil || mux != nil {
 			continue
@@ -236,7 +375,7 @@ func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topicc
 		topic := topic
 		spawnInF := func(partition int32) multiplexer.In {
 			return partitioncsm.Spawn(gc.supActorID, gc.group, topic, partition,
-				gc.cfg, gc.groupMember, gc.msgStreamFactory, gc.offsetMgrFactory)
+				gc.cfg, gc.groupMember, gc.msgStreamFactory, gc.offsetMgrFactory, gc.partitionCsmReg)
 		}
 		mux = multiplexer.New(gc.supActorID, spawnInF)
 		gc.rewireMuxAsync(topic, &wg, mux, tc, assignedTopicPartitions)
@@ -249,94 +388,246 @@ func (gc *T) runRebalancing(actorID *actor.ID, topicConsumers map[string]*topicc
 			delete(gc.multiplexers, topic)
 		}
 	}
+	metrics.RebalanceDuration.WithLabelValues(gc.group).Observe(time.Since(startedAt).Seconds())
 	// Notify the caller that rebalancing has completed successfully.
-	rebalanceResultCh <- nil
+	rebalanceResultCh <- rebalanceResult{partitionCounts: partitionCounts}
 	return
 }
 
 // rewireMuxAsync calls muxInputs in another goroutine.
 func (gc *T) rewireMuxAsync(topic string, wg *sync.WaitGroup, mux *multiplexer.T, tc *topiccsm.T, assigned []int32) {
 	actor.Spawn(gc.supActorID.NewChild("rewire", topic), wg, func() {
+		// A nil topic consumer pointer must not be wrapped into a non nil
+		// interface value.
+		if tc == nil {
+			mux.WireUp(nil, nil)
+			return
+		}
 		mux.WireUp(tc, assigned)
 	})
 }
 
 // resolvePartitions given topic subscriptions of all consumer group members,
 // resolves what topic partitions are assigned to the specified group member.
-func (gc *T) resolvePartitions(subscriptions map[string][]string) (
-	map[string][]int32, error,
+// Topic patterns that members subscribed to are resolved against the given
+// list of topics existing in the cluster. Partition counts of all topics the
+// member subscribed to are returned as well.
+func (gc *T) resolvePartitions(subscriptions map[string][]string, clusterTopics []string) (
+	map[string][]int32, map[string]int, error,
 ) {
 	// Convert members->topics to topic->members map.
 	topicsToMembers := make(map[string][]string)
 	for groupMemberID, topics := range subscriptions {
-		for _, topic := range topics {
+		for _, topic := range gc.expandTopicPatterns(topics, clusterTopics) {
 			topicsToMembers[topic] = append(topicsToMembers[topic], groupMemberID)
 		}
 	}
 	// Create a set of topics this consumer group member subscribed to.
 	subscribedTopics := make(map[string]bool)
-	for _, topic := range subscriptions[gc.cfg.ClientID] {
+	for _, topic := range gc.expandTopicPatterns(subscriptions[gc.cfg.ClientID], clusterTopics) {
 		subscribedTopics[topic] = true
 	}
 	// Resolve new partition assignments for all subscribed topics.
 	assignedPartitions := make(map[string][]int32)
+	partitionCounts := make(map[string]int, len(subscribedTopics))
 	for topic := range subscribedTopics {
 		topicPartitions, err := gc.fetchTopicPartitionsFn(topic)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get partition list: topic=%s, err=(%s)", topic, err)
+			return nil, nil, fmt.Errorf("failed to get partition list: topic=%s, err=(%s)", topic, err)
 		}
-		subscribersToPartitions := assignTopicPartitions(topicPartitions, topicsToMembers[topic])
+		partitionCounts[topic] = len(topicPartitions)
+		subscribersToPartitions := gc.assignor.Assign(topic, topicPartitions, topicsToMembers[topic])
 		assignedTopicPartitions := subscribersToPartitions[gc.cfg.ClientID]
 		if len(assignedTopicPartitions) > 0 {
 			assignedPartitions[topic] = assignedTopicPartitions
 		}
 	}
-	return assignedPartitions, nil
+	return assignedPartitions, partitionCounts, nil
 }
 
-// assignTopicPartitions divides topic partitions among all consumer group
-// members subscribed to the topic. The algorithm used closely resembles the
-// one implemented by the standard Java High-Level consumer
-// (see http://kafka.apache.org/documentation.html#distributionimpl and scroll
-// down to *Consumer registration algorithm*) except it does not take in account
-// how partitions are distributed among brokers.
-func assignTopicPartitions(partitions []int32, subscribers []string) map[string][]int32 {
-	partitionCount := len(partitions)
-	subscriberCount := len(subscribers)
-	if partitionCount == 0 || subscriberCount == 0 {
-		return nil
+// checkPartitions refreshes metadata of the topics, and tells whether the
+// partition count of any of them differs from the given one.
+func (gc *T) checkPartitions(partitionCounts map[string]int) (bool, error) {
+	topics := make([]string, 0, len(partitionCounts))
+	for topic := range partitionCounts {
+		topics = append(topics, topic)
 	}
-	sort.Sort(Int32Slice(partitions))
-	sort.Sort(sort.StringSlice(subscribers))
-
-	subscribersToPartitions := make(map[string][]int32, subscriberCount)
-	partitionsPerSubscriber := partitionCount / subscriberCount
-	extra := partitionCount - subscriberCount*partitionsPerSubscriber
-
-	begin := 0
-	for _, groupMemberID := range subscribers {
-		end := begin + partitionsPerSubscriber
-		if extra != 0 {
-			end++
-			extra--
+	if err := gc.refreshMetadataFn(topics...); err != nil {
+		return false, err
+	}
+	for topic, count := range partitionCounts {
+		topicPartitions, err := gc.fetchTopicPartitionsFn(topic)
+		if err != nil {
+			return false, fmt.Errorf("failed to get partition list: topic=%s, err=(%s)", topic, err)
 		}
-		assigned := partitions[begin:end]
-		if len(assigned) > 0 {
-			subscribersToPartitions[groupMemberID] = partitions[begin:end]
+		if len(topicPartitions) != count {
+			return true, nil
 		}
-		begin = end
 	}
-	return subscribersToPartitions
+	return false, nil
 }
 
-func listTopics(topicConsumers map[string]*topiccsm.T) []string {
+// expandTopicPatterns replaces topic patterns in the topic list with topics
+// from the cluster topic list that match them. The returned list contains
+// every topic only once.
+func (gc *T) expandTopicPatterns(topics, clusterTopics []string) []string {
+	expanded := make([]string, 0, len(topics))
+	seen := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		matched := []string{topic}
+		if consumer.IsTopicPattern(topic) {
+			matcher, err := consumer.CompileTopicPattern(topic)
+			if err != nil {
+				log.Errorf("<%s> invalid topic pattern ignored: %s, err=(%s)", gc.mgrActorID, topic, err)
+				continue
+			}
+			matched = matcher.MatchTopics(clusterTopics)
+		}
+		for _, topic := range matched {
+			if !seen[topic] {
+				seen[topic] = true
+				expanded = append(expanded, topic)
+			}
+		}
+	}
+	return expanded
+}
+
+// listTopics returns a sorted list of topics that this group member should
+// subscribe to, given topic consumers it runs, including those that consume
+// topic patterns, and the list of topics existing in the cluster. Retry
+// topics of consumed topics are included if they exist in the cluster.
+func (gc *T) listTopics(topicConsumers map[string]*topiccsm.T, clusterTopics []string) []string {
 	topics := make([]string, 0, len(topicConsumers))
 	for topic := range topicConsumers {
 		topics = append(topics, topic)
 	}
+	topics = gc.expandTopicPatterns(topics, clusterTopics)
+	for topic := range topicConsumers {
+		if !consumer.IsTopicPattern(topic) {
+			topics = append(topics, gc.existingRetryTopics(topic, clusterTopics)...)
+		}
+	}
+	return normalizeTopics(topics)
+}
+
+// existingRetryTopics returns retry topics of the specified topic that exist
+// in the cluster. Only retry topics of the group are returned, for messages
+// negatively acknowledged by other groups consuming the topic are none of its
+// business.
+func (gc *T) existingRetryTopics(topic string, clusterTopics []string) []string {
+	var retryTopics []string
+	for _, delay := range gc.cfg.Consumer.Retry.Delays {
+		retryTopic := consumer.RetryTopic(gc.group, topic, delay)
+		for _, clusterTopic := range clusterTopics {
+			if clusterTopic == retryTopic {
+				retryTopics = append(retryTopics, retryTopic)
+				break
+			}
+		}
+	}
+	return retryTopics
+}
+
+// hasRetries tells whether negatively acknowledged messages are republished
+// to retry topics, that have to be consumed along with consumed topics.
+func (gc *T) hasRetries() bool {
+	return len(gc.cfg.Consumer.Retry.Delays) > 0
+}
+
+// fetchTopics returns the list of all topics existing in the cluster.
+func (gc *T) fetchTopics() ([]string, error) {
+	if err := gc.saramaClient.RefreshMetadata(); err != nil {
+		return nil, err
+	}
+	return gc.saramaClient.Topics()
+}
+
+// topicConsumerOf returns a topic consumer that messages of the specified
+// topic should be sent to. A topic consumer created for the topic itself is
+// preferred, then that of the topic a retry topic of the group was derived
+// from, otherwise the first one in lexicographic order of topic patterns that
+// matches the topic is returned. Nil is returned if there are none.
+func topicConsumerOf(group string, topicConsumers map[string]*topiccsm.T, topic string) *topiccsm.T {
+	if tc := topicConsumers[topic]; tc != nil {
+		return tc
+	}
+	if retryTopicOrigin, ok := consumer.RetryTopicOrigin(group, topic); ok {
+		if tc := topicConsumers[retryTopicOrigin]; tc != nil {
+			return tc
+		}
+	}
+	patterns := make([]string, 0, len(topicConsumers))
+	for pattern := range topicConsumers {
+		if consumer.IsTopicPattern(pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		matcher, err := consumer.CompileTopicPattern(pattern)
+		if err == nil && matcher.Match(topic) {
+			return topicConsumers[pattern]
+		}
+	}
+	return nil
+}
+
+func hasTopicPatterns(subscriptions map[string][]string) bool {
+	for _, topics := range subscriptions {
+		for _, topic := range topics {
+			if consumer.IsTopicPattern(topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasPatternConsumers(topicConsumers map[string]*topiccsm.T) bool {
+	for topic := range topicConsumers {
+		if consumer.IsTopicPattern(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeTopics(topics []string) []string {
+	if len(topics) == 0 {
+		return nil
+	}
+	sort.Strings(topics)
 	return topics
 }
 
+func topicsEqual(lhs, rhs []string) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if lhs[i] != rhs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type topicScanResult struct {
+	topics []string
+	err    error
+}
+
+type rebalanceResult struct {
+	partitionCounts map[string]int
+	err             error
+}
+
+type partitionCheckResult struct {
+	changed bool
+	err     error
+}
+
 type Int32Slice []int32
 
 func (p Int32Slice) Len() int           { return len(p) }
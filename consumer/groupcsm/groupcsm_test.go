@@ -3,9 +3,12 @@ package groupcsm
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/topiccsm"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	. "gopkg.in/check.v1"
 )
@@ -28,73 +31,12 @@ func (s *GroupConsumerSuite) SetUpTest(*C) {
 	s.ns = actor.RootID.NewChild("T")
 }
 
-func (s *GroupConsumerSuite) TestAssignTopicPartitions(c *C) {
-	c.Assert(assignTopicPartitions(nil, nil), IsNil)
-	c.Assert(assignTopicPartitions(nil, []string{}), IsNil)
-	c.Assert(assignTopicPartitions(nil, []string{"a"}), IsNil)
-	c.Assert(assignTopicPartitions(nil, []string{"a", "b"}), IsNil)
-	c.Assert(assignTopicPartitions([]int32{}, nil), IsNil)
-	c.Assert(assignTopicPartitions([]int32{}, []string{}), IsNil)
-	c.Assert(assignTopicPartitions([]int32{}, []string{"a"}), IsNil)
-	c.Assert(assignTopicPartitions([]int32{}, []string{"a", "b"}), IsNil)
-	c.Assert(assignTopicPartitions([]int32{1}, nil), IsNil)
-	c.Assert(assignTopicPartitions([]int32{1}, []string{}), IsNil)
-
-	c.Assert(assignTopicPartitions([]int32{0}, []string{"a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0},
-		})
-	c.Assert(assignTopicPartitions([]int32{1, 2, 0}, []string{"a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1, 2},
-		})
-	c.Assert(assignTopicPartitions([]int32{0}, []string{"b", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0},
-		})
-	c.Assert(assignTopicPartitions([]int32{0, 3, 1, 2}, []string{"b", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1},
-			"b": {2, 3},
-		})
-	c.Assert(assignTopicPartitions([]int32{0, 3, 1, 2}, []string{"b", "c", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1},
-			"b": {2},
-			"c": {3},
-		})
-	c.Assert(assignTopicPartitions([]int32{0, 3, 1, 2, 4}, []string{"b", "c", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1},
-			"b": {2, 3},
-			"c": {4},
-		})
-	c.Assert(assignTopicPartitions([]int32{0, 3, 1, 2, 5, 4}, []string{"b", "c", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1},
-			"b": {2, 3},
-			"c": {4, 5},
-		})
-	c.Assert(assignTopicPartitions([]int32{6, 0, 3, 1, 2, 5, 4}, []string{"b", "c", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1, 2},
-			"b": {3, 4},
-			"c": {5, 6},
-		})
-	c.Assert(assignTopicPartitions([]int32{6, 0, 3, 1, 2, 5, 4}, []string{"d", "b", "c", "a"}),
-		DeepEquals, map[string][]int32{
-			"a": {0, 1},
-			"b": {2, 3},
-			"c": {4, 5},
-			"d": {6},
-		})
-}
-
 func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	cfg := config.Default()
 	cfg.ClientID = "c"
 	gc := T{
-		cfg: cfg,
+		cfg:      cfg,
+		assignor: rangeAssignor{},
 		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
 			return map[string][]int32{
 				"t1": {1, 2, 3, 4, 5},
@@ -107,7 +49,7 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 	}
 
 	// When
-	topicsToPartitions, err := gc.resolvePartitions(
+	topicsToPartitions, partitionCounts, err := gc.resolvePartitions(
 		map[string][]string{
 			"a": {"t1", "t2", "t3"},
 			"b": {"t1", "t2", "t3"},
@@ -115,7 +57,7 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 			"d": {"t1", "t4"},
 			"e": {},
 			"f": nil,
-		})
+		}, nil)
 
 	// Then
 	c.Assert(err, IsNil)
@@ -124,20 +66,22 @@ func (s *GroupConsumerSuite) TestResolvePartitions(c *C) {
 		"t4": {1, 2},
 		"t5": {1, 2, 3},
 	})
+	c.Assert(partitionCounts, DeepEquals, map[string]int{"t1": 5, "t2": 2, "t4": 3, "t5": 3})
 }
 
 func (s *GroupConsumerSuite) TestResolvePartitionsEmpty(c *C) {
 	cfg := config.Default()
 	cfg.ClientID = "c"
 	gc := T{
-		cfg: cfg,
+		cfg:      cfg,
+		assignor: rangeAssignor{},
 		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
 			return nil, nil
 		},
 	}
 
 	// When
-	topicsToPartitions, err := gc.resolvePartitions(nil)
+	topicsToPartitions, _, err := gc.resolvePartitions(nil, nil)
 
 	// Then
 	c.Assert(err, IsNil)
@@ -148,16 +92,190 @@ func (s *GroupConsumerSuite) TestResolvePartitionsError(c *C) {
 	cfg := config.Default()
 	cfg.ClientID = "c"
 	gc := T{
-		cfg: cfg,
+		cfg:      cfg,
+		assignor: rangeAssignor{},
 		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
 			return nil, errors.New("Kaboom!")
 		},
 	}
 
 	// When
-	topicsToPartitions, err := gc.resolvePartitions(map[string][]string{"c": {"t1"}})
+	topicsToPartitions, _, err := gc.resolvePartitions(map[string][]string{"c": {"t1"}}, nil)
 
 	// Then
 	c.Assert(err.Error(), Equals, "failed to get partition list: topic=t1, err=(Kaboom!)")
 	c.Assert(topicsToPartitions, IsNil)
 }
+
+// A partition count change is detected after metadata is refreshed.
+func (s *GroupConsumerSuite) TestCheckPartitions(c *C) {
+	var refreshed []string
+	gc := T{
+		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
+			return map[string][]int32{
+				"t1": {0, 1},
+				"t2": {0, 1, 2},
+			}[topic], nil
+		},
+		refreshMetadataFn: func(topics ...string) error {
+			refreshed = append(refreshed, topics...)
+			return nil
+		},
+	}
+
+	for i, tc := range []struct {
+		partitionCounts map[string]int
+		changed         bool
+	}{
+		{partitionCounts: map[string]int{"t1": 2, "t2": 3}, changed: false},
+		{partitionCounts: map[string]int{"t1": 2, "t2": 2}, changed: true},
+		{partitionCounts: map[string]int{"t1": 1}, changed: true},
+	} {
+		refreshed = nil
+
+		// When
+		changed, err := gc.checkPartitions(tc.partitionCounts)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(changed, Equals, tc.changed, Commentf("case #%d", i))
+		c.Assert(len(refreshed), Equals, len(tc.partitionCounts), Commentf("case #%d", i))
+	}
+}
+
+// If metadata cannot be refreshed, then partition check fails.
+func (s *GroupConsumerSuite) TestCheckPartitionsError(c *C) {
+	gc := T{
+		refreshMetadataFn: func(topics ...string) error {
+			return errors.New("Kaboom!")
+		},
+	}
+
+	// When
+	changed, err := gc.checkPartitions(map[string]int{"t1": 2})
+
+	// Then
+	c.Assert(err.Error(), Equals, "Kaboom!")
+	c.Assert(changed, Equals, false)
+}
+
+// Topic patterns in subscriptions are resolved against the cluster topic list,
+// and members subscribed to matching topics via patterns are taken into
+// account along with those subscribed explicitly.
+func (s *GroupConsumerSuite) TestResolvePartitionsPatterns(c *C) {
+	cfg := config.Default()
+	cfg.ClientID = "c"
+	gc := T{
+		mgrActorID: s.ns,
+		cfg:        cfg,
+		assignor:   rangeAssignor{},
+		fetchTopicPartitionsFn: func(topic string) ([]int32, error) {
+			return map[string][]int32{
+				"foo1": {1, 2, 3},
+				"foo2": {1, 2},
+				"bar":  {1, 2, 3, 4},
+			}[topic], nil
+		},
+	}
+
+	// When
+	topicsToPartitions, _, err := gc.resolvePartitions(
+		map[string][]string{
+			"a": {consumer.TopicPattern("foo.*")},
+			"b": {consumer.ExcludeTopicPattern("foo1|foo2")},
+			"c": {"bar", "foo1", "foo2"},
+			"d": {consumer.TopicPattern("[")},
+		},
+		[]string{"__consumer_offsets", "bar", "foo1", "foo2"})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(topicsToPartitions, DeepEquals, map[string][]int32{
+		"bar":  {3, 4},
+		"foo1": {3},
+		"foo2": {2},
+	})
+}
+
+func (s *GroupConsumerSuite) TestListTopics(c *C) {
+	gc := T{cfg: config.Default()}
+	topicConsumers := map[string]*topiccsm.T{
+		"bar":                           nil,
+		"foo1":                          nil,
+		consumer.TopicPattern("foo\\d"): nil,
+	}
+
+	// When
+	topics := gc.listTopics(topicConsumers, []string{"bar", "foo1", "foo2", "foo3", "fooo"})
+
+	// Then
+	c.Assert(topics, DeepEquals, []string{"bar", "foo1", "foo2", "foo3"})
+}
+
+// Retry topics of the group of topics consumed by name are subscribed to if
+// they exist.
+func (s *GroupConsumerSuite) TestListTopicsRetry(c *C) {
+	cfg := config.Default()
+	cfg.Consumer.Retry.Delays = []time.Duration{time.Minute, 10 * time.Minute}
+	gc := T{group: "g1", cfg: cfg}
+	topicConsumers := map[string]*topiccsm.T{
+		"bar":                          nil,
+		consumer.TopicPattern("foo.*"): nil,
+	}
+
+	// When
+	topics := gc.listTopics(topicConsumers, []string{"bar", "foo", "g1.bar.retry.10m", "g1.bar.dlq", "g1.foo.retry.1m"})
+
+	// Then
+	c.Assert(topics, DeepEquals, []string{"bar", "foo", "g1.bar.retry.10m"})
+}
+
+// Messages negatively acknowledged by one group are republished to retry
+// topics of that group, that other groups consuming the same topic neither
+// subscribe to nor route to their topic consumers.
+func (s *GroupConsumerSuite) TestRetryTopicsOfOtherGroups(c *C) {
+	cfg := config.Default()
+	cfg.Consumer.Retry.Delays = []time.Duration{time.Minute}
+	gc1 := T{group: "g1", cfg: cfg}
+	gc2 := T{group: "g2", cfg: cfg}
+	lifespanCh := make(chan *topiccsm.T)
+	tc1 := topiccsm.New(s.ns, "g1", "foo", cfg, nil, lifespanCh)
+	tc2 := topiccsm.New(s.ns, "g2", "foo", cfg, nil, lifespanCh)
+	retryTopic := consumer.RetryTopic("g1", "foo", time.Minute)
+	clusterTopics := []string{"foo", retryTopic}
+
+	// When
+	topics1 := gc1.listTopics(map[string]*topiccsm.T{"foo": tc1}, clusterTopics)
+	topics2 := gc2.listTopics(map[string]*topiccsm.T{"foo": tc2}, clusterTopics)
+
+	// Then
+	c.Assert(retryTopic, Equals, "g1.foo.retry.1m")
+	c.Assert(topics1, DeepEquals, []string{"foo", "g1.foo.retry.1m"})
+	c.Assert(topics2, DeepEquals, []string{"foo"})
+	c.Assert(topicConsumerOf("g1", map[string]*topiccsm.T{"foo": tc1}, retryTopic), Equals, tc1)
+	c.Assert(topicConsumerOf("g2", map[string]*topiccsm.T{"foo": tc2}, retryTopic), IsNil)
+}
+
+// A topic consumer created for a topic is preferred over topic consumers
+// created for patterns that match the topic.
+func (s *GroupConsumerSuite) TestTopicConsumerOf(c *C) {
+	cfg := config.Default()
+	lifespanCh := make(chan *topiccsm.T)
+	tcBar := topiccsm.New(s.ns, "g", "bar", cfg, nil, lifespanCh)
+	tcFoo := topiccsm.New(s.ns, "g", consumer.TopicPattern("foo.*"), cfg, nil, lifespanCh)
+	tcFooBar := topiccsm.New(s.ns, "g", consumer.TopicPattern("(foo|bar).*"), cfg, nil, lifespanCh)
+	topicConsumers := map[string]*topiccsm.T{
+		tcBar.Topic():    tcBar,
+		tcFoo.Topic():    tcFoo,
+		tcFooBar.Topic(): tcFooBar,
+	}
+
+	// When/Then
+	c.Assert(topicConsumerOf("g", topicConsumers, "bar"), Equals, tcBar)
+	c.Assert(topicConsumerOf("g", topicConsumers, "bar1"), Equals, tcFooBar)
+	c.Assert(topicConsumerOf("g", topicConsumers, "foo"), Equals, tcFooBar)
+	c.Assert(topicConsumerOf("g", topicConsumers, "baz"), IsNil)
+	c.Assert(topicConsumerOf("g", topicConsumers, "g.bar.retry.1m"), Equals, tcBar)
+	c.Assert(topicConsumerOf("g", topicConsumers, "g.baz.retry.1m"), IsNil)
+	c.Assert(topicConsumerOf("g", map[string]*topiccsm.T{tcFoo.Topic(): tcFoo}, "foo"), Equals, tcFoo)
+}
@@ -0,0 +1,46 @@
+package groupcsm
+
+import (
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/none"
+)
+
+// Notifier lets group consumers running in this instance know that the
+// number of partitions of some topics may have changed, so that they check
+// the partitions of the topics they consume right away rather than on the
+// next topic scan, and rebalance if needed.
+type Notifier struct {
+	mu  sync.Mutex
+	gcs map[*T]none.T
+}
+
+// NewNotifier creates a notifier with no group consumers subscribed.
+func NewNotifier() *Notifier {
+	return &Notifier{gcs: make(map[*T]none.T)}
+}
+
+// Notify makes all subscribed group consumers check partitions.
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for gc := range n.gcs {
+		// A check that is already pending covers this notification too.
+		select {
+		case gc.partitionsChangedCh <- none.V:
+		default:
+		}
+	}
+}
+
+func (n *Notifier) subscribe(gc *T) {
+	n.mu.Lock()
+	n.gcs[gc] = none.V
+	n.mu.Unlock()
+}
+
+func (n *Notifier) unsubscribe(gc *T) {
+	n.mu.Lock()
+	delete(n.gcs, gc)
+	n.mu.Unlock()
+}
@@ -1,13 +1,16 @@
 package groupmember
 
 import (
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
 	"github.com/samuel/go-zookeeper/zk"
@@ -19,64 +22,111 @@ import (
 // first several failures to claim a partition as an error.
 const safeClaimRetriesCount = 10
 
-// T maintains a consumer group member registration in ZooKeeper, watches for
-// other members to join, leave and update their subscriptions, and generates
-// notifications of such changes.
-type T struct {
+var errStaleClaim = errors.New("partition claimed by a previous session with the same client ID")
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// T maintains a consumer group membership, watches for other members to join,
+// leave and update their subscriptions, and generates notifications of such
+// changes. There are two implementations: one that registers members in
+// ZooKeeper, and another that uses the Kafka group coordinator API.
+type T interface {
+	// Topics returns a channel to receive a list of topics the member should
+	// subscribe to. To make the member unsubscribe from all topics either nil
+	// or an empty topic list can be sent.
+	Topics() chan<- []string
+
+	// Subscriptions returns a channel that subscriptions will be sent whenever
+	// a member joins or leaves the group or when an existing member updates
+	// its subscription. Subscriptions are keyed by member client IDs.
+	Subscriptions() <-chan map[string][]string
+
+	// ClaimPartition claims a topic/partition to be consumed by this member
+	// of the consumer group. It blocks until either succeeds or canceled by
+	// the caller. It returns a function that should be called to release the
+	// claim.
+	ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func()
+
+	// Stop signals the consumer group member to stop and blocks until its
+	// goroutines are over.
+	Stop()
+}
+
+// zkMember maintains a consumer group member registration in ZooKeeper,
+// watches for other members to join, leave and update their subscriptions,
+// and generates notifications of such changes.
+//
+// If `Config.Consumer.MemberGracePeriod` is configured, then subscriptions of
+// a member that left the group are still reported for that period, so if
+// the member comes back with the same ID in the meantime, then subscriptions
+// do not change and the group is not rebalanced.
+//
+// Partition owner nodes are ephemeral, so an owner node created by a
+// previous run of an instance with the same stable client ID lingers until
+// the ZooKeeper session of that run expires. Such a node is not mistaken for
+// a claim of this member, because the member keeps track of the partitions
+// that it has claimed itself.
+//
+// implements `T`.
+type zkMember struct {
 	actorID          *actor.ID
 	cfg              *config.T
 	group            string
+	memberID         string
 	groupZNode       *kazoo.Consumergroup
 	groupMemberZNode *kazoo.ConsumergroupInstance
 	topics           []string
 	subscriptions    map[string][]string
+	departed         map[string]departedMember
+	claimed          map[topicPartition]none.T
+	claimedLock      sync.Mutex
 	topicsCh         chan []string
 	subscriptionsCh  chan map[string][]string
 	stopCh           chan none.T
 	wg               sync.WaitGroup
 }
 
-// Spawn creates a consumer group member instance and starts its background
-// goroutines.
-func Spawn(namespace *actor.ID, group, memberID string, cfg *config.T, kazooConn *kazoo.Kazoo) *T {
+// Spawn creates a consumer group member instance that registers in ZooKeeper
+// and starts its background goroutines.
+func Spawn(namespace *actor.ID, group, memberID string, cfg *config.T, kazooConn *kazoo.Kazoo) T {
 	groupZNode := kazooConn.Consumergroup(group)
 	groupMemberZNode := groupZNode.Instance(memberID)
-	gm := &T{
+	gm := &zkMember{
 		actorID:          namespace.NewChild("member"),
 		cfg:              cfg,
 		group:            group,
+		memberID:         memberID,
 		groupZNode:       groupZNode,
 		groupMemberZNode: groupMemberZNode,
 		topicsCh:         make(chan []string),
 		subscriptionsCh:  make(chan map[string][]string),
+		departed:         make(map[string]departedMember),
+		claimed:          make(map[topicPartition]none.T),
 		stopCh:           make(chan none.T),
 	}
 	actor.Spawn(gm.actorID, &gm.wg, gm.run)
 	return gm
 }
 
-// Topics returns a channel to receive a list of topics the member should
-// subscribe to. To make the member unsubscribe from all topics either nil or
-// an empty topic list can be sent.
-func (gm *T) Topics() chan<- []string {
+// implements `T`.
+func (gm *zkMember) Topics() chan<- []string {
 	return gm.topicsCh
 }
 
-// Subscriptions returns a channel that subscriptions will be sent whenever a
-// member joins or leaves the group or when an existing member updates its
-// subscription.
-func (gm *T) Subscriptions() <-chan map[string][]string {
+// implements `T`.
+func (gm *zkMember) Subscriptions() <-chan map[string][]string {
 	return gm.subscriptionsCh
 }
 
-// ClaimPartition claims a topic/partition to be consumed by this member of the
-// consumer group. It blocks until either succeeds or canceled by the caller. It
-// returns a function that should be called to release the claim.
-func (gm *T) ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func() {
+// implements `T`.
+func (gm *zkMember) ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func() {
 	beginAt := time.Now()
 	retries := 0
 	logFailureFn := log.Infof
-	err := gm.groupMemberZNode.ClaimPartition(topic, partition)
+	err := gm.claimPartition(topic, partition)
 	for err != nil {
 		if retries++; retries > safeClaimRetriesCount {
 			logFailureFn = log.Errorf
@@ -88,7 +138,7 @@ func (gm *T) ClaimPartition(claimerActorID *actor.ID, topic string, partition in
 		case <-cancelCh:
 			return func() {}
 		}
-		err = gm.groupMemberZNode.ClaimPartition(topic, partition)
+		err = gm.claimPartition(topic, partition)
 	}
 	log.Infof("<%s> partition claimed: via=%s, retries=%d, took=%s",
 		claimerActorID, gm.actorID, retries, millisSince(beginAt))
@@ -106,19 +156,50 @@ func (gm *T) ClaimPartition(claimerActorID *actor.ID, topic string, partition in
 			<-time.After(gm.cfg.Consumer.BackOffTimeout)
 			err = gm.groupMemberZNode.ReleasePartition(topic, partition)
 		}
+		gm.claimedLock.Lock()
+		delete(gm.claimed, topicPartition{topic, partition})
+		gm.claimedLock.Unlock()
 		log.Infof("<%s> partition released: via=%s, retries=%d, took=%s",
 			claimerActorID, gm.actorID, retries, millisSince(beginAt))
 	}
 }
 
-// Stop signals the consumer group member to stop and blocks until its
-// goroutines are over.
-func (gm *T) Stop() {
+// claimPartition makes a single attempt to claim a partition. An owner node
+// with the ID of this member is only considered a claim of this member if the
+// member has claimed the partition itself, otherwise it has been left behind
+// by a previous run with the same client ID, and the partition is treated as
+// claimed by another member until the node expires with the session of that
+// run.
+func (gm *zkMember) claimPartition(topic string, partition int32) error {
+	tp := topicPartition{topic, partition}
+	gm.claimedLock.Lock()
+	_, claimed := gm.claimed[tp]
+	gm.claimedLock.Unlock()
+	if !claimed {
+		owner, err := gm.groupZNode.PartitionOwner(topic, partition)
+		if err != nil {
+			return err
+		}
+		if owner != nil && owner.ID == gm.memberID {
+			return errStaleClaim
+		}
+	}
+	if err := gm.groupMemberZNode.ClaimPartition(topic, partition); err != nil {
+		return err
+	}
+	gm.claimedLock.Lock()
+	gm.claimed[tp] = none.V
+	gm.claimedLock.Unlock()
+	return nil
+}
+
+// implements `T`.
+func (gm *zkMember) Stop() {
 	close(gm.stopCh)
 	gm.wg.Wait()
 }
 
-func (gm *T) run() {
+func (gm *zkMember) run() {
 	defer close(gm.subscriptionsCh)
 
 	// Ensure a group ZNode exist.
@@ -148,6 +229,7 @@ func (gm *T) run() {
 		nilOrSubscriptionsCh     chan<- map[string][]string
 		nilOrGroupUpdatedCh      <-chan zk.Event
 		nilOrTimeoutCh           <-chan time.Time
+		nilOrGraceExpiredCh      <-chan time.Time
 		pendingTopics            []string
 		pendingSubscriptions     map[string][]string
 		shouldSubmitTopics       = false
@@ -167,6 +249,9 @@ func (gm *T) run() {
 			nilOrGroupUpdatedCh = nil
 			shouldFetchMembers = true
 		case <-nilOrTimeoutCh:
+		case <-nilOrGraceExpiredCh:
+			nilOrGraceExpiredCh = nil
+			shouldFetchSubscriptions = true
 		case <-gm.stopCh:
 			return
 		}
@@ -207,6 +292,10 @@ func (gm *T) run() {
 			}
 			shouldFetchSubscriptions = false
 			log.Infof("<%s> fetched subscriptions: %v", gm.actorID, pendingSubscriptions)
+			nilOrGraceExpiredCh = nil
+			if graceExpiresAt := gm.retainDeparted(pendingSubscriptions, time.Now().UTC()); !graceExpiresAt.IsZero() {
+				nilOrGraceExpiredCh = time.After(graceExpiresAt.Sub(time.Now().UTC()))
+			}
 			if subscriptionsEqual(pendingSubscriptions, gm.subscriptions) {
 				nilOrSubscriptionsCh = nil
 				pendingSubscriptions = nil
@@ -220,11 +309,7 @@ func (gm *T) run() {
 
 // fetchSubscriptions retrieves registration records for the specified members
 // from ZooKeeper.
-//
-// FIXME: It is assumed that all members of the group are registered with the
-// FIXME: `static` pattern. If a member that pattern is either `white_list` or
-// FIXME: `black_list` joins the group the result will be unpredictable.
-func (gm *T) fetchSubscriptions(members []*kazoo.ConsumergroupInstance) (map[string][]string, error) {
+func (gm *zkMember) fetchSubscriptions(members []*kazoo.ConsumergroupInstance) (map[string][]string, error) {
 	subscriptions := make(map[string][]string, len(members))
 	for _, member := range members {
 		var registration *kazoo.Registration
@@ -232,17 +317,88 @@ func (gm *T) fetchSubscriptions(members []*kazoo.ConsumergroupInstance) (map[str
 		for err != nil {
 			return nil, fmt.Errorf("failed to fetch registration: member=%s, err=(%s)", member.ID, err)
 		}
-		// Sort topics to ensure deterministic output.
-		topics := make([]string, 0, len(registration.Subscription))
-		for topic := range registration.Subscription {
-			topics = append(topics, topic)
-		}
-		subscriptions[member.ID] = normalizeTopics(topics)
+		subscriptions[member.ID] = registrationTopics(registration)
 	}
 	return subscriptions, nil
 }
 
-func (gm *T) submitTopics(topics []string) error {
+// departedMember is a member that has left the group, but whose subscriptions
+// are retained until its grace period expires.
+type departedMember struct {
+	topics    []string
+	expiresAt time.Time
+}
+
+// retainDeparted adds subscriptions of members that have left the group
+// within `Config.Consumer.MemberGracePeriod` to the fetched subscriptions. It
+// returns the time when the earliest grace period expires, that is when
+// subscriptions should be fetched again, or zero time if no subscriptions
+// are retained.
+func (gm *zkMember) retainDeparted(subscriptions map[string][]string, now time.Time) time.Time {
+	if gm.cfg.Consumer.MemberGracePeriod <= 0 {
+		return time.Time{}
+	}
+	for memberID, topics := range gm.subscriptions {
+		if _, ok := subscriptions[memberID]; ok || memberID == gm.memberID {
+			continue
+		}
+		if _, ok := gm.departed[memberID]; ok {
+			continue
+		}
+		log.Infof("<%s> member departed: id=%s, grace=%s", gm.actorID, memberID, gm.cfg.Consumer.MemberGracePeriod)
+		gm.departed[memberID] = departedMember{topics, now.Add(gm.cfg.Consumer.MemberGracePeriod)}
+	}
+	var earliest time.Time
+	for memberID, dm := range gm.departed {
+		if _, ok := subscriptions[memberID]; ok {
+			log.Infof("<%s> member returned: id=%s", gm.actorID, memberID)
+			delete(gm.departed, memberID)
+			continue
+		}
+		if !dm.expiresAt.After(now) {
+			log.Infof("<%s> member grace period expired: id=%s", gm.actorID, memberID)
+			delete(gm.departed, memberID)
+			continue
+		}
+		subscriptions[memberID] = dm.topics
+		if earliest.IsZero() || dm.expiresAt.Before(earliest) {
+			earliest = dm.expiresAt
+		}
+	}
+	return earliest
+}
+
+// registrationTopics returns a sorted list of topics from a member
+// registration. Members registered by Java clients with either the
+// `white_list` or `black_list` pattern subscribe to a topic filter regular
+// expression, and it is returned as a topic pattern (see
+// `consumer.TopicPattern`) to be resolved against the cluster topic list.
+func registrationTopics(registration *kazoo.Registration) []string {
+	topics := make([]string, 0, len(registration.Subscription))
+	for topic := range registration.Subscription {
+		switch registration.Pattern {
+		case kazoo.RegPatternWhiteList:
+			topic = consumer.TopicPattern(javaTopicFilterRegex(topic))
+		case kazoo.RegPatternBlackList:
+			topic = consumer.ExcludeTopicPattern(javaTopicFilterRegex(topic))
+		}
+		topics = append(topics, topic)
+	}
+	// Sort topics to ensure deterministic output.
+	return normalizeTopics(topics)
+}
+
+// javaTopicFilterRegex converts a topic filter regular expression as it is
+// registered by Java clients to a form that should be matched against topic
+// names, the same way as `kafka.consumer.TopicFilter` does it.
+func javaTopicFilterRegex(regex string) string {
+	regex = strings.TrimSpace(regex)
+	regex = strings.Replace(regex, ",", "|", -1)
+	regex = strings.Replace(regex, " ", "", -1)
+	return strings.Trim(regex, "\"'")
+}
+
+func (gm *zkMember) submitTopics(topics []string) error {
 	if gm.topics != nil {
 		err := gm.groupMemberZNode.Deregister()
 		if err != nil && err != kazoo.ErrInstanceNotRegistered {
@@ -251,6 +407,16 @@ func (gm *T) submitTopics(topics []string) error {
 	}
 	gm.topics = nil
 	err := gm.groupMemberZNode.Register(topics)
+	if err == kazoo.ErrInstanceAlreadyRegistered {
+		// The member has not registered yet, so the registration has been
+		// left behind by a previous run of an instance with the same stable
+		// client ID, whose ZooKeeper session has not expired yet.
+		log.Infof("<%s> replacing stale registration", gm.actorID)
+		if err = gm.groupMemberZNode.Deregister(); err != nil && err != kazoo.ErrInstanceNotRegistered {
+			return fmt.Errorf("failed to deregister stale registration: err=(%s)", err)
+		}
+		err = gm.groupMemberZNode.Register(topics)
+	}
 	for err != nil {
 		return fmt.Errorf("failed to register: err=(%s)", err)
 	}
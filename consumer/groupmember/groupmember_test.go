@@ -7,6 +7,7 @@ import (
 
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/wvanbergen/kazoo-go"
@@ -71,6 +72,93 @@ func (s *GroupRegistratorSuite) TestSubscriptionsEqual(c *C) {
 		}), Equals, true)
 }
 
+// Registrations made by Java clients with topic filters are turned into topic
+// patterns.
+func (s *GroupRegistratorSuite) TestRegistrationTopics(c *C) {
+	for i, tc := range []struct {
+		registration kazoo.Registration
+		topics       []string
+	}{{
+		registration: kazoo.Registration{Pattern: kazoo.RegPatternStatic, Subscription: map[string]int{"foo": 1, "bar": 1}},
+		topics:       []string{"bar", "foo"},
+	}, {
+		registration: kazoo.Registration{Pattern: kazoo.RegPatternStatic},
+		topics:       nil,
+	}, {
+		registration: kazoo.Registration{Pattern: kazoo.RegPatternWhiteList, Subscription: map[string]int{"foo.*": 1}},
+		topics:       []string{consumer.TopicPattern("foo.*")},
+	}, {
+		registration: kazoo.Registration{Pattern: kazoo.RegPatternWhiteList, Subscription: map[string]int{" 'foo, bar.*' ": 1}},
+		topics:       []string{consumer.TopicPattern("foo|bar.*")},
+	}, {
+		registration: kazoo.Registration{Pattern: kazoo.RegPatternBlackList, Subscription: map[string]int{"foo.*": 1}},
+		topics:       []string{consumer.ExcludeTopicPattern("foo.*")},
+	}} {
+		// When
+		topics := registrationTopics(&tc.registration)
+
+		// Then
+		c.Assert(topics, DeepEquals, tc.topics, Commentf("case #%d", i))
+	}
+}
+
+// Subscriptions of a departed member are retained for the grace period, and
+// forgotten as soon as it expires.
+func (s *GroupRegistratorSuite) TestRetainDeparted(c *C) {
+	cfg := config.Default()
+	cfg.Consumer.MemberGracePeriod = time.Minute
+	gm := &zkMember{
+		actorID:       s.ns,
+		cfg:           cfg,
+		memberID:      "m1",
+		subscriptions: map[string][]string{"m1": {"a"}, "m2": {"a", "b"}, "m3": {"b"}},
+		departed:      make(map[string]departedMember),
+	}
+	now := time.Now().UTC()
+
+	// When
+	subscriptions := map[string][]string{"m3": {"b"}}
+	expiresAt := gm.retainDeparted(subscriptions, now)
+
+	// Then
+	c.Assert(subscriptions, DeepEquals, map[string][]string{"m2": {"a", "b"}, "m3": {"b"}})
+	c.Assert(expiresAt, Equals, now.Add(time.Minute))
+
+	// When: the grace period expires.
+	gm.subscriptions = subscriptions
+	subscriptions = map[string][]string{"m3": {"b"}}
+	expiresAt = gm.retainDeparted(subscriptions, now.Add(time.Minute))
+
+	// Then
+	c.Assert(subscriptions, DeepEquals, map[string][]string{"m3": {"b"}})
+	c.Assert(expiresAt.IsZero(), Equals, true)
+}
+
+// A member that returns within the grace period is reported with its actual
+// subscriptions.
+func (s *GroupRegistratorSuite) TestRetainDepartedReturned(c *C) {
+	cfg := config.Default()
+	cfg.Consumer.MemberGracePeriod = time.Minute
+	gm := &zkMember{
+		actorID:       s.ns,
+		cfg:           cfg,
+		memberID:      "m1",
+		subscriptions: map[string][]string{"m1": {"a"}, "m2": {"a"}},
+		departed:      make(map[string]departedMember),
+	}
+	now := time.Now().UTC()
+	gm.retainDeparted(map[string][]string{"m1": {"a"}}, now)
+
+	// When
+	subscriptions := map[string][]string{"m1": {"a"}, "m2": {"a", "b"}}
+	expiresAt := gm.retainDeparted(subscriptions, now.Add(time.Second))
+
+	// Then
+	c.Assert(subscriptions, DeepEquals, map[string][]string{"m1": {"a"}, "m2": {"a", "b"}})
+	c.Assert(expiresAt.IsZero(), Equals, true)
+	c.Assert(gm.departed, HasLen, 0)
+}
+
 // When a list of topics is sent to the `topics()` channel, a membership change
 // is received with the same list of topics for the registrator name.
 func (s *GroupRegistratorSuite) TestSimpleSubscribe(c *C) {
@@ -325,6 +413,47 @@ func (s *GroupRegistratorSuite) TestClaimPartitionTwice(c *C) {
 	c.Assert(owner, Equals, "m1")
 }
 
+// A partition owner node with the same member ID that has been left behind by
+// a previous session is not mistaken for a claim of the member, the partition
+// is claimed only after the node expires with its session.
+func (s *GroupRegistratorSuite) TestClaimPartitionStale(c *C) {
+	// Given
+	cfg := config.Default()
+	cfg.Consumer.BackOffTimeout = 50 * time.Millisecond
+	prevKazooConn, err := kazoo.NewKazoo(testhelpers.ZookeeperPeers, kazoo.NewConfig())
+	c.Assert(err, IsNil)
+	err = prevKazooConn.Consumergroup("g1").Instance("m1").ClaimPartition("foo", 1)
+	c.Assert(err, IsNil)
+	gm := Spawn(s.ns.NewChild("m1"), "g1", "m1", cfg, s.kazooConn)
+	defer gm.Stop()
+	cancelCh := make(chan none.T)
+	defer close(cancelCh)
+	claimedCh := make(chan func())
+	go func() {
+		claimedCh <- gm.ClaimPartition(s.ns, "foo", 1, cancelCh)
+	}()
+
+	// When
+	select {
+	case release := <-claimedCh:
+		release()
+		c.Fatal("stale claim mistaken for a claim of the member")
+	case <-time.After(300 * time.Millisecond):
+	}
+	prevKazooConn.Close()
+
+	// Then
+	select {
+	case release := <-claimedCh:
+		defer release()
+	case <-time.After(3 * time.Second):
+		c.Fatal("partition has not been claimed")
+	}
+	owner, err := partitionOwner(gm, "foo", 1)
+	c.Assert(err, IsNil)
+	c.Assert(owner, Equals, "m1")
+}
+
 // If a partition has been claimed more then once then it is release as soon as
 // any of the claims is revoked.
 func (s *GroupRegistratorSuite) TestReleasePartition(c *C) {
@@ -418,8 +547,8 @@ func (s *GroupRegistratorSuite) TestClaimPartitionCanceled(c *C) {
 
 // partitionOwner returns the id of the consumer group member that has claimed
 // the specified topic/partition.
-func partitionOwner(gm *T, topic string, partition int32) (string, error) {
-	owner, err := gm.groupZNode.PartitionOwner(topic, partition)
+func partitionOwner(gm T, topic string, partition int32) (string, error) {
+	owner, err := gm.(*zkMember).groupZNode.PartitionOwner(topic, partition)
 	if err != nil {
 		return "", err
 	}
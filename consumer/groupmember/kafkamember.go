@@ -0,0 +1,481 @@
+package groupmember
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/offsetmgr"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+const (
+	protocolType = "consumer"
+	// All Kafka-Pixy members of a group join it with this protocol, so that
+	// the group coordinator can tell them from members using other clients.
+	protocolName = "kafka-pixy"
+)
+
+// kafkaMember maintains a consumer group membership with the Kafka group
+// coordinator using the JoinGroup, SyncGroup, Heartbeat and LeaveGroup
+// requests.
+//
+// Members join the group with their topic subscriptions and client IDs in the
+// protocol metadata. The member elected to be the group leader by the
+// coordinator collects subscriptions of all members and distributes them in
+// the SyncGroup assignments. So every member gets the same subscriptions that
+// it would read from ZooKeeper, and resolves partitions it should consume the
+// same way.
+//
+// The group coordinator only accepts offset commits from members of the
+// current group generation, therefore the generation is reported to the
+// offset manager factory whenever the member joins a new one.
+//
+// Partition ownership is defined by the group generation, so before the
+// member rejoins the group it revokes all partition claims: it emits empty
+// subscriptions to make the group consumer stop all partition consumers, and
+// waits for their claims to be released. New claims are only granted after
+// the member has joined the next generation. As the coordinator completes a
+// rebalance only when all members have rejoined, a partition is never
+// consumed by two members at the same time.
+//
+// implements `T`.
+type kafkaMember struct {
+	actorID          *actor.ID
+	cfg              *config.T
+	group            string
+	clientID         string
+	saramaClient     sarama.Client
+	offsetMgrFactory offsetmgr.Factory
+	memberID         string
+	generationID     int32
+	topics           []string
+	subscriptions    map[string][]string
+	claims           int
+	claimable        bool
+	claimableCh      chan none.T
+	claimsLock       sync.Mutex
+	claimsReleasedCh chan none.T
+	topicsCh         chan []string
+	subscriptionsCh  chan map[string][]string
+	stopCh           chan none.T
+	wg               sync.WaitGroup
+}
+
+// SpawnKafka creates a consumer group member instance that uses the Kafka
+// group coordinator API and starts its background goroutines. The member
+// maintains a dedicated connection to the coordinator, for JoinGroup requests
+// may block for as long as `Config.Consumer.SessionTimeout` and would delay
+// any other requests sent over the same connection.
+func SpawnKafka(namespace *actor.ID, group, clientID string, cfg *config.T, offsetMgrFactory offsetmgr.Factory) (T, error) {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.ClientID = clientID
+	// The coordinator replies to a JoinGroup request only when all members
+	// have rejoined or the session timeout has elapsed.
+	saramaCfg.Net.ReadTimeout += cfg.Consumer.SessionTimeout
+	saramaClient, err := sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sarama.Client, err=(%s)", err)
+	}
+	gm := &kafkaMember{
+		actorID:          namespace.NewChild("member"),
+		cfg:              cfg,
+		group:            group,
+		clientID:         clientID,
+		saramaClient:     saramaClient,
+		offsetMgrFactory: offsetMgrFactory,
+		generationID:     sarama.GroupGenerationUndefined,
+		claimableCh:      make(chan none.T),
+		claimsReleasedCh: make(chan none.T, 1),
+		topicsCh:         make(chan []string),
+		subscriptionsCh:  make(chan map[string][]string),
+		stopCh:           make(chan none.T),
+	}
+	actor.Spawn(gm.actorID, &gm.wg, gm.run)
+	return gm, nil
+}
+
+// implements `T`.
+func (gm *kafkaMember) Topics() chan<- []string {
+	return gm.topicsCh
+}
+
+// implements `T`.
+func (gm *kafkaMember) Subscriptions() <-chan map[string][]string {
+	return gm.subscriptionsCh
+}
+
+// ClaimPartition blocks while the member is not a member of the current
+// group generation, that is until it has joined the group and while it is
+// rejoining. Claims are counted, so that the member does not rejoin or leave
+// the group until all partition consumers are stopped and have committed
+// their offsets.
+//
+// implements `T`.
+func (gm *kafkaMember) ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func() {
+	beginAt := time.Now()
+	for {
+		gm.claimsLock.Lock()
+		if gm.claimable {
+			gm.claims++
+			gm.claimsLock.Unlock()
+			break
+		}
+		claimableCh := gm.claimableCh
+		gm.claimsLock.Unlock()
+		select {
+		case <-claimableCh:
+		case <-cancelCh:
+			return func() {}
+		}
+	}
+	log.Infof("<%s> partition claimed: via=%s, took=%s", claimerActorID, gm.actorID, millisSince(beginAt))
+	return func() {
+		gm.claimsLock.Lock()
+		gm.claims--
+		if gm.claims == 0 {
+			select {
+			case gm.claimsReleasedCh <- none.V:
+			default:
+			}
+		}
+		gm.claimsLock.Unlock()
+		log.Infof("<%s> partition released: via=%s", claimerActorID, gm.actorID)
+	}
+}
+
+// implements `T`.
+func (gm *kafkaMember) Stop() {
+	close(gm.stopCh)
+	gm.wg.Wait()
+}
+
+func (gm *kafkaMember) run() {
+	defer gm.saramaClient.Close()
+	heartbeatTicker := time.NewTicker(gm.cfg.Consumer.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	var (
+		nilOrSubscriptionsCh chan<- map[string][]string
+		nilOrRetryCh         <-chan time.Time
+		nilOrJoinResultCh    <-chan joinResult
+		pendingSubscriptions map[string][]string
+		shouldJoin           = false
+		joined               = false
+	)
+	for {
+		select {
+		case topics := <-gm.topicsCh:
+			topics = normalizeTopics(topics)
+			if !topicsEqual(topics, gm.topics) {
+				gm.topics = topics
+				shouldJoin = true
+			}
+		case nilOrSubscriptionsCh <- pendingSubscriptions:
+			nilOrSubscriptionsCh = nil
+			gm.subscriptions = pendingSubscriptions
+		case <-gm.claimsReleasedCh:
+		case <-heartbeatTicker.C:
+			if !joined || shouldJoin {
+				continue
+			}
+			if err := gm.heartbeat(); err != nil {
+				log.Infof("<%s> rejoining: err=(%s)", gm.actorID, err)
+				shouldJoin = true
+			}
+		case <-nilOrRetryCh:
+			nilOrRetryCh = nil
+		case result := <-nilOrJoinResultCh:
+			nilOrJoinResultCh = nil
+			gm.memberID = result.memberID
+			if result.err != nil {
+				log.Errorf("<%s> failed to join: err=(%s)", gm.actorID, result.err)
+				shouldJoin = true
+				nilOrRetryCh = time.After(gm.cfg.Consumer.BackOffTimeout)
+				continue
+			}
+			gm.generationID = result.generationID
+			gm.offsetMgrFactory.SetGeneration(gm.group, gm.generationID, gm.memberID)
+			log.Infof("<%s> joined: generation=%d, memberID=%s, topics=%v",
+				gm.actorID, gm.generationID, gm.memberID, gm.topics)
+			joined = true
+			// Topics could have changed while joining, then the member has
+			// to rejoin right away.
+			if shouldJoin {
+				break
+			}
+			gm.grantClaims()
+			pendingSubscriptions = result.subscriptions
+			nilOrSubscriptionsCh = gm.subscriptionsCh
+		case <-gm.stopCh:
+			goto done
+		}
+
+		if !shouldJoin || nilOrRetryCh != nil || nilOrJoinResultCh != nil {
+			continue
+		}
+		// Partitions claimed in the current generation have to be released
+		// before the member rejoins the group.
+		if gm.revokeClaims() {
+			pendingSubscriptions = nil
+			nilOrSubscriptionsCh = nil
+			if len(gm.subscriptions) > 0 {
+				pendingSubscriptions = make(map[string][]string)
+				nilOrSubscriptionsCh = gm.subscriptionsCh
+			}
+		}
+		if nilOrSubscriptionsCh != nil || gm.hasClaims() {
+			continue
+		}
+		joined = false
+		shouldJoin = false
+		joinResultCh := make(chan joinResult, 1)
+		memberID, topics := gm.memberID, gm.topics
+		actor.Spawn(gm.actorID.NewChild("join"), nil, func() {
+			joinResultCh <- gm.join(memberID, topics)
+		})
+		nilOrJoinResultCh = joinResultCh
+	}
+done:
+	close(gm.subscriptionsCh)
+	gm.revokeClaims()
+	// A join in progress is abandoned, the coordinator will expire the
+	// membership it may create after the session timeout.
+	if nilOrJoinResultCh != nil {
+		return
+	}
+	// Keep the membership until all partition consumers are stopped, so that
+	// they can commit their final offsets with the current generation.
+	for gm.hasClaims() {
+		select {
+		case <-gm.claimsReleasedCh:
+		case <-heartbeatTicker.C:
+			if joined {
+				if err := gm.heartbeat(); err != nil {
+					log.Infof("<%s> heartbeat failed while stopping: err=(%s)", gm.actorID, err)
+					joined = false
+				}
+			}
+		}
+	}
+	gm.leave()
+}
+
+// joinResult is the outcome of a join attempt. The member ID is returned
+// even if the attempt failed, for the coordinator may have reported that it
+// does not know the member anymore.
+type joinResult struct {
+	memberID      string
+	generationID  int32
+	subscriptions map[string][]string
+	err           error
+}
+
+// join sends JoinGroup and SyncGroup requests to the group coordinator and
+// returns subscriptions of all group members assigned by the group leader.
+// It is executed in a dedicated goroutine, for the coordinator replies to a
+// JoinGroup request only when all members have rejoined the group, and the
+// member should keep handling topic updates and stop requests meanwhile.
+func (gm *kafkaMember) join(memberID string, topics []string) joinResult {
+	result := joinResult{memberID: memberID}
+	coordinator, err := gm.saramaClient.Coordinator(gm.group)
+	if err != nil {
+		result.err = fmt.Errorf("failed to get coordinator, err=(%s)", err)
+		return result
+	}
+	joinReq := &sarama.JoinGroupRequest{
+		GroupId:        gm.group,
+		SessionTimeout: int32(gm.cfg.Consumer.SessionTimeout / time.Millisecond),
+		MemberId:       memberID,
+		ProtocolType:   protocolType,
+	}
+	meta := &sarama.ConsumerGroupMemberMetadata{Topics: topics, UserData: []byte(gm.clientID)}
+	if err := joinReq.AddGroupProtocolMetadata(protocolName, meta); err != nil {
+		result.err = fmt.Errorf("failed to encode metadata, err=(%s)", err)
+		return result
+	}
+	joinRes, err := coordinator.JoinGroup(joinReq)
+	if err != nil {
+		gm.resetCoordinator(coordinator)
+		result.err = fmt.Errorf("JoinGroup failed, err=(%s)", err)
+		return result
+	}
+	if joinRes.Err != sarama.ErrNoError {
+		if !gm.handleCoordinatorError(joinRes.Err) {
+			result.memberID = ""
+		}
+		result.err = fmt.Errorf("JoinGroup failed, err=(%s)", joinRes.Err)
+		return result
+	}
+	result.memberID = joinRes.MemberId
+
+	syncReq := &sarama.SyncGroupRequest{
+		GroupId:      gm.group,
+		GenerationId: joinRes.GenerationId,
+		MemberId:     joinRes.MemberId,
+	}
+	if joinRes.LeaderId == joinRes.MemberId {
+		if err := gm.assignSubscriptions(joinRes, syncReq); err != nil {
+			result.err = err
+			return result
+		}
+	}
+	syncRes, err := coordinator.SyncGroup(syncReq)
+	if err != nil {
+		gm.resetCoordinator(coordinator)
+		result.err = fmt.Errorf("SyncGroup failed, err=(%s)", err)
+		return result
+	}
+	if syncRes.Err != sarama.ErrNoError {
+		if !gm.handleCoordinatorError(syncRes.Err) {
+			result.memberID = ""
+		}
+		result.err = fmt.Errorf("SyncGroup failed, err=(%s)", syncRes.Err)
+		return result
+	}
+	assignment, err := syncRes.GetMemberAssignment()
+	if err != nil {
+		result.err = fmt.Errorf("failed to decode assignment, err=(%s)", err)
+		return result
+	}
+	if err := json.Unmarshal(assignment.UserData, &result.subscriptions); err != nil {
+		result.err = fmt.Errorf("failed to decode subscriptions, err=(%s)", err)
+		return result
+	}
+	result.generationID = joinRes.GenerationId
+	return result
+}
+
+// assignSubscriptions is executed by the group leader. It collects
+// subscriptions of all members from the JoinGroup response, and adds them as
+// an assignment for every member to the SyncGroup request.
+func (gm *kafkaMember) assignSubscriptions(joinRes *sarama.JoinGroupResponse, syncReq *sarama.SyncGroupRequest) error {
+	members, err := joinRes.GetMembers()
+	if err != nil {
+		return fmt.Errorf("failed to decode members, err=(%s)", err)
+	}
+	subscriptions := make(map[string][]string, len(members))
+	for _, meta := range members {
+		subscriptions[string(meta.UserData)] = normalizeTopics(meta.Topics)
+	}
+	encoded, err := json.Marshal(subscriptions)
+	if err != nil {
+		return fmt.Errorf("failed to encode subscriptions, err=(%s)", err)
+	}
+	for memberID := range members {
+		assignment := &sarama.ConsumerGroupMemberAssignment{UserData: encoded}
+		if err := syncReq.AddGroupAssignmentMember(memberID, assignment); err != nil {
+			return fmt.Errorf("failed to encode assignment, err=(%s)", err)
+		}
+	}
+	log.Infof("<%s> assigned as leader: %v", gm.actorID, subscriptions)
+	return nil
+}
+
+// heartbeat sends a Heartbeat request to the group coordinator. An error is
+// returned if the member has to rejoin the group.
+func (gm *kafkaMember) heartbeat() error {
+	coordinator, err := gm.saramaClient.Coordinator(gm.group)
+	if err != nil {
+		return fmt.Errorf("failed to get coordinator, err=(%s)", err)
+	}
+	res, err := coordinator.Heartbeat(&sarama.HeartbeatRequest{
+		GroupId:      gm.group,
+		GenerationId: gm.generationID,
+		MemberId:     gm.memberID,
+	})
+	if err != nil {
+		gm.resetCoordinator(coordinator)
+		return fmt.Errorf("Heartbeat failed, err=(%s)", err)
+	}
+	if res.Err != sarama.ErrNoError {
+		if !gm.handleCoordinatorError(res.Err) {
+			gm.memberID = ""
+		}
+		return fmt.Errorf("Heartbeat failed, err=(%s)", res.Err)
+	}
+	return nil
+}
+
+// leave sends a LeaveGroup request to the group coordinator, so that other
+// members do not have to wait for the session timeout to rebalance.
+func (gm *kafkaMember) leave() {
+	gm.offsetMgrFactory.SetGeneration(gm.group, sarama.GroupGenerationUndefined, "")
+	if gm.memberID == "" {
+		return
+	}
+	coordinator, err := gm.saramaClient.Coordinator(gm.group)
+	if err != nil {
+		log.Errorf("<%s> failed to leave: err=(%s)", gm.actorID, err)
+		return
+	}
+	res, err := coordinator.LeaveGroup(&sarama.LeaveGroupRequest{GroupId: gm.group, MemberId: gm.memberID})
+	if err == nil && res.Err != sarama.ErrNoError {
+		err = res.Err
+	}
+	if err != nil {
+		log.Errorf("<%s> failed to leave: err=(%s)", gm.actorID, err)
+		return
+	}
+	log.Infof("<%s> left: memberID=%s", gm.actorID, gm.memberID)
+}
+
+// handleCoordinatorError refreshes the group coordinator if an error returned
+// by the coordinator says that it has moved. It returns false if the
+// coordinator does not know the member anymore, so that it has to join as a
+// new one.
+func (gm *kafkaMember) handleCoordinatorError(kerr sarama.KError) bool {
+	switch kerr {
+	case sarama.ErrUnknownMemberId, sarama.ErrIllegalGeneration:
+		return false
+	case sarama.ErrNotCoordinatorForConsumer, sarama.ErrConsumerCoordinatorNotAvailable:
+		if err := gm.saramaClient.RefreshCoordinator(gm.group); err != nil {
+			log.Errorf("<%s> failed to refresh coordinator: err=(%s)", gm.actorID, err)
+		}
+	}
+	return true
+}
+
+// resetCoordinator closes the coordinator connection after a network error, so
+// that it is re-established by the next request.
+func (gm *kafkaMember) resetCoordinator(coordinator *sarama.Broker) {
+	_ = coordinator.Close()
+	if err := gm.saramaClient.RefreshCoordinator(gm.group); err != nil {
+		log.Errorf("<%s> failed to refresh coordinator: err=(%s)", gm.actorID, err)
+	}
+}
+
+func (gm *kafkaMember) hasClaims() bool {
+	gm.claimsLock.Lock()
+	defer gm.claimsLock.Unlock()
+	return gm.claims > 0
+}
+
+// grantClaims lets partitions be claimed in the generation that the member
+// has just joined, and unblocks pending `ClaimPartition` calls.
+func (gm *kafkaMember) grantClaims() {
+	gm.claimsLock.Lock()
+	defer gm.claimsLock.Unlock()
+	if !gm.claimable {
+		gm.claimable = true
+		close(gm.claimableCh)
+	}
+}
+
+// revokeClaims makes `ClaimPartition` calls block until claims are granted
+// again. It returns true if claims were granted before the call.
+func (gm *kafkaMember) revokeClaims() bool {
+	gm.claimsLock.Lock()
+	defer gm.claimsLock.Unlock()
+	if !gm.claimable {
+		return false
+	}
+	gm.claimable = false
+	gm.claimableCh = make(chan none.T)
+	return true
+}
@@ -0,0 +1,418 @@
+package groupmember
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer/offsetmgr"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+type KafkaMemberSuite struct {
+	ns     *actor.ID
+	broker *sarama.MockBroker
+	cfg    *config.T
+	omf    *mockOffsetMgrFactory
+}
+
+var _ = Suite(&KafkaMemberSuite{})
+
+func (s *KafkaMemberSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging(c)
+}
+
+func (s *KafkaMemberSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+	s.broker = sarama.NewMockBroker(c, 101)
+	s.cfg = testhelpers.NewTestConfig("c1")
+	s.cfg.Kafka.SeedPeers = []string{s.broker.Addr()}
+	s.cfg.Consumer.GroupMembership = config.GroupMembershipKafka
+	s.cfg.Consumer.HeartbeatInterval = 50 * time.Millisecond
+	s.cfg.Consumer.BackOffTimeout = 50 * time.Millisecond
+	s.omf = &mockOffsetMgrFactory{}
+}
+
+func (s *KafkaMemberSuite) TearDownTest(c *C) {
+	s.broker.Close()
+}
+
+// When a member is elected a group leader, it assigns subscriptions of all
+// members to every member. Subscriptions assigned to the member by the group
+// leader are emitted, and the generation is reported to the offset manager
+// factory.
+func (s *KafkaMemberSuite) TestLeaderAssigns(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockWrapper(s.joinGroupResponse(c, 3, "m-1", "m-1", map[string][]string{
+			"m-1": {"t2", "t1"},
+			"m-2": {"t3"},
+		})),
+		"SyncGroupRequest": sarama.NewMockWrapper(s.syncGroupResponse(c, map[string][]string{
+			"c1": {"t1", "t2"},
+			"c2": {"t3"},
+		})),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+
+	// When
+	gm.Topics() <- []string{"t2", "t1"}
+
+	// Then
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{
+		"c1": {"t1", "t2"},
+		"c2": {"t3"},
+	})
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1"})
+
+	joinReq := s.lastRequest(c, "JoinGroupRequest").(*sarama.JoinGroupRequest)
+	c.Assert(joinReq.GroupId, Equals, "g1")
+	c.Assert(joinReq.MemberId, Equals, "")
+	c.Assert(joinReq.ProtocolType, Equals, protocolType)
+	c.Assert(joinReq.SessionTimeout, Equals, int32(s.cfg.Consumer.SessionTimeout/time.Millisecond))
+
+	syncReq := s.lastRequest(c, "SyncGroupRequest").(*sarama.SyncGroupRequest)
+	c.Assert(syncReq.GenerationId, Equals, int32(3))
+	c.Assert(syncReq.MemberId, Equals, "m-1")
+	c.Assert(len(syncReq.GroupAssignments), Equals, 2)
+	for _, memberID := range []string{"m-1", "m-2"} {
+		c.Assert(decodeAssignment(c, syncReq.GroupAssignments[memberID]), DeepEquals, map[string][]string{
+			"c_m-1": {"t1", "t2"},
+			"c_m-2": {"t3"},
+		})
+	}
+}
+
+// A member that is not the group leader does not assign subscriptions.
+func (s *KafkaMemberSuite) TestFollowerDoesNotAssign(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockWrapper(s.joinGroupResponse(c, 3, "m-2", "m-1", nil)),
+		"SyncGroupRequest": sarama.NewMockWrapper(s.syncGroupResponse(c, map[string][]string{
+			"c1": {"t1"},
+			"c2": {"t1"},
+		})),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+
+	// When
+	gm.Topics() <- []string{"t1"}
+
+	// Then
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{
+		"c1": {"t1"},
+		"c2": {"t1"},
+	})
+	syncReq := s.lastRequest(c, "SyncGroupRequest").(*sarama.SyncGroupRequest)
+	c.Assert(len(syncReq.GroupAssignments), Equals, 0)
+}
+
+// If the coordinator replies to a heartbeat that the group is rebalancing,
+// then the member revokes partitions by emitting empty subscriptions, rejoins
+// the group and emits updated subscriptions.
+func (s *KafkaMemberSuite) TestRejoinOnRebalance(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockSequence(
+			s.joinGroupResponse(c, 3, "m-1", "m-2", nil),
+			s.joinGroupResponse(c, 4, "m-1", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockSequence(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}}),
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}, "c2": {"t1"}})),
+		"HeartbeatRequest": sarama.NewMockSequence(
+			&sarama.HeartbeatResponse{Err: sarama.ErrRebalanceInProgress},
+			&sarama.HeartbeatResponse{Err: sarama.ErrNoError}),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+	gm.Topics() <- []string{"t1"}
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}})
+
+	// When
+	revoked := <-gm.Subscriptions()
+	subscriptions := <-gm.Subscriptions()
+
+	// Then
+	c.Assert(revoked, DeepEquals, map[string][]string{})
+	c.Assert(subscriptions, DeepEquals, map[string][]string{"c1": {"t1"}, "c2": {"t1"}})
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1", "g1:4:m-1"})
+	// The member ID is preserved when rejoining.
+	joinReq := s.lastRequest(c, "JoinGroupRequest").(*sarama.JoinGroupRequest)
+	c.Assert(joinReq.MemberId, Equals, "m-1")
+}
+
+// Partitions can only be claimed when the member has joined the group. Before
+// the member rejoins the group it waits for all claims to be released, and
+// new claims are not granted until the member has joined the next generation.
+func (s *KafkaMemberSuite) TestClaimsReleasedBeforeRejoin(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockSequence(
+			s.joinGroupResponse(c, 3, "m-1", "m-2", nil),
+			s.joinGroupResponse(c, 4, "m-1", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockSequence(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}}),
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}, "c2": {"t1"}})),
+		"HeartbeatRequest": sarama.NewMockSequence(
+			&sarama.HeartbeatResponse{Err: sarama.ErrRebalanceInProgress},
+			&sarama.HeartbeatResponse{Err: sarama.ErrNoError}),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+
+	claimedCh := make(chan func(), 1)
+	go func() { claimedCh <- gm.ClaimPartition(s.ns, "t1", 1, nil) }()
+	select {
+	case <-claimedCh:
+		c.Error("partition claimed before the member joined the group")
+	case <-time.After(200 * time.Millisecond):
+	}
+	gm.Topics() <- []string{"t1"}
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}})
+	release1 := <-claimedCh
+
+	// When
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{})
+	go func() { claimedCh <- gm.ClaimPartition(s.ns, "t1", 2, nil) }()
+
+	// Then
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(s.lastRequest(c, "JoinGroupRequest").(*sarama.JoinGroupRequest).MemberId, Equals, "")
+	select {
+	case <-claimedCh:
+		c.Error("partition claimed while the member is rejoining")
+	default:
+	}
+
+	release1()
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}, "c2": {"t1"}})
+	c.Assert(s.lastRequest(c, "JoinGroupRequest").(*sarama.JoinGroupRequest).MemberId, Equals, "m-1")
+	release2 := <-claimedCh
+	release2()
+}
+
+// If the coordinator does not know the member anymore, then it rejoins the
+// group as a new member.
+func (s *KafkaMemberSuite) TestRejoinUnknownMember(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockSequence(
+			s.joinGroupResponse(c, 3, "m-1", "m-2", nil),
+			s.joinGroupResponse(c, 4, "m-3", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockSequence(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}}),
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1", "t2"}})),
+		"HeartbeatRequest": sarama.NewMockSequence(
+			&sarama.HeartbeatResponse{Err: sarama.ErrUnknownMemberId},
+			&sarama.HeartbeatResponse{Err: sarama.ErrNoError}),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+	gm.Topics() <- []string{"t1"}
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}})
+
+	// When
+	revoked := <-gm.Subscriptions()
+	subscriptions := <-gm.Subscriptions()
+
+	// Then
+	c.Assert(revoked, DeepEquals, map[string][]string{})
+	c.Assert(subscriptions, DeepEquals, map[string][]string{"c1": {"t1", "t2"}})
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1", "g1:4:m-3"})
+	joinReq := s.lastRequest(c, "JoinGroupRequest").(*sarama.JoinGroupRequest)
+	c.Assert(joinReq.MemberId, Equals, "")
+}
+
+// If JoinGroup fails, then it is retried after a backoff timeout.
+func (s *KafkaMemberSuite) TestJoinRetried(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockSequence(
+			&sarama.JoinGroupResponse{Err: sarama.ErrNotCoordinatorForConsumer},
+			s.joinGroupResponse(c, 3, "m-1", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockWrapper(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}})),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	defer gm.Stop()
+
+	// When
+	gm.Topics() <- []string{"t1"}
+
+	// Then
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}})
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1"})
+}
+
+// Joining the group does not block the member, so it keeps accepting topic
+// updates and can be stopped while the coordinator is yet to reply.
+func (s *KafkaMemberSuite) TestStopWhileJoining(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockWrapper(s.joinGroupResponse(c, 3, "m-1", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockWrapper(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}})),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	s.broker.SetLatency(time.Second)
+	gm.Topics() <- []string{"t1"}
+
+	// When
+	beginAt := time.Now()
+	gm.Topics() <- []string{"t1", "t2"}
+	gm.Stop()
+
+	// Then
+	c.Assert(time.Since(beginAt) < 500*time.Millisecond, Equals, true)
+	_, ok := <-gm.Subscriptions()
+	c.Assert(ok, Equals, false)
+	c.Assert(s.omf.generations(), DeepEquals, []string(nil))
+}
+
+// On stop the member keeps the group membership until all partition claims
+// are released, and only then leaves the group.
+func (s *KafkaMemberSuite) TestStopWaitsForClaims(c *C) {
+	s.setHandlers(c, map[string]sarama.MockResponse{
+		"JoinGroupRequest": sarama.NewMockWrapper(s.joinGroupResponse(c, 3, "m-1", "m-2", nil)),
+		"SyncGroupRequest": sarama.NewMockWrapper(
+			s.syncGroupResponse(c, map[string][]string{"c1": {"t1"}})),
+	})
+	gm, err := SpawnKafka(s.ns, "g1", "c1", s.cfg, s.omf)
+	c.Assert(err, IsNil)
+	gm.Topics() <- []string{"t1"}
+	c.Assert(<-gm.Subscriptions(), DeepEquals, map[string][]string{"c1": {"t1"}})
+	release1 := gm.ClaimPartition(s.ns, "t1", 1, nil)
+	release2 := gm.ClaimPartition(s.ns, "t1", 2, nil)
+
+	// When
+	var wg sync.WaitGroup
+	actor.Spawn(s.ns.NewChild("stop"), &wg, gm.Stop)
+
+	// Then
+	_, ok := <-gm.Subscriptions()
+	c.Assert(ok, Equals, false)
+	release1()
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(s.lastRequest(c, "LeaveGroupRequest"), IsNil)
+	c.Assert(s.lastRequest(c, "HeartbeatRequest"), NotNil)
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1"})
+
+	release2()
+	wg.Wait()
+	leaveReq := s.lastRequest(c, "LeaveGroupRequest").(*sarama.LeaveGroupRequest)
+	c.Assert(leaveReq.GroupId, Equals, "g1")
+	c.Assert(leaveReq.MemberId, Equals, "m-1")
+	c.Assert(s.omf.generations(), DeepEquals, []string{"g1:3:m-1", "g1:-1:"})
+}
+
+func (s *KafkaMemberSuite) setHandlers(c *C, handlers map[string]sarama.MockResponse) {
+	handlers["MetadataRequest"] = sarama.NewMockMetadataResponse(c).
+		SetBroker(s.broker.Addr(), s.broker.BrokerID())
+	handlers["ConsumerMetadataRequest"] = sarama.NewMockConsumerMetadataResponse(c).
+		SetCoordinator("g1", s.broker)
+	if handlers["HeartbeatRequest"] == nil {
+		handlers["HeartbeatRequest"] = sarama.NewMockWrapper(&sarama.HeartbeatResponse{})
+	}
+	handlers["LeaveGroupRequest"] = sarama.NewMockWrapper(&sarama.LeaveGroupResponse{})
+	s.broker.SetHandlerByMap(handlers)
+}
+
+// joinGroupResponse builds a JoinGroup response. Members are given as a map
+// of member IDs to topics, the client ID of every member is its member ID
+// prefixed with `c_`.
+func (s *KafkaMemberSuite) joinGroupResponse(c *C, generationID int32, memberID, leaderID string,
+	members map[string][]string,
+) *sarama.JoinGroupResponse {
+	res := &sarama.JoinGroupResponse{
+		GenerationId:  generationID,
+		GroupProtocol: protocolName,
+		LeaderId:      leaderID,
+		MemberId:      memberID,
+		Members:       make(map[string][]byte),
+	}
+	for memberID, topics := range members {
+		// JoinGroupRequest is used to get sarama encode member metadata.
+		req := &sarama.JoinGroupRequest{}
+		meta := &sarama.ConsumerGroupMemberMetadata{Topics: topics, UserData: []byte("c_" + memberID)}
+		c.Assert(req.AddGroupProtocolMetadata(protocolName, meta), IsNil)
+		res.Members[memberID] = req.GroupProtocols[protocolName]
+	}
+	return res
+}
+
+func (s *KafkaMemberSuite) syncGroupResponse(c *C, subscriptions map[string][]string) *sarama.SyncGroupResponse {
+	encoded, err := json.Marshal(subscriptions)
+	c.Assert(err, IsNil)
+	// SyncGroupRequest is used to get sarama encode the member assignment.
+	req := &sarama.SyncGroupRequest{}
+	c.Assert(req.AddGroupAssignmentMember("m", &sarama.ConsumerGroupMemberAssignment{UserData: encoded}), IsNil)
+	return &sarama.SyncGroupResponse{MemberAssignment: req.GroupAssignments["m"]}
+}
+
+// lastRequest returns the last request of the specified type received by the
+// mock broker, or nil if there has been none.
+func (s *KafkaMemberSuite) lastRequest(c *C, requestType string) interface{} {
+	var last interface{}
+	for _, rr := range s.broker.History() {
+		switch rr.Request.(type) {
+		case *sarama.JoinGroupRequest:
+			if requestType == "JoinGroupRequest" {
+				last = rr.Request
+			}
+		case *sarama.SyncGroupRequest:
+			if requestType == "SyncGroupRequest" {
+				last = rr.Request
+			}
+		case *sarama.HeartbeatRequest:
+			if requestType == "HeartbeatRequest" {
+				last = rr.Request
+			}
+		case *sarama.LeaveGroupRequest:
+			if requestType == "LeaveGroupRequest" {
+				last = rr.Request
+			}
+		}
+	}
+	return last
+}
+
+func decodeAssignment(c *C, encoded []byte) map[string][]string {
+	// SyncGroupResponse is used to get sarama decode the member assignment.
+	res := &sarama.SyncGroupResponse{MemberAssignment: encoded}
+	assignment, err := res.GetMemberAssignment()
+	c.Assert(err, IsNil)
+	var subscriptions map[string][]string
+	c.Assert(json.Unmarshal(assignment.UserData, &subscriptions), IsNil)
+	return subscriptions
+}
+
+// mockOffsetMgrFactory records generations reported by a group member.
+type mockOffsetMgrFactory struct {
+	mu   sync.Mutex
+	gens []string
+}
+
+func (f *mockOffsetMgrFactory) SpawnOffsetManager(namespace *actor.ID, group, topic string, partition int32) (offsetmgr.T, error) {
+	panic("not implemented")
+}
+
+func (f *mockOffsetMgrFactory) SetGeneration(group string, generationID int32, memberID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gens = append(f.gens, fmt.Sprintf("%s:%d:%s", group, generationID, memberID))
+}
+
+func (f *mockOffsetMgrFactory) Stop() {}
+
+func (f *mockOffsetMgrFactory) generations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.gens...)
+}
@@ -2,15 +2,19 @@ package msgstream
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/mapper"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Factory provides API to spawn message streams to that read message from
@@ -47,6 +51,18 @@ type T interface {
 	// channel.
 	Errors() <-chan *Err
 
+	// Err returns an error that made the message stream give up fetching
+	// messages and close the `Messages()` channel, that is
+	// `sarama.ErrOffsetOutOfRange` if the offset to fetch from is not
+	// available in Kafka. It returns nil if the stream has not given up. It
+	// should only be called after the `Messages()` channel is closed.
+	Err() error
+
+	// SetPaused makes the message stream stop or resume making fetch
+	// requests. Messages that have already been fetched are still pushed to
+	// the `Messages()` channel while the stream is paused.
+	SetPaused(paused bool)
+
 	// Stop synchronously stops the partition consumer. It must be called
 	// before the factory that created the instance can be stopped.
 	Stop()
@@ -75,6 +91,7 @@ func (ce Errors) Error() string {
 
 type factory struct {
 	namespace    *actor.ID
+	group        string
 	saramaCfg    *sarama.Config
 	client       sarama.Client
 	children     map[topicPartition]*msgStream
@@ -89,10 +106,12 @@ type topicPartition struct {
 
 // SpawnFactory creates a new message stream factory using the given client. It
 // is still necessary to call Stop() on the underlying client after shutting
-// down this factory.
-func SpawnFactory(namespace *actor.ID, client sarama.Client) (Factory, error) {
+// down this factory. The `group` is only used to label partition lag metrics
+// of the spawned message streams.
+func SpawnFactory(namespace *actor.ID, group string, client sarama.Client) (Factory, error) {
 	f := &factory{
 		namespace: namespace.NewChild("msg_stream_f"),
+		group:     group,
 		client:    client,
 		saramaCfg: client.Config(),
 		children:  make(map[topicPartition]*msgStream),
@@ -185,11 +204,15 @@ type msgStream struct {
 	fetchSize    int32
 	offset       int64
 	lag          int64
+	lagGauge     prometheus.Gauge
 	assignmentCh chan mapper.Executor
 	initErrorCh  chan error
 	messagesCh   chan *consumer.Message
 	errorsCh     chan *Err
 	closingCh    chan none.T
+	pausedCh     chan none.T
+	paused       int32
+	err          error
 	wg           sync.WaitGroup
 }
 
@@ -203,8 +226,10 @@ func (f *factory) spawnMsgStream(namespace *actor.ID, tp topicPartition, offset
 		messagesCh:   make(chan *consumer.Message, f.saramaCfg.ChannelBufferSize),
 		errorsCh:     make(chan *Err, f.saramaCfg.ChannelBufferSize),
 		closingCh:    make(chan none.T, 1),
+		pausedCh:     make(chan none.T, 1),
 		offset:       offset,
 		fetchSize:    f.saramaCfg.Consumer.Fetch.Default,
+		lagGauge:     metrics.PartitionLag.WithLabelValues(f.group, tp.topic, strconv.Itoa(int(tp.partition))),
 	}
 	actor.Spawn(ms.actorID, &ms.wg, ms.run)
 	return ms
@@ -220,6 +245,25 @@ func (ms *msgStream) Errors() <-chan *Err {
 	return ms.errorsCh
 }
 
+// implements `T`.
+func (ms *msgStream) Err() error {
+	return ms.err
+}
+
+// implements `T`.
+func (ms *msgStream) SetPaused(paused bool) {
+	var value int32
+	if paused {
+		value = 1
+	}
+	atomic.StoreInt32(&ms.paused, value)
+	// Only the latest value matters, so a pending notification is enough.
+	select {
+	case ms.pausedCh <- none.V:
+	default:
+	}
+}
+
 // implements `Factory`.
 func (ms *msgStream) Stop() {
 	close(ms.closingCh)
@@ -229,6 +273,7 @@ func (ms *msgStream) Stop() {
 	delete(ms.f.children, ms.tp)
 	ms.f.childrenLock.Unlock()
 	ms.f.mapper.WorkerStopped() <- ms
+	metrics.PartitionLag.DeleteLabelValues(ms.f.group, ms.tp.topic, strconv.Itoa(int(ms.tp.partition)))
 }
 
 // implements `mapper.Worker`.
@@ -253,6 +298,7 @@ func (ms *msgStream) run() {
 		currMessage               *consumer.Message
 		currMessageIdx            int
 		lastReassignTime          time.Time
+		paused                    bool
 	)
 	triggerOrScheduleReassign := func(reason string) {
 		assignedFetchRequestCh = nil
@@ -268,6 +314,11 @@ func (ms *msgStream) run() {
 	}
 pullMessagesLoop:
 	for {
+		// A pending fetch request is held back while the stream is paused.
+		nilOrUnpausedFetchRequestsCh := nilOrFetchRequestsCh
+		if paused {
+			nilOrUnpausedFetchRequestsCh = nil
+		}
 		select {
 		case bw := <-ms.assignmentCh:
 			log.Infof("<%s> assigned %s", ms.actorID, bw)
@@ -286,7 +337,7 @@ pullMessagesLoop:
 				nilOrFetchRequestsCh = assignedFetchRequestCh
 			}
 
-		case nilOrFetchRequestsCh <- fetchRequest{ms.tp.topic, ms.tp.partition, ms.offset, ms.fetchSize, ms.lag, fetchResultCh}:
+		case nilOrUnpausedFetchRequestsCh <- fetchRequest{ms.tp.topic, ms.tp.partition, ms.offset, ms.fetchSize, ms.lag, fetchResultCh}:
 			nilOrFetchRequestsCh = nil
 			nilOrFetchResultsCh = fetchResultCh
 
@@ -297,7 +348,9 @@ pullMessagesLoop:
 				ms.reportError(err)
 				if err == sarama.ErrOffsetOutOfRange {
 					// There's no point in retrying this it will just fail the
-					// same way, therefore is nothing to do but give up.
+					// same way, therefore is nothing to do but give up and
+					// let the owner decide where to continue from.
+					ms.err = err
 					goto done
 				}
 				triggerOrScheduleReassign("fetch error")
@@ -329,6 +382,10 @@ pullMessagesLoop:
 			log.Infof("<%s> reassign triggered by timeout", ms.actorID)
 			nilOrReassignRetryTimerCh = time.After(ms.f.saramaCfg.Consumer.Retry.Backoff)
 
+		case <-ms.pausedCh:
+			paused = atomic.LoadInt32(&ms.paused) == 1
+			log.Infof("<%s> paused: %t", ms.actorID, paused)
+
 		case <-ms.closingCh:
 			goto done
 		}
@@ -357,6 +414,7 @@ func (ms *msgStream) parseFetchResult(cid *actor.ID, fetchResult fetchResult) ([
 	if block.Err != sarama.ErrNoError {
 		return nil, block.Err
 	}
+	ms.lagGauge.Set(float64(block.HighWaterMarkOffset - ms.offset))
 
 	if len(block.MsgSet.Messages) == 0 {
 		// We got no messages. If we got a trailing one then we need to ask for more data.
@@ -53,7 +53,7 @@ func (s *MessageStreamFuncSuite) TestSlacker(c *C) {
 	config.ChannelBufferSize = 10
 	client, _ := sarama.NewClient(testhelpers.KafkaPeers, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -7,8 +7,10 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/log"
+	dto "github.com/prometheus/client_model/go"
 	. "gopkg.in/check.v1"
 )
 
@@ -59,7 +61,7 @@ func (s *MessageStreamSuite) TestOffsetManual(c *C) {
 	defer client.Close()
 
 	// When
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -102,7 +104,7 @@ func (s *MessageStreamSuite) TestOffsetNewest(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -118,6 +120,47 @@ func (s *MessageStreamSuite) TestOffsetNewest(c *C) {
 	c.Assert(msg.HighWaterMark, Equals, int64(14))
 }
 
+// Partition lag of a message stream is reported as the difference between the
+// high water mark and the offset of the next message to be fetched. The
+// metric is removed when the message stream is stopped.
+func (s *MessageStreamSuite) TestPartitionLagMetric(c *C) {
+	// Given
+	broker0 := sarama.NewMockBroker(c, 0)
+	defer broker0.Close()
+	broker0.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(c).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 10).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 7),
+		"FetchRequest": sarama.NewMockFetchResponse(c, 1).
+			SetMessage("my_topic", 0, 10, testMsg).
+			SetMessage("my_topic", 0, 11, testMsg).
+			SetHighWaterMark("my_topic", 0, 14),
+	})
+
+	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
+	defer client.Close()
+	f, err := SpawnFactory(s.ns, "g-lag", client)
+	c.Assert(err, IsNil)
+	defer f.Stop()
+	pc, _, err := f.SpawnMessageStream(s.ns.NewChild("my_topic", 0), "my_topic", 0, 10)
+	c.Assert(err, IsNil)
+
+	// When
+	msg := <-pc.Messages()
+
+	// Then
+	c.Assert(msg.Offset, Equals, int64(10))
+	var lag dto.Metric
+	c.Assert(metrics.PartitionLag.WithLabelValues("g-lag", "my_topic", "0").Write(&lag), IsNil)
+	c.Assert(lag.GetGauge().GetValue(), Equals, float64(4))
+
+	pc.Stop()
+	c.Assert(metrics.PartitionLag.DeleteLabelValues("g-lag", "my_topic", "0"), Equals, false)
+}
+
 // It is possible to close a partition consumer and create the same anew.
 func (s *MessageStreamSuite) TestRecreate(c *C) {
 	// Given
@@ -136,7 +179,7 @@ func (s *MessageStreamSuite) TestRecreate(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -173,7 +216,7 @@ func (s *MessageStreamSuite) TestDuplicate(c *C) {
 	config.ChannelBufferSize = 0
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -218,7 +261,7 @@ func (s *MessageStreamSuite) TestLeaderRefreshError(c *C) {
 	config.Metadata.Retry.Max = 0
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -275,7 +318,7 @@ func (s *MessageStreamSuite) TestInvalidTopic(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -312,7 +355,7 @@ func (s *MessageStreamSuite) TestClosePartitionWithoutLeader(c *C) {
 	config.Metadata.Retry.Max = 0
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -359,7 +402,7 @@ func (s *MessageStreamSuite) TestShutsDownOutOfRange(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -372,6 +415,7 @@ func (s *MessageStreamSuite) TestShutsDownOutOfRange(c *C) {
 	if _, ok := <-pc.Messages(); ok {
 		c.Error("Expected the consumer to shut down")
 	}
+	c.Assert(pc.Err(), Equals, sarama.ErrOffsetOutOfRange)
 }
 
 // If a fetch response contains messages with offsets that are smaller then
@@ -399,7 +443,7 @@ func (s *MessageStreamSuite) TestExtraOffsets(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -438,7 +482,7 @@ func (s *MessageStreamSuite) TestNonSequentialOffsets(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -492,7 +536,7 @@ func (s *MessageStreamSuite) TestRebalancingMultiplePartitions(c *C) {
 	config.Consumer.Retry.Backoff = 50 * time.Millisecond
 	client, _ := sarama.NewClient([]string{seedBroker.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -632,7 +676,7 @@ func (s *MessageStreamSuite) TestInterleavedClose(c *C) {
 	config.ChannelBufferSize = 0
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -690,7 +734,7 @@ func (s *MessageStreamSuite) TestBounceWithReferenceOpen(c *C) {
 	config.ChannelBufferSize = 1
 	client, _ := sarama.NewClient([]string{broker1.Addr()}, config)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
@@ -755,7 +799,7 @@ func (s *MessageStreamSuite) TestOffsetOutOfRange(c *C) {
 
 	client, _ := sarama.NewClient([]string{broker0.Addr()}, nil)
 	defer client.Close()
-	f, err := SpawnFactory(s.ns, client)
+	f, err := SpawnFactory(s.ns, "g1", client)
 	c.Assert(err, IsNil)
 	defer f.Stop()
 
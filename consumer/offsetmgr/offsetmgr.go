@@ -9,6 +9,7 @@ import (
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer/mapper"
+	"github.com/mailgun/kafka-pixy/metrics"
 	"github.com/mailgun/log"
 	"math"
 )
@@ -31,6 +32,13 @@ type Factory interface {
 	// new one can be started.
 	SpawnOffsetManager(namespace *actor.ID, group, topic string, partition int32) (T, error)
 
+	// SetGeneration sets the generation and the member ID that offsets of the
+	// group are committed with. It has to be called by members of groups
+	// managed by the Kafka group coordinator whenever they join a new group
+	// generation, for the coordinator rejects commits that do not specify
+	// the current one. `sarama.GroupGenerationUndefined` resets it.
+	SetGeneration(group string, generationID int32, memberID string)
+
 	// Stop waits for the spawned offset managers to stop and then terminates. Note
 	// that all spawned offset managers has to be explicitly stopped by calling
 	// their Stop method.
@@ -94,10 +102,11 @@ var ErrRequestTimeout = errors.New("request timeout")
 // SpawnFactory creates a new offset manager factory from the given client.
 func SpawnFactory(namespace *actor.ID, cfg *config.T, client sarama.Client) Factory {
 	f := &factory{
-		namespace: namespace.NewChild("offset_mgr_f"),
-		client:    client,
-		cfg:       cfg,
-		children:  make(map[groupTopicPartition]*offsetManager),
+		namespace:   namespace.NewChild("offset_mgr_f"),
+		client:      client,
+		cfg:         cfg,
+		children:    make(map[groupTopicPartition]*offsetManager),
+		generations: make(map[string]generation),
 	}
 	f.mapper = mapper.Spawn(f.namespace, f)
 	return f
@@ -112,6 +121,14 @@ type factory struct {
 	mapper       *mapper.T
 	children     map[groupTopicPartition]*offsetManager
 	childrenLock sync.Mutex
+	// Generations of groups managed by the Kafka group coordinator.
+	generations     map[string]generation
+	generationsLock sync.Mutex
+}
+
+type generation struct {
+	id       int32
+	memberID string
 }
 
 type groupTopicPartition struct {
@@ -135,6 +152,29 @@ func (f *factory) SpawnOffsetManager(namespace *actor.ID, group, topic string, p
 	return om, nil
 }
 
+// implements `Factory`
+func (f *factory) SetGeneration(group string, generationID int32, memberID string) {
+	f.generationsLock.Lock()
+	defer f.generationsLock.Unlock()
+	if generationID == sarama.GroupGenerationUndefined {
+		delete(f.generations, group)
+		return
+	}
+	f.generations[group] = generation{generationID, memberID}
+}
+
+// generation returns the generation and the member ID that offsets of the
+// group should be committed with.
+func (f *factory) generation(group string) generation {
+	f.generationsLock.Lock()
+	defer f.generationsLock.Unlock()
+	gen, ok := f.generations[group]
+	if !ok {
+		return generation{id: sarama.GroupGenerationUndefined}
+	}
+	return gen
+}
+
 // implements `mapper.Resolver`.
 func (f *factory) ResolveBroker(pw mapper.Worker) (*sarama.Broker, error) {
 	om := pw.(*offsetManager)
@@ -154,6 +194,7 @@ func (f *factory) SpawnExecutor(brokerConn *sarama.Broker) mapper.Executor {
 	be := &brokerExecutor{
 		aggrActorID:     f.namespace.NewChild("broker", brokerConn.ID(), "aggr"),
 		execActorID:     f.namespace.NewChild("broker", brokerConn.ID(), "exec"),
+		f:               f,
 		cfg:             f.cfg,
 		conn:            brokerConn,
 		requestsCh:      make(chan submitRequest),
@@ -413,6 +454,7 @@ type submitResponse struct {
 type brokerExecutor struct {
 	aggrActorID     *actor.ID
 	execActorID     *actor.ID
+	f               *factory
 	cfg             *config.T
 	conn            *sarama.Broker
 	requestsCh      chan submitRequest
@@ -479,16 +521,23 @@ offsetCommitLoop:
 			}
 			nilOrBatchRequestsCh = nil
 			for group, groupRequests := range batchRequest {
+				gen := be.f.generation(group)
 				kafkaReq := &sarama.OffsetCommitRequest{
 					Version:                 1,
 					ConsumerGroup:           group,
-					ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+					ConsumerGroupGeneration: gen.id,
+					ConsumerID:              gen.memberID,
 				}
 				for _, submitReq := range groupRequests {
 					kafkaReq.AddBlock(submitReq.gtp.topic, submitReq.gtp.partition, submitReq.offset, sarama.ReceiveTime, submitReq.metadata)
 				}
 				var kafkaRes *sarama.OffsetCommitResponse
+				commitStartedAt := time.Now()
 				kafkaRes, lastErr = be.conn.CommitOffset(kafkaReq)
+				metrics.OffsetCommitLatency.WithLabelValues(group).Observe(time.Since(commitStartedAt).Seconds())
+				if lastErr != nil || hasCommitErrors(kafkaRes) {
+					metrics.OffsetCommitFailures.WithLabelValues(group).Inc()
+				}
 				if lastErr != nil {
 					lastErrTime = time.Now().UTC()
 					be.conn.Close()
@@ -511,6 +560,19 @@ func (be *brokerExecutor) String() string {
 	return be.aggrActorID.String()
 }
 
+// hasCommitErrors tells whether Kafka failed to commit an offset of at least
+// one partition in an offset commit request.
+func hasCommitErrors(kafkaRes *sarama.OffsetCommitResponse) bool {
+	for _, partitionErrors := range kafkaRes.Errors {
+		for _, err := range partitionErrors {
+			if err != sarama.ErrNoError {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func isSameDecoratedOffset(r submitRequest, o DecoratedOffset) bool {
 	return r.offset == o.Offset && r.metadata == o.Metadata
 }
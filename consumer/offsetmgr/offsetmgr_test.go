@@ -381,6 +381,59 @@ func (s *OffsetMgrSuite) TestCommitDifferentGroups(c *C) {
 	c.Assert(committedOffset2, DeepEquals, DecoratedOffset{2019, "bar3"})
 }
 
+// Offsets of groups managed by the Kafka group coordinator are committed with
+// the generation and the member ID set for the group, and offsets of other
+// groups are committed with the undefined generation.
+func (s *OffsetMgrSuite) TestCommitGeneration(c *C) {
+	// Given
+	broker1 := sarama.NewMockBroker(c, 101)
+	defer broker1.Close()
+
+	broker1.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(c).
+			SetBroker(broker1.Addr(), broker1.BrokerID()),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(c).
+			SetCoordinator("g1", broker1).
+			SetCoordinator("g2", broker1),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(c).
+			SetOffset("g1", "t1", 7, 1000, "foo", sarama.ErrNoError).
+			SetOffset("g2", "t1", 7, 2000, "bar", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(c).
+			SetError("g1", "t1", 7, sarama.ErrNoError).
+			SetError("g2", "t1", 7, sarama.ErrNoError),
+	})
+
+	cfg := testhelpers.NewTestConfig("c1")
+	cfg.Consumer.OffsetsCommitInterval = 50 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker1.Addr()}, nil)
+	c.Assert(err, IsNil)
+	f := SpawnFactory(s.ns.NewChild(), cfg, client)
+	defer f.Stop()
+	f.SetGeneration("g1", 3, "m-1")
+	om1, err := f.SpawnOffsetManager(s.ns.NewChild("g1", "t1", 7), "g1", "t1", 7)
+	c.Assert(err, IsNil)
+	om2, err := f.SpawnOffsetManager(s.ns.NewChild("g2", "t1", 7), "g2", "t1", 7)
+	c.Assert(err, IsNil)
+
+	// When
+	om1.SubmitOffset(1001, "foo1")
+	om2.SubmitOffset(2001, "bar1")
+	om1.Stop()
+	om2.Stop()
+
+	// Then
+	var generations = make(map[string]int32)
+	var memberIDs = make(map[string]string)
+	for _, rr := range broker1.History() {
+		if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			generations[req.ConsumerGroup] = req.ConsumerGroupGeneration
+			memberIDs[req.ConsumerGroup] = req.ConsumerID
+		}
+	}
+	c.Assert(generations, DeepEquals, map[string]int32{"g1": 3, "g2": sarama.GroupGenerationUndefined})
+	c.Assert(memberIDs, DeepEquals, map[string]string{"g1": "m-1", "g2": ""})
+}
+
 func (s *OffsetMgrSuite) TestCommitNetworkError(c *C) {
 	// Given
 	broker1 := sarama.NewMockBroker(c, 101)
@@ -12,6 +12,7 @@ import (
 	"github.com/mailgun/kafka-pixy/none"
 	"github.com/mailgun/log"
 	"sync"
+	"time"
 )
 
 var (
@@ -23,26 +24,43 @@ var (
 // exclusiveConsumer ensures exclusive consumption of messages from a topic
 // partition within a particular group. It ensures that a partition is consumed
 // exclusively by first claiming the partition in ZooKeeper. When a fetched
-// message is pulled from the `messages()` channel, it is considered to be
-// consumed and its offset is committed.
+// message is pulled from the `messages()` channel, it becomes pending and
+// stays so until it is acknowledged via the `Registry`. If a pending message
+// is not acknowledged within `Config.Consumer.AckTimeout` then it is offered
+// again. The committed offset never moves past the oldest pending message.
+//
+// Messages of a retry topic are unwrapped from their retry envelopes, and
+// are not offered until their due time comes. Since all messages of a retry
+// topic are delayed by the same period, fetching is paused while a message is
+// waiting for its due time.
+//
+// While consumption of the group/topic is paused via the `Registry`, the
+// partition stays claimed, but neither messages are offered nor fetched.
 type T struct {
 	actorID          *actor.ID
 	cfg              *config.T
 	group            string
 	topic            string
 	partition        int32
-	groupMember      *groupmember.T
+	groupMember      groupmember.T
 	msgStreamFactory msgstream.Factory
 	offsetMgrFactory offsetmgr.Factory
+	registry         *Registry
 	messagesCh       chan *consumer.Message
 	acksCh           chan *consumer.Message
+	explicitAcksCh   chan ackRq
+	pendingRqCh      chan pendingRq
+	seekCh           chan seekRq
+	pausedCh         chan none.T
 	stopCh           chan none.T
+	doneCh           chan none.T
 	wg               sync.WaitGroup
 }
 
 // Spawn creates a partition consumer instance and starts its goroutines.
 func Spawn(namespace *actor.ID, group, topic string, partition int32, cfg *config.T,
-	groupMember *groupmember.T, msgStreamFactory msgstream.Factory, offsetMgrFactory offsetmgr.Factory,
+	groupMember groupmember.T, msgStreamFactory msgstream.Factory, offsetMgrFactory offsetmgr.Factory,
+	registry *Registry,
 ) *T {
 	pc := &T{
 		actorID:          namespace.NewChild(fmt.Sprintf("P:%s_%d", topic, partition)),
@@ -53,9 +71,15 @@ func Spawn(namespace *actor.ID, group, topic string, partition int32, cfg *confi
 		groupMember:      groupMember,
 		msgStreamFactory: msgStreamFactory,
 		offsetMgrFactory: offsetMgrFactory,
+		registry:         registry,
 		messagesCh:       make(chan *consumer.Message),
 		acksCh:           make(chan *consumer.Message),
+		explicitAcksCh:   make(chan ackRq),
+		pendingRqCh:      make(chan pendingRq),
+		seekCh:           make(chan seekRq),
+		pausedCh:         make(chan none.T, 1),
 		stopCh:           make(chan none.T),
+		doneCh:           make(chan none.T),
 	}
 	actor.Spawn(pc.actorID, &pc.wg, pc.run)
 	return pc
@@ -77,6 +101,7 @@ func (pc *T) Acks() chan<- *consumer.Message {
 }
 
 func (pc *T) run() {
+	defer close(pc.doneCh)
 	defer pc.groupMember.ClaimPartition(pc.actorID, pc.topic, pc.partition, pc.stopCh)()
 
 	om, err := pc.offsetMgrFactory.SpawnOffsetManager(pc.actorID, pc.group, pc.topic, pc.partition)
@@ -99,67 +124,261 @@ func (pc *T) run() {
 		return
 	}
 
-	ms, concreteOffset, err := pc.msgStreamFactory.SpawnMessageStream(pc.actorID, pc.topic, pc.partition, initialOffset.Offset)
-	if err != nil {
-		// Must never happen.
-		log.Errorf("<%s> failed to start message stream: offset=%d, err=(%s)", pc.actorID, initialOffset.Offset, err)
-		return
-	}
-	defer ms.Stop()
-	if initialOffset.Offset != concreteOffset {
-		log.Errorf("<%s> invalid initial offset: stored=%d, adjusted=%d",
-			pc.actorID, initialOffset.Offset, concreteOffset)
+	// If the group has no offset committed for the partition yet, then the
+	// auto offset reset policy defines where to start from.
+	startOffset := initialOffset.Offset
+	var noOffsetErr error
+	if initialOffset.Offset == sarama.OffsetNewest {
+		startOffset, noOffsetErr = pc.resetOffset(initialOffset.Offset)
 	}
-	log.Infof("<%s> initialized: offset=%d", pc.actorID, concreteOffset)
+	var (
+		ms             msgstream.T
+		concreteOffset = initialOffset.Offset
+	)
+	// The message stream is replaced on seek and offset reset, so the
+	// current one is stopped.
+	defer func() {
+		if ms != nil {
+			ms.Stop()
+		}
+	}()
+	if noOffsetErr == nil {
+		ms, concreteOffset, err = pc.msgStreamFactory.SpawnMessageStream(pc.actorID, pc.topic, pc.partition, startOffset)
+		if err != nil {
+			// Must never happen.
+			log.Errorf("<%s> failed to start message stream: offset=%d, err=(%s)", pc.actorID, startOffset, err)
+			return
+		}
+		if initialOffset.Offset != sarama.OffsetNewest && initialOffset.Offset != concreteOffset {
+			log.Errorf("<%s> invalid initial offset: stored=%d, adjusted=%d",
+				pc.actorID, initialOffset.Offset, concreteOffset)
+		}
+		log.Infof("<%s> initialized: offset=%d", pc.actorID, concreteOffset)
 
-	// Initialize the Kafka offset storage for a group on first consumption.
-	if initialOffset.Offset == sarama.OffsetNewest {
-		om.SubmitOffset(concreteOffset, "")
+		// Initialize the Kafka offset storage for a group on first consumption.
+		if initialOffset.Offset == sarama.OffsetNewest {
+			om.SubmitOffset(concreteOffset, "")
+		}
+	} else {
+		log.Errorf("<%s> consumption stopped: err=(%s)", pc.actorID, noOffsetErr)
 	}
 	lastSubmittedOffset := concreteOffset
 	lastCommittedOffset := concreteOffset
 
-	firstMessageFetched := false
+	pc.registry.add(pc)
+	pc.registry.setNoOffsetError(pc, noOffsetErr)
+	paused := pc.registry.partitionPaused(pc)
+	var (
+		// Messages that have been delivered but not acknowledged yet, sorted
+		// in ascending order of offsets.
+		pending []pendingMsg
+		// A message that is being offered to the multiplexer. It is either
+		// a newly fetched message or a pending message to be redelivered.
+		offered      *consumer.Message
+		offeredAcked bool
+		// A message fetched from a retry topic that is waiting for its due
+		// time to be offered.
+		delayed        *consumer.Message
+		delayedDueTime time.Time
+		// The offset following the last delivered message.
+		nextOffset          = concreteOffset
+		nilOrMsgStreamCh    <-chan *consumer.Message
+		firstMessageFetched = false
+	)
+	if ms != nil {
+		ms.SetPaused(paused)
+		nilOrMsgStreamCh = ms.Messages()
+	}
+	// restartMsgStream replaces the message stream with one that starts
+	// from the specified offset, and drops all pending messages.
+	restartMsgStream := func(offset int64) (int64, error) {
+		if ms != nil {
+			ms.Stop()
+			ms = nil
+		}
+		newMS, newOffset, err := pc.msgStreamFactory.SpawnMessageStream(pc.actorID, pc.topic, pc.partition, offset)
+		if err != nil {
+			return 0, err
+		}
+		ms = newMS
+		ms.SetPaused(paused)
+		nilOrMsgStreamCh = ms.Messages()
+		pending = nil
+		offered, offeredAcked = nil, false
+		delayed = nil
+		nextOffset = newOffset
+		return newOffset, nil
+	}
+	_, isRetryTopic := consumer.RetryTopicOrigin(pc.group, pc.topic)
+	// A single timer wakes the loop up when either a pending message should
+	// be redelivered, or a delayed message is due.
+	wakeUpTimer := time.NewTimer(0)
+	stopTimer(wakeUpTimer)
+	defer wakeUpTimer.Stop()
+	var wakeUpAt time.Time
 	for {
-		var msg *consumer.Message
-		// Wait for a fetched message to to provided by the controlled
-		// partition consumer.
-		for {
-			select {
-			case msg = <-ms.Messages():
-				// Notify tests when the very first message is fetched.
-				if !firstMessageFetched && FirstMessageFetchedCh != nil {
-					firstMessageFetched = true
-					FirstMessageFetchedCh <- pc
+		var (
+			nilOrMessagesCh chan<- *consumer.Message
+			nilOrFetchedCh  <-chan *consumer.Message
+			nilOrWakeUpCh   <-chan time.Time
+			nextWakeUpAt    time.Time
+		)
+		now := time.Now().UTC()
+		if offered == nil {
+			offered = firstExpired(pending, now)
+		}
+		if offered == nil && delayed != nil && !delayedDueTime.After(now) {
+			offered, offeredAcked = delayed, false
+			delayed = nil
+		}
+		if offered != nil {
+			if !paused {
+				nilOrMessagesCh = pc.messagesCh
+			}
+		} else {
+			if delayed != nil {
+				nextWakeUpAt = delayedDueTime
+			} else if !paused && len(pending) < pc.cfg.Consumer.MaxPendingMessages {
+				nilOrFetchedCh = nilOrMsgStreamCh
+			}
+			if len(pending) > 0 {
+				if deadline := earliestDeadline(pending); nextWakeUpAt.IsZero() || deadline.Before(nextWakeUpAt) {
+					nextWakeUpAt = deadline
 				}
-				goto offerAndAck
-			case committedOffset := <-om.CommittedOffsets():
-				lastCommittedOffset = committedOffset.Offset
-				continue
-			case <-pc.stopCh:
-				goto done
 			}
 		}
-	offerAndAck:
-		// Offer the fetched message to the upstream consumer and wait for it
-		// to be acknowledged.
-		for {
-			select {
-			case pc.messagesCh <- msg:
-			// Keep offering the same message until it is acknowledged.
-			case <-pc.acksCh:
-				lastSubmittedOffset = msg.Offset + 1
-				om.SubmitOffset(lastSubmittedOffset, "")
-				break offerAndAck
-			case committedOffset := <-om.CommittedOffsets():
-				lastCommittedOffset = committedOffset.Offset
+		if !nextWakeUpAt.Equal(wakeUpAt) {
+			stopTimer(wakeUpTimer)
+			if !nextWakeUpAt.IsZero() {
+				wakeUpTimer.Reset(nextWakeUpAt.Sub(now))
+			}
+			wakeUpAt = nextWakeUpAt
+		}
+		if !wakeUpAt.IsZero() {
+			nilOrWakeUpCh = wakeUpTimer.C
+		}
+		select {
+		case msg, ok := <-nilOrFetchedCh:
+			if !ok {
+				nilOrMsgStreamCh = nil
+				if ms.Err() != sarama.ErrOffsetOutOfRange {
+					log.Errorf("<%s> message stream closed", pc.actorID)
+					continue
+				}
+				// The offset to fetch has either been deleted by retention, or
+				// is beyond the end of the partition, therefore so are all
+				// pending messages, and they are dropped.
+				offset, err := pc.resetOffset(nextOffset)
+				if err != nil {
+					log.Errorf("<%s> consumption stopped: err=(%s)", pc.actorID, err)
+					ms.Stop()
+					ms = nil
+					pending = nil
+					offered, offeredAcked = nil, false
+					pc.registry.setNoOffsetError(pc, err)
+					continue
+				}
+				if offset, err = restartMsgStream(offset); err != nil {
+					// Must never happen.
+					log.Errorf("<%s> failed to restart message stream: err=(%s)", pc.actorID, err)
+					goto done
+				}
+				log.Infof("<%s> offset out of range reset: offset=%d", pc.actorID, offset)
+				break
+			}
+			// Notify tests when the very first message is fetched.
+			if !firstMessageFetched && FirstMessageFetchedCh != nil {
+				firstMessageFetched = true
+				FirstMessageFetchedCh <- pc
+			}
+			if isRetryTopic {
+				var dueTime time.Time
+				msg, dueTime = unwrapRetry(msg)
+				if dueTime.After(time.Now().UTC()) {
+					delayed, delayedDueTime = msg, dueTime
+					continue
+				}
+			}
+			offered, offeredAcked = msg, false
+		// Keep offering the same message until its delivery is confirmed.
+		case nilOrMessagesCh <- offered:
+		case msg := <-pc.acksCh:
+			if msg != offered {
+				// The message was taken by the multiplexer before a seek.
 				continue
-			case <-pc.stopCh:
+			}
+			if !offeredAcked {
+				deadline := time.Now().UTC().Add(pc.cfg.Consumer.AckTimeout)
+				pending = addPending(pending, msg, deadline)
+			}
+			if msg.Offset >= nextOffset {
+				nextOffset = msg.Offset + 1
+			}
+			offered, offeredAcked = nil, false
+		case rq := <-pc.explicitAcksCh:
+			// It is possible that an acknowledgement comes before the
+			// multiplexer confirms delivery of the offered message.
+			isOffered := func(offset int64) bool {
+				return offered != nil && offered.Offset == offset
+			}
+			if offset, ok := firstUnknown(pending, rq.offsets, isOffered); !ok {
+				rq.replyCh <- consumer.ErrInvalidAck{Err: fmt.Errorf("message is not pending: group=%s, topic=%s, partition=%d, offset=%d",
+					pc.group, pc.topic, pc.partition, offset)}
+				continue
+			}
+			for _, offset := range rq.offsets {
+				pending = removePending(pending, offset)
+				if isOffered(offset) {
+					offeredAcked = true
+				}
+			}
+			rq.replyCh <- nil
+		case <-nilOrWakeUpCh:
+			wakeUpAt = time.Time{}
+			continue
+		case <-pc.pausedCh:
+			paused = pc.registry.partitionPaused(pc)
+			if ms != nil {
+				ms.SetPaused(paused)
+			}
+			log.Infof("<%s> paused: %t", pc.actorID, paused)
+			continue
+		case rq := <-pc.pendingRqCh:
+			rq.replyCh <- findPending(pending, rq.offset)
+			continue
+		case rq := <-pc.seekCh:
+			droppedCount := len(pending)
+			seekOffset, err := restartMsgStream(rq.offset)
+			if err != nil {
+				// Must never happen.
+				log.Errorf("<%s> failed to restart message stream: offset=%d, err=(%s)", pc.actorID, rq.offset, err)
+				rq.replyCh <- seekRs{err: err}
 				goto done
 			}
+			log.Infof("<%s> seek: offset=%d, dropped=%d", pc.actorID, seekOffset, droppedCount)
+			pc.registry.setNoOffsetError(pc, nil)
+			rq.replyCh <- seekRs{offset: seekOffset}
+		case committedOffset := <-om.CommittedOffsets():
+			lastCommittedOffset = committedOffset.Offset
+			continue
+		case <-pc.stopCh:
+			goto done
+		}
+		commitOffset := nextOffset
+		if len(pending) > 0 {
+			commitOffset = pending[0].msg.Offset
+		}
+		if commitOffset != lastSubmittedOffset {
+			lastSubmittedOffset = commitOffset
+			om.SubmitOffset(lastSubmittedOffset, "")
 		}
 	}
 done:
+	pc.registry.remove(pc)
+	if len(pending) > 0 {
+		log.Infof("<%s> unacknowledged messages: count=%d, oldest=%d",
+			pc.actorID, len(pending), pending[0].msg.Offset)
+	}
 	om.Stop()
 	// Drain committed offsets.
 	for committedOffset := range om.CommittedOffsets() {
@@ -180,3 +399,382 @@ func (pc *T) Stop() {
 	close(pc.stopCh)
 	pc.wg.Wait()
 }
+
+// resetOffset returns an offset to continue consumption from according to the
+// auto offset reset policy of the group, given that the specified offset is
+// either not committed yet or out of range. If the policy is `fail`, then
+// `consumer.ErrNoValidOffset` is returned instead.
+func (pc *T) resetOffset(offset int64) (int64, error) {
+	switch pc.registry.autoOffsetReset(pc.cfg, pc.group) {
+	case config.AutoOffsetResetEarliest:
+		return sarama.OffsetOldest, nil
+	case config.AutoOffsetResetFail:
+		reason := fmt.Sprintf("offset out of range: %d", offset)
+		if offset == sarama.OffsetNewest {
+			reason = "no committed offset"
+		}
+		return 0, consumer.ErrNoValidOffset{Err: fmt.Errorf("%s: group=%s, topic=%s, partition=%d",
+			reason, pc.group, pc.topic, pc.partition)}
+	default:
+		return sarama.OffsetNewest, nil
+	}
+}
+
+// unwrapRetry returns a message consumed from a retry topic with the value
+// and the retry count taken from its retry envelope, along with the time when
+// the message is due to be consumed. Messages that are not wrapped into a
+// retry envelope are returned intact.
+func unwrapRetry(msg *consumer.Message) (*consumer.Message, time.Time) {
+	env, err := consumer.DecodeRetryEnvelope(msg.Value)
+	if err != nil {
+		return msg, time.Time{}
+	}
+	unwrapped := *msg
+	unwrapped.Value = env.Value
+	unwrapped.Retries = env.Retries
+	return &unwrapped, env.DueTime
+}
+
+type ackRq struct {
+	offsets []int64
+	replyCh chan<- error
+}
+
+type pendingRq struct {
+	offset  int64
+	replyCh chan<- *consumer.Message
+}
+
+type seekRq struct {
+	offset  int64
+	replyCh chan<- seekRs
+}
+
+type seekRs struct {
+	offset int64
+	err    error
+}
+
+// pendingMsg is a message that has been delivered but not yet acknowledged.
+type pendingMsg struct {
+	msg      *consumer.Message
+	deadline time.Time
+}
+
+// addPending inserts a delivered message into a list of pending messages
+// sorted by offset. If the message is already pending, then only its deadline
+// is updated.
+func addPending(pending []pendingMsg, msg *consumer.Message, deadline time.Time) []pendingMsg {
+	i := len(pending)
+	for i > 0 && pending[i-1].msg.Offset >= msg.Offset {
+		i--
+	}
+	if i < len(pending) && pending[i].msg.Offset == msg.Offset {
+		pending[i].deadline = deadline
+		return pending
+	}
+	pending = append(pending, pendingMsg{})
+	copy(pending[i+1:], pending[i:])
+	pending[i] = pendingMsg{msg, deadline}
+	return pending
+}
+
+// removePending removes a message with the specified offset from a list of
+// pending messages. If there is no such message, then the list is returned
+// intact.
+func removePending(pending []pendingMsg, offset int64) []pendingMsg {
+	for i := range pending {
+		if pending[i].msg.Offset == offset {
+			return append(pending[:i], pending[i+1:]...)
+		}
+	}
+	return pending
+}
+
+// findPending returns a pending message with the specified offset, or nil if
+// there is none.
+func findPending(pending []pendingMsg, offset int64) *consumer.Message {
+	for i := range pending {
+		if pending[i].msg.Offset == offset {
+			return pending[i].msg
+		}
+	}
+	return nil
+}
+
+// firstUnknown returns the first of the offsets that belongs neither to a
+// pending message nor to a message for which `isOffered` is true. False is
+// returned along with it, and true if all offsets are known.
+func firstUnknown(pending []pendingMsg, offsets []int64, isOffered func(int64) bool) (int64, bool) {
+	for _, offset := range offsets {
+		if findPending(pending, offset) == nil && !isOffered(offset) {
+			return offset, false
+		}
+	}
+	return 0, true
+}
+
+// firstExpired returns a pending message with the lowest offset among those
+// whose acknowledgement deadline has passed, or nil if there is none.
+func firstExpired(pending []pendingMsg, now time.Time) *consumer.Message {
+	for i := range pending {
+		if !pending[i].deadline.After(now) {
+			return pending[i].msg
+		}
+	}
+	return nil
+}
+
+// stopTimer stops the timer and drains its channel, so that it can be safely
+// reset.
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+func earliestDeadline(pending []pendingMsg) time.Time {
+	earliest := pending[0].deadline
+	for _, pm := range pending[1:] {
+		if pm.deadline.Before(earliest) {
+			earliest = pm.deadline
+		}
+	}
+	return earliest
+}
+
+// Registry keeps track of partition consumers running in the process, so that
+// acknowledgements can be routed to the partition consumer responsible for a
+// particular group/topic/partition. It also keeps auto offset reset policies
+// of consumer groups overridden at runtime, errors of partition consumers
+// that stopped due to the `fail` policy, and group/topics whose consumption
+// is paused.
+type Registry struct {
+	children         map[groupTopicPartition]*T
+	noOffsetErrors   map[groupTopicPartition]error
+	autoOffsetResets map[string]string
+	paused           map[groupTopic]none.T
+	childrenLock     sync.Mutex
+}
+
+type groupTopic struct {
+	group string
+	topic string
+}
+
+type groupTopicPartition struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// NewRegistry creates an empty partition consumer registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		children:         make(map[groupTopicPartition]*T),
+		noOffsetErrors:   make(map[groupTopicPartition]error),
+		autoOffsetResets: make(map[string]string),
+		paused:           make(map[groupTopic]none.T),
+	}
+}
+
+// Ack acknowledges messages with the specified offsets to the partition
+// consumer that is responsible for the group/topic/partition. All offsets are
+// applied at once, so the committed offset is updated only once. If there is
+// no such partition consumer, or any of the offsets is not pending
+// acknowledgement, then `consumer.ErrInvalidAck` is returned and none of the
+// offsets is applied.
+func (r *Registry) Ack(group, topic string, partition int32, offsets ...int64) error {
+	r.childrenLock.Lock()
+	pc := r.children[groupTopicPartition{group, topic, partition}]
+	r.childrenLock.Unlock()
+	if pc != nil {
+		replyCh := make(chan error, 1)
+		select {
+		case pc.explicitAcksCh <- ackRq{offsets, replyCh}:
+			return <-replyCh
+		case <-pc.doneCh:
+		}
+	}
+	return consumer.ErrInvalidAck{Err: fmt.Errorf("partition is not consumed by this instance: group=%s, topic=%s, partition=%d",
+		group, topic, partition)}
+}
+
+// Pending returns a message with the specified offset that has been delivered
+// by the partition consumer responsible for the group/topic/partition but not
+// acknowledged yet. If there is no such message, then `consumer.ErrInvalidAck`
+// is returned.
+func (r *Registry) Pending(group, topic string, partition int32, offset int64) (*consumer.Message, error) {
+	r.childrenLock.Lock()
+	pc := r.children[groupTopicPartition{group, topic, partition}]
+	r.childrenLock.Unlock()
+	if pc == nil {
+		return nil, consumer.ErrInvalidAck{Err: fmt.Errorf("partition is not consumed by this instance: group=%s, topic=%s, partition=%d",
+			group, topic, partition)}
+	}
+	replyCh := make(chan *consumer.Message, 1)
+	select {
+	case pc.pendingRqCh <- pendingRq{offset, replyCh}:
+		if msg := <-replyCh; msg != nil {
+			return msg, nil
+		}
+	case <-pc.doneCh:
+	}
+	return nil, consumer.ErrInvalidAck{Err: fmt.Errorf("message is not pending: group=%s, topic=%s, partition=%d, offset=%d",
+		group, topic, partition, offset)}
+}
+
+// Seek makes the partition consumer that is responsible for the
+// group/topic/partition continue consumption from the specified offset. The
+// offset is adjusted to the available range of offsets and returned. If there
+// is no such partition consumer, then `consumer.ErrNotConsumed` is returned.
+func (r *Registry) Seek(group, topic string, partition int32, offset int64) (int64, error) {
+	r.childrenLock.Lock()
+	pc := r.children[groupTopicPartition{group, topic, partition}]
+	r.childrenLock.Unlock()
+	if pc != nil {
+		replyCh := make(chan seekRs, 1)
+		select {
+		case pc.seekCh <- seekRq{offset, replyCh}:
+			rs := <-replyCh
+			return rs.offset, rs.err
+		case <-pc.doneCh:
+		}
+	}
+	return 0, consumer.ErrNotConsumed{Err: fmt.Errorf("partition is not consumed by this instance: group=%s, topic=%s, partition=%d",
+		group, topic, partition)}
+}
+
+func (r *Registry) add(pc *T) {
+	r.childrenLock.Lock()
+	r.children[groupTopicPartition{pc.group, pc.topic, pc.partition}] = pc
+	r.childrenLock.Unlock()
+}
+
+// SetAutoOffsetReset overrides the auto offset reset policy of the group for
+// all its partition consumers running in the process.
+func (r *Registry) SetAutoOffsetReset(group, policy string) {
+	r.childrenLock.Lock()
+	r.autoOffsetResets[group] = policy
+	r.childrenLock.Unlock()
+}
+
+// Pause pauses consumption of the group/topic. The topic can also be a topic
+// pattern, and pausing a topic pauses its retry topics as well.
+func (r *Registry) Pause(group, topic string) {
+	r.childrenLock.Lock()
+	r.paused[groupTopic{group, topic}] = none.V
+	r.notifyPausedLocked(group)
+	r.childrenLock.Unlock()
+}
+
+// Resume resumes consumption of the group/topic paused with `Pause`.
+func (r *Registry) Resume(group, topic string) {
+	r.childrenLock.Lock()
+	delete(r.paused, groupTopic{group, topic})
+	r.notifyPausedLocked(group)
+	r.childrenLock.Unlock()
+}
+
+// IsPaused tells whether consumption of the group/topic is paused, that is
+// if the topic, the topic its retry topic was derived from, or a topic
+// pattern matching either of them has been paused with `Pause`.
+func (r *Registry) IsPaused(group, topic string) bool {
+	r.childrenLock.Lock()
+	defer r.childrenLock.Unlock()
+	return r.pausedLocked(group, topic)
+}
+
+// NoOffsetError returns an error of a partition consumer of the group/topic
+// that has stopped consumption due to the `fail` auto offset reset policy, or
+// nil if there is none.
+func (r *Registry) NoOffsetError(group, topic string) error {
+	r.childrenLock.Lock()
+	defer r.childrenLock.Unlock()
+	for gtp, err := range r.noOffsetErrors {
+		if gtp.group == group && gtp.topic == topic {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoOffsetReset returns the auto offset reset policy to be used by
+// partition consumers of the group.
+func (r *Registry) autoOffsetReset(cfg *config.T, group string) string {
+	r.childrenLock.Lock()
+	defer r.childrenLock.Unlock()
+	if policy, ok := r.autoOffsetResets[group]; ok {
+		return policy
+	}
+	return cfg.GroupAutoOffsetReset(group)
+}
+
+// setNoOffsetError records an error of a partition consumer that has stopped
+// consumption due to the `fail` auto offset reset policy, or clears it if
+// nil is passed.
+func (r *Registry) setNoOffsetError(pc *T, err error) {
+	gtp := groupTopicPartition{pc.group, pc.topic, pc.partition}
+	r.childrenLock.Lock()
+	if err != nil {
+		r.noOffsetErrors[gtp] = err
+	} else {
+		delete(r.noOffsetErrors, gtp)
+	}
+	r.childrenLock.Unlock()
+}
+
+// partitionPaused tells whether the partition consumer should be paused.
+func (r *Registry) partitionPaused(pc *T) bool {
+	r.childrenLock.Lock()
+	defer r.childrenLock.Unlock()
+	return r.pausedLocked(pc.group, pc.topic)
+}
+
+// pausedLocked tells whether the group/topic is paused, see `IsPaused`. It
+// must be called with `childrenLock` held.
+func (r *Registry) pausedLocked(group, topic string) bool {
+	retryTopicOrigin, _ := consumer.RetryTopicOrigin(group, topic)
+	for gt := range r.paused {
+		if gt.group != group {
+			continue
+		}
+		if gt.topic == topic || gt.topic == retryTopicOrigin {
+			return true
+		}
+		if consumer.IsTopicPattern(gt.topic) && !consumer.IsTopicPattern(topic) {
+			tm, err := consumer.CompileTopicPattern(gt.topic)
+			if err == nil && (tm.Match(topic) || retryTopicOrigin != "" && tm.Match(retryTopicOrigin)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notifyPausedLocked makes all partition consumers of the group reevaluate
+// whether they should be paused. It must be called with `childrenLock` held.
+func (r *Registry) notifyPausedLocked(group string) {
+	for gtp, pc := range r.children {
+		if gtp.group != group {
+			continue
+		}
+		select {
+		case pc.pausedCh <- none.V:
+		default:
+		}
+	}
+}
+
+func (r *Registry) remove(pc *T) {
+	gtp := groupTopicPartition{pc.group, pc.topic, pc.partition}
+	r.childrenLock.Lock()
+	if r.children[gtp] == pc {
+		delete(r.children, gtp)
+		delete(r.noOffsetErrors, gtp)
+	}
+	r.childrenLock.Unlock()
+}
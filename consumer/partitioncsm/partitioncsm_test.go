@@ -0,0 +1,456 @@
+package partitioncsm
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/msgstream"
+	"github.com/mailgun/kafka-pixy/consumer/offsetmgr"
+	"github.com/mailgun/kafka-pixy/none"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PartitionCsmSuite struct {
+	ns  *actor.ID
+	cfg *config.T
+}
+
+var _ = Suite(&PartitionCsmSuite{})
+
+func (s *PartitionCsmSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+	s.cfg = config.Default()
+}
+
+// Pending messages are kept sorted by offset regardless of the order they are
+// delivered in, and redelivery of a pending message only updates its deadline.
+func (s *PartitionCsmSuite) TestAddPending(c *C) {
+	t0 := time.Now().UTC()
+	var pending []pendingMsg
+
+	pending = addPending(pending, msg(3), t0)
+	pending = addPending(pending, msg(5), t0)
+	pending = addPending(pending, msg(1), t0)
+	pending = addPending(pending, msg(4), t0)
+	pending = addPending(pending, msg(3), t0.Add(time.Second))
+
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{1, 3, 4, 5})
+	c.Assert(pending[1].deadline, Equals, t0.Add(time.Second))
+}
+
+func (s *PartitionCsmSuite) TestRemovePending(c *C) {
+	t0 := time.Now().UTC()
+	var pending []pendingMsg
+	for _, offset := range []int64{1, 3, 4, 5} {
+		pending = addPending(pending, msg(offset), t0)
+	}
+
+	pending = removePending(pending, 4)
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{1, 3, 5})
+	pending = removePending(pending, 2)
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{1, 3, 5})
+	pending = removePending(pending, 1)
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{3, 5})
+	pending = removePending(pending, 5)
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{3})
+	pending = removePending(pending, 3)
+	c.Assert(offsetsOf(pending), DeepEquals, []int64{})
+}
+
+// The expired message with the lowest offset is redelivered first.
+func (s *PartitionCsmSuite) TestFirstExpired(c *C) {
+	t0 := time.Now().UTC()
+	var pending []pendingMsg
+	pending = addPending(pending, msg(1), t0.Add(3*time.Second))
+	pending = addPending(pending, msg(2), t0.Add(2*time.Second))
+	pending = addPending(pending, msg(3), t0.Add(1*time.Second))
+
+	c.Assert(firstExpired(pending, t0), IsNil)
+	c.Assert(firstExpired(pending, t0.Add(1*time.Second)).Offset, Equals, int64(3))
+	c.Assert(firstExpired(pending, t0.Add(2*time.Second)).Offset, Equals, int64(2))
+	c.Assert(firstExpired(pending, t0.Add(5*time.Second)).Offset, Equals, int64(1))
+	c.Assert(earliestDeadline(pending), Equals, t0.Add(1*time.Second))
+}
+
+// A seek for a partition that has no partition consumer in the registry is
+// rejected.
+func (s *PartitionCsmSuite) TestSeekNotConsumed(c *C) {
+	r := NewRegistry()
+
+	// When
+	_, err := r.Seek("g1", "t1", 3, 1000)
+
+	// Then
+	c.Assert(err, FitsTypeOf, consumer.ErrNotConsumed{})
+	c.Assert(err.Error(), Equals, "partition is not consumed by this instance: group=g1, topic=t1, partition=3")
+}
+
+// Messages of retry topics are unwrapped from retry envelopes, and other
+// messages are left intact.
+func (s *PartitionCsmSuite) TestUnwrapRetry(c *C) {
+	dueTime := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	wrapped := &consumer.Message{
+		Key:    []byte("k"),
+		Value:  consumer.EncodeRetryEnvelope(consumer.RetryEnvelope{Group: "g1", Topic: "t1", Retries: 2, DueTime: dueTime, Value: []byte("v")}),
+		Topic:  "g1.t1.retry.1m",
+		Offset: 7,
+	}
+	raw := &consumer.Message{Value: []byte("v"), Topic: "g1.t1.retry.1m", Offset: 8}
+
+	// When
+	unwrapped, unwrappedDueTime := unwrapRetry(wrapped)
+	intact, intactDueTime := unwrapRetry(raw)
+
+	// Then
+	c.Assert(unwrapped, DeepEquals, &consumer.Message{
+		Key:     []byte("k"),
+		Value:   []byte("v"),
+		Topic:   "g1.t1.retry.1m",
+		Offset:  7,
+		Retries: 2,
+	})
+	c.Assert(unwrappedDueTime.Equal(dueTime), Equals, true)
+	c.Assert(intact, Equals, raw)
+	c.Assert(intactDueTime.IsZero(), Equals, true)
+}
+
+// A negative acknowledgement is only accepted for a pending message of a
+// partition consumed by the instance.
+func (s *PartitionCsmSuite) TestPendingNotConsumed(c *C) {
+	r := NewRegistry()
+
+	// When
+	_, err := r.Pending("g1", "t1", 3, 1000)
+
+	// Then
+	c.Assert(err.Error(), Equals, "partition is not consumed by this instance: group=g1, topic=t1, partition=3")
+	var pending []pendingMsg
+	pending = addPending(pending, msg(5), time.Now().UTC())
+	c.Assert(findPending(pending, 5), Equals, pending[0].msg)
+	c.Assert(findPending(pending, 6), IsNil)
+}
+
+// A partition consumer is paused if its topic, the origin of its retry topic,
+// or a topic pattern matching either of them is paused for its group.
+func (s *PartitionCsmSuite) TestPartitionPaused(c *C) {
+	r := NewRegistry()
+
+	// When
+	r.Pause("g1", "t1")
+	r.Pause("g1", consumer.TopicPattern("p.*"))
+
+	// Then
+	c.Assert(r.IsPaused("g1", "t1"), Equals, true)
+	c.Assert(r.IsPaused("g2", "t1"), Equals, false)
+	c.Assert(r.IsPaused("g1", "p1"), Equals, true)
+	c.Assert(r.IsPaused("g1", "g1.t1.retry.1m"), Equals, true)
+	c.Assert(r.IsPaused("g1", "t2"), Equals, false)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "t1"}), Equals, true)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "g1.t1.retry.1m"}), Equals, true)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "g1.p1.retry.1m"}), Equals, true)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "g2.t1.retry.1m"}), Equals, false)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "p1"}), Equals, true)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "t2"}), Equals, false)
+	c.Assert(r.partitionPaused(&T{group: "g2", topic: "t1"}), Equals, false)
+
+	// When
+	r.Resume("g1", "t1")
+
+	// Then
+	c.Assert(r.IsPaused("g1", "t1"), Equals, false)
+	c.Assert(r.IsPaused("g1", "p1"), Equals, true)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "t1"}), Equals, false)
+	c.Assert(r.partitionPaused(&T{group: "g1", topic: "p1"}), Equals, true)
+}
+
+// An acknowledgement is rejected as a whole if any of its offsets does not
+// belong to a pending message.
+func (s *PartitionCsmSuite) TestAckNotPending(c *C) {
+	msf := newMockMsgStreamFactory(10, 12)
+	r := NewRegistry()
+	pc := s.spawn(r, 10, msf)
+	defer pc.Stop()
+	delivered := deliver(c, pc)
+
+	// When
+	err := r.Ack("g1", "t1", 0, delivered.Offset, 100)
+
+	// Then
+	c.Assert(err, FitsTypeOf, consumer.ErrInvalidAck{})
+	c.Assert(err.Error(), Equals, "message is not pending: group=g1, topic=t1, partition=0, offset=100")
+	pendingMsg, err := r.Pending("g1", "t1", 0, delivered.Offset)
+	c.Assert(err, IsNil)
+	c.Assert(pendingMsg, Equals, delivered)
+
+	// When
+	err = r.Ack("g1", "t1", 0, delivered.Offset)
+
+	// Then
+	c.Assert(err, IsNil)
+	_, err = r.Pending("g1", "t1", 0, delivered.Offset)
+	c.Assert(err, FitsTypeOf, consumer.ErrInvalidAck{})
+}
+
+// If the group has no offset committed for the partition, then consumption
+// starts according to the auto offset reset policy of the group: from the
+// oldest message, from the newest message, or not at all.
+func (s *PartitionCsmSuite) TestNoCommittedOffset(c *C) {
+	for i, tc := range []struct {
+		policy      string
+		spawned     []int64
+		noOffsetErr string
+	}{
+		{config.AutoOffsetResetEarliest, []int64{10}, ""},
+		{config.AutoOffsetResetLatest, []int64{15}, ""},
+		{config.AutoOffsetResetFail, nil, "no committed offset: group=g1, topic=t1, partition=0"},
+	} {
+		msf := newMockMsgStreamFactory(10, 15)
+		r := NewRegistry()
+		s.cfg.Consumer.AutoOffsetReset = tc.policy
+
+		// When
+		pc := s.spawn(r, sarama.OffsetNewest, msf)
+
+		// Then
+		if tc.noOffsetErr != "" {
+			err := waitForNoOffsetError(c, r)
+			c.Assert(err, FitsTypeOf, consumer.ErrNoValidOffset{}, Commentf("case #%d", i))
+			c.Assert(err.Error(), Equals, tc.noOffsetErr, Commentf("case #%d", i))
+		} else {
+			waitForSpawned(c, msf, len(tc.spawned))
+			c.Assert(r.NoOffsetError("g1", "t1"), IsNil, Commentf("case #%d", i))
+		}
+		c.Assert(msf.spawnedAt(), DeepEquals, tc.spawned, Commentf("case #%d", i))
+		pc.Stop()
+	}
+}
+
+// If the offset to fetch is out of the range available in Kafka, then
+// consumption continues according to the auto offset reset policy of the
+// group, that can be overridden in the registry.
+func (s *PartitionCsmSuite) TestOffsetOutOfRange(c *C) {
+	for i, tc := range []struct {
+		policy      string
+		spawned     []int64
+		noOffsetErr string
+	}{
+		{config.AutoOffsetResetEarliest, []int64{12, 10}, ""},
+		{config.AutoOffsetResetLatest, []int64{12, 15}, ""},
+		{config.AutoOffsetResetFail, []int64{12}, "offset out of range: 12: group=g1, topic=t1, partition=0"},
+	} {
+		msf := newMockMsgStreamFactory(10, 15)
+		msf.outOfRange = true
+		r := NewRegistry()
+		r.SetAutoOffsetReset("g1", tc.policy)
+
+		// When
+		pc := s.spawn(r, 12, msf)
+
+		// Then
+		if tc.noOffsetErr != "" {
+			err := waitForNoOffsetError(c, r)
+			c.Assert(err, FitsTypeOf, consumer.ErrNoValidOffset{}, Commentf("case #%d", i))
+			c.Assert(err.Error(), Equals, tc.noOffsetErr, Commentf("case #%d", i))
+		} else {
+			waitForSpawned(c, msf, len(tc.spawned))
+			c.Assert(r.NoOffsetError("g1", "t1"), IsNil, Commentf("case #%d", i))
+		}
+		c.Assert(msf.spawnedAt(), DeepEquals, tc.spawned, Commentf("case #%d", i))
+		pc.Stop()
+	}
+}
+
+// When consumption is stopped by the `fail` policy, a seek to a valid offset
+// resumes it and clears the error.
+func (s *PartitionCsmSuite) TestSeekAfterFail(c *C) {
+	msf := newMockMsgStreamFactory(10, 15)
+	r := NewRegistry()
+	s.cfg.Consumer.AutoOffsetReset = config.AutoOffsetResetFail
+	pc := s.spawn(r, sarama.OffsetNewest, msf)
+	defer pc.Stop()
+	waitForNoOffsetError(c, r)
+
+	// When
+	offset, err := r.Seek("g1", "t1", 0, 13)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(offset, Equals, int64(13))
+	c.Assert(r.NoOffsetError("g1", "t1"), IsNil)
+	c.Assert(deliver(c, pc).Offset, Equals, int64(13))
+}
+
+// spawn starts a partition consumer of partition 0 of topic `t1` on behalf
+// of group `g1`, with the specified initial committed offset.
+func (s *PartitionCsmSuite) spawn(r *Registry, initialOffset int64, msf *mockMsgStreamFactory) *T {
+	return Spawn(s.ns, "g1", "t1", 0, s.cfg, mockGroupMember{}, msf, newMockOffsetMgrFactory(initialOffset), r)
+}
+
+// deliver takes a message offered by the partition consumer and confirms its
+// delivery the same way the multiplexer does.
+func deliver(c *C, pc *T) *consumer.Message {
+	select {
+	case msg := <-pc.Messages():
+		pc.Acks() <- msg
+		return msg
+	case <-time.After(3 * time.Second):
+		c.Fatal("message is not offered")
+		return nil
+	}
+}
+
+// waitForNoOffsetError waits for a partition consumer of `g1`/`t1` to stop
+// consumption due to the `fail` auto offset reset policy.
+func waitForNoOffsetError(c *C, r *Registry) error {
+	for i := 0; i < 300; i++ {
+		if err := r.NoOffsetError("g1", "t1"); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("consumption is not stopped")
+	return nil
+}
+
+// waitForSpawned waits for the specified number of message streams to be
+// spawned by the factory.
+func waitForSpawned(c *C, msf *mockMsgStreamFactory, count int) {
+	for i := 0; i < 300; i++ {
+		if len(msf.spawnedAt()) >= count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("message streams are not spawned: want=%d, got=%d", count, len(msf.spawnedAt()))
+}
+
+func msg(offset int64) *consumer.Message {
+	return &consumer.Message{Offset: offset}
+}
+
+func offsetsOf(pending []pendingMsg) []int64 {
+	offsets := make([]int64, len(pending))
+	for i, pm := range pending {
+		offsets[i] = pm.msg.Offset
+	}
+	return offsets
+}
+
+// mockGroupMember grants all partition claims right away.
+type mockGroupMember struct{}
+
+func (m mockGroupMember) Topics() chan<- []string                   { return nil }
+func (m mockGroupMember) Subscriptions() <-chan map[string][]string { return nil }
+func (m mockGroupMember) Stop()                                     {}
+
+func (m mockGroupMember) ClaimPartition(claimerActorID *actor.ID, topic string, partition int32, cancelCh <-chan none.T) func() {
+	return func() {}
+}
+
+// mockMsgStreamFactory spawns message streams of a partition that has
+// messages with offsets in the range [oldest, newest). A spawned stream
+// yields all messages from its concrete offset to the end of the range. If
+// `outOfRange` is set, then the next spawned stream yields no messages and
+// gives up with `sarama.ErrOffsetOutOfRange` instead.
+type mockMsgStreamFactory struct {
+	oldest     int64
+	newest     int64
+	outOfRange bool
+	spawned    []int64
+	mu         sync.Mutex
+}
+
+func newMockMsgStreamFactory(oldest, newest int64) *mockMsgStreamFactory {
+	return &mockMsgStreamFactory{oldest: oldest, newest: newest}
+}
+
+func (f *mockMsgStreamFactory) SpawnMessageStream(namespace *actor.ID, topic string, partition int32, offset int64) (msgstream.T, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case offset == sarama.OffsetNewest || offset > f.newest:
+		offset = f.newest
+	case offset == sarama.OffsetOldest || offset < f.oldest:
+		offset = f.oldest
+	}
+	f.spawned = append(f.spawned, offset)
+	ms := &mockMsgStream{messagesCh: make(chan *consumer.Message, f.newest-f.oldest+1)}
+	if f.outOfRange {
+		f.outOfRange = false
+		ms.err = sarama.ErrOffsetOutOfRange
+	} else {
+		for o := offset; o < f.newest; o++ {
+			ms.messagesCh <- &consumer.Message{Topic: topic, Partition: partition, Offset: o}
+		}
+	}
+	close(ms.messagesCh)
+	return ms, offset, nil
+}
+
+func (f *mockMsgStreamFactory) Stop() {}
+
+// spawnedAt returns concrete offsets of all spawned message streams.
+func (f *mockMsgStreamFactory) spawnedAt() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.spawned...)
+}
+
+type mockMsgStream struct {
+	messagesCh chan *consumer.Message
+	err        error
+}
+
+func (ms *mockMsgStream) Messages() <-chan *consumer.Message { return ms.messagesCh }
+func (ms *mockMsgStream) Errors() <-chan *msgstream.Err      { return nil }
+func (ms *mockMsgStream) Err() error                         { return ms.err }
+func (ms *mockMsgStream) SetPaused(paused bool)              {}
+func (ms *mockMsgStream) Stop()                              {}
+
+// mockOffsetMgrFactory spawns offset managers that report the specified
+// initial offset, and commit submitted offsets right away.
+type mockOffsetMgrFactory struct {
+	initialOffset int64
+}
+
+func newMockOffsetMgrFactory(initialOffset int64) *mockOffsetMgrFactory {
+	return &mockOffsetMgrFactory{initialOffset: initialOffset}
+}
+
+func (f *mockOffsetMgrFactory) SpawnOffsetManager(namespace *actor.ID, group, topic string, partition int32) (offsetmgr.T, error) {
+	om := &mockOffsetMgr{
+		initialOffsetCh:    make(chan offsetmgr.DecoratedOffset, 1),
+		committedOffsetsCh: make(chan offsetmgr.DecoratedOffset, 100),
+	}
+	om.initialOffsetCh <- offsetmgr.DecoratedOffset{Offset: f.initialOffset}
+	close(om.initialOffsetCh)
+	return om, nil
+}
+
+func (f *mockOffsetMgrFactory) SetGeneration(group string, generationID int32, memberID string) {}
+func (f *mockOffsetMgrFactory) Stop()                                                           {}
+
+type mockOffsetMgr struct {
+	initialOffsetCh    chan offsetmgr.DecoratedOffset
+	committedOffsetsCh chan offsetmgr.DecoratedOffset
+}
+
+func (om *mockOffsetMgr) InitialOffset() <-chan offsetmgr.DecoratedOffset { return om.initialOffsetCh }
+func (om *mockOffsetMgr) CommittedOffsets() <-chan offsetmgr.DecoratedOffset {
+	return om.committedOffsetsCh
+}
+func (om *mockOffsetMgr) Errors() <-chan *offsetmgr.OffsetCommitError { return nil }
+func (om *mockOffsetMgr) Stop()                                       { close(om.committedOffsetsCh) }
+
+func (om *mockOffsetMgr) SubmitOffset(offset int64, metadata string) {
+	om.committedOffsetsCh <- offsetmgr.DecoratedOffset{Offset: offset, Metadata: metadata}
+}
@@ -0,0 +1,87 @@
+package consumer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Characters that are not allowed in Kafka topic names are used to tell
+	// topic patterns from topic names.
+	includePatternPrefix = "~"
+	excludePatternPrefix = "!"
+
+	// Internal topics never match topic patterns.
+	offsetsTopic = "__consumer_offsets"
+)
+
+// TopicPattern returns a topic pattern that can be passed to the `Consume*`
+// methods of `T` in place of a topic name to consume messages from all topics
+// that entirely match the specified regular expression. Consumed messages
+// report the topic they were consumed from, and that topic should be used to
+// acknowledge them.
+func TopicPattern(regex string) string {
+	return includePatternPrefix + regex
+}
+
+// ExcludeTopicPattern returns a topic pattern that matches all topics except
+// those that entirely match the specified regular expression. It is only used
+// to represent subscriptions of group members registered with the
+// `black_list` pattern by Java clients.
+func ExcludeTopicPattern(regex string) string {
+	return excludePatternPrefix + regex
+}
+
+// IsTopicPattern tells whether the specified string is a topic pattern
+// rather than a topic name.
+func IsTopicPattern(topic string) bool {
+	return strings.HasPrefix(topic, includePatternPrefix) || strings.HasPrefix(topic, excludePatternPrefix)
+}
+
+// TopicMatcher tells whether topics match a topic pattern.
+type TopicMatcher struct {
+	regexp  *regexp.Regexp
+	exclude bool
+}
+
+// CompileTopicPattern parses a topic pattern created by either `TopicPattern`
+// or `ExcludeTopicPattern` and returns a matcher for it.
+func CompileTopicPattern(pattern string) (*TopicMatcher, error) {
+	var regex string
+	var exclude bool
+	switch {
+	case strings.HasPrefix(pattern, includePatternPrefix):
+		regex = pattern[len(includePatternPrefix):]
+	case strings.HasPrefix(pattern, excludePatternPrefix):
+		regex = pattern[len(excludePatternPrefix):]
+		exclude = true
+	default:
+		return nil, fmt.Errorf("not a topic pattern: %s", pattern)
+	}
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &TopicMatcher{regexp: re, exclude: exclude}, nil
+}
+
+// Match returns true if the specified topic matches the pattern.
+func (tm *TopicMatcher) Match(topic string) bool {
+	if topic == offsetsTopic {
+		return false
+	}
+	return tm.regexp.MatchString(topic) != tm.exclude
+}
+
+// MatchTopics returns topics from the specified list that match the pattern
+// in the order they are listed.
+func (tm *TopicMatcher) MatchTopics(topics []string) []string {
+	var matched []string
+	for _, topic := range topics {
+		if tm.Match(topic) {
+			matched = append(matched, topic)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,101 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const retryTopicInfix = ".retry."
+
+// RetryEnvelope is the value of a message republished to either a retry or a
+// dead letter topic when it is negatively acknowledged. It wraps the value of
+// the original message, and the key of the original message is preserved as
+// is.
+type RetryEnvelope struct {
+	// The consumer group that negatively acknowledged the message.
+	Group string `json:"group"`
+	// The topic that the message was originally produced to.
+	Topic string `json:"topic"`
+	// The number of times the message has been negatively acknowledged.
+	Retries int `json:"retries"`
+	// The message should not be consumed again before this time. It is zero
+	// for messages republished to a dead letter topic.
+	DueTime time.Time `json:"dueTime"`
+	Value   []byte    `json:"value"`
+}
+
+// RetryTopic returns the name of the topic that messages of the specified
+// topic negatively acknowledged by the specified group are republished to in
+// order to be consumed again after the specified delay, e.g.
+// `bar.foo.retry.10m`. Retry topics are specific to a group, so that other
+// groups consuming the same topic never get the message again.
+func RetryTopic(group, topic string, delay time.Duration) string {
+	return group + "." + topic + retryTopicInfix + formatDelay(delay)
+}
+
+// RetryTopicOrigin returns the name of the topic that the specified retry
+// topic was derived from by `RetryTopic` for the specified group. False is
+// returned if the topic is not a retry topic of the group.
+func RetryTopicOrigin(group, topic string) (string, bool) {
+	prefix := group + "."
+	if !strings.HasPrefix(topic, prefix) {
+		return "", false
+	}
+	topic = topic[len(prefix):]
+	i := strings.LastIndex(topic, retryTopicInfix)
+	if i <= 0 {
+		return "", false
+	}
+	if _, err := time.ParseDuration(topic[i+len(retryTopicInfix):]); err != nil {
+		return "", false
+	}
+	return topic[:i], true
+}
+
+// DeadLetterTopic returns the name of the topic that messages of the
+// specified topic are republished to when they have been negatively
+// acknowledged by the specified group more times than there are retry
+// delays, e.g. `bar.foo.dlq` for the `.dlq` suffix.
+func DeadLetterTopic(group, topic, suffix string) string {
+	return group + "." + topic + suffix
+}
+
+// EncodeRetryEnvelope returns a value of a message to be republished to a
+// retry or a dead letter topic.
+func EncodeRetryEnvelope(env RetryEnvelope) []byte {
+	value, err := json.Marshal(env)
+	if err != nil {
+		// Must never happen.
+		panic(err)
+	}
+	return value
+}
+
+// DecodeRetryEnvelope parses a value of a message consumed from a retry topic.
+func DecodeRetryEnvelope(value []byte) (RetryEnvelope, error) {
+	var env RetryEnvelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return RetryEnvelope{}, fmt.Errorf("invalid retry envelope: err=(%s)", err)
+	}
+	if env.Topic == "" {
+		return RetryEnvelope{}, fmt.Errorf("invalid retry envelope: topic missing")
+	}
+	return env, nil
+}
+
+// formatDelay returns the shortest representation of a delay that can be
+// parsed with `time.ParseDuration`, e.g. `90s` or `2h`.
+func formatDelay(delay time.Duration) string {
+	switch {
+	case delay%time.Hour == 0:
+		return fmt.Sprintf("%dh", delay/time.Hour)
+	case delay%time.Minute == 0:
+		return fmt.Sprintf("%dm", delay/time.Minute)
+	case delay%time.Second == 0:
+		return fmt.Sprintf("%ds", delay/time.Second)
+	default:
+		return fmt.Sprintf("%dms", delay/time.Millisecond)
+	}
+}
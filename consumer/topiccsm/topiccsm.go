@@ -9,13 +9,18 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	"github.com/mailgun/kafka-pixy/none"
 )
 
 // T implements a consumer request dispatch tier responsible for a particular
 // topic. It receives requests on the `Requests()` channel and replies with
 // messages received on `Messages()` channel. If there has been no message
 // received for `Config.Consumer.LongPollingTimeout` then a timeout error is
-// sent to the requests' reply channel.
+// sent to the requests' reply channel. While consumption of the group/topic
+// is paused, either directly or by a paused topic pattern matching the topic,
+// requests are held concurrently for the same time, or until the topic
+// consumer is stopped, and then replied with `consumer.ErrPaused`.
 //
 // implements `dispatcher.Tier`.
 // implements `multiplexer.Out`.
@@ -24,25 +29,31 @@ type T struct {
 	cfg           *config.T
 	group         string
 	topic         string
+	registry      *partitioncsm.Registry
 	lifespanCh    chan<- *T
 	assignmentsCh chan []int32
 	requestsCh    chan dispatcher.Request
 	messagesCh    chan *consumer.Message
+	stopCh        chan none.T
 	wg            sync.WaitGroup
 }
 
 // Creates a topic consumer instance. It should be explicitly started in
 // accordance with the `dispatcher.Tier` contract.
-func New(namespace *actor.ID, group, topic string, cfg *config.T, lifespanCh chan<- *T) *T {
+func New(namespace *actor.ID, group, topic string, cfg *config.T, registry *partitioncsm.Registry,
+	lifespanCh chan<- *T,
+) *T {
 	return &T{
 		actorID:       namespace.NewChild(fmt.Sprintf("T:%s", topic)),
 		cfg:           cfg,
 		group:         group,
 		topic:         topic,
+		registry:      registry,
 		lifespanCh:    lifespanCh,
 		assignmentsCh: make(chan []int32),
 		requestsCh:    make(chan dispatcher.Request, cfg.Consumer.ChannelBufferSize),
 		messagesCh:    make(chan *consumer.Message),
+		stopCh:        make(chan none.T),
 	}
 }
 
@@ -76,6 +87,7 @@ func (tc *T) Start(stoppedCh chan<- dispatcher.Tier) {
 
 // implements `dispatcher.Tier`.
 func (tc *T) Stop() {
+	close(tc.stopCh)
 	close(tc.requestsCh)
 	tc.wg.Wait()
 }
@@ -86,8 +98,11 @@ func (tc *T) run() {
 		tc.lifespanCh <- tc
 	}()
 
-	timeoutErr := consumer.ErrRequestTimeout(fmt.Errorf("long polling timeout"))
+	timeoutErr := consumer.ErrRequestTimeout{Err: fmt.Errorf("long polling timeout")}
 	timeoutResult := dispatcher.Response{Err: timeoutErr}
+	pausedResult := dispatcher.Response{Err: consumer.ErrPaused{Group: tc.group, Topic: tc.topic}}
+	var parkedWG sync.WaitGroup
+	defer parkedWG.Wait()
 	for consumeReq := range tc.requestsCh {
 		requestAge := time.Now().UTC().Sub(consumeReq.Timestamp)
 		ttl := tc.cfg.Consumer.LongPollingTimeout - requestAge
@@ -100,6 +115,33 @@ func (tc *T) run() {
 			continue
 		}
 
+		// Paused requests are held for the long polling timeout, so that
+		// clients keep polling at the usual pace rather than spinning. They
+		// are held concurrently, for otherwise requests queued behind the
+		// first one would time out before they are replied to.
+		if tc.registry.IsPaused(tc.group, tc.topic) {
+			parkedWG.Add(1)
+			go func(responseCh chan<- dispatcher.Response) {
+				defer parkedWG.Done()
+				select {
+				case <-time.After(ttl):
+				case <-tc.stopCh:
+				}
+				responseCh <- pausedResult
+			}(consumeReq.ResponseCh)
+			continue
+		}
+
+		if consumeReq.MaxMessages > 0 {
+			msgs := tc.consumeBatch(consumeReq, ttl)
+			if len(msgs) == 0 {
+				consumeReq.ResponseCh <- timeoutResult
+				continue
+			}
+			consumeReq.ResponseCh <- dispatcher.Response{Msgs: msgs}
+			continue
+		}
+
 		select {
 		case msg := <-tc.messagesCh:
 			consumeReq.ResponseCh <- dispatcher.Response{Msg: msg}
@@ -109,6 +151,45 @@ func (tc *T) run() {
 	}
 }
 
+// consumeBatch collects up to `consumeReq.MaxMessages` messages. It waits for
+// the first message at most `ttl`, and after that keeps adding messages to
+// the batch until either `consumeReq.MaxWait` elapses since the first message
+// was received, or the batch is full, or `ttl` elapses. When
+// `consumeReq.MaxWait` has elapsed, only messages that are available right
+// away are added.
+func (tc *T) consumeBatch(consumeReq dispatcher.Request, ttl time.Duration) []*consumer.Message {
+	var (
+		msgs           []*consumer.Message
+		timeoutCh      = time.After(ttl)
+		nilOrMaxWaitCh <-chan time.Time
+		maxWaitExpired = consumeReq.MaxWait <= 0
+	)
+	for len(msgs) < consumeReq.MaxMessages {
+		if maxWaitExpired && len(msgs) > 0 {
+			select {
+			case msg := <-tc.messagesCh:
+				msgs = append(msgs, msg)
+				continue
+			default:
+				return msgs
+			}
+		}
+		select {
+		case msg := <-tc.messagesCh:
+			if len(msgs) == 0 && !maxWaitExpired {
+				nilOrMaxWaitCh = time.After(consumeReq.MaxWait)
+			}
+			msgs = append(msgs, msg)
+		case <-nilOrMaxWaitCh:
+			maxWaitExpired = true
+			nilOrMaxWaitCh = nil
+		case <-timeoutCh:
+			return msgs
+		}
+	}
+	return msgs
+}
+
 func (tc *T) String() string {
 	return tc.actorID.String()
 }
@@ -0,0 +1,185 @@
+package topiccsm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/dispatcher"
+	"github.com/mailgun/kafka-pixy/consumer/partitioncsm"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type TopicCsmSuite struct {
+	ns  *actor.ID
+	cfg *config.T
+}
+
+var _ = Suite(&TopicCsmSuite{})
+
+func (s *TopicCsmSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+	s.cfg = config.Default()
+	s.cfg.Consumer.LongPollingTimeout = 3 * time.Second
+}
+
+// The max wait of a batch starts when the first message is received, rather
+// than when the request is received.
+func (s *TopicCsmSuite) TestBatchMaxWaitStartsWithFirstMessage(c *C) {
+	tc, stop := s.start()
+	defer stop()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		tc.Messages() <- &consumer.Message{Offset: 1}
+		time.Sleep(100 * time.Millisecond)
+		tc.Messages() <- &consumer.Message{Offset: 2}
+	}()
+
+	// When
+	res := s.request(tc, 10, 200*time.Millisecond)
+
+	// Then
+	c.Assert(res.Err, IsNil)
+	c.Assert(offsetsOf(res.Msgs), DeepEquals, []int64{1, 2})
+}
+
+// Once the max wait of a batch has elapsed, only messages that are available
+// right away are added to it.
+func (s *TopicCsmSuite) TestBatchMaxWaitExpired(c *C) {
+	tc, stop := s.start()
+	defer stop()
+	go func() {
+		tc.Messages() <- &consumer.Message{Offset: 1}
+		time.Sleep(400 * time.Millisecond)
+		tc.Messages() <- &consumer.Message{Offset: 2}
+	}()
+
+	// When
+	res := s.request(tc, 10, 200*time.Millisecond)
+
+	// Then
+	c.Assert(res.Err, IsNil)
+	c.Assert(offsetsOf(res.Msgs), DeepEquals, []int64{1})
+	<-tc.messagesCh
+}
+
+// Pausing a topic pattern pauses a topic consumer of a matching topic that is
+// already running.
+func (s *TopicCsmSuite) TestPausedByPattern(c *C) {
+	s.cfg.Consumer.LongPollingTimeout = 100 * time.Millisecond
+	registry := partitioncsm.NewRegistry()
+	tc, stop := s.startWith(registry)
+	defer stop()
+	res := s.request(tc, 0, 0)
+	c.Assert(res.Err, FitsTypeOf, consumer.ErrRequestTimeout{})
+
+	// When
+	registry.Pause("g1", consumer.TopicPattern("t.*"))
+
+	// Then
+	c.Assert(registry.IsPaused("g1", "t1"), Equals, true)
+	res = s.request(tc, 0, 0)
+	c.Assert(res.Err, DeepEquals, consumer.ErrPaused{Group: "g1", Topic: "t1"})
+
+	// When
+	registry.Resume("g1", consumer.TopicPattern("t.*"))
+
+	// Then
+	c.Assert(registry.IsPaused("g1", "t1"), Equals, false)
+	go func() {
+		tc.Messages() <- &consumer.Message{Offset: 1}
+	}()
+	res = s.request(tc, 0, 0)
+	c.Assert(res.Err, IsNil)
+	c.Assert(res.Msg.Offset, Equals, int64(1))
+}
+
+// Paused requests are held concurrently, so that requests queued behind the
+// first one are replied with `consumer.ErrPaused` rather than time out.
+func (s *TopicCsmSuite) TestPausedConcurrently(c *C) {
+	s.cfg.Consumer.LongPollingTimeout = 300 * time.Millisecond
+	registry := partitioncsm.NewRegistry()
+	registry.Pause("g1", "t1")
+	tc, stop := s.startWith(registry)
+	defer stop()
+	begin := time.Now()
+
+	// When
+	responseChs := make([]chan dispatcher.Response, 3)
+	for i := range responseChs {
+		responseChs[i] = make(chan dispatcher.Response, 1)
+		tc.Requests() <- dispatcher.Request{Timestamp: time.Now().UTC(), Group: "g1", Topic: "t1", ResponseCh: responseChs[i]}
+	}
+
+	// Then
+	for i, responseCh := range responseChs {
+		res := <-responseCh
+		c.Assert(res.Err, FitsTypeOf, consumer.ErrPaused{}, Commentf("request #%d", i))
+	}
+	c.Assert(time.Now().Sub(begin) < 2*s.cfg.Consumer.LongPollingTimeout, Equals, true)
+}
+
+// A topic consumer that holds a paused request stops without waiting for the
+// long polling timeout.
+func (s *TopicCsmSuite) TestStopWhilePaused(c *C) {
+	registry := partitioncsm.NewRegistry()
+	registry.Pause("g1", "t1")
+	tc, stop := s.startWith(registry)
+	responseCh := make(chan dispatcher.Response, 1)
+	tc.Requests() <- dispatcher.Request{Timestamp: time.Now().UTC(), Group: "g1", Topic: "t1", ResponseCh: responseCh}
+	time.Sleep(100 * time.Millisecond)
+	begin := time.Now()
+
+	// When
+	stop()
+
+	// Then
+	c.Assert(time.Now().Sub(begin) < time.Second, Equals, true)
+	res := <-responseCh
+	c.Assert(res.Err, FitsTypeOf, consumer.ErrPaused{})
+}
+
+// start starts a topic consumer and returns a function that stops it.
+func (s *TopicCsmSuite) start() (*T, func()) {
+	return s.startWith(partitioncsm.NewRegistry())
+}
+
+// startWith starts a topic consumer that uses the given partition consumer
+// registry and returns a function that stops it.
+func (s *TopicCsmSuite) startWith(registry *partitioncsm.Registry) (*T, func()) {
+	lifespanCh := make(chan *T, 2)
+	stoppedCh := make(chan dispatcher.Tier, 1)
+	tc := New(s.ns, "g1", "t1", s.cfg, registry, lifespanCh)
+	tc.Start(stoppedCh)
+	return tc, func() {
+		tc.Stop()
+		<-stoppedCh
+	}
+}
+
+func (s *TopicCsmSuite) request(tc *T, max int, maxWait time.Duration) dispatcher.Response {
+	responseCh := make(chan dispatcher.Response, 1)
+	tc.Requests() <- dispatcher.Request{
+		Timestamp:   time.Now().UTC(),
+		Group:       "g1",
+		Topic:       "t1",
+		ResponseCh:  responseCh,
+		MaxMessages: max,
+		MaxWait:     maxWait,
+	}
+	return <-responseCh
+}
+
+func offsetsOf(msgs []*consumer.Message) []int64 {
+	offsets := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		offsets[i] = msg.Offset
+	}
+	return offsets
+}
@@ -0,0 +1,831 @@
+// Code generated by protoc-gen-go.
+// source: kafkapixy.proto
+// DO NOT EDIT!
+
+/*
+Package pb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	kafkapixy.proto
+
+It has these top-level messages:
+
+	ProdRq
+	ProdRs
+	ConsRq
+	ConsRs
+	AckRq
+	AckRs
+	PartitionOffset
+	GetOffsetsRq
+	GetOffsetsRs
+	SetOffsetsRq
+	SetOffsetsRs
+	ListConsumersRq
+	ConsumerPartitions
+	ConsumerGroup
+	ListConsumersRs
+*/
+package pb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type ProdRq struct {
+	Topic        string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	KeyValue     []byte `protobuf:"bytes,2,opt,name=key_value,json=keyValue,proto3" json:"key_value,omitempty"`
+	KeyUndefined bool   `protobuf:"varint,3,opt,name=key_undefined,json=keyUndefined" json:"key_undefined,omitempty"`
+	Message      []byte `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	AsyncMode    bool   `protobuf:"varint,5,opt,name=async_mode,json=asyncMode" json:"async_mode,omitempty"`
+}
+
+func (m *ProdRq) Reset()                    { *m = ProdRq{} }
+func (m *ProdRq) String() string            { return proto.CompactTextString(m) }
+func (*ProdRq) ProtoMessage()               {}
+func (*ProdRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *ProdRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *ProdRq) GetKeyValue() []byte {
+	if m != nil {
+		return m.KeyValue
+	}
+	return nil
+}
+
+func (m *ProdRq) GetKeyUndefined() bool {
+	if m != nil {
+		return m.KeyUndefined
+	}
+	return false
+}
+
+func (m *ProdRq) GetMessage() []byte {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (m *ProdRq) GetAsyncMode() bool {
+	if m != nil {
+		return m.AsyncMode
+	}
+	return false
+}
+
+type ProdRs struct {
+	Partition int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	Offset    int64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+}
+
+func (m *ProdRs) Reset()                    { *m = ProdRs{} }
+func (m *ProdRs) String() string            { return proto.CompactTextString(m) }
+func (*ProdRs) ProtoMessage()               {}
+func (*ProdRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *ProdRs) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *ProdRs) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+type ConsRq struct {
+	Topic       string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Group       string `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+	ExplicitAck bool   `protobuf:"varint,3,opt,name=explicit_ack,json=explicitAck" json:"explicit_ack,omitempty"`
+}
+
+func (m *ConsRq) Reset()                    { *m = ConsRq{} }
+func (m *ConsRq) String() string            { return proto.CompactTextString(m) }
+func (*ConsRq) ProtoMessage()               {}
+func (*ConsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ConsRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *ConsRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *ConsRq) GetExplicitAck() bool {
+	if m != nil {
+		return m.ExplicitAck
+	}
+	return false
+}
+
+type ConsRs struct {
+	Partition    int32  `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	Offset       int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	KeyValue     []byte `protobuf:"bytes,3,opt,name=key_value,json=keyValue,proto3" json:"key_value,omitempty"`
+	KeyUndefined bool   `protobuf:"varint,4,opt,name=key_undefined,json=keyUndefined" json:"key_undefined,omitempty"`
+	Message      []byte `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *ConsRs) Reset()                    { *m = ConsRs{} }
+func (m *ConsRs) String() string            { return proto.CompactTextString(m) }
+func (*ConsRs) ProtoMessage()               {}
+func (*ConsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ConsRs) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *ConsRs) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *ConsRs) GetKeyValue() []byte {
+	if m != nil {
+		return m.KeyValue
+	}
+	return nil
+}
+
+func (m *ConsRs) GetKeyUndefined() bool {
+	if m != nil {
+		return m.KeyUndefined
+	}
+	return false
+}
+
+func (m *ConsRs) GetMessage() []byte {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+type AckRq struct {
+	Topic     string  `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Group     string  `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+	Partition int32   `protobuf:"varint,3,opt,name=partition" json:"partition,omitempty"`
+	Offsets   []int64 `protobuf:"varint,4,rep,packed,name=offsets" json:"offsets,omitempty"`
+}
+
+func (m *AckRq) Reset()                    { *m = AckRq{} }
+func (m *AckRq) String() string            { return proto.CompactTextString(m) }
+func (*AckRq) ProtoMessage()               {}
+func (*AckRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *AckRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *AckRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *AckRq) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *AckRq) GetOffsets() []int64 {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+type AckRs struct {
+}
+
+func (m *AckRs) Reset()                    { *m = AckRs{} }
+func (m *AckRs) String() string            { return proto.CompactTextString(m) }
+func (*AckRs) ProtoMessage()               {}
+func (*AckRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type PartitionOffset struct {
+	Partition int32  `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	Begin     int64  `protobuf:"varint,2,opt,name=begin" json:"begin,omitempty"`
+	End       int64  `protobuf:"varint,3,opt,name=end" json:"end,omitempty"`
+	Count     int64  `protobuf:"varint,4,opt,name=count" json:"count,omitempty"`
+	Offset    int64  `protobuf:"varint,5,opt,name=offset" json:"offset,omitempty"`
+	Lag       int64  `protobuf:"varint,6,opt,name=lag" json:"lag,omitempty"`
+	Metadata  string `protobuf:"bytes,7,opt,name=metadata" json:"metadata,omitempty"`
+}
+
+func (m *PartitionOffset) Reset()                    { *m = PartitionOffset{} }
+func (m *PartitionOffset) String() string            { return proto.CompactTextString(m) }
+func (*PartitionOffset) ProtoMessage()               {}
+func (*PartitionOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *PartitionOffset) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetBegin() int64 {
+	if m != nil {
+		return m.Begin
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetEnd() int64 {
+	if m != nil {
+		return m.End
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetCount() int64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetLag() int64 {
+	if m != nil {
+		return m.Lag
+	}
+	return 0
+}
+
+func (m *PartitionOffset) GetMetadata() string {
+	if m != nil {
+		return m.Metadata
+	}
+	return ""
+}
+
+type GetOffsetsRq struct {
+	Topic string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Group string `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+}
+
+func (m *GetOffsetsRq) Reset()                    { *m = GetOffsetsRq{} }
+func (m *GetOffsetsRq) String() string            { return proto.CompactTextString(m) }
+func (*GetOffsetsRq) ProtoMessage()               {}
+func (*GetOffsetsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *GetOffsetsRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *GetOffsetsRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+type GetOffsetsRs struct {
+	Offsets []*PartitionOffset `protobuf:"bytes,1,rep,name=offsets" json:"offsets,omitempty"`
+}
+
+func (m *GetOffsetsRs) Reset()                    { *m = GetOffsetsRs{} }
+func (m *GetOffsetsRs) String() string            { return proto.CompactTextString(m) }
+func (*GetOffsetsRs) ProtoMessage()               {}
+func (*GetOffsetsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *GetOffsetsRs) GetOffsets() []*PartitionOffset {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+type SetOffsetsRq struct {
+	Topic   string             `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Group   string             `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+	Offsets []*PartitionOffset `protobuf:"bytes,3,rep,name=offsets" json:"offsets,omitempty"`
+}
+
+func (m *SetOffsetsRq) Reset()                    { *m = SetOffsetsRq{} }
+func (m *SetOffsetsRq) String() string            { return proto.CompactTextString(m) }
+func (*SetOffsetsRq) ProtoMessage()               {}
+func (*SetOffsetsRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *SetOffsetsRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *SetOffsetsRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *SetOffsetsRq) GetOffsets() []*PartitionOffset {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+type SetOffsetsRs struct {
+}
+
+func (m *SetOffsetsRs) Reset()                    { *m = SetOffsetsRs{} }
+func (m *SetOffsetsRs) String() string            { return proto.CompactTextString(m) }
+func (*SetOffsetsRs) ProtoMessage()               {}
+func (*SetOffsetsRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+type ListConsumersRq struct {
+	Topic string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Group string `protobuf:"bytes,2,opt,name=group" json:"group,omitempty"`
+}
+
+func (m *ListConsumersRq) Reset()                    { *m = ListConsumersRq{} }
+func (m *ListConsumersRq) String() string            { return proto.CompactTextString(m) }
+func (*ListConsumersRq) ProtoMessage()               {}
+func (*ListConsumersRq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *ListConsumersRq) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *ListConsumersRq) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+type ConsumerPartitions struct {
+	Partitions []int32 `protobuf:"varint,1,rep,packed,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *ConsumerPartitions) Reset()                    { *m = ConsumerPartitions{} }
+func (m *ConsumerPartitions) String() string            { return proto.CompactTextString(m) }
+func (*ConsumerPartitions) ProtoMessage()               {}
+func (*ConsumerPartitions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *ConsumerPartitions) GetPartitions() []int32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+type ConsumerGroup struct {
+	Consumers map[string]*ConsumerPartitions `protobuf:"bytes,1,rep,name=consumers" json:"consumers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ConsumerGroup) Reset()                    { *m = ConsumerGroup{} }
+func (m *ConsumerGroup) String() string            { return proto.CompactTextString(m) }
+func (*ConsumerGroup) ProtoMessage()               {}
+func (*ConsumerGroup) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ConsumerGroup) GetConsumers() map[string]*ConsumerPartitions {
+	if m != nil {
+		return m.Consumers
+	}
+	return nil
+}
+
+type ListConsumersRs struct {
+	Groups map[string]*ConsumerGroup `protobuf:"bytes,1,rep,name=groups" json:"groups,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ListConsumersRs) Reset()                    { *m = ListConsumersRs{} }
+func (m *ListConsumersRs) String() string            { return proto.CompactTextString(m) }
+func (*ListConsumersRs) ProtoMessage()               {}
+func (*ListConsumersRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *ListConsumersRs) GetGroups() map[string]*ConsumerGroup {
+	if m != nil {
+		return m.Groups
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ProdRq)(nil), "kafkapixy.ProdRq")
+	proto.RegisterType((*ProdRs)(nil), "kafkapixy.ProdRs")
+	proto.RegisterType((*ConsRq)(nil), "kafkapixy.ConsRq")
+	proto.RegisterType((*ConsRs)(nil), "kafkapixy.ConsRs")
+	proto.RegisterType((*AckRq)(nil), "kafkapixy.AckRq")
+	proto.RegisterType((*AckRs)(nil), "kafkapixy.AckRs")
+	proto.RegisterType((*PartitionOffset)(nil), "kafkapixy.PartitionOffset")
+	proto.RegisterType((*GetOffsetsRq)(nil), "kafkapixy.GetOffsetsRq")
+	proto.RegisterType((*GetOffsetsRs)(nil), "kafkapixy.GetOffsetsRs")
+	proto.RegisterType((*SetOffsetsRq)(nil), "kafkapixy.SetOffsetsRq")
+	proto.RegisterType((*SetOffsetsRs)(nil), "kafkapixy.SetOffsetsRs")
+	proto.RegisterType((*ListConsumersRq)(nil), "kafkapixy.ListConsumersRq")
+	proto.RegisterType((*ConsumerPartitions)(nil), "kafkapixy.ConsumerPartitions")
+	proto.RegisterType((*ConsumerGroup)(nil), "kafkapixy.ConsumerGroup")
+	proto.RegisterType((*ListConsumersRs)(nil), "kafkapixy.ListConsumersRs")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for KafkaPixy service
+
+type KafkaPixyClient interface {
+	Produce(ctx context.Context, in *ProdRq, opts ...grpc.CallOption) (*ProdRs, error)
+	Consume(ctx context.Context, in *ConsRq, opts ...grpc.CallOption) (*ConsRs, error)
+	ConsumeStream(ctx context.Context, in *ConsRq, opts ...grpc.CallOption) (KafkaPixy_ConsumeStreamClient, error)
+	Ack(ctx context.Context, in *AckRq, opts ...grpc.CallOption) (*AckRs, error)
+	GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error)
+	SetOffsets(ctx context.Context, in *SetOffsetsRq, opts ...grpc.CallOption) (*SetOffsetsRs, error)
+	ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (*ListConsumersRs, error)
+}
+
+type kafkaPixyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewKafkaPixyClient(cc *grpc.ClientConn) KafkaPixyClient {
+	return &kafkaPixyClient{cc}
+}
+
+func (c *kafkaPixyClient) Produce(ctx context.Context, in *ProdRq, opts ...grpc.CallOption) (*ProdRs, error) {
+	out := new(ProdRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/Produce", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) Consume(ctx context.Context, in *ConsRq, opts ...grpc.CallOption) (*ConsRs, error) {
+	out := new(ConsRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/Consume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) ConsumeStream(ctx context.Context, in *ConsRq, opts ...grpc.CallOption) (KafkaPixy_ConsumeStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_KafkaPixy_serviceDesc.Streams[0], c.cc, "/kafkapixy.KafkaPixy/ConsumeStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kafkaPixyConsumeStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KafkaPixy_ConsumeStreamClient interface {
+	Recv() (*ConsRs, error)
+	grpc.ClientStream
+}
+
+type kafkaPixyConsumeStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *kafkaPixyConsumeStreamClient) Recv() (*ConsRs, error) {
+	m := new(ConsRs)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kafkaPixyClient) Ack(ctx context.Context, in *AckRq, opts ...grpc.CallOption) (*AckRs, error) {
+	out := new(AckRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/Ack", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) GetOffsets(ctx context.Context, in *GetOffsetsRq, opts ...grpc.CallOption) (*GetOffsetsRs, error) {
+	out := new(GetOffsetsRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/GetOffsets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) SetOffsets(ctx context.Context, in *SetOffsetsRq, opts ...grpc.CallOption) (*SetOffsetsRs, error) {
+	out := new(SetOffsetsRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/SetOffsets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kafkaPixyClient) ListConsumers(ctx context.Context, in *ListConsumersRq, opts ...grpc.CallOption) (*ListConsumersRs, error) {
+	out := new(ListConsumersRs)
+	err := grpc.Invoke(ctx, "/kafkapixy.KafkaPixy/ListConsumers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for KafkaPixy service
+
+type KafkaPixyServer interface {
+	Produce(context.Context, *ProdRq) (*ProdRs, error)
+	Consume(context.Context, *ConsRq) (*ConsRs, error)
+	ConsumeStream(*ConsRq, KafkaPixy_ConsumeStreamServer) error
+	Ack(context.Context, *AckRq) (*AckRs, error)
+	GetOffsets(context.Context, *GetOffsetsRq) (*GetOffsetsRs, error)
+	SetOffsets(context.Context, *SetOffsetsRq) (*SetOffsetsRs, error)
+	ListConsumers(context.Context, *ListConsumersRq) (*ListConsumersRs, error)
+}
+
+func RegisterKafkaPixyServer(s *grpc.Server, srv KafkaPixyServer) {
+	s.RegisterService(&_KafkaPixy_serviceDesc, srv)
+}
+
+func _KafkaPixy_Produce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProdRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Produce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/Produce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Produce(ctx, req.(*ProdRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_Consume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Consume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/Consume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Consume(ctx, req.(*ConsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_ConsumeStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConsRq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KafkaPixyServer).ConsumeStream(m, &kafkaPixyConsumeStreamServer{stream})
+}
+
+type KafkaPixy_ConsumeStreamServer interface {
+	Send(*ConsRs) error
+	grpc.ServerStream
+}
+
+type kafkaPixyConsumeStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *kafkaPixyConsumeStreamServer) Send(m *ConsRs) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KafkaPixy_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/Ack",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).Ack(ctx, req.(*AckRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_GetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOffsetsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).GetOffsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/GetOffsets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).GetOffsets(ctx, req.(*GetOffsetsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_SetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetOffsetsRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).SetOffsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/SetOffsets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).SetOffsets(ctx, req.(*SetOffsetsRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KafkaPixy_ListConsumers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConsumersRq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KafkaPixyServer).ListConsumers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kafkapixy.KafkaPixy/ListConsumers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KafkaPixyServer).ListConsumers(ctx, req.(*ListConsumersRq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KafkaPixy_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kafkapixy.KafkaPixy",
+	HandlerType: (*KafkaPixyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Produce",
+			Handler:    _KafkaPixy_Produce_Handler,
+		},
+		{
+			MethodName: "Consume",
+			Handler:    _KafkaPixy_Consume_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _KafkaPixy_Ack_Handler,
+		},
+		{
+			MethodName: "GetOffsets",
+			Handler:    _KafkaPixy_GetOffsets_Handler,
+		},
+		{
+			MethodName: "SetOffsets",
+			Handler:    _KafkaPixy_SetOffsets_Handler,
+		},
+		{
+			MethodName: "ListConsumers",
+			Handler:    _KafkaPixy_ListConsumers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ConsumeStream",
+			Handler:       _KafkaPixy_ConsumeStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kafkapixy.proto",
+}
+
+func init() { proto.RegisterFile("kafkapixy.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 687 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x55, 0x5d, 0x6f, 0xd3, 0x30,
+	0x14, 0x5d, 0xe6, 0xa6, 0x5d, 0x6e, 0xbb, 0x0f, 0xac, 0x09, 0xa2, 0xc0, 0x50, 0x09, 0x12, 0x54,
+	0x42, 0xaa, 0x60, 0xdb, 0x03, 0x4c, 0x62, 0x62, 0xc0, 0x34, 0x21, 0x40, 0x4c, 0xae, 0x00, 0x09,
+	0x1e, 0x26, 0x2f, 0x75, 0xab, 0x28, 0x6d, 0x92, 0xc5, 0x2e, 0x5a, 0x7e, 0x0a, 0xfc, 0x00, 0xc4,
+	0x3b, 0x7f, 0x10, 0xd9, 0x4e, 0x9a, 0xa4, 0x5b, 0xc7, 0xba, 0xb7, 0xde, 0xeb, 0x7b, 0xee, 0x3d,
+	0xe7, 0xf8, 0xc6, 0x85, 0xf5, 0x80, 0x0e, 0x02, 0x1a, 0xfb, 0xe7, 0x69, 0x37, 0x4e, 0x22, 0x11,
+	0x61, 0x6b, 0x9a, 0x70, 0x7f, 0x1a, 0x50, 0x3f, 0x4e, 0xa2, 0x3e, 0x39, 0xc3, 0x9b, 0x60, 0x8a,
+	0x28, 0xf6, 0x3d, 0xdb, 0x68, 0x1b, 0x1d, 0x8b, 0xe8, 0x00, 0xdf, 0x05, 0x2b, 0x60, 0xe9, 0xc9,
+	0x0f, 0x3a, 0x9a, 0x30, 0x7b, 0xb9, 0x6d, 0x74, 0x5a, 0x64, 0x25, 0x60, 0xe9, 0x17, 0x19, 0xe3,
+	0x87, 0xb0, 0x2a, 0x0f, 0x27, 0x61, 0x9f, 0x0d, 0xfc, 0x90, 0xf5, 0x6d, 0xd4, 0x36, 0x3a, 0x2b,
+	0xa4, 0x15, 0xb0, 0xf4, 0x73, 0x9e, 0xc3, 0x36, 0x34, 0xc6, 0x8c, 0x73, 0x3a, 0x64, 0x76, 0x4d,
+	0xe1, 0xf3, 0x10, 0x6f, 0x01, 0x50, 0x9e, 0x86, 0xde, 0xc9, 0x38, 0xea, 0x33, 0xdb, 0x54, 0x58,
+	0x4b, 0x65, 0x3e, 0x46, 0x7d, 0xe6, 0xee, 0x67, 0xd4, 0x38, 0xbe, 0x07, 0x56, 0x4c, 0x13, 0xe1,
+	0x0b, 0x3f, 0x0a, 0x15, 0x3d, 0x93, 0x14, 0x09, 0x7c, 0x1b, 0xea, 0xd1, 0x60, 0xc0, 0x99, 0x50,
+	0xfc, 0x10, 0xc9, 0x22, 0xf7, 0x2b, 0xd4, 0xdf, 0x44, 0x21, 0x9f, 0x2b, 0x6d, 0x13, 0xcc, 0x61,
+	0x12, 0x4d, 0x62, 0x05, 0xb3, 0x88, 0x0e, 0xf0, 0x03, 0x68, 0xb1, 0xf3, 0x78, 0xe4, 0x7b, 0xbe,
+	0x38, 0xa1, 0x5e, 0x90, 0x49, 0x6a, 0xe6, 0xb9, 0x03, 0x2f, 0x70, 0x7f, 0x19, 0x59, 0xe7, 0x1b,
+	0x32, 0xab, 0x9a, 0x8a, 0xfe, 0x67, 0x6a, 0xed, 0x6a, 0x53, 0xcd, 0x8a, 0xa9, 0x6e, 0x00, 0xe6,
+	0x81, 0x17, 0x2c, 0x28, 0xba, 0x22, 0x03, 0xcd, 0xca, 0xb0, 0xa1, 0xa1, 0x89, 0x73, 0xbb, 0xd6,
+	0x46, 0x1d, 0x44, 0xf2, 0xd0, 0x6d, 0xe8, 0x61, 0xdc, 0xfd, 0x6b, 0xc0, 0xfa, 0x71, 0x0e, 0xf8,
+	0xa4, 0x55, 0x5e, 0xed, 0xcd, 0x26, 0x98, 0xa7, 0x6c, 0xe8, 0x87, 0x99, 0x35, 0x3a, 0xc0, 0x1b,
+	0x80, 0x58, 0xa8, 0xf7, 0x08, 0x11, 0xf9, 0x53, 0xd6, 0x79, 0xd1, 0x24, 0x14, 0xca, 0x06, 0x44,
+	0x74, 0x50, 0x72, 0xd6, 0xac, 0x38, 0xbb, 0x01, 0x68, 0x44, 0x87, 0x76, 0x5d, 0xe3, 0x47, 0x74,
+	0x88, 0x1d, 0x58, 0x19, 0x33, 0x41, 0xfb, 0x54, 0x50, 0xbb, 0xa1, 0x34, 0x4f, 0x63, 0x77, 0x0f,
+	0x5a, 0x47, 0x4c, 0x68, 0xba, 0x0b, 0xee, 0x89, 0xfb, 0xb6, 0x82, 0xe5, 0x78, 0xb7, 0x30, 0xc9,
+	0x68, 0xa3, 0x4e, 0x73, 0xdb, 0xe9, 0x16, 0xdf, 0xdd, 0x8c, 0x35, 0x85, 0x81, 0x31, 0xb4, 0x7a,
+	0x37, 0x64, 0x50, 0x9e, 0x88, 0xae, 0x3f, 0x71, 0xad, 0x32, 0x91, 0xbb, 0x2f, 0x61, 0xfd, 0x83,
+	0xcf, 0x85, 0xdc, 0xe7, 0xc9, 0x98, 0x25, 0x8b, 0xda, 0xb0, 0x0b, 0x38, 0x87, 0x4e, 0x47, 0x72,
+	0x7c, 0x1f, 0x60, 0x7a, 0xd3, 0xda, 0x0f, 0x93, 0x94, 0x32, 0x72, 0x5d, 0x56, 0x73, 0xd8, 0x91,
+	0x12, 0x73, 0x08, 0x96, 0x97, 0x53, 0xc8, 0x0c, 0x7c, 0x5c, 0x92, 0x53, 0x29, 0x9e, 0x46, 0xfc,
+	0x30, 0x14, 0x49, 0x4a, 0x0a, 0xa4, 0xf3, 0x1d, 0xd6, 0xaa, 0x87, 0x72, 0x23, 0x02, 0x96, 0x66,
+	0x52, 0xe4, 0x4f, 0xbc, 0x03, 0x66, 0xf1, 0x9c, 0x35, 0xb7, 0xb7, 0x2e, 0x19, 0x53, 0x48, 0x21,
+	0xba, 0x76, 0x6f, 0xf9, 0xb9, 0xe1, 0xfe, 0x36, 0x66, 0xbd, 0xe2, 0x78, 0x1f, 0xea, 0xca, 0x88,
+	0x9c, 0xf4, 0xa3, 0x52, 0xb7, 0x99, 0xda, 0xae, 0x22, 0x9f, 0x71, 0xce, 0x50, 0x4e, 0x0f, 0x9a,
+	0xa5, 0xf4, 0x25, 0x6c, 0xbb, 0x55, 0xb6, 0xf6, 0x3c, 0x53, 0x4a, 0x44, 0xb7, 0xff, 0x20, 0xb0,
+	0xde, 0xcb, 0xb2, 0x63, 0xff, 0x3c, 0xc5, 0xcf, 0xa0, 0x21, 0xdf, 0xd1, 0x89, 0xc7, 0xf0, 0xad,
+	0xf2, 0x86, 0xa8, 0x67, 0xdf, 0xb9, 0x90, 0xe2, 0xee, 0x92, 0x84, 0x64, 0xcd, 0x2b, 0x10, 0xfd,
+	0x9c, 0x3a, 0x17, 0x52, 0x12, 0xf2, 0x62, 0x7a, 0xa3, 0x3d, 0x91, 0x30, 0x3a, 0xbe, 0x2e, 0xf0,
+	0xa9, 0x81, 0x9f, 0x00, 0x3a, 0xf0, 0x02, 0xbc, 0x51, 0x3a, 0x55, 0x4f, 0x98, 0x33, 0x9b, 0x91,
+	0x73, 0x5e, 0x01, 0x14, 0xdf, 0x1d, 0xbe, 0x53, 0xaa, 0x28, 0x7f, 0xca, 0xce, 0x9c, 0x83, 0xac,
+	0x43, 0xef, 0xf2, 0x0e, 0xbd, 0x79, 0x1d, 0x7a, 0xd5, 0x0e, 0xef, 0x60, 0xb5, 0x72, 0xb7, 0xd8,
+	0x99, 0x7b, 0xeb, 0x67, 0xce, 0xfc, 0x33, 0xee, 0x2e, 0xbd, 0xae, 0x7d, 0x5b, 0x8e, 0x4f, 0x4f,
+	0xeb, 0xea, 0x8f, 0x79, 0xe7, 0xdf, 0x00, 0xae, 0x97, 0x77, 0x4f, 0xab, 0x07, 0x00, 0x00,
+}
@@ -0,0 +1,341 @@
+package grpcsrv
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/consumer"
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/producer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	NetworkTCP  = "tcp"
+	NetworkUnix = "unix"
+)
+
+// T implements the gRPC API. It serves the same requests as the HTTP API
+// does, executing them with the same producer, consumer and admin instances.
+type T struct {
+	actorID    *actor.ID
+	addr       string
+	listener   net.Listener
+	grpcServer *grpc.Server
+	prod       *producer.T
+	cons       consumer.T
+	admin      *admin.T
+	stopCh     chan none.T
+	errorCh    chan error
+}
+
+// New creates a gRPC server instance that will accept API requests at the
+// specified `network`/`address` and execute them with the specified
+// `producer`, `consumer`, or `admin`, depending on the request type.
+func New(network, addr string, prod *producer.T, cons consumer.T, admin *admin.T) (*T, error) {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener, err=(%s)", err)
+	}
+	// If the address is Unix Domain Socket then make it accessible for everyone.
+	if network == NetworkUnix {
+		if err := os.Chmod(addr, 0777); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to change socket permissions, err=(%s)", err)
+		}
+	}
+	s := &T{
+		actorID:    actor.RootID.NewChild(fmt.Sprintf("gRPC@%s", addr)),
+		addr:       addr,
+		listener:   listener,
+		grpcServer: grpc.NewServer(),
+		prod:       prod,
+		cons:       cons,
+		admin:      admin,
+		stopCh:     make(chan none.T),
+		errorCh:    make(chan error, 1),
+	}
+	pb.RegisterKafkaPixyServer(s.grpcServer, s)
+	return s, nil
+}
+
+// Starts triggers asynchronous gRPC server start. If it fails then the error
+// will be sent down to `ErrorCh()`.
+func (s *T) Start() {
+	actor.Spawn(s.actorID, nil, func() {
+		defer close(s.errorCh)
+		if err := s.grpcServer.Serve(s.listener); err != nil {
+			select {
+			case <-s.stopCh:
+				// An error returned after stop was requested is expected.
+			default:
+				s.errorCh <- fmt.Errorf("gRPC API listener failed, err=(%s)", err)
+			}
+		}
+	})
+}
+
+// ErrorCh returns an output channel that the gRPC server running in another
+// goroutine will use if it stops with error. The channel will be closed when
+// the server is fully stopped due to an error or otherwise.
+func (s *T) ErrorCh() <-chan error {
+	return s.errorCh
+}
+
+// Close releases the listener of a server that has not been started. It is
+// used to clean up if the service fails to spawn after the server was created.
+func (s *T) Close() {
+	s.listener.Close()
+}
+
+// AsyncStop triggers gRPC API listener stop. Consume streams in progress are
+// terminated, and the server waits for all other pending requests to
+// complete. If a caller wants to know when the server terminates it should
+// read from the `ErrorCh()` channel that will be closed upon server
+// termination.
+func (s *T) AsyncStop() {
+	close(s.stopCh)
+	go s.grpcServer.GracefulStop()
+}
+
+// Produce implements `pb.KafkaPixyServer`.
+func (s *T) Produce(ctx context.Context, req *pb.ProdRq) (*pb.ProdRs, error) {
+	if req.Topic == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	var key sarama.Encoder
+	if !req.KeyUndefined {
+		key = sarama.ByteEncoder(req.KeyValue)
+	}
+	if req.AsyncMode {
+		if err := s.prod.AsyncProduce(req.Topic, key, sarama.ByteEncoder(req.Message)); err != nil {
+			return nil, grpc.Errorf(codes.Internal, "%s", err)
+		}
+		return &pb.ProdRs{}, nil
+	}
+	prodMsg, err := s.prod.Produce(req.Topic, key, sarama.ByteEncoder(req.Message))
+	if err != nil {
+		if err == sarama.ErrUnknownTopicOrPartition {
+			return nil, grpc.Errorf(codes.NotFound, "%s", err)
+		}
+		return nil, grpc.Errorf(codes.Internal, "%s", err)
+	}
+	return &pb.ProdRs{Partition: prodMsg.Partition, Offset: prodMsg.Offset}, nil
+}
+
+// Consume implements `pb.KafkaPixyServer`.
+func (s *T) Consume(ctx context.Context, req *pb.ConsRq) (*pb.ConsRs, error) {
+	if err := validateConsRq(req); err != nil {
+		return nil, err
+	}
+	consMsg, err := s.consume(req)
+	if err != nil {
+		return nil, consumeError(err)
+	}
+	return toConsRs(consMsg), nil
+}
+
+// ConsumeStream implements `pb.KafkaPixyServer`.
+func (s *T) ConsumeStream(req *pb.ConsRq, stream pb.KafkaPixy_ConsumeStreamServer) error {
+	if err := validateConsRq(req); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.stopCh:
+			return grpc.Errorf(codes.Unavailable, "server is shutting down")
+		default:
+		}
+		consMsg, err := s.consume(req)
+		if err != nil {
+			delay, ok := consumer.RepeatAfter(err)
+			if !ok {
+				return consumeError(err)
+			}
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-stream.Context().Done():
+					return nil
+				case <-s.stopCh:
+					return grpc.Errorf(codes.Unavailable, "server is shutting down")
+				}
+			}
+			continue
+		}
+		if err := stream.Send(toConsRs(consMsg)); err != nil {
+			return err
+		}
+	}
+}
+
+// Ack implements `pb.KafkaPixyServer`.
+func (s *T) Ack(ctx context.Context, req *pb.AckRq) (*pb.AckRs, error) {
+	if req.Topic == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	if req.Group == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "group is not specified")
+	}
+	if len(req.Offsets) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "offsets are not specified")
+	}
+	if err := s.cons.Ack(req.Group, req.Topic, req.Partition, req.Offsets...); err != nil {
+		switch err.(type) {
+		case consumer.ErrInvalidAck:
+			return nil, grpc.Errorf(codes.FailedPrecondition, "%s", err)
+		default:
+			return nil, grpc.Errorf(codes.Internal, "%s", err)
+		}
+	}
+	return &pb.AckRs{}, nil
+}
+
+// GetOffsets implements `pb.KafkaPixyServer`.
+func (s *T) GetOffsets(ctx context.Context, req *pb.GetOffsetsRq) (*pb.GetOffsetsRs, error) {
+	if req.Topic == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	if req.Group == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "group is not specified")
+	}
+	partitionOffsets, err := s.admin.GetGroupOffsets(req.Group, req.Topic)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &pb.GetOffsetsRs{Offsets: make([]*pb.PartitionOffset, len(partitionOffsets))}
+	for i, po := range partitionOffsets {
+		res.Offsets[i] = &pb.PartitionOffset{
+			Partition: po.Partition,
+			Begin:     po.Begin,
+			End:       po.End,
+			Count:     po.End - po.Begin,
+			Offset:    po.Offset,
+			Metadata:  po.Metadata,
+		}
+		if po.Offset == sarama.OffsetNewest {
+			res.Offsets[i].Lag = 0
+		} else if po.Offset == sarama.OffsetOldest {
+			res.Offsets[i].Lag = po.End - po.Begin
+		} else {
+			res.Offsets[i].Lag = po.End - po.Offset
+		}
+	}
+	return res, nil
+}
+
+// SetOffsets implements `pb.KafkaPixyServer`.
+func (s *T) SetOffsets(ctx context.Context, req *pb.SetOffsetsRq) (*pb.SetOffsetsRs, error) {
+	if req.Topic == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	if req.Group == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "group is not specified")
+	}
+	partitionOffsets := make([]admin.PartitionOffset, len(req.Offsets))
+	for i, po := range req.Offsets {
+		partitionOffsets[i].Partition = po.Partition
+		partitionOffsets[i].Offset = po.Offset
+		partitionOffsets[i].Metadata = po.Metadata
+	}
+	if err := s.admin.SetGroupOffsets(req.Group, req.Topic, partitionOffsets); err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.SetOffsetsRs{}, nil
+}
+
+// ListConsumers implements `pb.KafkaPixyServer`.
+func (s *T) ListConsumers(ctx context.Context, req *pb.ListConsumersRq) (*pb.ListConsumersRs, error) {
+	if req.Topic == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	var consumers map[string]map[string][]int32
+	if req.Group == "" {
+		var err error
+		if consumers, err = s.admin.GetAllTopicConsumers(req.Topic); err != nil {
+			return nil, adminError(err)
+		}
+	} else {
+		groupConsumers, err := s.admin.GetTopicConsumers(req.Group, req.Topic)
+		if err != nil {
+			if _, ok := err.(admin.ErrInvalidParam); ok {
+				return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+			}
+			return nil, adminError(err)
+		}
+		consumers = make(map[string]map[string][]int32)
+		if len(groupConsumers) != 0 {
+			consumers[req.Group] = groupConsumers
+		}
+	}
+	res := &pb.ListConsumersRs{Groups: make(map[string]*pb.ConsumerGroup, len(consumers))}
+	for group, groupConsumers := range consumers {
+		consumerGroup := &pb.ConsumerGroup{Consumers: make(map[string]*pb.ConsumerPartitions, len(groupConsumers))}
+		for clientID, partitions := range groupConsumers {
+			consumerGroup.Consumers[clientID] = &pb.ConsumerPartitions{Partitions: partitions}
+		}
+		res.Groups[group] = consumerGroup
+	}
+	return res, nil
+}
+
+func (s *T) consume(req *pb.ConsRq) (*consumer.Message, error) {
+	if req.ExplicitAck {
+		return s.cons.ConsumeExplicitAck(req.Group, req.Topic)
+	}
+	return s.cons.Consume(req.Group, req.Topic)
+}
+
+func validateConsRq(req *pb.ConsRq) error {
+	if req.Topic == "" {
+		return grpc.Errorf(codes.InvalidArgument, "topic is not specified")
+	}
+	if req.Group == "" {
+		return grpc.Errorf(codes.InvalidArgument, "group is not specified")
+	}
+	return nil
+}
+
+func toConsRs(consMsg *consumer.Message) *pb.ConsRs {
+	return &pb.ConsRs{
+		Partition:    consMsg.Partition,
+		Offset:       consMsg.Offset,
+		KeyValue:     consMsg.Key,
+		KeyUndefined: consMsg.Key == nil,
+		Message:      consMsg.Value,
+	}
+}
+
+// consumeError converts a consume error to a gRPC error with an appropriate
+// status code.
+func consumeError(err error) error {
+	switch err.(type) {
+	case consumer.ErrPaused, consumer.ErrNoValidOffset:
+		return grpc.Errorf(codes.FailedPrecondition, "%s", err)
+	case consumer.ErrRequestTimeout:
+		return grpc.Errorf(codes.DeadlineExceeded, "%s", err)
+	case consumer.ErrBufferOverflow:
+		return grpc.Errorf(codes.ResourceExhausted, "%s", err)
+	default:
+		return grpc.Errorf(codes.Internal, "%s", err)
+	}
+}
+
+// adminError converts an admin error to a gRPC error with an appropriate
+// status code.
+func adminError(err error) error {
+	if err, ok := err.(admin.ErrQuery); ok && err.Cause() == sarama.ErrUnknownTopicOrPartition {
+		return grpc.Errorf(codes.NotFound, "Unknown topic")
+	}
+	return grpc.Errorf(codes.Internal, "%s", err)
+}
@@ -0,0 +1,200 @@
+package grpcsrv
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/admin"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/consumer/consumerimpl"
+	pb "github.com/mailgun/kafka-pixy/gen/golang"
+	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type GRPCSrvSuite struct {
+	cfg  *config.T
+	addr string
+}
+
+var _ = Suite(&GRPCSrvSuite{})
+
+func (s *GRPCSrvSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging(c)
+}
+
+func (s *GRPCSrvSuite) SetUpTest(c *C) {
+	s.cfg = testhelpers.NewTestConfig("grpcsrv")
+	s.addr = path.Join(os.TempDir(), "kafka-pixy-grpc.sock")
+	os.Remove(s.addr)
+}
+
+// Requests that do not specify mandatory parameters are rejected before they
+// get to the producer, consumer or admin.
+func (s *GRPCSrvSuite) TestInvalidArgument(c *C) {
+	// Given
+	srv, err := New(NetworkUnix, s.addr, nil, nil, nil)
+	c.Assert(err, IsNil)
+	srv.Start()
+	defer stop(srv)
+	clt := s.newClient(c)
+	ctx := context.Background()
+
+	// When
+	_, prodErr := clt.Produce(ctx, &pb.ProdRq{})
+	_, consErr := clt.Consume(ctx, &pb.ConsRq{Topic: "test.1"})
+	_, ackErr := clt.Ack(ctx, &pb.AckRq{Topic: "test.1", Group: "g1"})
+	_, getOffsetsErr := clt.GetOffsets(ctx, &pb.GetOffsetsRq{Group: "g1"})
+	_, setOffsetsErr := clt.SetOffsets(ctx, &pb.SetOffsetsRq{Topic: "test.1"})
+	_, listConsumersErr := clt.ListConsumers(ctx, &pb.ListConsumersRq{})
+
+	// Then
+	c.Assert(grpc.Code(prodErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(prodErr), Equals, "topic is not specified")
+	c.Assert(grpc.Code(consErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(consErr), Equals, "group is not specified")
+	c.Assert(grpc.Code(ackErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(ackErr), Equals, "offsets are not specified")
+	c.Assert(grpc.Code(getOffsetsErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(getOffsetsErr), Equals, "topic is not specified")
+	c.Assert(grpc.Code(setOffsetsErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(setOffsetsErr), Equals, "group is not specified")
+	c.Assert(grpc.Code(listConsumersErr), Equals, codes.InvalidArgument)
+	c.Assert(grpc.ErrorDesc(listConsumersErr), Equals, "topic is not specified")
+}
+
+func (s *GRPCSrvSuite) TestProduceAndConsume(c *C) {
+	// Given
+	kh := kafkahelper.New(c)
+	defer kh.Close()
+	kh.ResetOffsets("g1", "test.4")
+	srv, cleanup := s.spawnServer(c)
+	defer cleanup()
+	srv.Start()
+	clt := s.newClient(c)
+	ctx := context.Background()
+
+	// When
+	prodRs, err := clt.Produce(ctx, &pb.ProdRq{Topic: "test.4", KeyValue: []byte("1"), Message: []byte("Foo")})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(prodRs.Partition, Equals, int32(0))
+	consRs, err := clt.Consume(ctx, &pb.ConsRq{Topic: "test.4", Group: "g1"})
+	c.Assert(err, IsNil)
+	c.Assert(consRs.Partition, Equals, int32(0))
+	c.Assert(consRs.Offset, Equals, prodRs.Offset)
+	c.Assert(string(consRs.KeyValue), Equals, "1")
+	c.Assert(consRs.KeyUndefined, Equals, false)
+	c.Assert(string(consRs.Message), Equals, "Foo")
+}
+
+// Messages produced to a topic are sent down a consume stream as soon as they
+// become available.
+func (s *GRPCSrvSuite) TestConsumeStream(c *C) {
+	// Given
+	kh := kafkahelper.New(c)
+	defer kh.Close()
+	kh.ResetOffsets("g1", "test.4")
+	produced := kh.PutMessages("grpc-stream", "test.4", map[string]int{"B": 3})
+	srv, cleanup := s.spawnServer(c)
+	defer cleanup()
+	srv.Start()
+	clt := s.newClient(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// When
+	stream, err := clt.ConsumeStream(ctx, &pb.ConsRq{Topic: "test.4", Group: "g1", ExplicitAck: true})
+	c.Assert(err, IsNil)
+
+	// Then
+	for i := 0; i < 3; i++ {
+		consRs, err := stream.Recv()
+		c.Assert(err, IsNil)
+		c.Assert(string(consRs.Message), Equals, string(produced["B"][i].Value.(sarama.StringEncoder)))
+		_, err = clt.Ack(ctx, &pb.AckRq{Topic: "test.4", Group: "g1", Partition: consRs.Partition, Offsets: []int64{consRs.Offset}})
+		c.Assert(err, IsNil)
+	}
+}
+
+// Consume errors are mapped to gRPC status codes that tell a timeout from
+// other failures.
+func (s *GRPCSrvSuite) TestConsumeErrorCodes(c *C) {
+	for i, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{consumer.ErrRequestTimeout{Err: fmt.Errorf("long polling timeout")}, codes.DeadlineExceeded},
+		{consumer.ErrPaused{Group: "g1", Topic: "test.1"}, codes.FailedPrecondition},
+		{consumer.ErrBufferOverflow{Err: fmt.Errorf("too many requests")}, codes.ResourceExhausted},
+		{fmt.Errorf("unexpected"), codes.Internal},
+	} {
+		c.Assert(grpc.Code(consumeError(tc.err)), Equals, tc.code, Commentf("case #%d", i))
+	}
+}
+
+func (s *GRPCSrvSuite) TestGetOffsetsNoSuchTopic(c *C) {
+	// Given
+	srv, cleanup := s.spawnServer(c)
+	defer cleanup()
+	srv.Start()
+	clt := s.newClient(c)
+
+	// When
+	_, err := clt.GetOffsets(context.Background(), &pb.GetOffsetsRq{Topic: "no-such-topic", Group: "g1"})
+
+	// Then
+	c.Assert(grpc.Code(err), Equals, codes.NotFound)
+	c.Assert(grpc.ErrorDesc(err), Equals, "Unknown topic")
+}
+
+// spawnServer creates a gRPC server along with producer, consumer and admin
+// instances that it uses, and returns a function that stops them all.
+func (s *GRPCSrvSuite) spawnServer(c *C) (*T, func()) {
+	prod, err := producer.Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	cons, err := consumerimpl.Spawn(actor.RootID, s.cfg, prod)
+	c.Assert(err, IsNil)
+	adm, err := admin.Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	srv, err := New(NetworkUnix, s.addr, prod, cons, adm)
+	c.Assert(err, IsNil)
+	return srv, func() {
+		stop(srv)
+		cons.Stop()
+		prod.Stop()
+		adm.Stop()
+	}
+}
+
+func (s *GRPCSrvSuite) newClient(c *C) pb.KafkaPixyClient {
+	conn, err := grpc.Dial(s.addr, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(3*time.Second),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	c.Assert(err, IsNil)
+	return pb.NewKafkaPixyClient(conn)
+}
+
+func stop(srv *T) {
+	srv.AsyncStop()
+	for range srv.ErrorCh() {
+	}
+}
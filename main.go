@@ -25,32 +25,75 @@ const (
 )
 
 var (
-	cfg            *config.T
+	configFile     string
+	tcpAddr        string
+	unixAddr       string
+	grpcAddr       string
+	kafkaPeers     string
+	zookeeperPeers string
 	pidFile        string
 	loggingJSONCfg string
 )
 
 func init() {
-	cfg = config.Default()
-	var kafkaPeers, zookeeperPeers string
-
-	flag.StringVar(&cfg.TCPAddr, "tcpAddr", defaultTCPAddr, "TCP address that the HTTP API should listen on")
-	flag.StringVar(&cfg.UnixAddr, "unixAddr", "", "Unix domain socket address that the HTTP API should listen on")
+	flag.StringVar(&configFile, "config", "", "Path to a YAML config file, command line parameters override values specified in the file")
+	flag.StringVar(&tcpAddr, "tcpAddr", defaultTCPAddr, "TCP address that the HTTP API should listen on")
+	flag.StringVar(&unixAddr, "unixAddr", "", "Unix domain socket address that the HTTP API should listen on")
+	flag.StringVar(&grpcAddr, "grpcAddr", "", "Address that the gRPC API should listen on, either TCP or Unix domain socket prefixed with unix:")
 	flag.StringVar(&kafkaPeers, "kafkaPeers", defaultKafkaPeers, "Comma separated list of brokers")
 	flag.StringVar(&zookeeperPeers, "zookeeperPeers", defaultZookeeperPeers, "Comma separated list of ZooKeeper nodes followed by optional chroot")
 	flag.StringVar(&pidFile, "pidFile", "", "Path to the PID file")
 	flag.StringVar(&loggingJSONCfg, "logging", defaultLoggingCfg, "Logging configuration")
 	flag.Parse()
+}
 
-	cfg.Kafka.SeedPeers = strings.Split(kafkaPeers, ",")
+// makeConfig builds a config from defaults, values specified in the config
+// file if any, and command line parameters, each next source taking
+// precedence over the previous one. The resulting config is validated.
+func makeConfig() (*config.T, error) {
+	cfg := config.Default()
+	cfg.TCPAddr = defaultTCPAddr
+	cfg.Kafka.SeedPeers = strings.Split(defaultKafkaPeers, ",")
+	cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.Chroot = parseZooKeeperPeers(defaultZookeeperPeers)
+
+	if configFile != "" {
+		if err := cfg.LoadYAMLFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+	// Only parameters explicitly specified on the command line override
+	// config file values.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tcpAddr":
+			cfg.TCPAddr = tcpAddr
+		case "unixAddr":
+			cfg.UnixAddr = unixAddr
+		case "grpcAddr":
+			cfg.GRPCAddr = grpcAddr
+		case "kafkaPeers":
+			cfg.Kafka.SeedPeers = strings.Split(kafkaPeers, ",")
+		case "zookeeperPeers":
+			cfg.ZooKeeper.SeedPeers, cfg.ZooKeeper.Chroot = parseZooKeeperPeers(zookeeperPeers)
+		}
+	})
+	if err := cfg.LoadClientIDFile(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config, err=(%s)", err)
+	}
+	return cfg, nil
+}
 
+// parseZooKeeperPeers splits a comma separated list of ZooKeeper nodes
+// followed by an optional chroot into the list of nodes and the chroot.
+func parseZooKeeperPeers(zookeeperPeers string) ([]string, string) {
 	chrootStartIdx := strings.Index(zookeeperPeers, "/")
 	if chrootStartIdx >= 0 {
-		cfg.ZooKeeper.SeedPeers = strings.Split(zookeeperPeers[:chrootStartIdx], ",")
-		cfg.ZooKeeper.Chroot = zookeeperPeers[chrootStartIdx:]
-	} else {
-		cfg.ZooKeeper.SeedPeers = strings.Split(zookeeperPeers, ",")
+		return strings.Split(zookeeperPeers[:chrootStartIdx], ","), zookeeperPeers[chrootStartIdx:]
 	}
+	return strings.Split(zookeeperPeers, ","), ""
 }
 
 func main() {
@@ -62,6 +105,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	cfg, err := makeConfig()
+	if err != nil {
+		log.Errorf("Failed to load config: err=(%s)", err)
+		os.Exit(1)
+	}
+
 	if pidFile != "" {
 		if err := writePID(pidFile); err != nil {
 			log.Errorf("Failed to write PID file: err=(%s)", err)
@@ -78,6 +127,13 @@ func main() {
 		}
 	}
 
+	if strings.HasPrefix(cfg.GRPCAddr, service.GRPCUnixAddrPrefix) {
+		grpcUnixAddr := strings.TrimPrefix(cfg.GRPCAddr, service.GRPCUnixAddrPrefix)
+		if err := os.Remove(grpcUnixAddr); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Cannot remove %s: err=(%s)", grpcUnixAddr, err)
+		}
+	}
+
 	log.Infof("Starting with config: %+v", cfg)
 	svc, err := service.Spawn(cfg)
 	if err != nil {
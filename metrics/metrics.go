@@ -0,0 +1,131 @@
+// Package metrics defines Prometheus metrics exposed by Kafka-Pixy at the
+// `/metrics` endpoint of the HTTP API. All metrics are registered with the
+// default Prometheus registry on package initialization.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "kafkapixy"
+
+// Outcomes of consume requests used as values of the `outcome` label.
+const (
+	ConsumeSuccess  = "success"
+	ConsumeTimeout  = "timeout"
+	ConsumeOverflow = "overflow"
+	ConsumeError    = "error"
+)
+
+var (
+	// ProducedMessages counts messages successfully written to Kafka.
+	ProducedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "messages_total",
+		Help:      "Number of messages successfully produced to Kafka.",
+	}, []string{"topic"})
+
+	// ProducedBytes counts key and value bytes of messages successfully
+	// written to Kafka.
+	ProducedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "bytes_total",
+		Help:      "Number of key and value bytes successfully produced to Kafka.",
+	}, []string{"topic"})
+
+	// ProduceErrors counts messages that could not be written to Kafka.
+	ProduceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "errors_total",
+		Help:      "Number of messages that failed to be produced to Kafka.",
+	}, []string{"topic"})
+
+	// DeadLetters counts asynchronously produced messages that could not be
+	// delivered to Kafka and were handed over to dead letter handling.
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "dead_letters_total",
+		Help:      "Number of undelivered messages handed over to dead letter handling.",
+	}, []string{"topic"})
+
+	// WALPending is the number of asynchronously produced messages persisted
+	// in the write-ahead log that have not been delivered to Kafka yet.
+	WALPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "wal_pending",
+		Help:      "Number of messages in the write-ahead log that have not been delivered to Kafka yet.",
+	})
+
+	// ConsumeRequests counts consume requests by outcome.
+	ConsumeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "requests_total",
+		Help:      "Number of consume requests by outcome: success, timeout, overflow, or error.",
+	}, []string{"group", "topic", "outcome"})
+
+	// PartitionLag is the number of messages in a partition that follow the
+	// last one fetched on behalf of a consumer group.
+	PartitionLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "partition_lag",
+		Help:      "Number of messages between the last fetched offset and the partition high water mark.",
+	}, []string{"group", "topic", "partition"})
+
+	// OffsetCommitLatency measures how long it takes to commit offsets of a
+	// consumer group to Kafka.
+	OffsetCommitLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "offset_commit_latency_seconds",
+		Help:      "Latency of offset commit requests to Kafka.",
+	}, []string{"group"})
+
+	// OffsetCommitFailures counts failed offset commit requests.
+	OffsetCommitFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "offset_commit_failures_total",
+		Help:      "Number of offset commit requests that failed.",
+	}, []string{"group"})
+
+	// Rebalances counts consumer group rebalances performed by this
+	// Kafka-Pixy instance.
+	Rebalances = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "rebalances_total",
+		Help:      "Number of consumer group rebalances.",
+	}, []string{"group"})
+
+	// RebalanceDuration measures how long consumer group rebalances take.
+	RebalanceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "rebalance_duration_seconds",
+		Help:      "Time it takes to rebalance a consumer group.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"group"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ProducedMessages,
+		ProducedBytes,
+		ProduceErrors,
+		DeadLetters,
+		WALPending,
+		ConsumeRequests,
+		PartitionLag,
+		OffsetCommitLatency,
+		OffsetCommitFailures,
+		Rebalances,
+		RebalanceDuration,
+	)
+}
@@ -0,0 +1,319 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/log"
+)
+
+const replaySuffix = ".replay"
+
+// ErrNotSpooled is returned by `List` and `Replay` when dead letters are not
+// written to a spool file.
+var ErrNotSpooled = errors.New("dead letters are not spooled")
+
+// Record is a message that could not be delivered to Kafka along with the
+// error returned by the producer.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	Topic     string    `json:"topic"`
+	// Key is nil if the message was produced to a random partition.
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Error string `json:"error"`
+}
+
+// T handles messages that the producer failed to deliver to Kafka. Depending
+// on `Config.Producer.DeadLetter.Mode` it either appends them to a local
+// spool file, or produces them to a dedicated Kafka topic. Dead letters are
+// handled in a separate goroutine so that the producer is not blocked by a
+// slow dead letter destination.
+//
+// Messages appended to the spool file can be listed and replayed back to
+// Kafka. The spool file is a sequence of JSON encoded `Record`s separated
+// with new lines.
+type T struct {
+	actorID        *actor.ID
+	mode           string
+	spoolFile      string
+	topic          string
+	saramaProducer sarama.SyncProducer
+	file           *os.File
+	fileLock       sync.Mutex
+	replayLock     sync.Mutex
+	recordsCh      chan Record
+	wg             sync.WaitGroup
+}
+
+// Spawn creates a dead letter handler configured by
+// `Config.Producer.DeadLetter` and starts its goroutine. `saramaClient` is
+// only used in the topic mode to produce dead letters to Kafka. If dead
+// letter handling is disabled, then nil is returned, and it is safe to call
+// all methods on a nil instance.
+func Spawn(namespace *actor.ID, cfg *config.T, saramaClient sarama.Client) (*T, error) {
+	dlCfg := cfg.Producer.DeadLetter
+	if dlCfg.Mode == config.DeadLetterNone || dlCfg.Mode == "" {
+		return nil, nil
+	}
+	dl := &T{
+		actorID:   namespace.NewChild("dead_letter"),
+		mode:      dlCfg.Mode,
+		spoolFile: dlCfg.SpoolFile,
+		topic:     dlCfg.Topic,
+		recordsCh: make(chan Record, cfg.Producer.ChannelBufferSize),
+	}
+	switch dl.mode {
+	case config.DeadLetterSpool:
+		if err := dl.recoverReplay(); err != nil {
+			return nil, err
+		}
+		file, err := openSpool(dl.spoolFile)
+		if err != nil {
+			return nil, err
+		}
+		dl.file = file
+	case config.DeadLetterTopic:
+		saramaProducer, err := sarama.NewSyncProducerFromClient(saramaClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sarama.SyncProducer, err=(%s)", err)
+		}
+		dl.saramaProducer = saramaProducer
+	default:
+		return nil, fmt.Errorf("invalid dead letter mode: %s", dl.mode)
+	}
+	actor.Spawn(dl.actorID, &dl.wg, dl.run)
+	return dl, nil
+}
+
+// Put submits a message that could not be delivered to Kafka for dead letter
+// handling.
+func (dl *T) Put(prodMsg *sarama.ProducerMessage, err error) {
+	if dl == nil {
+		return
+	}
+	rec := Record{
+		Timestamp: time.Now().UTC(),
+		Topic:     prodMsg.Topic,
+		Key:       encode(prodMsg.Key),
+		Value:     encode(prodMsg.Value),
+		Error:     err.Error(),
+	}
+	if rec.Value == nil {
+		rec.Value = []byte{}
+	}
+	dl.recordsCh <- rec
+}
+
+// List returns all records in the spool file in the order they were added.
+func (dl *T) List() ([]Record, error) {
+	if dl == nil || dl.mode != config.DeadLetterSpool {
+		return nil, ErrNotSpooled
+	}
+	dl.fileLock.Lock()
+	defer dl.fileLock.Unlock()
+	return readRecords(dl.spoolFile)
+}
+
+// Replay takes all records from the spool file and passes them to the
+// `produce` function that must return an error for every record that failed
+// to be produced again, or nil if it succeeded. Failed records are appended
+// back to the spool file with the updated error. The function returns the
+// number of successfully replayed records and the number of those that failed.
+//
+// Records being replayed are moved aside to a separate file, so that dead
+// letters that keep coming during replay are not lost, and so that records
+// are not lost if Kafka-Pixy terminates in the middle of a replay. Those are
+// returned to the spool file next time Kafka-Pixy starts.
+func (dl *T) Replay(produce func(records []Record) []error) (int, int, error) {
+	if dl == nil || dl.mode != config.DeadLetterSpool {
+		return 0, 0, ErrNotSpooled
+	}
+	dl.replayLock.Lock()
+	defer dl.replayLock.Unlock()
+
+	replayFile := dl.spoolFile + replaySuffix
+	dl.fileLock.Lock()
+	err := dl.rotate(replayFile)
+	dl.fileLock.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	records, err := readRecords(replayFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	var failed []Record
+	if len(records) > 0 {
+		errs := produce(records)
+		for i, err := range errs {
+			if err != nil {
+				records[i].Error = err.Error()
+				failed = append(failed, records[i])
+			}
+		}
+	}
+	dl.fileLock.Lock()
+	defer dl.fileLock.Unlock()
+	for _, rec := range failed {
+		if err := writeRecord(dl.file, rec); err != nil {
+			return len(records) - len(failed), len(failed), err
+		}
+	}
+	if err := os.Remove(replayFile); err != nil {
+		return len(records) - len(failed), len(failed), fmt.Errorf("failed to remove replay file, err=(%s)", err)
+	}
+	log.Infof("<%s> replayed: total=%d, failed=%d", dl.actorID, len(records), len(failed))
+	return len(records) - len(failed), len(failed), nil
+}
+
+// Stop waits for all submitted dead letters to be handled and releases all
+// resources.
+func (dl *T) Stop() {
+	if dl == nil {
+		return
+	}
+	close(dl.recordsCh)
+	dl.wg.Wait()
+	if dl.file != nil {
+		dl.file.Close()
+	}
+	if dl.saramaProducer != nil {
+		dl.saramaProducer.Close()
+	}
+}
+
+func (dl *T) run() {
+	for rec := range dl.recordsCh {
+		metrics.DeadLetters.WithLabelValues(rec.Topic).Inc()
+		if dl.mode == config.DeadLetterSpool {
+			dl.fileLock.Lock()
+			err := writeRecord(dl.file, rec)
+			dl.fileLock.Unlock()
+			if err != nil {
+				log.Errorf("<%s> failed to spool dead letter: topic=%s, err=(%s)", dl.actorID, rec.Topic, err)
+			}
+			continue
+		}
+		value, err := json.Marshal(rec)
+		if err != nil {
+			// Must never happen.
+			log.Errorf("<%s> failed to encode dead letter: err=(%s)", dl.actorID, err)
+			continue
+		}
+		prodMsg := &sarama.ProducerMessage{Topic: dl.topic, Value: sarama.ByteEncoder(value)}
+		if rec.Key != nil {
+			prodMsg.Key = sarama.ByteEncoder(rec.Key)
+		}
+		if _, _, err := dl.saramaProducer.SendMessage(prodMsg); err != nil {
+			log.Errorf("<%s> failed to produce dead letter: topic=%s, err=(%s)", dl.actorID, rec.Topic, err)
+		}
+	}
+}
+
+// rotate renames the spool file to `replayFile` and opens a new empty spool
+// file. It must be called with `fileLock` held.
+func (dl *T) rotate(replayFile string) error {
+	if err := dl.file.Close(); err != nil {
+		return fmt.Errorf("failed to close spool file, err=(%s)", err)
+	}
+	renameErr := os.Rename(dl.spoolFile, replayFile)
+	file, err := openSpool(dl.spoolFile)
+	if err != nil {
+		return err
+	}
+	dl.file = file
+	if renameErr != nil {
+		return fmt.Errorf("failed to rename spool file, err=(%s)", renameErr)
+	}
+	return nil
+}
+
+// recoverReplay appends records left in the replay file by a replay that was
+// interrupted back to the spool file.
+func (dl *T) recoverReplay() error {
+	replayFile := dl.spoolFile + replaySuffix
+	records, err := readRecords(replayFile)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		os.Remove(replayFile)
+		return nil
+	}
+	file, err := openSpool(dl.spoolFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, rec := range records {
+		if err := writeRecord(file, rec); err != nil {
+			return err
+		}
+	}
+	log.Infof("<%s> interrupted replay recovered: count=%d", dl.actorID, len(records))
+	return os.Remove(replayFile)
+}
+
+func openSpool(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file, err=(%s)", err)
+	}
+	return file, nil
+}
+
+func writeRecord(w io.Writer, rec Record) error {
+	encoded, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(encoded, '\n'))
+	return err
+}
+
+// readRecords reads all records from a spool file. A missing file is treated
+// as an empty one.
+func readRecords(filename string) ([]Record, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Record{}, nil
+		}
+		return nil, fmt.Errorf("failed to open spool file, err=(%s)", err)
+	}
+	defer file.Close()
+	records := []Record{}
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, fmt.Errorf("failed to parse spool file %s, record #%d, err=(%s)", filename, len(records), err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func encode(e sarama.Encoder) []byte {
+	if e == nil {
+		return nil
+	}
+	encoded, err := e.Encode()
+	if err != nil {
+		return nil
+	}
+	return encoded
+}
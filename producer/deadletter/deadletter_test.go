@@ -0,0 +1,200 @@
+package deadletter
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type DeadLetterSuite struct {
+	ns  *actor.ID
+	cfg *config.T
+}
+
+var _ = Suite(&DeadLetterSuite{})
+
+func (s *DeadLetterSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging(c)
+}
+
+func (s *DeadLetterSuite) SetUpTest(c *C) {
+	s.ns = actor.RootID.NewChild("T")
+	s.cfg = config.Default()
+	s.cfg.Producer.DeadLetter.Mode = config.DeadLetterSpool
+	s.cfg.Producer.DeadLetter.SpoolFile = path.Join(c.MkDir(), "dead_letters")
+}
+
+// If dead letter handling is disabled, then nil is returned that can be used
+// the same way as an actual instance.
+func (s *DeadLetterSuite) TestDisabled(c *C) {
+	s.cfg.Producer.DeadLetter.Mode = config.DeadLetterNone
+
+	// When
+	dl, err := Spawn(s.ns, s.cfg, nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(dl, IsNil)
+	dl.Put(prodMsg("foo", "1", "bar"), errors.New("kaboom"))
+	_, err = dl.List()
+	c.Assert(err, Equals, ErrNotSpooled)
+	_, _, err = dl.Replay(nil)
+	c.Assert(err, Equals, ErrNotSpooled)
+	dl.Stop()
+}
+
+// Spooled dead letters survive restarts, and the difference between an empty
+// and an undefined key is preserved.
+func (s *DeadLetterSuite) TestPutAndList(c *C) {
+	dl, err := Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	dl.Put(prodMsg("foo", "1", "bar"), errors.New("kaboom"))
+	dl.Put(&sarama.ProducerMessage{Topic: "foo", Value: sarama.StringEncoder("baz")}, errors.New("boom"))
+	dl.Put(prodMsg("qux", "", ""), errors.New("bang"))
+	dl.Stop()
+
+	// When
+	dl, err = Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	defer dl.Stop()
+	records, err := dl.List()
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 3)
+	c.Assert(records[0].Topic, Equals, "foo")
+	c.Assert(string(records[0].Key), Equals, "1")
+	c.Assert(string(records[0].Value), Equals, "bar")
+	c.Assert(records[0].Error, Equals, "kaboom")
+	c.Assert(records[0].Timestamp.IsZero(), Equals, false)
+	c.Assert(records[1].Key, IsNil)
+	c.Assert(string(records[1].Value), Equals, "baz")
+	c.Assert(records[2].Topic, Equals, "qux")
+	c.Assert(records[2].Key, DeepEquals, []byte{})
+	c.Assert(records[2].Value, DeepEquals, []byte{})
+}
+
+// Records that are successfully replayed are removed from the spool, and
+// those that fail stay there with the updated error.
+func (s *DeadLetterSuite) TestReplay(c *C) {
+	dl, err := Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	defer dl.Stop()
+	for _, value := range []string{"A", "B", "C"} {
+		dl.Put(prodMsg("foo", "1", value), errors.New("kaboom"))
+	}
+	waitForRecords(c, dl, 3)
+
+	// When
+	var replayed []string
+	replayedCount, failedCount, err := dl.Replay(func(records []Record) []error {
+		errs := make([]error, len(records))
+		for i, rec := range records {
+			replayed = append(replayed, string(rec.Value))
+			if string(rec.Value) == "B" {
+				errs[i] = errors.New("boom")
+			}
+		}
+		return errs
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(replayed, DeepEquals, []string{"A", "B", "C"})
+	c.Assert(replayedCount, Equals, 2)
+	c.Assert(failedCount, Equals, 1)
+	records, err := dl.List()
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 1)
+	c.Assert(string(records[0].Value), Equals, "B")
+	c.Assert(records[0].Error, Equals, "boom")
+}
+
+// Dead letters that keep coming while a replay is in progress are not lost.
+func (s *DeadLetterSuite) TestReplayConcurrentPut(c *C) {
+	dl, err := Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	defer dl.Stop()
+	dl.Put(prodMsg("foo", "1", "A"), errors.New("kaboom"))
+	waitForRecords(c, dl, 1)
+
+	// When
+	_, _, err = dl.Replay(func(records []Record) []error {
+		dl.Put(prodMsg("foo", "1", "B"), errors.New("boom"))
+		waitForRecords(c, dl, 1)
+		return make([]error, len(records))
+	})
+
+	// Then
+	c.Assert(err, IsNil)
+	records, err := dl.List()
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 1)
+	c.Assert(string(records[0].Value), Equals, "B")
+}
+
+// Records left behind by an interrupted replay are returned to the spool on
+// start.
+func (s *DeadLetterSuite) TestReplayInterrupted(c *C) {
+	dl, err := Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	dl.Put(prodMsg("foo", "1", "A"), errors.New("kaboom"))
+	dl.Put(prodMsg("foo", "1", "B"), errors.New("kaboom"))
+	dl.Stop()
+	// Simulate a replay interrupted after it moved records aside, and a dead
+	// letter added after that.
+	dl, err = Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	dl.fileLock.Lock()
+	err = dl.rotate(s.cfg.Producer.DeadLetter.SpoolFile + replaySuffix)
+	dl.fileLock.Unlock()
+	c.Assert(err, IsNil)
+	dl.Put(prodMsg("foo", "1", "C"), errors.New("kaboom"))
+	dl.Stop()
+
+	// When
+	dl, err = Spawn(s.ns, s.cfg, nil)
+	c.Assert(err, IsNil)
+	defer dl.Stop()
+
+	// Then
+	records, err := dl.List()
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 3)
+	c.Assert(string(records[0].Value), Equals, "C")
+	c.Assert(string(records[1].Value), Equals, "A")
+	c.Assert(string(records[2].Value), Equals, "B")
+}
+
+func prodMsg(topic, key, value string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.StringEncoder(value),
+	}
+}
+
+// waitForRecords waits until the spool file contains `count` records. Dead
+// letters are written to the spool file asynchronously.
+func waitForRecords(c *C, dl *T, count int) {
+	for i := 0; i < 100; i++ {
+		records, err := dl.List()
+		c.Assert(err, IsNil)
+		if len(records) == count {
+			return
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+	c.Fatalf("spool file does not have %d records", count)
+}
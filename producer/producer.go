@@ -8,6 +8,10 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/actor"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/kafka-pixy/producer/deadletter"
+	"github.com/mailgun/kafka-pixy/producer/wal"
 	"github.com/mailgun/log"
 )
 
@@ -20,49 +24,82 @@ const (
 // messages as soon as it is ordered to shutdown. On the contrary, when `T` is
 // ordered to stop it allows some time for the buffered messages to be
 // committed to the Kafka cluster, and only when that time has elapsed it drops
-// uncommitted messages.
+// uncommitted messages. Asynchronously produced messages that could not be
+// delivered are handed over to a dead letter handler if one is configured.
 //
-// TODO Consider implementing some sort of dead message processing.
+// If `Config.Producer.WAL.Dir` is specified, then asynchronously produced
+// messages are persisted to a write-ahead log before `AsyncProduce` returns,
+// and the WAL drainer goroutine submits them to Kafka from there. Messages are
+// removed from the log once Kafka acknowledges them. Messages that fail with
+// a retriable error are resubmitted after `Config.Producer.RetryBackoff`, and
+// messages that are still in the log when the producer stops are submitted
+// again after restart. So async produce survives restarts and Kafka outages,
+// but a message can be delivered more than once.
 type T struct {
 	mergerActorID     *actor.ID
 	dispatcherActorID *actor.ID
+	walDrainerActorID *actor.ID
 	saramaClient      sarama.Client
 	saramaProducer    sarama.AsyncProducer
 	shutdownTimeout   time.Duration
 	deadMessageCh     chan<- *sarama.ProducerMessage
+	deadLetters       *deadletter.T
 	dispatcherCh      chan *sarama.ProducerMessage
 	resultCh          chan produceResult
 	wg                sync.WaitGroup
+
+	wal            *wal.T
+	retryBackoff   time.Duration
+	walInflightCh  chan none.T
+	walRetries     []*sarama.ProducerMessage
+	walRetriesLock sync.Mutex
+	walRetryCh     chan none.T
+	walStopCh      chan none.T
+	walDrainerWG   sync.WaitGroup
 }
 
+// walSeq is used as `sarama.ProducerMessage.Metadata` of messages submitted
+// from the write-ahead log to tell them from other messages.
+type walSeq uint64
+
 type produceResult struct {
 	Msg *sarama.ProducerMessage
 	Err error
 }
 
 // Spawn creates a producer instance and starts its internal goroutines.
-func Spawn(cfg *config.T) (*T, error) {
-	saramaCfg := sarama.NewConfig()
-	saramaCfg.ChannelBufferSize = cfg.Producer.ChannelBufferSize
-	saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
-	saramaCfg.Producer.Return.Successes = true
-	saramaCfg.Producer.Return.Errors = true
-	saramaCfg.Producer.Compression = sarama.CompressionSnappy
-	saramaCfg.Producer.Retry.Backoff = 10 * time.Second
-	saramaCfg.Producer.Retry.Max = 6
-	saramaCfg.Producer.Flush.Frequency = 500 * time.Millisecond
-	saramaCfg.Producer.Flush.Bytes = 1024 * 1024
-
+func Spawn(cfg *config.T) (_ *T, err error) {
+	saramaCfg := cfg.SaramaProducerCfg()
 	saramaClient, err := sarama.NewClient(cfg.Kafka.SeedPeers, saramaCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create sarama.Client, err=(%s)", err)
 	}
+	// Everything created so far is released if spawning fails later on.
+	defer func() {
+		if err != nil {
+			saramaClient.Close()
+		}
+	}()
 	saramaProducer, err := sarama.NewAsyncProducerFromClient(saramaClient)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create sarama.Producer, err=(%s)", err)
 	}
+	defer func() {
+		if err != nil {
+			saramaProducer.Close()
+		}
+	}()
 
 	actorNamespace := actor.RootID.NewChild("producer")
+	deadLetters, err := deadletter.Spawn(actorNamespace, cfg, saramaClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to spawn dead letter handler, err=(%s)", err)
+	}
+	defer func() {
+		if err != nil {
+			deadLetters.Stop()
+		}
+	}()
 	p := &T{
 		mergerActorID:     actorNamespace.NewChild("merger"),
 		dispatcherActorID: actorNamespace.NewChild("dispatcher"),
@@ -70,18 +107,45 @@ func Spawn(cfg *config.T) (*T, error) {
 		saramaProducer:    saramaProducer,
 		shutdownTimeout:   cfg.Producer.ShutdownTimeout,
 		deadMessageCh:     cfg.Producer.DeadMessageCh,
+		deadLetters:       deadLetters,
 		dispatcherCh:      make(chan *sarama.ProducerMessage, cfg.Producer.ChannelBufferSize),
 		resultCh:          make(chan produceResult, cfg.Producer.ChannelBufferSize),
 	}
+	if cfg.Producer.WAL.Dir != "" {
+		walCfg := cfg.Producer.WAL
+		if p.wal, err = wal.Open(walCfg.Dir, walCfg.SegmentSize, walCfg.Fsync); err != nil {
+			return nil, fmt.Errorf("failed to open WAL, err=(%s)", err)
+		}
+		p.walDrainerActorID = actorNamespace.NewChild("wal_drainer")
+		p.retryBackoff = cfg.Producer.RetryBackoff
+		p.walInflightCh = make(chan none.T, cfg.Producer.ChannelBufferSize)
+		p.walRetryCh = make(chan none.T, 1)
+		p.walStopCh = make(chan none.T)
+	}
 	actor.Spawn(p.mergerActorID, &p.wg, p.runMerger)
 	actor.Spawn(p.dispatcherActorID, &p.wg, p.runDispatcher)
+	if p.wal != nil {
+		actor.Spawn(p.walDrainerActorID, &p.walDrainerWG, p.runWALDrainer)
+	}
 	return p, nil
 }
 
-// Stop shuts down all producer goroutines and releases all resources.
+// Stop shuts down all producer goroutines and releases all resources. Messages
+// in the write-ahead log that have not been acknowledged by Kafka by the time
+// the producer is stopped stay there to be submitted after restart.
 func (p *T) Stop() {
+	if p.wal != nil {
+		close(p.walStopCh)
+		p.walDrainerWG.Wait()
+	}
 	close(p.dispatcherCh)
 	p.wg.Wait()
+	if p.wal != nil {
+		if err := p.wal.Close(); err != nil {
+			log.Errorf("<%v> Failed to close WAL: err=(%s)", p.walDrainerActorID, err)
+		}
+	}
+	p.deadLetters.Stop()
 }
 
 // Produce submits a message to the specified `topic` of the Kafka cluster
@@ -105,15 +169,83 @@ func (p *T) Produce(topic string, key, message sarama.Encoder) (*sarama.Producer
 	return result.Msg, result.Err
 }
 
+// ProduceBatch submits several messages to the Kafka cluster at once and
+// waits until each of them is either committed or failed. Messages are
+// submitted in the order they are given, and partitions and offsets of
+// committed messages are set in the respective `prodMsgs` elements. The
+// returned slice has an error for every message that failed, and `nil` for
+// every message that was committed.
+func (p *T) ProduceBatch(prodMsgs []*sarama.ProducerMessage) []error {
+	replyCh := make(chan produceResult, len(prodMsgs))
+	indexes := make(map[*sarama.ProducerMessage]int, len(prodMsgs))
+	for i, prodMsg := range prodMsgs {
+		prodMsg.Metadata = replyCh
+		indexes[prodMsg] = i
+		p.dispatcherCh <- prodMsg
+	}
+	errs := make([]error, len(prodMsgs))
+	for range prodMsgs {
+		result := <-replyCh
+		errs[indexes[result.Msg]] = result.Err
+	}
+	return errs
+}
+
 // AsyncProduce is an asynchronously counterpart of the `Produce` function.
-// Errors are silently ignored.
-func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) {
+// Errors of producing to Kafka are silently ignored. If the write-ahead log is
+// enabled, then the message is persisted to the log before the function
+// returns, and an error is returned if that fails.
+func (p *T) AsyncProduce(topic string, key, message sarama.Encoder) error {
+	if p.wal != nil {
+		rec := wal.Record{Topic: topic}
+		var err error
+		if key != nil {
+			if rec.Key, err = key.Encode(); err != nil {
+				return fmt.Errorf("failed to encode key, err=(%s)", err)
+			}
+			if rec.Key == nil {
+				rec.Key = []byte{}
+			}
+		}
+		if message != nil {
+			if rec.Value, err = message.Encode(); err != nil {
+				return fmt.Errorf("failed to encode message, err=(%s)", err)
+			}
+		}
+		_, err = p.wal.Append(rec)
+		return err
+	}
 	prodMsg := &sarama.ProducerMessage{
 		Topic: topic,
 		Key:   key,
 		Value: message,
 	}
 	p.dispatcherCh <- prodMsg
+	return nil
+}
+
+// ListDeadLetters returns messages that could not be delivered to Kafka and
+// were put to the dead letter spool file. If dead letters are not spooled,
+// then `deadletter.ErrNotSpooled` is returned.
+func (p *T) ListDeadLetters() ([]deadletter.Record, error) {
+	return p.deadLetters.List()
+}
+
+// ReplayDeadLetters produces messages from the dead letter spool file to
+// their original topics again. Messages that fail again are returned to the
+// spool. It returns the number of successfully replayed messages and the
+// number of those that failed.
+func (p *T) ReplayDeadLetters() (int, int, error) {
+	return p.deadLetters.Replay(func(records []deadletter.Record) []error {
+		prodMsgs := make([]*sarama.ProducerMessage, len(records))
+		for i, rec := range records {
+			prodMsgs[i] = &sarama.ProducerMessage{Topic: rec.Topic, Value: sarama.ByteEncoder(rec.Value)}
+			if rec.Key != nil {
+				prodMsgs[i].Key = sarama.ByteEncoder(rec.Key)
+			}
+		}
+		return p.ProduceBatch(prodMsgs)
+	})
 }
 
 // merge receives both message acknowledgements and producer errors from the
@@ -205,23 +337,163 @@ shutdownNow:
 	}
 }
 
+// runWALDrainer reads messages from the write-ahead log and submits them to
+// the dispatcher. The number of messages submitted but not acknowledged yet
+// is limited by the size of `walInflightCh`. When some messages fail with a
+// retriable error, the drainer stops reading the log and resubmits the failed
+// messages after `retryBackoff`.
+func (p *T) runWALDrainer() {
+	var nilOrRetryTimeoutCh <-chan time.Time
+	for {
+		if nilOrRetryTimeoutCh == nil {
+			if p.hasWALRetries() {
+				nilOrRetryTimeoutCh = time.After(p.retryBackoff)
+			} else if !p.submitFromWAL() {
+				return
+			}
+		}
+		select {
+		case <-p.wal.NotifyCh():
+		case <-p.walRetryCh:
+		case <-nilOrRetryTimeoutCh:
+			nilOrRetryTimeoutCh = nil
+			p.walRetriesLock.Lock()
+			retries := p.walRetries
+			p.walRetries = nil
+			p.walRetriesLock.Unlock()
+			log.Infof("<%v> Resubmitting messages: count=%d", p.walDrainerActorID, len(retries))
+			for _, prodMsg := range retries {
+				p.dispatcherCh <- prodMsg
+			}
+		case <-p.walStopCh:
+			return
+		}
+	}
+}
+
+// submitFromWAL submits all available messages from the write-ahead log to
+// the dispatcher. It returns early if there are messages to be retried, and
+// returns false if the producer is stopping.
+func (p *T) submitFromWAL() bool {
+	for !p.hasWALRetries() {
+		// Acquire an inflight slot before reading a record, so that the
+		// record is not lost if the producer is stopped meanwhile.
+		select {
+		case p.walInflightCh <- none.V:
+		case <-p.walRetryCh:
+			return true
+		case <-p.walStopCh:
+			return false
+		}
+		rec, ok, err := p.wal.Next()
+		if err != nil || !ok {
+			<-p.walInflightCh
+			if err != nil {
+				log.Errorf("<%v> Failed to read WAL: err=(%s)", p.walDrainerActorID, err)
+			}
+			return true
+		}
+		prodMsg := &sarama.ProducerMessage{
+			Topic:    rec.Topic,
+			Value:    sarama.ByteEncoder(rec.Value),
+			Metadata: walSeq(rec.Seq),
+		}
+		if rec.Key != nil {
+			prodMsg.Key = sarama.ByteEncoder(rec.Key)
+		}
+		p.dispatcherCh <- prodMsg
+	}
+	return true
+}
+
+func (p *T) hasWALRetries() bool {
+	p.walRetriesLock.Lock()
+	defer p.walRetriesLock.Unlock()
+	return len(p.walRetries) > 0
+}
+
+// retryWALMessage queues a message submitted from the write-ahead log to be
+// resubmitted by the WAL drainer. A new message instance is created, for
+// `sarama.AsyncProducer` keeps internal state in submitted messages.
+func (p *T) retryWALMessage(prodMsg *sarama.ProducerMessage) {
+	p.walRetriesLock.Lock()
+	p.walRetries = append(p.walRetries, &sarama.ProducerMessage{
+		Topic:    prodMsg.Topic,
+		Key:      prodMsg.Key,
+		Value:    prodMsg.Value,
+		Metadata: prodMsg.Metadata,
+	})
+	p.walRetriesLock.Unlock()
+	select {
+	case p.walRetryCh <- none.V:
+	default:
+	}
+}
+
+// releaseWALMessage removes a message submitted from the write-ahead log from
+// the log once it is either produced or handed over to dead letter handling.
+func (p *T) releaseWALMessage(seq walSeq) {
+	p.wal.Ack(uint64(seq))
+	<-p.walInflightCh
+}
+
 // handleProduceResult inspects a production results and if it is an error
 // then logs it and flushes it down the `deadMessageCh` if one had been
-// configured.
+// configured. Failed asynchronously produced messages are also handed over to
+// the dead letter handler, whereas synchronous producers get the error.
+// Messages from the write-ahead log that failed with a retriable error are
+// queued for resubmission instead.
 func (p *T) handleProduceResult(result produceResult) {
-	if replyCh, ok := result.Msg.Metadata.(chan produceResult); ok {
+	replyCh, isSync := result.Msg.Metadata.(chan produceResult)
+	if isSync {
 		replyCh <- result
 	}
+	seq, isWAL := result.Msg.Metadata.(walSeq)
 	if result.Err == nil {
+		var size int
+		if result.Msg.Key != nil {
+			size += result.Msg.Key.Length()
+		}
+		if result.Msg.Value != nil {
+			size += result.Msg.Value.Length()
+		}
+		metrics.ProducedMessages.WithLabelValues(result.Msg.Topic).Inc()
+		metrics.ProducedBytes.WithLabelValues(result.Msg.Topic).Add(float64(size))
+		if isWAL {
+			p.releaseWALMessage(seq)
+		}
 		return
 	}
+	metrics.ProduceErrors.WithLabelValues(result.Msg.Topic).Inc()
 	prodMsgRepr := fmt.Sprintf(`{Topic: "%s", Key: "%s", Value: "%s"}`,
 		result.Msg.Topic, encoderRepr(result.Msg.Key), encoderRepr(result.Msg.Value))
 	log.Errorf("<%v> Failed to submit message: msg=%v, err=(%s)",
 		p.dispatcherActorID, prodMsgRepr, result.Err)
+	if isWAL && isRetriable(result.Err) {
+		p.retryWALMessage(result.Msg)
+		return
+	}
 	if p.deadMessageCh != nil {
 		p.deadMessageCh <- result.Msg
 	}
+	if !isSync {
+		p.deadLetters.Put(result.Msg, result.Err)
+	}
+	if isWAL {
+		p.releaseWALMessage(seq)
+	}
+}
+
+// isRetriable tells whether producing a message that failed with the
+// specified error may succeed if attempted again later.
+func isRetriable(err error) bool {
+	switch err {
+	case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidTopic,
+		sarama.ErrInvalidMessage, sarama.ErrInvalidMessageSize,
+		sarama.ErrMessageSizeTooLarge, sarama.ErrMessageSetSizeTooLarge:
+		return false
+	}
+	return true
 }
 
 // encoderRepr returns the string representation of an encoder value. The value
@@ -2,11 +2,14 @@ package producer
 
 import (
 	"fmt"
+	"path"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/producer/wal"
 	"github.com/mailgun/kafka-pixy/testhelpers"
 	"github.com/mailgun/kafka-pixy/testhelpers/kafkahelper"
 	. "gopkg.in/check.v1"
@@ -76,6 +79,106 @@ func (s *ProducerSuite) TestProduceInvalidTopic(c *C) {
 	p.Stop()
 }
 
+// A batch of messages is submitted in order, and partitions and offsets are
+// set in committed messages.
+func (s *ProducerSuite) TestProduceBatch(c *C) {
+	// Given
+	p, _ := Spawn(s.cfg)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+	prodMsgs := []*sarama.ProducerMessage{
+		{Topic: "test.4", Key: sarama.StringEncoder("1"), Value: sarama.StringEncoder("Foo")},
+		{Topic: "no-such-topic", Key: sarama.StringEncoder("1"), Value: sarama.StringEncoder("Bar")},
+		{Topic: "test.4", Key: sarama.StringEncoder("1"), Value: sarama.StringEncoder("Bazz")},
+	}
+	// When
+	errs := p.ProduceBatch(prodMsgs)
+	// Then
+	c.Assert(errs, DeepEquals, []error{nil, sarama.ErrUnknownTopicOrPartition, nil})
+	c.Assert(prodMsgs[0].Partition, Equals, int32(0))
+	c.Assert(prodMsgs[0].Offset, Equals, offsetsBefore[0])
+	c.Assert(prodMsgs[2].Partition, Equals, int32(0))
+	c.Assert(prodMsgs[2].Offset, Equals, offsetsBefore[0]+1)
+	// Cleanup
+	p.Stop()
+}
+
+// Asynchronously produced messages that failed are spooled, and can be
+// replayed after the problem is fixed.
+func (s *ProducerSuite) TestAsyncProduceDeadLetters(c *C) {
+	// Given
+	s.cfg.Producer.DeadLetter.Mode = config.DeadLetterSpool
+	s.cfg.Producer.DeadLetter.SpoolFile = path.Join(c.MkDir(), "dead_letters")
+	p, _ := Spawn(s.cfg)
+	p.AsyncProduce("no-such-topic", sarama.StringEncoder("1"), sarama.StringEncoder("Foo"))
+	p.Stop()
+	p, _ = Spawn(s.cfg)
+	defer p.Stop()
+	// When
+	records, err := p.ListDeadLetters()
+	replayed, failed, replayErr := p.ReplayDeadLetters()
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 1)
+	c.Assert(records[0].Topic, Equals, "no-such-topic")
+	c.Assert(string(records[0].Key), Equals, "1")
+	c.Assert(string(records[0].Value), Equals, "Foo")
+	c.Assert(records[0].Error, Equals, sarama.ErrUnknownTopicOrPartition.Error())
+	c.Assert(replayErr, IsNil)
+	c.Assert(replayed, Equals, 0)
+	c.Assert(failed, Equals, 1)
+}
+
+// Messages left in the write-ahead log by a previous run are produced after
+// restart, and the log is truncated as they are acknowledged by Kafka.
+func (s *ProducerSuite) TestAsyncProduceWAL(c *C) {
+	// Given
+	s.cfg.Producer.WAL.Dir = c.MkDir()
+	w, err := wal.Open(s.cfg.Producer.WAL.Dir, s.cfg.Producer.WAL.SegmentSize, false)
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		_, err := w.Append(wal.Record{Topic: "test.4", Key: []byte("1"), Value: []byte(strconv.Itoa(i))})
+		c.Assert(err, IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+	// When
+	p, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(p.AsyncProduce("test.4", sarama.StringEncoder("1"), sarama.StringEncoder("10")), IsNil)
+	for i := 0; i < 100 && p.wal.Pending() > 0; i++ {
+		<-time.After(100 * time.Millisecond)
+	}
+	p.Stop()
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+	// Then
+	c.Assert(s.failedMessages(), DeepEquals, []string{})
+	c.Assert(offsetsAfter[0], Equals, offsetsBefore[0]+11)
+	w, err = wal.Open(s.cfg.Producer.WAL.Dir, s.cfg.Producer.WAL.SegmentSize, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	c.Assert(w.Pending(), Equals, 0)
+}
+
+// Messages from the write-ahead log that fail with a permanent error are
+// handed over to dead letter handling and removed from the log.
+func (s *ProducerSuite) TestAsyncProduceWALPermanentError(c *C) {
+	// Given
+	s.cfg.Producer.WAL.Dir = c.MkDir()
+	p, err := Spawn(s.cfg)
+	c.Assert(err, IsNil)
+	// When
+	c.Assert(p.AsyncProduce("no-such-topic", sarama.StringEncoder("1"), sarama.StringEncoder("Foo")), IsNil)
+	for i := 0; i < 100 && p.wal.Pending() > 0; i++ {
+		<-time.After(100 * time.Millisecond)
+	}
+	p.Stop()
+	// Then
+	c.Assert(len(s.deadMessageCh), Equals, 1)
+	deadMsg := <-s.deadMessageCh
+	c.Assert(deadMsg.Topic, Equals, "no-such-topic")
+	c.Assert(string(deadMsg.Value.(sarama.ByteEncoder)), Equals, "Foo")
+}
+
 // If `key` is not `nil` then produced messages are deterministically
 // distributed between partitions based on the `key` hash.
 func (s *ProducerSuite) TestAsyncProduce(c *C) {
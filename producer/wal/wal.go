@@ -0,0 +1,486 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/kafka-pixy/metrics"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+const (
+	segmentExt     = ".wal"
+	checkpointFile = "checkpoint"
+	headerSize     = 8 // payload length + CRC32
+	// maxPayloadSize protects from allocating huge buffers when a corrupted
+	// payload length is read.
+	maxPayloadSize = 1 << 30
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// Record is an asynchronously produced message persisted in the write-ahead
+// log.
+type Record struct {
+	// Seq is a sequence number assigned to the record by `Append`.
+	Seq   uint64
+	Topic string
+	// Key is nil if the message should be produced to a random partition.
+	Key   []byte
+	Value []byte
+}
+
+// T is a write-ahead log of messages to be produced to Kafka. Records are
+// appended to segment files, each named after the sequence number of its
+// first record. Records are read by a single reader with `Next` in the order
+// they were appended, and acknowledged with `Ack` once they are produced.
+// Segment files are deleted when all their records are acknowledged.
+//
+// The sequence number of the oldest record that has not been acknowledged is
+// persisted in a checkpoint file whenever a segment is deleted and when the
+// log is closed. When a log is opened, all records starting from the
+// checkpoint are offered by `Next` again, therefore records acknowledged
+// after the last checkpoint are read again if Kafka-Pixy crashes.
+type T struct {
+	dir         string
+	segmentSize int64
+	fsync       bool
+	lock        sync.Mutex
+	// First sequence numbers of segment files in ascending order. The last
+	// one is the active segment that records are appended to.
+	segments   []uint64
+	active     *os.File
+	activeSize int64
+	nextSeq    uint64
+	// All records with sequence numbers lower then the watermark have been
+	// acknowledged.
+	watermark  uint64
+	acked      map[uint64]bool
+	readSeg    uint64
+	readFile   *os.File
+	readOffset int64
+	notifyCh   chan none.T
+}
+
+// Open opens a write-ahead log in the specified directory, creating the
+// directory if it does not exist. If the last segment file ends with a
+// partially written record, then the record is discarded.
+func Open(dir string, segmentSize int, fsync bool) (*T, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory, err=(%s)", err)
+	}
+	w := &T{
+		dir:         dir,
+		segmentSize: int64(segmentSize),
+		fsync:       fsync,
+		acked:       make(map[uint64]bool),
+		notifyCh:    make(chan none.T, 1),
+	}
+	var err error
+	if w.watermark, err = w.readCheckpoint(); err != nil {
+		return nil, err
+	}
+	if w.segments, err = w.listSegments(); err != nil {
+		return nil, err
+	}
+	if err = w.deleteAckedSegments(); err != nil {
+		return nil, err
+	}
+	if len(w.segments) == 0 {
+		w.nextSeq = w.watermark
+		if err := w.startSegment(); err != nil {
+			return nil, err
+		}
+	} else if err := w.openLastSegment(); err != nil {
+		return nil, err
+	}
+	if w.nextSeq < w.watermark {
+		w.nextSeq = w.watermark
+	}
+	w.readSeg = w.segments[0]
+	w.updatePendingMetric()
+	if pending := w.nextSeq - w.watermark; pending > 0 {
+		log.Infof("WAL opened with pending records: dir=%s, count=%d", dir, pending)
+	}
+	return w, nil
+}
+
+// Append writes a record to the log and assigns it a sequence number. Once it
+// returns successfully, the record is going to be offered by `Next`.
+func (w *T) Append(rec Record) (uint64, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.active == nil {
+		return 0, errors.New("WAL is closed")
+	}
+	rec.Seq = w.nextSeq
+	encoded := encodeRecord(rec)
+	if w.activeSize > 0 && w.activeSize+int64(len(encoded)) > w.segmentSize {
+		if err := w.startSegment(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := w.active.Write(encoded); err != nil {
+		// Cut off whatever part of the record has been written.
+		w.active.Truncate(w.activeSize)
+		return 0, fmt.Errorf("failed to write WAL record, err=(%s)", err)
+	}
+	if w.fsync {
+		if err := w.active.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync WAL segment, err=(%s)", err)
+		}
+	}
+	w.activeSize += int64(len(encoded))
+	w.nextSeq++
+	w.updatePendingMetric()
+	select {
+	case w.notifyCh <- none.V:
+	default:
+	}
+	return rec.Seq, nil
+}
+
+// Next returns the next record in the log, or false if all appended records
+// have been read already. If a corrupted record is found, then the rest of
+// the segment it belongs to is skipped.
+func (w *T) Next() (Record, bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for {
+		if w.readFile == nil {
+			file, err := os.Open(w.segmentPath(w.readSeg))
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return Record{}, false, fmt.Errorf("failed to open WAL segment, err=(%s)", err)
+				}
+				// The segment has been deleted, move on to the next one.
+				if !w.nextReadSegment() {
+					return Record{}, false, nil
+				}
+				continue
+			}
+			w.readFile, w.readOffset = file, 0
+		}
+		rec, size, err := readRecordAt(w.readFile, w.readOffset)
+		if err == nil {
+			w.readOffset += size
+			if rec.Seq < w.watermark {
+				// Acknowledged before the log was opened.
+				continue
+			}
+			return rec, true, nil
+		}
+		if err == errCorrupted {
+			log.Errorf("WAL segment corrupted, skipping the rest: segment=%s, offset=%d",
+				w.segmentPath(w.readSeg), w.readOffset)
+		} else if err != io.EOF {
+			return Record{}, false, fmt.Errorf("failed to read WAL segment, err=(%s)", err)
+		}
+		if !w.nextReadSegment() {
+			return Record{}, false, nil
+		}
+	}
+}
+
+// Ack acknowledges that a record has been produced to Kafka. Segment files
+// are deleted as soon as all their records are acknowledged.
+func (w *T) Ack(seq uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if seq < w.watermark {
+		return
+	}
+	w.acked[seq] = true
+	for w.acked[w.watermark] {
+		delete(w.acked, w.watermark)
+		w.watermark++
+	}
+	w.updatePendingMetric()
+	if err := w.deleteAckedSegments(); err != nil {
+		log.Errorf("Failed to delete WAL segments: err=(%s)", err)
+	}
+}
+
+// NotifyCh returns a channel that gets a notification when a record is
+// appended to the log. Notifications are coalesced, so there may be more than
+// one record available when one is received.
+func (w *T) NotifyCh() <-chan none.T {
+	return w.notifyCh
+}
+
+// Pending returns the number of records that have not been acknowledged yet.
+func (w *T) Pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return int(w.nextSeq - w.watermark)
+}
+
+// Close persists the checkpoint and closes all files. Records appended after
+// a log is closed are rejected.
+func (w *T) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.readFile != nil {
+		w.readFile.Close()
+		w.readFile = nil
+	}
+	if w.active != nil {
+		w.active.Close()
+		w.active = nil
+	}
+	return w.writeCheckpoint()
+}
+
+// nextReadSegment moves the reader to the segment following the one being
+// read. It returns false if the reader is at the active segment.
+func (w *T) nextReadSegment() bool {
+	for _, seg := range w.segments {
+		if seg > w.readSeg {
+			if w.readFile != nil {
+				w.readFile.Close()
+				w.readFile = nil
+			}
+			w.readSeg, w.readOffset = seg, 0
+			return true
+		}
+	}
+	return false
+}
+
+// startSegment closes the active segment file if any, and starts a new one
+// named after the next sequence number.
+func (w *T) startSegment() error {
+	file, err := os.OpenFile(w.segmentPath(w.nextSeq), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment, err=(%s)", err)
+	}
+	if w.active != nil {
+		w.active.Close()
+	}
+	w.active, w.activeSize = file, 0
+	w.segments = append(w.segments, w.nextSeq)
+	return nil
+}
+
+// openLastSegment opens the last segment file for appending. It scans the
+// segment to find the next sequence number, and truncates the segment if it
+// ends with a partially written or corrupted record.
+func (w *T) openLastSegment() error {
+	first := w.segments[len(w.segments)-1]
+	path := w.segmentPath(first)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segment, err=(%s)", err)
+	}
+	var offset int64
+	w.nextSeq = first
+	for {
+		rec, size, err := readRecordAt(file, offset)
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("WAL segment has a broken tail, truncating: segment=%s, offset=%d, err=(%s)",
+					path, offset, err)
+			}
+			break
+		}
+		offset += size
+		w.nextSeq = rec.Seq + 1
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to truncate WAL segment, err=(%s)", err)
+	}
+	w.active, w.activeSize = file, offset
+	return nil
+}
+
+// deleteAckedSegments deletes all segment files except the active one, whose
+// records have all been acknowledged, and updates the checkpoint if any have
+// been deleted.
+func (w *T) deleteAckedSegments() error {
+	deleted := false
+	for len(w.segments) > 1 && w.segments[1] <= w.watermark {
+		if err := os.Remove(w.segmentPath(w.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.segments = w.segments[1:]
+		deleted = true
+	}
+	if deleted {
+		return w.writeCheckpoint()
+	}
+	return nil
+}
+
+func (w *T) listSegments() ([]uint64, error) {
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL directory, err=(%s)", err)
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			log.Errorf("Unexpected file in WAL directory: %s", name)
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Sort(seqSlice(segments))
+	return segments, nil
+}
+
+func (w *T) readCheckpoint() (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(w.dir, checkpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read WAL checkpoint, err=(%s)", err)
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid WAL checkpoint: %q", data)
+	}
+	return seq, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint file with the current
+// watermark.
+func (w *T) writeCheckpoint() error {
+	path := filepath.Join(w.dir, checkpointFile)
+	tmpPath := path + ".tmp"
+	data := []byte(strconv.FormatUint(w.watermark, 10) + "\n")
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write WAL checkpoint, err=(%s)", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write WAL checkpoint, err=(%s)", err)
+	}
+	return nil
+}
+
+func (w *T) segmentPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func (w *T) updatePendingMetric() {
+	metrics.WALPending.Set(float64(w.nextSeq - w.watermark))
+}
+
+// encodeRecord serializes a record into a frame that consists of the payload
+// length, the payload CRC32, and the payload itself. The payload is the
+// sequence number, the topic, the key and the value. The key length is -1 if
+// the key is nil.
+func encodeRecord(rec Record) []byte {
+	payloadSize := 8 + 2 + len(rec.Topic) + 4 + len(rec.Key) + 4 + len(rec.Value)
+	buf := make([]byte, headerSize+payloadSize)
+	payload := buf[headerSize:]
+	binary.BigEndian.PutUint64(payload, rec.Seq)
+	i := 8
+	binary.BigEndian.PutUint16(payload[i:], uint16(len(rec.Topic)))
+	i += 2
+	i += copy(payload[i:], rec.Topic)
+	keySize := int32(-1)
+	if rec.Key != nil {
+		keySize = int32(len(rec.Key))
+	}
+	binary.BigEndian.PutUint32(payload[i:], uint32(keySize))
+	i += 4
+	i += copy(payload[i:], rec.Key)
+	binary.BigEndian.PutUint32(payload[i:], uint32(len(rec.Value)))
+	i += 4
+	copy(payload[i:], rec.Value)
+	binary.BigEndian.PutUint32(buf, uint32(payloadSize))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+// readRecordAt reads a record frame at the specified offset of a segment
+// file. It returns the record and the frame size. `io.EOF` is returned if
+// there are no more complete records in the segment, and `errCorrupted` if
+// the payload does not match its CRC32.
+func readRecordAt(r io.ReaderAt, offset int64) (Record, int64, error) {
+	var header [headerSize]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		if err == io.EOF {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, err
+	}
+	payloadSize := binary.BigEndian.Uint32(header[:])
+	if payloadSize > maxPayloadSize {
+		return Record{}, 0, errCorrupted
+	}
+	payload := make([]byte, payloadSize)
+	if _, err := r.ReadAt(payload, offset+headerSize); err != nil {
+		if err == io.EOF {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return Record{}, 0, errCorrupted
+	}
+	rec, err := decodePayload(payload)
+	if err != nil {
+		return Record{}, 0, err
+	}
+	return rec, int64(headerSize + payloadSize), nil
+}
+
+func decodePayload(payload []byte) (Record, error) {
+	var rec Record
+	if len(payload) < 8+2 {
+		return rec, errCorrupted
+	}
+	rec.Seq = binary.BigEndian.Uint64(payload)
+	i := 8
+	topicSize := int(binary.BigEndian.Uint16(payload[i:]))
+	i += 2
+	if len(payload) < i+topicSize+4 {
+		return rec, errCorrupted
+	}
+	rec.Topic = string(payload[i : i+topicSize])
+	i += topicSize
+	keySize := int32(binary.BigEndian.Uint32(payload[i:]))
+	i += 4
+	if keySize >= 0 {
+		if len(payload) < i+int(keySize)+4 {
+			return rec, errCorrupted
+		}
+		rec.Key = payload[i : i+int(keySize)]
+		i += int(keySize)
+	}
+	if len(payload) < i+4 {
+		return rec, errCorrupted
+	}
+	valueSize := int(binary.BigEndian.Uint32(payload[i:]))
+	i += 4
+	if len(payload) != i+valueSize {
+		return rec, errCorrupted
+	}
+	rec.Value = payload[i:]
+	return rec, nil
+}
+
+type seqSlice []uint64
+
+func (p seqSlice) Len() int           { return len(p) }
+func (p seqSlice) Less(i, j int) bool { return p[i] < p[j] }
+func (p seqSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
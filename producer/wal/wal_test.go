@@ -0,0 +1,253 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mailgun/kafka-pixy/testhelpers"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type WALSuite struct {
+	dir string
+}
+
+var _ = Suite(&WALSuite{})
+
+func (s *WALSuite) SetUpSuite(c *C) {
+	testhelpers.InitLogging(c)
+}
+
+func (s *WALSuite) SetUpTest(c *C) {
+	s.dir = filepath.Join(c.MkDir(), "wal")
+}
+
+// Records are read in the order they were appended, and the difference
+// between an empty and an undefined key is preserved.
+func (s *WALSuite) TestAppendAndNext(c *C) {
+	w, err := Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// When
+	seq0, err := w.Append(Record{Topic: "foo", Key: []byte("1"), Value: []byte("A")})
+	c.Assert(err, IsNil)
+	seq1, err := w.Append(Record{Topic: "bar", Value: []byte("B")})
+	c.Assert(err, IsNil)
+	seq2, err := w.Append(Record{Topic: "foo", Key: []byte{}, Value: []byte{}})
+	c.Assert(err, IsNil)
+
+	// Then
+	c.Assert([]uint64{seq0, seq1, seq2}, DeepEquals, []uint64{0, 1, 2})
+	c.Assert(w.Pending(), Equals, 3)
+	rec, ok, err := w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(rec, DeepEquals, Record{Seq: 0, Topic: "foo", Key: []byte("1"), Value: []byte("A")})
+	rec, _, _ = w.Next()
+	c.Assert(rec.Topic, Equals, "bar")
+	c.Assert(rec.Key, IsNil)
+	rec, _, _ = w.Next()
+	c.Assert(rec.Key, DeepEquals, []byte{})
+	c.Assert(rec.Value, DeepEquals, []byte{})
+	_, ok, err = w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+}
+
+// A notification is sent when a record is appended.
+func (s *WALSuite) TestNotify(c *C) {
+	w, err := Open(s.dir, 1024, true)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// When
+	w.Append(Record{Topic: "foo", Value: []byte("A")})
+	w.Append(Record{Topic: "foo", Value: []byte("B")})
+
+	// Then
+	select {
+	case <-w.NotifyCh():
+	default:
+		c.Error("Notification expected")
+	}
+	select {
+	case <-w.NotifyCh():
+		c.Error("Notifications are supposed to be coalesced")
+	default:
+	}
+}
+
+// New segment files are started when the active one grows too large, and the
+// reader moves on to them.
+func (s *WALSuite) TestSegmentRotation(c *C) {
+	w, err := Open(s.dir, 100, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// When
+	for i := 0; i < 10; i++ {
+		_, err := w.Append(Record{Topic: "foo", Value: make([]byte, 20)})
+		c.Assert(err, IsNil)
+	}
+
+	// Then
+	c.Assert(s.segmentFiles(c), DeepEquals, []string{
+		"00000000000000000000.wal",
+		"00000000000000000002.wal",
+		"00000000000000000004.wal",
+		"00000000000000000006.wal",
+		"00000000000000000008.wal",
+	})
+	for i := 0; i < 10; i++ {
+		rec, ok, err := w.Next()
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+		c.Assert(rec.Seq, Equals, uint64(i))
+	}
+	_, ok, _ := w.Next()
+	c.Assert(ok, Equals, false)
+}
+
+// A segment file is deleted as soon as all its records are acknowledged,
+// regardless of the order acknowledgements come in.
+func (s *WALSuite) TestAckDeletesSegments(c *C) {
+	w, err := Open(s.dir, 100, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	for i := 0; i < 6; i++ {
+		w.Append(Record{Topic: "foo", Value: make([]byte, 20)})
+	}
+
+	// When
+	w.Ack(1)
+	w.Ack(2)
+	w.Ack(3)
+
+	// Then
+	c.Assert(len(s.segmentFiles(c)), Equals, 3)
+	c.Assert(w.Pending(), Equals, 6)
+
+	// When
+	w.Ack(0)
+
+	// Then
+	c.Assert(s.segmentFiles(c), DeepEquals, []string{"00000000000000000004.wal"})
+	c.Assert(w.Pending(), Equals, 2)
+}
+
+// Records that have not been acknowledged are read again after the log is
+// reopened, and sequence numbers keep growing.
+func (s *WALSuite) TestReopen(c *C) {
+	w, err := Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	for _, v := range []string{"A", "B", "C"} {
+		w.Append(Record{Topic: "foo", Value: []byte(v)})
+	}
+	w.Next()
+	w.Ack(0)
+	c.Assert(w.Close(), IsNil)
+
+	// When
+	w, err = Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// Then
+	c.Assert(w.Pending(), Equals, 2)
+	rec, ok, err := w.Next()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(rec.Seq, Equals, uint64(1))
+	c.Assert(string(rec.Value), Equals, "B")
+	seq, err := w.Append(Record{Topic: "foo", Value: []byte("D")})
+	c.Assert(err, IsNil)
+	c.Assert(seq, Equals, uint64(3))
+}
+
+// If all records were acknowledged and their segments deleted, then
+// sequence numbers continue from the checkpoint after reopen.
+func (s *WALSuite) TestReopenEmpty(c *C) {
+	w, err := Open(s.dir, 50, false)
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		w.Append(Record{Topic: "foo", Value: make([]byte, 40)})
+		w.Ack(uint64(i))
+	}
+	c.Assert(w.Close(), IsNil)
+
+	// When
+	w, err = Open(s.dir, 50, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// Then
+	c.Assert(w.Pending(), Equals, 0)
+	_, ok, _ := w.Next()
+	c.Assert(ok, Equals, false)
+	seq, err := w.Append(Record{Topic: "foo", Value: []byte("A")})
+	c.Assert(err, IsNil)
+	c.Assert(seq, Equals, uint64(3))
+}
+
+// A partially written record at the end of the last segment, e.g. left by a
+// crash, is discarded on open.
+func (s *WALSuite) TestTornTail(c *C) {
+	w, err := Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	w.Append(Record{Topic: "foo", Value: []byte("A")})
+	w.Append(Record{Topic: "foo", Value: []byte("B")})
+	c.Assert(w.Close(), IsNil)
+	segment := filepath.Join(s.dir, "00000000000000000000.wal")
+	info, err := os.Stat(segment)
+	c.Assert(err, IsNil)
+	c.Assert(os.Truncate(segment, info.Size()-3), IsNil)
+
+	// When
+	w, err = Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	// Then
+	c.Assert(w.Pending(), Equals, 1)
+	seq, err := w.Append(Record{Topic: "foo", Value: []byte("C")})
+	c.Assert(err, IsNil)
+	c.Assert(seq, Equals, uint64(1))
+	rec, _, _ := w.Next()
+	c.Assert(string(rec.Value), Equals, "A")
+	rec, _, _ = w.Next()
+	c.Assert(string(rec.Value), Equals, "C")
+	_, ok, _ := w.Next()
+	c.Assert(ok, Equals, false)
+}
+
+// Records are not accepted after the log is closed.
+func (s *WALSuite) TestAppendClosed(c *C) {
+	w, err := Open(s.dir, 1024, false)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	// When
+	_, err = w.Append(Record{Topic: "foo", Value: []byte("A")})
+
+	// Then
+	c.Assert(err, ErrorMatches, "WAL is closed")
+}
+
+func (s *WALSuite) segmentFiles(c *C) []string {
+	entries, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	var names []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == segmentExt {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
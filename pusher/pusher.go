@@ -0,0 +1,337 @@
+package pusher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/actor"
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	"github.com/mailgun/kafka-pixy/none"
+	"github.com/mailgun/log"
+)
+
+const (
+	defaultConcurrency     = 1
+	defaultTimeout         = 30 * time.Second
+	defaultRetryBackoff    = time.Second
+	defaultRetryBackoffMax = time.Minute
+)
+
+// T delivers messages of push subscriptions defined in
+// `Config.PushSubscriptions` to webhooks. Every subscription is served by a
+// number of workers, each of them consumes a message in the explicit
+// acknowledgement mode and POSTs it to the webhook. The message is
+// acknowledged as soon as the webhook responds with a 2xx status, otherwise
+// delivery is retried with exponential backoff. If a subscription is
+// configured with `MaxRetries`, then a message that has run out of retries is
+// negatively acknowledged.
+//
+// A message that is not acknowledged within `Config.Consumer.AckTimeout` is
+// consumed again, possibly by another worker. So a worker stops retrying a
+// message before its ack timeout expires, and leaves further retries to the
+// worker that gets the message when it is consumed again. Failures of a
+// message are counted across such redeliveries, so that backoff and
+// `MaxRetries` apply to the message as a whole.
+type T struct {
+	actorID       *actor.ID
+	cfg           *config.T
+	cons          consumer.T
+	subscriptions []*subscription
+	stopCh        chan none.T
+	wg            sync.WaitGroup
+}
+
+// Status describes the state of a push subscription.
+type Status struct {
+	Group string `json:"group"`
+	Topic string `json:"topic"`
+	URL   string `json:"url"`
+	// The number of messages delivered to the webhook.
+	Delivered int64 `json:"delivered"`
+	// The number of failed delivery attempts.
+	Failures int64 `json:"failures"`
+	// The number of messages negatively acknowledged after running out of
+	// retries.
+	Nacked          int64      `json:"nacked"`
+	LastDeliveredAt *time.Time `json:"lastDeliveredAt,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+	LastErrorAt     *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+type subscription struct {
+	cfg          config.PushSubscription
+	httpClient   *http.Client
+	status       Status
+	statusLock   sync.Mutex
+	failures     map[msgID]failureRecord
+	failuresLock sync.Mutex
+}
+
+// msgID identifies a message of a push subscription.
+type msgID struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// failureRecord tracks failed delivery attempts of a message, that are
+// continued when the message is consumed again.
+type failureRecord struct {
+	count   int
+	retryAt time.Time
+}
+
+// pushedMessage is the body of a request made to a webhook. It has the same
+// structure as a consume response of the HTTP API.
+type pushedMessage struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Retries   int    `json:"retries,omitempty"`
+}
+
+// Spawn creates a pusher instance and starts workers of all configured push
+// subscriptions.
+func Spawn(cfg *config.T, cons consumer.T) *T {
+	p := &T{
+		actorID: actor.RootID.NewChild("pusher"),
+		cfg:     cfg,
+		cons:    cons,
+		stopCh:  make(chan none.T),
+	}
+	for _, subCfg := range cfg.PushSubscriptions {
+		sub := newSubscription(subCfg)
+		p.subscriptions = append(p.subscriptions, sub)
+		for i := 0; i < sub.cfg.Concurrency; i++ {
+			actorID := p.actorID.NewChild(sub.cfg.Group, sub.cfg.Topic, i)
+			actor.Spawn(actorID, &p.wg, func() { p.runWorker(actorID, sub) })
+		}
+	}
+	return p
+}
+
+// Status returns states of all push subscriptions in the order they are
+// configured.
+func (p *T) Status() []Status {
+	statuses := make([]Status, len(p.subscriptions))
+	for i, sub := range p.subscriptions {
+		sub.statusLock.Lock()
+		statuses[i] = sub.status
+		sub.statusLock.Unlock()
+	}
+	return statuses
+}
+
+// Stop makes all workers stop and waits for them to terminate. Messages that
+// have not been delivered by then are not acknowledged, so they are consumed
+// again later.
+func (p *T) Stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
+
+func (p *T) runWorker(actorID *actor.ID, sub *subscription) {
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		default:
+		}
+		msg, err := p.cons.ConsumeExplicitAck(sub.cfg.Group, sub.cfg.Topic)
+		if err != nil {
+			// Most likely the long polling timeout has expired, but
+			// backing off a bit is harmless even so.
+			select {
+			case <-time.After(p.cfg.Consumer.BackOffTimeout):
+			case <-p.stopCh:
+				return
+			}
+			continue
+		}
+		p.deliver(actorID, sub, msg)
+	}
+}
+
+// deliver keeps POSTing the message to the webhook of the subscription until
+// it succeeds, the message runs out of retries, the ack timeout of the
+// message is about to expire, or the pusher is stopped.
+func (p *T) deliver(actorID *actor.ID, sub *subscription, msg *consumer.Message) {
+	body, err := json.Marshal(pushedMessage{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Retries:   msg.Retries,
+	})
+	if err != nil {
+		// Must never happen, but if it does, then the message could never be
+		// delivered, so there is no point in retrying.
+		log.Errorf("<%s> failed to encode message, giving up: topic=%s, partition=%d, offset=%d, err=(%s)",
+			actorID, msg.Topic, msg.Partition, msg.Offset, err)
+		if err := p.cons.Nack(sub.cfg.Group, msg.Topic, msg.Partition, msg.Offset); err != nil {
+			log.Errorf("<%s> nack failed: err=(%s)", actorID, err)
+			return
+		}
+		sub.recordNack()
+		return
+	}
+	ackDeadline := time.Now().Add(p.cfg.Consumer.AckTimeout)
+	id := msgID{msg.Topic, msg.Partition, msg.Offset}
+	// If delivery of the message was retried before it was consumed again,
+	// then the backoff of the last failure is respected.
+	if wait := sub.retryAt(id).Sub(time.Now()); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-p.stopCh:
+			return
+		}
+	}
+	for {
+		err := sub.post(body)
+		if err == nil {
+			sub.recordDelivery(id)
+			if err := p.cons.Ack(sub.cfg.Group, msg.Topic, msg.Partition, msg.Offset); err != nil {
+				log.Infof("<%s> ack failed: err=(%s)", actorID, err)
+			}
+			return
+		}
+		failures, backoff := sub.recordFailure(id, err, p.cfg.Consumer.AckTimeout)
+		if sub.cfg.MaxRetries > 0 && failures > sub.cfg.MaxRetries {
+			log.Errorf("<%s> delivery failed, giving up: topic=%s, partition=%d, offset=%d, err=(%s)",
+				actorID, msg.Topic, msg.Partition, msg.Offset, err)
+			sub.forget(id)
+			if err := p.cons.Nack(sub.cfg.Group, msg.Topic, msg.Partition, msg.Offset); err != nil {
+				log.Errorf("<%s> nack failed: err=(%s)", actorID, err)
+				return
+			}
+			sub.recordNack()
+			return
+		}
+		// The next attempt must complete before the message is consumed
+		// again, for otherwise it could be delivered twice in parallel.
+		if time.Now().Add(backoff + sub.cfg.Timeout).After(ackDeadline) {
+			log.Infof("<%s> delivery postponed until redelivery: topic=%s, partition=%d, offset=%d, failures=%d",
+				actorID, msg.Topic, msg.Partition, msg.Offset, failures)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// newSubscription creates a push subscription with the unspecified
+// parameters set to their defaults.
+func newSubscription(cfg config.PushSubscription) *subscription {
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.RetryBackoffMax == 0 {
+		cfg.RetryBackoffMax = defaultRetryBackoffMax
+	}
+	return &subscription{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		status:     Status{Group: cfg.Group, Topic: cfg.Topic, URL: cfg.URL},
+		failures:   make(map[msgID]failureRecord),
+	}
+}
+
+// post sends a message to the webhook, and returns an error unless the
+// webhook responds with a 2xx status.
+func (sub *subscription) post(body []byte) error {
+	res, err := sub.httpClient.Post(sub.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// The body is drained to let the connection be reused.
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+func (sub *subscription) recordDelivery(id msgID) {
+	sub.forget(id)
+	now := time.Now().UTC()
+	sub.statusLock.Lock()
+	sub.status.Delivered++
+	sub.status.LastDeliveredAt = &now
+	sub.statusLock.Unlock()
+}
+
+// recordFailure counts a failed delivery attempt of the message, and returns
+// the number of failed attempts of the message so far along with the backoff
+// to wait before the next attempt. Records of messages that are acknowledged
+// by other instances are never forgotten explicitly, therefore records that
+// have not been updated for a long while relative to the ack timeout are
+// dropped.
+func (sub *subscription) recordFailure(id msgID, err error, ackTimeout time.Duration) (int, time.Duration) {
+	now := time.Now().UTC()
+	sub.statusLock.Lock()
+	sub.status.Failures++
+	sub.status.LastError = err.Error()
+	sub.status.LastErrorAt = &now
+	sub.statusLock.Unlock()
+
+	sub.failuresLock.Lock()
+	defer sub.failuresLock.Unlock()
+	staleBefore := now.Add(-2*ackTimeout - sub.cfg.RetryBackoffMax)
+	for staleID, record := range sub.failures {
+		if record.retryAt.Before(staleBefore) {
+			delete(sub.failures, staleID)
+		}
+	}
+	record := sub.failures[id]
+	record.count++
+	backoff := sub.cfg.RetryBackoff
+	for i := 1; i < record.count && backoff < sub.cfg.RetryBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > sub.cfg.RetryBackoffMax {
+		backoff = sub.cfg.RetryBackoffMax
+	}
+	record.retryAt = now.Add(backoff)
+	sub.failures[id] = record
+	return record.count, backoff
+}
+
+// retryAt returns the time when delivery of the message can be retried after
+// a failure. It is zero if delivery of the message has not failed.
+func (sub *subscription) retryAt(id msgID) time.Time {
+	sub.failuresLock.Lock()
+	defer sub.failuresLock.Unlock()
+	return sub.failures[id].retryAt
+}
+
+func (sub *subscription) forget(id msgID) {
+	sub.failuresLock.Lock()
+	delete(sub.failures, id)
+	sub.failuresLock.Unlock()
+}
+
+func (sub *subscription) recordNack() {
+	sub.statusLock.Lock()
+	sub.status.Nacked++
+	sub.statusLock.Unlock()
+}
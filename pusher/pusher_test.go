@@ -0,0 +1,287 @@
+package pusher
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/kafka-pixy/config"
+	"github.com/mailgun/kafka-pixy/consumer"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type PusherSuite struct {
+	cfg *config.T
+}
+
+var _ = Suite(&PusherSuite{})
+
+func (s *PusherSuite) SetUpTest(c *C) {
+	s.cfg = config.Default()
+	s.cfg.Consumer.BackOffTimeout = 10 * time.Millisecond
+}
+
+// A message is POSTed to the webhook and acknowledged once the webhook
+// responds with a 2xx status.
+func (s *PusherSuite) TestDelivered(c *C) {
+	// Given
+	var pushed []pushedMessage
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg pushedMessage
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Check(json.Unmarshal(body, &msg), IsNil)
+		pushed = append(pushed, msg)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+	s.cfg.PushSubscriptions = []config.PushSubscription{{Group: "g1", Topic: "t1", URL: webhook.URL}}
+	cons := newFakeConsumer(&consumer.Message{Key: []byte("k"), Value: []byte("v"), Topic: "t1", Partition: 2, Offset: 7})
+
+	// When
+	p := Spawn(s.cfg, cons)
+	<-cons.doneCh
+	p.Stop()
+
+	// Then
+	c.Assert(pushed, DeepEquals, []pushedMessage{{Key: []byte("k"), Value: []byte("v"), Topic: "t1", Partition: 2, Offset: 7}})
+	c.Assert(cons.acked, DeepEquals, []int64{7})
+	c.Assert(cons.nacked, IsNil)
+	status := p.Status()
+	c.Assert(len(status), Equals, 1)
+	c.Assert(status[0].Delivered, Equals, int64(1))
+	c.Assert(status[0].Failures, Equals, int64(0))
+	c.Assert(status[0].LastDeliveredAt, NotNil)
+}
+
+// Failed deliveries are retried until they succeed.
+func (s *PusherSuite) TestRetried(c *C) {
+	// Given
+	attempts := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts++; attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer webhook.Close()
+	s.cfg.PushSubscriptions = []config.PushSubscription{{
+		Group: "g1", Topic: "t1", URL: webhook.URL, RetryBackoff: time.Millisecond,
+	}}
+	cons := newFakeConsumer(&consumer.Message{Topic: "t1", Offset: 7})
+
+	// When
+	p := Spawn(s.cfg, cons)
+	<-cons.doneCh
+	p.Stop()
+
+	// Then
+	c.Assert(attempts, Equals, 3)
+	c.Assert(cons.acked, DeepEquals, []int64{7})
+	status := p.Status()
+	c.Assert(status[0].Delivered, Equals, int64(1))
+	c.Assert(status[0].Failures, Equals, int64(2))
+	c.Assert(status[0].LastError, Equals, "webhook responded with 503 Service Unavailable")
+}
+
+// A message that has run out of retries is negatively acknowledged.
+func (s *PusherSuite) TestNacked(c *C) {
+	// Given
+	attempts := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+	s.cfg.PushSubscriptions = []config.PushSubscription{{
+		Group: "g1", Topic: "t1", URL: webhook.URL, RetryBackoff: time.Millisecond, MaxRetries: 2,
+	}}
+	cons := newFakeConsumer(&consumer.Message{Topic: "t1", Offset: 7})
+
+	// When
+	p := Spawn(s.cfg, cons)
+	<-cons.doneCh
+	p.Stop()
+
+	// Then
+	c.Assert(attempts, Equals, 3)
+	c.Assert(cons.acked, IsNil)
+	c.Assert(cons.nacked, DeepEquals, []int64{7})
+	status := p.Status()
+	c.Assert(status[0].Delivered, Equals, int64(0))
+	c.Assert(status[0].Failures, Equals, int64(3))
+	c.Assert(status[0].Nacked, Equals, int64(1))
+}
+
+// Retries of a message stop before its ack timeout expires, so that the
+// message is never pushed by two workers in parallel when it is consumed
+// again. Retries are continued by the worker that gets the message again.
+func (s *PusherSuite) TestRetriesStopBeforeAckTimeout(c *C) {
+	// Given
+	var mu sync.Mutex
+	attempts := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		failed := attempts < 7
+		mu.Unlock()
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer webhook.Close()
+	s.cfg.Consumer.AckTimeout = 200 * time.Millisecond
+	s.cfg.PushSubscriptions = []config.PushSubscription{{
+		Group: "g1", Topic: "t1", URL: webhook.URL, Concurrency: 2, Timeout: 50 * time.Millisecond,
+		RetryBackoff: 40 * time.Millisecond, RetryBackoffMax: 40 * time.Millisecond,
+	}}
+	cons := newFakeConsumer(&consumer.Message{Topic: "t1", Offset: 7})
+	cons.redeliverAfter = s.cfg.Consumer.AckTimeout
+
+	// When
+	p := Spawn(s.cfg, cons)
+	<-cons.doneCh
+	// Give a worker that could still be retrying the message a chance to
+	// push it once again.
+	time.Sleep(100 * time.Millisecond)
+	p.Stop()
+
+	// Then
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(attempts, Equals, 7)
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	c.Assert(cons.redelivered, Equals, 1)
+	c.Assert(cons.acked, DeepEquals, []int64{7})
+	status := p.Status()
+	c.Assert(status[0].Delivered, Equals, int64(1))
+	c.Assert(status[0].Failures, Equals, int64(6))
+}
+
+// Failures of a message are counted across redeliveries, so a message that
+// is consumed again is nacked once it runs out of retries.
+func (s *PusherSuite) TestRetriesCountedAcrossRedeliveries(c *C) {
+	// Given
+	var mu sync.Mutex
+	attempts := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+	s.cfg.Consumer.AckTimeout = 100 * time.Millisecond
+	s.cfg.PushSubscriptions = []config.PushSubscription{{
+		Group: "g1", Topic: "t1", URL: webhook.URL, Timeout: 50 * time.Millisecond,
+		RetryBackoff: 40 * time.Millisecond, RetryBackoffMax: 40 * time.Millisecond, MaxRetries: 3,
+	}}
+	cons := newFakeConsumer(&consumer.Message{Topic: "t1", Offset: 7})
+	cons.redeliverAfter = s.cfg.Consumer.AckTimeout
+
+	// When
+	p := Spawn(s.cfg, cons)
+	<-cons.doneCh
+	p.Stop()
+
+	// Then
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(attempts, Equals, 4)
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	c.Assert(cons.redelivered > 0, Equals, true)
+	c.Assert(cons.nacked, DeepEquals, []int64{7})
+}
+
+// fakeConsumer returns the given messages one by one, and then fails all
+// consume requests. If `redeliverAfter` is set, then a message that has not
+// been acknowledged or negatively acknowledged that long after it was
+// returned is returned again, like a message that has not been acknowledged
+// within the ack timeout. `doneCh` is closed when all messages are either
+// acknowledged or negatively acknowledged.
+type fakeConsumer struct {
+	consumer.T
+	msgs           []*consumer.Message
+	acked          []int64
+	nacked         []int64
+	redeliverAfter time.Duration
+	redelivered    int
+	doneCh         chan struct{}
+	mu             sync.Mutex
+}
+
+func newFakeConsumer(msgs ...*consumer.Message) *fakeConsumer {
+	return &fakeConsumer{msgs: msgs, doneCh: make(chan struct{})}
+}
+
+func (fc *fakeConsumer) ConsumeExplicitAck(group, topic string) (*consumer.Message, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if len(fc.msgs) == 0 {
+		return nil, consumer.ErrRequestTimeout{Err: errors.New("long polling timeout")}
+	}
+	msg := fc.msgs[0]
+	fc.msgs = fc.msgs[1:]
+	if fc.redeliverAfter > 0 {
+		time.AfterFunc(fc.redeliverAfter, func() {
+			fc.mu.Lock()
+			defer fc.mu.Unlock()
+			if !fc.settled(msg.Offset) {
+				fc.msgs = append(fc.msgs, msg)
+				fc.redelivered++
+			}
+		})
+	}
+	return msg, nil
+}
+
+func (fc *fakeConsumer) settled(offset int64) bool {
+	for _, settled := range [][]int64{fc.acked, fc.nacked} {
+		for _, settledOffset := range settled {
+			if settledOffset == offset {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (fc *fakeConsumer) Ack(group, topic string, partition int32, offsets ...int64) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.acked = append(fc.acked, offsets...)
+	fc.checkDone()
+	return nil
+}
+
+func (fc *fakeConsumer) Nack(group, topic string, partition int32, offsets ...int64) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.nacked = append(fc.nacked, offsets...)
+	fc.checkDone()
+	return nil
+}
+
+func (fc *fakeConsumer) checkDone() {
+	select {
+	case <-fc.doneCh:
+		return
+	default:
+	}
+	if len(fc.msgs) == 0 {
+		close(fc.doneCh)
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/mailgun/kafka-pixy/actor"
@@ -10,60 +11,100 @@ import (
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/consumer"
 	"github.com/mailgun/kafka-pixy/consumer/consumerimpl"
+	"github.com/mailgun/kafka-pixy/grpcsrv"
 	"github.com/mailgun/kafka-pixy/producer"
+	"github.com/mailgun/kafka-pixy/pusher"
 	"github.com/mailgun/log"
 )
 
+// GRPCUnixAddrPrefix designates a `config.T.GRPCAddr` value as a unix domain
+// socket address.
+const GRPCUnixAddrPrefix = "unix:"
+
 type T struct {
 	actorID    *actor.ID
 	prod       *producer.T
 	cons       consumer.T
 	admin      *admin.T
+	pusher     *pusher.T
 	tcpServer  *apiserver.T
 	unixServer *apiserver.T
+	grpcServer *grpcsrv.T
 	quitCh     chan struct{}
 	wg         sync.WaitGroup
 }
 
 func Spawn(cfg *config.T) (*T, error) {
-	prod, err := producer.Spawn(cfg)
-	if err != nil {
+	s := &T{
+		actorID: actor.RootID.NewChild("service"),
+		quitCh:  make(chan struct{}),
+	}
+	var err error
+	if s.prod, err = producer.Spawn(cfg); err != nil {
 		return nil, fmt.Errorf("failed to spawn producer, err=(%s)", err)
 	}
-	cons, err := consumerimpl.Spawn(actor.RootID, cfg)
+	// The consumer is assigned via a local variable, for a nil implementation
+	// pointer stored in the interface field would not compare equal to nil.
+	cons, err := consumerimpl.Spawn(actor.RootID, cfg, s.prod)
 	if err != nil {
+		s.stopSpawned()
 		return nil, fmt.Errorf("failed to spawn consumer, err=(%s)", err)
 	}
-	admin, err := admin.Spawn(cfg)
-	if err != nil {
+	s.cons = cons
+	if s.admin, err = admin.Spawn(cfg); err != nil {
+		s.stopSpawned()
 		return nil, fmt.Errorf("failed to spawn admin, err=(%s)", err)
 	}
-	tcpServer, err := apiserver.New(apiserver.NetworkTCP, cfg.TCPAddr, prod, cons, admin)
-	if err != nil {
-		prod.Stop()
+	s.pusher = pusher.Spawn(cfg, s.cons)
+	if s.tcpServer, err = apiserver.New(apiserver.NetworkTCP, cfg.TCPAddr, s.prod, s.cons, s.admin, s.pusher); err != nil {
+		s.stopSpawned()
 		return nil, fmt.Errorf("failed to start TCP socket based HTTP API, err=(%s)", err)
 	}
-	var unixServer *apiserver.T
 	if cfg.UnixAddr != "" {
-		unixServer, err = apiserver.New(apiserver.NetworkUnix, cfg.UnixAddr, prod, cons, admin)
-		if err != nil {
-			prod.Stop()
+		if s.unixServer, err = apiserver.New(apiserver.NetworkUnix, cfg.UnixAddr, s.prod, s.cons, s.admin, s.pusher); err != nil {
+			s.stopSpawned()
 			return nil, fmt.Errorf("failed to start Unix socket based HTTP API, err=(%s)", err)
 		}
 	}
-	s := &T{
-		actorID:    actor.RootID.NewChild("service"),
-		prod:       prod,
-		cons:       cons,
-		admin:      admin,
-		tcpServer:  tcpServer,
-		unixServer: unixServer,
-		quitCh:     make(chan struct{}),
+	if cfg.GRPCAddr != "" {
+		network, addr := grpcsrv.NetworkTCP, cfg.GRPCAddr
+		if strings.HasPrefix(addr, GRPCUnixAddrPrefix) {
+			network, addr = grpcsrv.NetworkUnix, strings.TrimPrefix(addr, GRPCUnixAddrPrefix)
+		}
+		if s.grpcServer, err = grpcsrv.New(network, addr, s.prod, s.cons, s.admin); err != nil {
+			s.stopSpawned()
+			return nil, fmt.Errorf("failed to start gRPC API, err=(%s)", err)
+		}
 	}
 	actor.Spawn(s.actorID, &s.wg, s.run)
 	return s, nil
 }
 
+// stopSpawned is called when Spawn fails half way through. It stops all
+// components that have been spawned by then in reverse order. API servers
+// have not been started at that point, so only their listeners are closed.
+func (s *T) stopSpawned() {
+	if s.grpcServer != nil {
+		s.grpcServer.Close()
+	}
+	if s.unixServer != nil {
+		s.unixServer.Close()
+	}
+	if s.tcpServer != nil {
+		s.tcpServer.Close()
+	}
+	if s.pusher != nil {
+		s.pusher.Stop()
+	}
+	if s.admin != nil {
+		s.admin.Stop()
+	}
+	if s.cons != nil {
+		s.cons.Stop()
+	}
+	s.prod.Stop()
+}
+
 func (s *T) Stop() {
 	close(s.quitCh)
 	s.wg.Wait()
@@ -71,13 +112,17 @@ func (s *T) Stop() {
 
 // supervisor takes care of the service graceful shutdown.
 func (s *T) run() {
-	var unixServerErrorCh <-chan error
+	var unixServerErrorCh, grpcServerErrorCh <-chan error
 
 	s.tcpServer.Start()
 	if s.unixServer != nil {
 		s.unixServer.Start()
 		unixServerErrorCh = s.unixServer.ErrorCh()
 	}
+	if s.grpcServer != nil {
+		s.grpcServer.Start()
+		grpcServerErrorCh = s.grpcServer.ErrorCh()
+	}
 	// Block to wait for quit signal or an API server crash.
 	select {
 	case <-s.quitCh:
@@ -89,12 +134,19 @@ func (s *T) run() {
 		if ok {
 			log.Errorf("TCP socket based HTTP API crashed, err=(%s)", err)
 		}
+	case err, ok := <-grpcServerErrorCh:
+		if ok {
+			log.Errorf("gRPC API crashed, err=(%s)", err)
+		}
 	}
 	// Initiate stop of all API servers.
 	s.tcpServer.AsyncStop()
 	if s.unixServer != nil {
 		s.unixServer.AsyncStop()
 	}
+	if s.grpcServer != nil {
+		s.grpcServer.AsyncStop()
+	}
 	// Wait until all API servers are stopped.
 	for range s.tcpServer.ErrorCh() {
 		// Drain the errors channel until it is closed.
@@ -104,6 +156,14 @@ func (s *T) run() {
 			// Drain the errors channel until it is closed.
 		}
 	}
+	if s.grpcServer != nil {
+		for range s.grpcServer.ErrorCh() {
+			// Drain the errors channel until it is closed.
+		}
+	}
+	// Push subscriptions use both the consumer and the producer, so they are
+	// stopped first.
+	s.pusher.Stop()
 	// There are no more requests in flight at this point so it is safe to stop
 	// all Kafka clients.
 	var wg sync.WaitGroup
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
@@ -10,13 +11,16 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/gorilla/websocket"
 	"github.com/mailgun/kafka-pixy/apiserver"
 	"github.com/mailgun/kafka-pixy/config"
 	"github.com/mailgun/kafka-pixy/testhelpers"
@@ -318,69 +322,804 @@ func (s *ServiceSuite) TestSyncProduceInvalidTopic(c *C) {
 	c.Assert(body["error"], Equals, sarama.ErrUnknownTopicOrPartition.Error())
 }
 
+// A batch given as a JSON array is submitted synchronously with a result
+// reported for every record.
+func (s *ServiceSuite) TestSyncProduceBatch(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/messages/batch?sync",
+		"application/json", strings.NewReader(`[
+			{"key": "MQ==", "value": "Rm9v"},
+			{"key": "MQ==", "value": "QmFy"}]`))
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, []interface{}{
+		map[string]interface{}{"partition": float64(0), "offset": float64(offsetsBefore[0])},
+		map[string]interface{}{"partition": float64(0), "offset": float64(offsetsBefore[0] + 1)},
+	})
+	c.Assert(offsetsAfter[0], Equals, offsetsBefore[0]+2)
+}
+
+// A batch of newline delimited records is submitted asynchronously by default.
+func (s *ServiceSuite) TestProduceBatchNewlineDelimited(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	offsetsBefore := s.kh.GetNewestOffsets("test.4")
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/messages/batch",
+		"application/x-ndjson", strings.NewReader(
+			"{\"key\": \"MQ==\", \"value\": \"Rm9v\"}\n"+
+				"{\"key\": \"MQ==\", \"value\": \"QmFy\"}\n"+
+				"{\"key\": \"MQ==\", \"value\": \"QmF6eg==\"}\n"))
+	svc.Stop() // Have to stop before getOffsets
+	offsetsAfter := s.kh.GetNewestOffsets("test.4")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, apiserver.EmptyResponse)
+	c.Assert(offsetsAfter[0], Equals, offsetsBefore[0]+3)
+}
+
+func (s *ServiceSuite) TestSyncProduceBatchInvalidTopic(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/no-such-topic/messages/batch?sync",
+		"application/json", strings.NewReader(`[{"value": "Rm9v"}]`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, []interface{}{
+		map[string]interface{}{"error": sarama.ErrUnknownTopicOrPartition.Error()},
+	})
+}
+
+func (s *ServiceSuite) TestProduceBatchInvalidBody(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		body  string
+		error string
+	}{{
+		body:  `[{"value": "Rm9v"}`,
+		error: "Failed to parse the request: err=(unexpected end of JSON input)",
+	}, {
+		body:  `{"value": "Rm9v"} bar`,
+		error: "Failed to parse the request: err=(invalid character 'b' looking for beginning of value)",
+	}, {
+		body:  `[{"value": "Rm9v", "headers": {"foo": "bar"}}]`,
+		error: "Failed to parse the request: err=(unsupported record field: headers)",
+	}, {
+		body:  `{"value": "Rm9v"}` + "\n" + `{"value": "QmFy", "partition": 1}`,
+		error: "Failed to parse the request: err=(unsupported record field: partition)",
+	}} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/test.4/messages/batch?sync",
+			"application/json", strings.NewReader(tc.body))
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
 func (s *ServiceSuite) TestConsumeNoGroup(c *C) {
 	// Given
 	svc, _ := Spawn(s.cfg)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/messages")
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "One consumer group is expected, but 0 provided")
+}
+
+func (s *ServiceSuite) TestConsumeManyGroups(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=a&group=b")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "One consumer group is expected, but 2 provided")
+}
+
+func (s *ServiceSuite) TestConsumeInvalidTopic(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/no-such-topic/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "long polling timeout")
+}
+
+func (s *ServiceSuite) TestConsumeSingleMessage(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.consume", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(ParseBase64(c, body["key"].(string)), Equals, "B")
+	c.Assert(ParseBase64(c, body["value"].(string)), Equals, ProdMsgVal(produced["B"][0]))
+	c.Assert(int(body["partition"].(float64)), Equals, 3)
+	c.Assert(int64(body["offset"].(float64)), Equals, produced["B"][0].Offset)
+}
+
+// Messages are read from a partition directly, without a consumer group.
+func (s *ServiceSuite) TestRead(c *C) {
+	// Given
+	produced := s.kh.PutMessages("service.read", "test.4", map[string]int{"B": 2})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get(fmt.Sprintf("http://_/topics/test.4/partitions/3/messages?offset=%d&limit=5",
+		produced["B"][0].Offset))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(int64(body["highWaterMark"].(float64)), Equals, produced["B"][1].Offset+1)
+	msgs := body["messages"].([]interface{})
+	c.Assert(len(msgs), Equals, 2)
+	for i, msg := range msgs {
+		msg := msg.(map[string]interface{})
+		c.Assert(ParseBase64(c, msg["key"].(string)), Equals, "B")
+		c.Assert(ParseBase64(c, msg["value"].(string)), Equals, ProdMsgVal(produced["B"][i]))
+		c.Assert(int(msg["partition"].(float64)), Equals, 3)
+		c.Assert(int64(msg["offset"].(float64)), Equals, produced["B"][i].Offset)
+	}
+}
+
+// Invalid read parameters are detected and properly reported.
+func (s *ServiceSuite) TestReadInvalidParams(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		url    string
+		status int
+		error  string
+	}{{
+		url:    "http://_/topics/test.4/partitions/foo/messages?offset=0",
+		status: http.StatusBadRequest,
+		error:  "Invalid partition: foo",
+	}, {
+		url:    "http://_/topics/test.4/partitions/0/messages",
+		status: http.StatusBadRequest,
+		error:  "One offset is expected, but 0 provided",
+	}, {
+		url:    "http://_/topics/test.4/partitions/0/messages?offset=0&limit=0",
+		status: http.StatusBadRequest,
+		error:  "Invalid limit: 0",
+	}, {
+		url:    "http://_/topics/test.4/partitions/4/messages?offset=0",
+		status: http.StatusNotFound,
+		error:  sarama.ErrUnknownTopicOrPartition.Error(),
+	}} {
+		// When
+		r, err := s.unixClient.Get(tc.url)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Assert(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Messages are pushed to a consume stream as newline delimited JSON documents
+// as they are consumed.
+func (s *ServiceSuite) TestConsumeStream(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.1")
+	produced := s.kh.PutMessages("service.stream", "test.1", map[string]int{"A": 3})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages/stream?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	defer r.Body.Close()
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(r.Header.Get("Content-Type"), Equals, "application/x-ndjson")
+	reader := bufio.NewReader(r.Body)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadBytes('\n')
+		c.Assert(err, IsNil)
+		var msg map[string]interface{}
+		c.Assert(json.Unmarshal(line, &msg), IsNil)
+		c.Assert(ParseBase64(c, msg["value"].(string)), Equals, ProdMsgVal(produced["A"][i]))
+		c.Assert(int64(msg["offset"].(float64)), Equals, produced["A"][i].Offset)
+	}
+}
+
+// Messages written to a consume stream that is not in the explicit
+// acknowledgement mode are acknowledged, so they are not consumed again.
+func (s *ServiceSuite) TestConsumeStreamAcked(c *C) {
+	// Given
+	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("service.stream.acked", "test.1", map[string]int{"A": 2})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages/stream?group=foo")
+	c.Assert(err, IsNil)
+	reader := bufio.NewReader(r.Body)
+	for i := 0; i < 2; i++ {
+		_, err := reader.ReadBytes('\n')
+		c.Assert(err, IsNil)
+	}
+	r.Body.Close()
+
+	// When
+	time.Sleep(600 * time.Millisecond)
+	r, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+}
+
+// Messages are pushed to a consume stream as Server-Sent Events if the client
+// accepts them.
+func (s *ServiceSuite) TestConsumeStreamSSE(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.1")
+	produced := s.kh.PutMessages("service.stream.sse", "test.1", map[string]int{"A": 2})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	req, err := http.NewRequest("GET", "http://_/topics/test.1/messages/stream?group=foo", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// When
+	r, err := s.unixClient.Do(req)
+
+	// Then
+	c.Assert(err, IsNil)
+	defer r.Body.Close()
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(r.Header.Get("Content-Type"), Equals, "text/event-stream")
+	reader := bufio.NewReader(r.Body)
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		c.Assert(err, IsNil)
+		c.Assert(line, Equals, "event: message\n")
+		line, err = reader.ReadString('\n')
+		c.Assert(err, IsNil)
+		c.Assert(strings.HasPrefix(line, "data: "), Equals, true)
+		var msg map[string]interface{}
+		c.Assert(json.Unmarshal([]byte(line[len("data: "):]), &msg), IsNil)
+		c.Assert(ParseBase64(c, msg["value"].(string)), Equals, ProdMsgVal(produced["A"][i]))
+		line, err = reader.ReadString('\n')
+		c.Assert(err, IsNil)
+		c.Assert(line, Equals, "\n")
+	}
+}
+
+// Invalid consume stream parameters are detected and properly reported.
+func (s *ServiceSuite) TestConsumeStreamInvalidParams(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		url   string
+		error string
+	}{{
+		url:   "http://_/topics/test.1/messages/stream",
+		error: "One consumer group is expected, but 0 provided",
+	}, {
+		url:   "http://_/topics/test.1/messages/stream?group=foo&format=xml",
+		error: "Invalid format: xml",
+	}} {
+		// When
+		r, err := s.unixClient.Get(tc.url)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Assert(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Messages can be produced, consumed and acknowledged over a single WebSocket
+// connection.
+func (s *ServiceSuite) TestWebSocket(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.1")
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", s.cfg.UnixAddr)
+	}}
+	conn, _, err := dialer.Dial("ws://_/ws", nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// When
+	c.Assert(conn.WriteJSON(map[string]interface{}{
+		"id": "1", "op": "subscribe", "group": "foo", "topic": "test.1", "explicitAck": true,
+	}), IsNil)
+	c.Assert(conn.WriteJSON(map[string]interface{}{
+		"id": "2", "op": "produce", "topic": "test.1", "value": []byte("Bazinga!"), "sync": true,
+	}), IsNil)
+
+	// Then
+	events := make(map[string]map[string]interface{})
+	for len(events) < 3 {
+		var event map[string]interface{}
+		c.Assert(conn.ReadJSON(&event), IsNil)
+		key := event["type"].(string)
+		if id, ok := event["id"]; ok {
+			key += id.(string)
+		}
+		events[key] = event
+	}
+	c.Assert(events["reply1"], NotNil)
+	produced := events["reply2"]
+	c.Assert(produced, NotNil)
+	consumed := events["message"]
+	c.Assert(consumed, NotNil)
+	c.Assert(ParseBase64(c, consumed["value"].(string)), Equals, "Bazinga!")
+	c.Assert(consumed["group"], Equals, "foo")
+	c.Assert(consumed["topic"], Equals, "test.1")
+	c.Assert(consumed["partition"], Equals, produced["partition"])
+	c.Assert(consumed["offset"], Equals, produced["offset"])
+
+	c.Assert(conn.WriteJSON(map[string]interface{}{
+		"id": "3", "op": "ack", "group": "foo", "topic": "test.1",
+		"partition": consumed["partition"], "offsets": []interface{}{consumed["offset"]},
+	}), IsNil)
+	var event map[string]interface{}
+	c.Assert(conn.ReadJSON(&event), IsNil)
+	c.Assert(event, DeepEquals, map[string]interface{}{"type": "reply", "id": "3"})
+}
+
+// Invalid WebSocket requests are reported, and the connection stays open.
+func (s *ServiceSuite) TestWebSocketInvalidRequests(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", s.cfg.UnixAddr)
+	}}
+	conn, _, err := dialer.Dial("ws://_/ws", nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	for i, tc := range []struct {
+		request string
+		error   string
+	}{{
+		request: `garbage`,
+		error:   "Failed to parse the request: err=(invalid character 'g' looking for beginning of value)",
+	}, {
+		request: `{"id": "1", "op": "foo"}`,
+		error:   "Invalid op: foo",
+	}, {
+		request: `{"id": "1", "op": "subscribe", "topic": "test.1"}`,
+		error:   "Group is not specified",
+	}, {
+		request: `{"id": "1", "op": "unsubscribe", "group": "foo", "topic": "test.1"}`,
+		error:   "Not subscribed: group=foo, topic=test.1",
+	}, {
+		request: `{"id": "1", "op": "ack", "group": "foo", "topic": "test.1"}`,
+		error:   "One offset is expected, but 0 provided",
+	}, {
+		request: `{"id": "1", "op": "produce", "value": "Zm9v"}`,
+		error:   "Topic is not specified",
+	}} {
+		// When
+		c.Assert(conn.WriteMessage(websocket.TextMessage, []byte(tc.request)), IsNil)
+
+		// Then
+		var event map[string]interface{}
+		c.Assert(conn.ReadJSON(&event), IsNil, Commentf("case #%d", i))
+		c.Assert(event["type"], Equals, "error", Commentf("case #%d", i))
+		c.Assert(event["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// A message consumed in the explicit acknowledgement mode is consumed again if
+// it has not been acknowledged in time.
+func (s *ServiceSuite) TestConsumeExplicitAck(c *C) {
+	// Given
+	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.explicit-ack", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(int64(body["offset"].(float64)), Equals, produced["B"][0].Offset)
+
+	// When
+	time.Sleep(600 * time.Millisecond)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body = ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(ParseBase64(c, body["value"].(string)), Equals, ProdMsgVal(produced["B"][0]))
+	c.Assert(int64(body["offset"].(float64)), Equals, produced["B"][0].Offset)
+
+	r, err = s.unixClient.Post(fmt.Sprintf("http://_/topics/test.4/acks?group=foo&partition=3&offset=%d",
+		produced["B"][0].Offset), "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, apiserver.EmptyResponse)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+}
+
+// The explicit acknowledgement mode is only enabled by a true value of the
+// parameter, and a value that is not a boolean is rejected.
+func (s *ServiceSuite) TestConsumeExplicitAckParam(c *C) {
+	// Given
+	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("service.explicit-ack-param", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck=maybe")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{"error": "Invalid explicitAck: maybe"})
+
+	// When
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck=false")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	time.Sleep(600 * time.Millisecond)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+}
+
+// A negatively acknowledged message is republished to the dead letter topic
+// if there are no retries configured, and is not consumed again.
+func (s *ServiceSuite) TestNack(c *C) {
+	// Given
+	s.cfg.Consumer.AckTimeout = 500 * time.Millisecond
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.nack", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Post(fmt.Sprintf("http://_/topics/test.4/nacks?group=foo&partition=3&offset=%d",
+		produced["B"][0].Offset), "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, apiserver.EmptyResponse)
+	time.Sleep(600 * time.Millisecond)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo&explicitAck")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+}
+
+// A negative acknowledgement is only accepted for a pending message.
+func (s *ServiceSuite) TestNackNotPending(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4/nacks?group=foo&partition=1&offset=2", "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "partition is not consumed by this instance: group=foo, topic=test.4, partition=1")
+}
+
+// While consumption of a group/topic is paused, consume requests are
+// rejected with 409 Conflict, and messages are consumed again once it is
+// resumed.
+func (s *ServiceSuite) TestPauseResume(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.pause", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/groups/foo/topics/test.4/pause", "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusConflict)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "consumption is paused: group=foo, topic=test.4")
+	r, err = s.unixClient.Get("http://_/topics/test.4/consumers?group=foo&verbose")
+	c.Assert(err, IsNil)
+	consumers := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(consumers["foo"].(map[string]interface{})["paused"], Equals, true)
+
+	// When
+	r, err = s.unixClient.Post("http://_/groups/foo/topics/test.4/resume", "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	msg := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(int64(msg["offset"].(float64)), Equals, produced["B"][0].Offset)
+}
+
+// Pausing a topic pattern pauses consumption of matching topics that the
+// group is already consuming.
+func (s *ServiceSuite) TestPausePattern(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.pause", "test.4", map[string]int{"B": 2})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	msg := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(int64(msg["offset"].(float64)), Equals, produced["B"][0].Offset)
+
+	// When
+	r, err = s.unixClient.Post("http://_/groups/foo/topics/~test%5C..*/pause", "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusConflict)
+	r, err = s.unixClient.Get("http://_/topics/test.4/consumers?group=foo&verbose")
+	c.Assert(err, IsNil)
+	consumers := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(consumers["foo"].(map[string]interface{})["paused"], Equals, true)
+
+	// When
+	r, err = s.unixClient.Post("http://_/groups/foo/topics/~test%5C..*/resume", "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	msg = ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(int64(msg["offset"].(float64)), Equals, produced["B"][1].Offset)
+}
+
+// If `max` is specified then a JSON array of up to `max` messages is
+// returned.
+func (s *ServiceSuite) TestConsumeBatch(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.batch", "test.4", map[string]int{"B": 3})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&max=5&maxWait=500ms")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).([]interface{})
+	c.Assert(len(body), Equals, 3)
+	for i, item := range body {
+		msg := item.(map[string]interface{})
+		c.Assert(ParseBase64(c, msg["key"].(string)), Equals, "B")
+		c.Assert(ParseBase64(c, msg["value"].(string)), Equals, ProdMsgVal(produced["B"][i]))
+		c.Assert(int64(msg["offset"].(float64)), Equals, produced["B"][i].Offset)
+	}
+}
+
+// Batch consume parameters are validated.
+func (s *ServiceSuite) TestConsumeBatchInvalidParams(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		query string
+		error string
+	}{{
+		query: "max=0",
+		error: "Invalid max: 0",
+	}, {
+		query: "max=foo",
+		error: "Invalid max: foo",
+	}, {
+		query: "max=10&maxWait=foo",
+		error: "Invalid maxWait: foo",
+	}} {
+		// When
+		r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo&" + tc.query)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusBadRequest, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
+// Messages consumed via a topic pattern report the topic they come from.
+func (s *ServiceSuite) TestConsumePattern(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.pattern", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/patterns/test%5C.%5B4%5D/messages?group=foo")
 
 	// Then
 	c.Assert(err, IsNil)
-	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Assert(body["error"], Equals, "One consumer group is expected, but 0 provided")
+	c.Assert(ParseBase64(c, body["value"].(string)), Equals, ProdMsgVal(produced["B"][0]))
+	c.Assert(body["topic"], Equals, "test.4")
+	c.Assert(int(body["partition"].(float64)), Equals, 3)
+	c.Assert(int64(body["offset"].(float64)), Equals, produced["B"][0].Offset)
 }
 
-func (s *ServiceSuite) TestConsumeManyGroups(c *C) {
+// A pattern that is not a valid regular expression is rejected.
+func (s *ServiceSuite) TestConsumePatternInvalid(c *C) {
 	// Given
 	svc, _ := Spawn(s.cfg)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=a&group=b")
+	r, err := s.unixClient.Get("http://_/patterns/test%5B/messages?group=foo")
 
 	// Then
 	c.Assert(err, IsNil)
 	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Assert(body["error"], Equals, "One consumer group is expected, but 2 provided")
+	c.Assert(body["error"], Equals, "Invalid pattern: error parsing regexp: missing closing ]: `[)$`")
 }
 
-func (s *ServiceSuite) TestConsumeInvalidTopic(c *C) {
+// An invalid auto offset reset policy is rejected with 400 Bad Request.
+func (s *ServiceSuite) TestConsumeAutoOffsetResetInvalid(c *C) {
 	// Given
 	svc, _ := Spawn(s.cfg)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/no-such-topic/messages?group=foo")
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo&autoOffsetReset=oldest")
 
 	// Then
 	c.Assert(err, IsNil)
-	c.Assert(r.StatusCode, Equals, http.StatusRequestTimeout)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Assert(body["error"], Equals, "long polling timeout")
+	c.Assert(body["error"], Equals, "Invalid autoOffsetReset: oldest")
 }
 
-func (s *ServiceSuite) TestConsumeSingleMessage(c *C) {
+// If the auto offset reset policy of a group is `fail` and the group has no
+// committed offset, then consume requests are rejected with 409 Conflict.
+func (s *ServiceSuite) TestConsumeNoValidOffset(c *C) {
 	// Given
-	s.kh.ResetOffsets("foo", "test.4")
-	produced := s.kh.PutMessages("service.consume", "test.4", map[string]int{"B": 1})
 	svc, _ := Spawn(s.cfg)
 	defer svc.Stop()
 
 	// When
-	r, err := s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=no-offset&autoOffsetReset=fail")
 
 	// Then
 	c.Assert(err, IsNil)
-	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(r.StatusCode, Equals, http.StatusConflict)
 	body := ParseJSONBody(c, r).(map[string]interface{})
-	c.Assert(ParseBase64(c, body["key"].(string)), Equals, "B")
-	c.Assert(ParseBase64(c, body["value"].(string)), Equals, ProdMsgVal(produced["B"][0]))
-	c.Assert(int(body["partition"].(float64)), Equals, 3)
-	c.Assert(int64(body["offset"].(float64)), Equals, produced["B"][0].Offset)
+	c.Assert(body["error"], Equals, "no committed offset: group=no-offset, topic=test.1, partition=0")
+}
+
+// An acknowledgement must specify valid partition and offset.
+func (s *ServiceSuite) TestAckInvalidParams(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		query  string
+		status int
+		error  string
+	}{{
+		query:  "partition=1&offset=2",
+		status: http.StatusBadRequest,
+		error:  "One consumer group is expected, but 0 provided",
+	}, {
+		query:  "group=foo&offset=2",
+		status: http.StatusBadRequest,
+		error:  "One partition is expected, but 0 provided",
+	}, {
+		query:  "group=foo&partition=bar&offset=2",
+		status: http.StatusBadRequest,
+		error:  "Invalid partition: bar",
+	}, {
+		query:  "group=foo&partition=1",
+		status: http.StatusBadRequest,
+		error:  "One offset is expected, but 0 provided",
+	}, {
+		query:  "group=foo&partition=1&offset=2",
+		status: http.StatusNotFound,
+		error:  "partition is not consumed by this instance: group=foo, topic=test.4, partition=1",
+	}} {
+		// When
+		r, err := s.unixClient.Post("http://_/topics/test.4/acks?"+tc.query, "text/plain", nil)
+
+		// Then
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
 }
 
 // If offsets for a group that does not exist are requested then -1 is returned
@@ -404,6 +1143,93 @@ func (s *ServiceSuite) TestGetOffsetsNoSuchGroup(c *C) {
 	}
 }
 
+// Partitions of a topic are reported with their leaders, replicas and
+// in-sync replicas.
+func (s *ServiceSuite) TestGetTopicMetadata(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/test.4")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["topic"], Equals, "test.4")
+	partitions := body["partitions"].([]interface{})
+	c.Assert(len(partitions), Equals, 4)
+	for i, item := range partitions {
+		partition := item.(map[string]interface{})
+		c.Assert(int(partition["partition"].(float64)), Equals, i)
+		c.Assert(partition["isr"], Not(HasLen), 0)
+	}
+}
+
+// An attempt to retrieve metadata of a topic that does not exist fails with
+// 404.
+func (s *ServiceSuite) TestGetTopicMetadataNoSuchTopic(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/topics/no_such_topic")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "Unknown topic")
+}
+
+// An attempt to create a topic that already exists fails with 409.
+func (s *ServiceSuite) TestCreateTopicExists(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.4", "application/json",
+		strings.NewReader(`{"partitions": 4, "replicationFactor": 1}`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusConflict)
+}
+
+// An attempt to create a topic with invalid parameters fails with 400.
+func (s *ServiceSuite) TestCreateTopicInvalid(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/service.invalid", "application/json",
+		strings.NewReader(`{"partitions": 0, "replicationFactor": 1}`))
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+}
+
+// An attempt to delete a topic that does not exist fails with 404.
+func (s *ServiceSuite) TestDeleteTopicNoSuchTopic(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	req, err := http.NewRequest("DELETE", "http://_/topics/no_such_topic", nil)
+	c.Assert(err, IsNil)
+
+	// When
+	r, err := s.unixClient.Do(req)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusNotFound)
+}
+
 // An attempt to retrieve offsets for a topic that does not exist fails with 404.
 func (s *ServiceSuite) TestGetOffsetsNoSuchTopic(c *C) {
 	// Given
@@ -510,6 +1336,107 @@ func (s *ServiceSuite) TestSetOffsetsInvalidPartition(c *C) {
 	c.Assert(ParseJSONBody(c, r), DeepEquals, apiserver.EmptyResponse)
 }
 
+// Offsets of partitions that are not consumed by the service are reset by
+// committing them directly.
+func (s *ServiceSuite) TestResetOffsets(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Post("http://_/topics/test.4/offsets?group=foo",
+		"application/json", strings.NewReader(
+			`[{"partition": 0, "offset": -2},
+			  {"partition": 1, "offset": -2},
+			  {"partition": 2, "offset": -2},
+			  {"partition": 3, "offset": -2}]`))
+	c.Assert(err, IsNil)
+
+	// When
+	r, err = s.unixClient.Post("http://_/topics/test.4/offsets/reset?group=foo&to=newest", "text/plain", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	resetViews := ParseJSONBody(c, r).([]interface{})
+	c.Assert(len(resetViews), Equals, 4)
+
+	r, err = s.unixClient.Get("http://_/topics/test.4/offsets?group=foo")
+	c.Assert(err, IsNil)
+	body := ParseJSONBody(c, r).([]interface{})
+	for i := 0; i < 4; i++ {
+		resetView := resetViews[i].(map[string]interface{})
+		partitionView := body[i].(map[string]interface{})
+		c.Assert(resetView["partition"].(float64), Equals, float64(i))
+		c.Assert(resetView["offset"], Equals, partitionView["end"])
+		c.Assert(partitionView["offset"], Equals, partitionView["end"])
+	}
+}
+
+// Partitions consumed by the service continue consumption from the offsets
+// they are reset to.
+func (s *ServiceSuite) TestResetOffsetsConsumed(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	s.kh.ResetOffsets("foo", "test.1")
+	s.kh.PutMessages("reset", "test.1", map[string]int{"A": 2})
+	for i := 0; i < 2; i++ {
+		r, err := s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+		c.Assert(err, IsNil)
+		c.Assert(r.StatusCode, Equals, http.StatusOK)
+	}
+
+	// When
+	r, err := s.unixClient.Post("http://_/topics/test.1/offsets/reset?group=foo&to=oldest", "text/plain", nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	resetView := ParseJSONBody(c, r).([]interface{})[0].(map[string]interface{})
+	r, err = s.unixClient.Get("http://_/topics/test.1/messages?group=foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["offset"], Equals, resetView["offset"])
+}
+
+// Invalid reset parameters are detected and properly reported.
+func (s *ServiceSuite) TestResetOffsetsInvalidParams(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	for i, tc := range []struct {
+		url    string
+		status int
+		error  string
+	}{{
+		url:    "http://_/topics/test.4/offsets/reset?to=oldest",
+		status: http.StatusBadRequest,
+		error:  "One consumer group is expected, but 0 provided",
+	}, {
+		url:    "http://_/topics/test.4/offsets/reset?group=foo",
+		status: http.StatusBadRequest,
+		error:  "One to is expected, but 0 provided",
+	}, {
+		url:    "http://_/topics/test.4/offsets/reset?group=foo&to=yesterday",
+		status: http.StatusBadRequest,
+		error:  "Invalid to: yesterday",
+	}, {
+		url:    "http://_/topics/no_such_topic/offsets/reset?group=foo&to=oldest",
+		status: http.StatusNotFound,
+		error:  "Unknown topic",
+	}} {
+		// When
+		r, err := s.unixClient.Post(tc.url, "text/plain", nil)
+
+		// Then
+		c.Assert(err, IsNil, Commentf("case #%d", i))
+		c.Assert(r.StatusCode, Equals, tc.status, Commentf("case #%d", i))
+		body := ParseJSONBody(c, r).(map[string]interface{})
+		c.Assert(body["error"], Equals, tc.error, Commentf("case #%d", i))
+	}
+}
+
 // Reported partition lags are correct, including those corresponding to -1 and
 // -2 special case offset values.
 func (s *ServiceSuite) TestGetOffsetsLag(c *C) {
@@ -681,6 +1608,104 @@ func (s *ServiceSuite) TestGetTopicConsumers(c *C) {
 	})
 }
 
+// A group registered in ZooKeeper is described with its members, partitions
+// they own and offsets committed by the group.
+func (s *ServiceSuite) TestGetGroup(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	s.kh.PutMessages("get.group", "test.4", map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+	svc, _ := Spawn(testhelpers.NewTestConfig("C1"))
+	defer svc.Stop()
+	for i := 0; i < 4; i++ {
+		s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	}
+
+	// When
+	r, err := s.unixClient.Get("http://_/groups/foo")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["group"], Equals, "foo")
+	c.Assert(body["membership"], Equals, "zookeeper")
+	members := body["members"].([]interface{})
+	c.Assert(len(members), Equals, 1)
+	member := members[0].(map[string]interface{})
+	c.Assert(member["id"], Equals, "C1")
+	c.Assert(member["topics"], DeepEquals, []interface{}{"test.4"})
+	offsets := body["offsets"].(map[string]interface{})
+	c.Assert(offsets["test.4"], HasLen, 4)
+
+	// When
+	r, err = s.unixClient.Get("http://_/groups")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), Not(HasLen), 0)
+}
+
+// Offsets copied from one group to another can be exported.
+func (s *ServiceSuite) TestCopyOffsets(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	suffix := time.Now().UnixNano()
+	srcGroup := fmt.Sprintf("copy.src.%d", suffix)
+	dstGroup := fmt.Sprintf("copy.dst.%d", suffix)
+	r, err := s.unixClient.Post(fmt.Sprintf("http://_/groups/%s/offsets/import", srcGroup), "application/json",
+		strings.NewReader(`{"test.4": [{"partition": 1, "offset": 1002, "metadata": "A2"}]}`))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Post(fmt.Sprintf("http://_/groups/%s/offsets/copy?to=%s", srcGroup, dstGroup),
+		"application/json", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get(fmt.Sprintf("http://_/groups/%s/offsets/export", dstGroup))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r), DeepEquals, map[string]interface{}{
+		"test.4": []interface{}{
+			map[string]interface{}{"partition": float64(1), "offset": float64(1002), "metadata": "A2"},
+		},
+	})
+}
+
+// An attempt to copy offsets without a destination group fails with 400.
+func (s *ServiceSuite) TestCopyOffsetsNoDestination(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Post("http://_/groups/foo/offsets/copy", "application/json", nil)
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusBadRequest)
+}
+
+// An attempt to describe a group that does not exist fails with 404.
+func (s *ServiceSuite) TestGetGroupNoSuchGroup(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r, err := s.unixClient.Get("http://_/groups/no_such_group")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r).(map[string]interface{})
+	c.Assert(body["error"], Equals, "Unknown group")
+}
+
 // Reported partition lags are correct, including those corresponding to -1 and
 // -2 special case offset values.
 func (s *ServiceSuite) TestHealthCheck(c *C) {
@@ -699,6 +1724,128 @@ func (s *ServiceSuite) TestHealthCheck(c *C) {
 	c.Assert(string(body), Equals, "pong")
 }
 
+// Produced messages and consume requests are reflected in the metrics
+// exposed in the Prometheus text format.
+func (s *ServiceSuite) TestMetrics(c *C) {
+	// Given
+	s.kh.ResetOffsets("foo", "test.4")
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+	r, err := s.unixClient.Post("http://_/topics/test.4/messages?key=1&sync",
+		"text/plain", strings.NewReader("Bar"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	r, err = s.unixClient.Get("http://_/topics/test.4/messages?group=foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+
+	// When
+	r, err = s.unixClient.Get("http://_/metrics")
+
+	// Then
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(r.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Matches, `(?s).*kafkapixy_producer_messages_total{topic="test.4"} \d+.*`)
+	c.Assert(string(body), Matches, `(?s).*kafkapixy_consumer_requests_total{group="foo",outcome="success",topic="test.4"} \d+.*`)
+	c.Assert(string(body), Matches, `(?s).*kafkapixy_consumer_partition_lag{group="foo",partition="\d+",topic="test.4"} \d+.*`)
+}
+
+// Dead letters cannot be listed or replayed unless they are spooled.
+func (s *ServiceSuite) TestDeadLettersNotSpooled(c *C) {
+	// Given
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r1, err1 := s.unixClient.Get("http://_/deadletters")
+	r2, err2 := s.unixClient.Post("http://_/deadletters/replay", "text/plain", nil)
+
+	// Then
+	c.Assert(err1, IsNil)
+	c.Assert(r1.StatusCode, Equals, http.StatusNotFound)
+	body := ParseJSONBody(c, r1).(map[string]interface{})
+	c.Assert(body["error"], Equals, "dead letters are not spooled")
+	c.Assert(err2, IsNil)
+	c.Assert(r2.StatusCode, Equals, http.StatusNotFound)
+}
+
+// Spooled dead letters are listed, and replayed with the number of replayed
+// and failed records reported.
+func (s *ServiceSuite) TestDeadLetters(c *C) {
+	// Given
+	s.cfg.Producer.DeadLetter.Mode = config.DeadLetterSpool
+	s.cfg.Producer.DeadLetter.SpoolFile = path.Join(c.MkDir(), "dead_letters")
+	svc, _ := Spawn(s.cfg)
+	r, err := s.unixClient.Post("http://_/topics/no-such-topic/messages?key=1",
+		"text/plain", strings.NewReader("Foo"))
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	svc.Stop() // Make sure the failed message is spooled.
+	svc, _ = Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	r1, err1 := s.unixClient.Get("http://_/deadletters")
+	r2, err2 := s.unixClient.Post("http://_/deadletters/replay", "text/plain", nil)
+
+	// Then
+	c.Assert(err1, IsNil)
+	c.Assert(r1.StatusCode, Equals, http.StatusOK)
+	records := ParseJSONBody(c, r1).([]interface{})
+	c.Assert(len(records), Equals, 1)
+	record := records[0].(map[string]interface{})
+	c.Assert(record["topic"], Equals, "no-such-topic")
+	c.Assert(ParseBase64(c, record["key"].(string)), Equals, "1")
+	c.Assert(ParseBase64(c, record["value"].(string)), Equals, "Foo")
+	c.Assert(record["error"], Equals, sarama.ErrUnknownTopicOrPartition.Error())
+	c.Assert(err2, IsNil)
+	c.Assert(r2.StatusCode, Equals, http.StatusOK)
+	c.Assert(ParseJSONBody(c, r2), DeepEquals, map[string]interface{}{"replayed": 0.0, "failed": 1.0})
+}
+
+// Messages of a push subscription are POSTed to the webhook, and the
+// subscription status reports delivered messages.
+func (s *ServiceSuite) TestPushSubscription(c *C) {
+	// Given
+	pushedCh := make(chan map[string]interface{}, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pushed map[string]interface{}
+		c.Check(json.NewDecoder(r.Body).Decode(&pushed), IsNil)
+		pushedCh <- pushed
+	}))
+	defer webhook.Close()
+	s.cfg.PushSubscriptions = []config.PushSubscription{{Group: "foo", Topic: "test.4", URL: webhook.URL}}
+	s.kh.ResetOffsets("foo", "test.4")
+	produced := s.kh.PutMessages("service.push", "test.4", map[string]int{"B": 1})
+	svc, _ := Spawn(s.cfg)
+	defer svc.Stop()
+
+	// When
+	var pushed map[string]interface{}
+	select {
+	case pushed = <-pushedCh:
+	case <-time.After(10 * time.Second):
+		c.Fatal("message not pushed")
+	}
+
+	// Then
+	c.Assert(ParseBase64(c, pushed["value"].(string)), Equals, ProdMsgVal(produced["B"][0]))
+	c.Assert(pushed["topic"], Equals, "test.4")
+	c.Assert(int64(pushed["offset"].(float64)), Equals, produced["B"][0].Offset)
+	time.Sleep(100 * time.Millisecond)
+	r, err := s.unixClient.Get("http://_/push/subscriptions")
+	c.Assert(err, IsNil)
+	c.Assert(r.StatusCode, Equals, http.StatusOK)
+	statuses := ParseJSONBody(c, r).([]interface{})
+	c.Assert(len(statuses), Equals, 1)
+	status := statuses[0].(map[string]interface{})
+	c.Assert(status["group"], Equals, "foo")
+	c.Assert(status["topic"], Equals, "test.4")
+	c.Assert(status["delivered"], Equals, 1.0)
+}
+
 func spawnTestService(c *C, port int) *T {
 	cfg := testhelpers.NewTestConfig(fmt.Sprintf("C%d", port))
 	cfg.UnixAddr = fmt.Sprintf("%s.%d", cfg.UnixAddr, port)